@@ -0,0 +1,414 @@
+/*
+Package ast declares the syntax tree of Lox programs, with a parser that
+builds it, a printer that shows it and a code generator that compiles it to
+the bytecode the compiler in package src emits.
+
+The single-pass compiler emits code as it parses, so tools that need the
+structure of a program, such as formatters, linters and optimizers, parse it
+with Parse instead.
+*/
+package ast
+
+import "github.com/smekuria1/goclox/globals"
+
+// Position is the place of a token in the source.
+type Position struct {
+	Offset int // The byte offset of the token.
+	Line   int // The line of the token, as the scanner counts it.
+	Column int // The column of the token's first character, in runes.
+}
+
+// Node is a node of the syntax tree.
+type Node interface {
+	Pos() Position // The position of the node's first token.
+	End() Position // The position of the node's last token.
+}
+
+// Expr is an expression node.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Stmt is a statement or declaration node.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Ident is a name.
+type Ident struct {
+	Name    string
+	NamePos Position
+}
+
+// Signature is the parameter list of a function.
+type Signature struct {
+	Params   []Ident // The parameters, a rest parameter included.
+	Defaults []Expr  // The default value of each parameter, nil for one without; nil if none has one.
+	Variadic bool    // Whether the last parameter is a rest parameter, as in ...rest.
+}
+
+// Required returns the number of parameters without a default value.
+func (s *Signature) Required() int {
+	n := 0
+	for n < len(s.Params) && (n >= len(s.Defaults) || s.Defaults[n] == nil) && !(s.Variadic && n == len(s.Params)-1) {
+		n++
+	}
+	return n
+}
+
+// File is a parsed source file.
+type File struct {
+	Stmts []Stmt
+	EOF   Position // The position of the end of the file.
+}
+
+// Expressions.
+type (
+	// BadExpr stands for an expression with a syntax error.
+	BadExpr struct {
+		From Position
+	}
+
+	// Literal is a number, string, true, false or nil.
+	Literal struct {
+		Kind     globals.TokenType // TokenNUMBER, TokenSTRING, TokenTRUE, TokenFALSE or TokenNIL.
+		Value    string            // The literal as written, with the quotes of a string.
+		ValuePos Position
+	}
+
+	// Variable reads a variable.
+	Variable struct {
+		Name Ident
+	}
+
+	// Assign is an assignment to a variable, as in a = 1 or a += 1.
+	Assign struct {
+		Name  Ident
+		Op    globals.TokenType // TokenEQUAL or a compound assignment operator such as TokenPLUS_EQUAL.
+		OpPos Position
+		Value Expr
+	}
+
+	// IncDec increments or decrements a variable by 1, as in ++a or a--.
+	IncDec struct {
+		Op      globals.TokenType // TokenPLUS_PLUS or TokenMINUS_MINUS.
+		OpPos   Position
+		X       Expr // A *Variable, or a *Member for a member of a module.
+		Postfix bool // Whether the operator follows X and the old value is the result.
+	}
+
+	// Unary is a unary operator applied to an operand, as in -x.
+	Unary struct {
+		Op    globals.TokenType // TokenMINUS or TokenBANG.
+		OpPos Position
+		X     Expr
+	}
+
+	// Binary is an arithmetic or comparison operator applied to two operands.
+	Binary struct {
+		X     Expr
+		Op    globals.TokenType
+		OpPos Position
+		Y     Expr
+	}
+
+	// Logical is an and, an or or a ??, which only evaluates Y if it needs to.
+	Logical struct {
+		X     Expr
+		Op    globals.TokenType // TokenAND, TokenOR or TokenQUESTION_QUESTION.
+		OpPos Position
+		Y     Expr
+	}
+
+	// Conditional picks one of two expressions, as in cond ? a : b.
+	Conditional struct {
+		Cond     Expr
+		Question Position
+		Then     Expr
+		Colon    Position
+		Else     Expr
+	}
+
+	// Grouping is an expression in parentheses.
+	Grouping struct {
+		Lparen Position
+		X      Expr
+		Rparen Position
+	}
+
+	// Call is a function call.
+	Call struct {
+		Callee Expr
+		Lparen Position
+		Args   []Expr
+		Rparen Position
+	}
+
+	// FunLit is an anonymous function, as in fun (a) { return a; }.
+	FunLit struct {
+		Fun Position
+		Signature
+		Body *Block
+	}
+
+	// ArrowFunc is an arrow function, which returns the value of its body, as in (a) => a + 1.
+	ArrowFunc struct {
+		Lparen Position
+		Signature
+		Arrow Position
+		Body  Expr
+	}
+
+	// Member reads a member of a module imported with a name, as in m.x, or
+	// a property of a value, as in e.message.
+	Member struct {
+		X        Expr
+		Dot      Position // The position of the '.' or '?.'.
+		Name     Ident
+		Optional bool // Whether the member is read with ?., which gives nil when X is nil and skips the rest of the chain.
+	}
+
+	// SetMember is an assignment to a member of a module, as in m.x = 1.
+	SetMember struct {
+		X     Expr
+		Name  Ident
+		Op    globals.TokenType // TokenEQUAL or a compound assignment operator.
+		OpPos Position
+		Value Expr
+	}
+)
+
+// Statements.
+type (
+	// BadStmt stands for a statement with a syntax error, up to where parsing resumed.
+	BadStmt struct {
+		From, To Position
+	}
+
+	// ExprStmt is an expression evaluated for its effect.
+	ExprStmt struct {
+		X         Expr
+		Semicolon Position
+	}
+
+	// PrintStmt prints the value of an expression.
+	PrintStmt struct {
+		Print     Position
+		X         Expr
+		Semicolon Position
+	}
+
+	// VarDecl declares a variable.
+	VarDecl struct {
+		Var       Position
+		Name      Ident
+		Init      Expr // The initializer, or nil.
+		Semicolon Position
+	}
+
+	// FunDecl declares a function.
+	FunDecl struct {
+		Fun  Position
+		Name Ident
+		Signature
+		Body *Block
+	}
+
+	// Block is a list of statements in braces, with its own scope.
+	Block struct {
+		Lbrace Position
+		Stmts  []Stmt
+		Rbrace Position
+	}
+
+	// IfStmt is an if statement.
+	IfStmt struct {
+		If     Position
+		Cond   Expr
+		Rparen Position
+		Then   Stmt
+		Else   Stmt // The else branch, or nil.
+	}
+
+	// WhileStmt is a while loop.
+	WhileStmt struct {
+		While  Position
+		Cond   Expr
+		Rparen Position
+		Body   Stmt
+	}
+
+	// ForStmt is a for loop.
+	ForStmt struct {
+		For       Position
+		Init      Stmt     // A *VarDecl, an *ExprStmt, or nil.
+		Cond      Expr     // The condition, or nil.
+		Semicolon Position // The ';' that ends the condition clause.
+		Incr      Expr     // The increment, or nil.
+		Rparen    Position
+		Body      Stmt
+	}
+
+	// ImportStmt imports a module, as in import "lib" as m;.
+	ImportStmt struct {
+		Import    Position
+		Path      *Literal // The path, a TokenSTRING.
+		Alias     *Ident   // The name after 'as', or nil.
+		Semicolon Position
+	}
+
+	// TryStmt is a try statement, with a catch clause, a finally clause or both.
+	TryStmt struct {
+		Try         Position
+		Body        *Block
+		Catch       Position // The position of 'catch', if there is a catch clause.
+		Name        *Ident   // The exception variable, or nil.
+		CatchBody   *Block   // The catch block, or nil.
+		Finally     Position // The position of 'finally', if there is a finally clause.
+		FinallyBody *Block   // The finally block, or nil.
+	}
+
+	// ThrowStmt throws a value.
+	ThrowStmt struct {
+		Throw     Position
+		Value     Expr
+		Semicolon Position
+	}
+
+	// ReturnStmt returns from a function.
+	ReturnStmt struct {
+		Return    Position
+		Value     Expr // The returned value, or nil.
+		Semicolon Position
+	}
+)
+
+// Pos returns the position of the first statement, or of the end of an empty file.
+func (f *File) Pos() Position {
+	if len(f.Stmts) == 0 {
+		return f.EOF
+	}
+	return f.Stmts[0].Pos()
+}
+
+// End returns the position of the end of the file.
+func (f *File) End() Position { return f.EOF }
+
+func (x *BadExpr) Pos() Position     { return x.From }
+func (x *Literal) Pos() Position     { return x.ValuePos }
+func (x *Variable) Pos() Position    { return x.Name.NamePos }
+func (x *Assign) Pos() Position      { return x.Name.NamePos }
+func (x *Unary) Pos() Position       { return x.OpPos }
+func (x *Binary) Pos() Position      { return x.X.Pos() }
+func (x *Logical) Pos() Position     { return x.X.Pos() }
+func (x *Conditional) Pos() Position { return x.Cond.Pos() }
+func (x *Grouping) Pos() Position    { return x.Lparen }
+func (x *Call) Pos() Position        { return x.Callee.Pos() }
+func (x *FunLit) Pos() Position      { return x.Fun }
+func (x *ArrowFunc) Pos() Position   { return x.Lparen }
+func (x *Member) Pos() Position      { return x.X.Pos() }
+func (x *SetMember) Pos() Position   { return x.X.Pos() }
+
+func (x *BadExpr) End() Position     { return x.From }
+func (x *Literal) End() Position     { return x.ValuePos }
+func (x *Variable) End() Position    { return x.Name.NamePos }
+func (x *Assign) End() Position      { return x.Value.End() }
+func (x *Unary) End() Position       { return x.X.End() }
+func (x *Binary) End() Position      { return x.Y.End() }
+func (x *Logical) End() Position     { return x.Y.End() }
+func (x *Conditional) End() Position { return x.Else.End() }
+func (x *Grouping) End() Position    { return x.Rparen }
+func (x *Call) End() Position        { return x.Rparen }
+func (x *FunLit) End() Position      { return x.Body.Rbrace }
+func (x *ArrowFunc) End() Position   { return x.Body.End() }
+func (x *Member) End() Position      { return x.Name.NamePos }
+func (x *SetMember) End() Position   { return x.Value.End() }
+
+func (x *IncDec) Pos() Position {
+	if x.Postfix {
+		return x.X.Pos()
+	}
+	return x.OpPos
+}
+
+func (x *IncDec) End() Position {
+	if x.Postfix {
+		return x.OpPos
+	}
+	return x.X.End()
+}
+
+func (s *BadStmt) Pos() Position    { return s.From }
+func (s *ExprStmt) Pos() Position   { return s.X.Pos() }
+func (s *PrintStmt) Pos() Position  { return s.Print }
+func (s *VarDecl) Pos() Position    { return s.Var }
+func (s *FunDecl) Pos() Position    { return s.Fun }
+func (s *Block) Pos() Position      { return s.Lbrace }
+func (s *IfStmt) Pos() Position     { return s.If }
+func (s *WhileStmt) Pos() Position  { return s.While }
+func (s *ForStmt) Pos() Position    { return s.For }
+func (s *ImportStmt) Pos() Position { return s.Import }
+func (s *TryStmt) Pos() Position    { return s.Try }
+func (s *ThrowStmt) Pos() Position  { return s.Throw }
+func (s *ReturnStmt) Pos() Position { return s.Return }
+
+func (s *BadStmt) End() Position    { return s.To }
+func (s *ExprStmt) End() Position   { return s.Semicolon }
+func (s *PrintStmt) End() Position  { return s.Semicolon }
+func (s *VarDecl) End() Position    { return s.Semicolon }
+func (s *FunDecl) End() Position    { return s.Body.Rbrace }
+func (s *Block) End() Position      { return s.Rbrace }
+func (s *WhileStmt) End() Position  { return s.Body.End() }
+func (s *ForStmt) End() Position    { return s.Body.End() }
+func (s *ImportStmt) End() Position { return s.Semicolon }
+func (s *ThrowStmt) End() Position  { return s.Semicolon }
+func (s *ReturnStmt) End() Position { return s.Semicolon }
+
+func (s *TryStmt) End() Position {
+	if s.FinallyBody != nil {
+		return s.FinallyBody.Rbrace
+	}
+	if s.CatchBody != nil {
+		return s.CatchBody.Rbrace
+	}
+	return s.Body.Rbrace
+}
+
+func (s *IfStmt) End() Position {
+	if s.Else != nil {
+		return s.Else.End()
+	}
+	return s.Then.End()
+}
+
+func (*BadExpr) exprNode()     {}
+func (*Literal) exprNode()     {}
+func (*Variable) exprNode()    {}
+func (*Assign) exprNode()      {}
+func (*Unary) exprNode()       {}
+func (*IncDec) exprNode()      {}
+func (*Binary) exprNode()      {}
+func (*Logical) exprNode()     {}
+func (*Conditional) exprNode() {}
+func (*Grouping) exprNode()    {}
+func (*Call) exprNode()        {}
+func (*FunLit) exprNode()      {}
+func (*ArrowFunc) exprNode()   {}
+func (*Member) exprNode()      {}
+func (*SetMember) exprNode()   {}
+
+func (*BadStmt) stmtNode()    {}
+func (*ExprStmt) stmtNode()   {}
+func (*PrintStmt) stmtNode()  {}
+func (*VarDecl) stmtNode()    {}
+func (*FunDecl) stmtNode()    {}
+func (*Block) stmtNode()      {}
+func (*IfStmt) stmtNode()     {}
+func (*WhileStmt) stmtNode()  {}
+func (*ForStmt) stmtNode()    {}
+func (*ImportStmt) stmtNode() {}
+func (*TryStmt) stmtNode()    {}
+func (*ThrowStmt) stmtNode()  {}
+func (*ReturnStmt) stmtNode() {}
@@ -0,0 +1,796 @@
+package ast
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/smekuria1/goclox/globals"
+	"github.com/smekuria1/goclox/src"
+)
+
+/*
+Compile generates the bytecode of a parsed file.
+
+The code is the same, instruction for instruction and line for line, as
+src.Compile emits for the file's source, and the globals flags select the
+same passes. Like src.Compile it interns strings and assigns global slots in
+the process-wide VM, so call src.InitVM first. Imports resolve against the
+working directory.
+
+Parameters:
+- file: a file Parse returned without errors.
+
+Returns:
+- *src.ObjFunction: the script function, or nil if there are errors.
+- []error: the errors the compiler reports after parsing, such as a
+variable declared twice in a scope, each an *Error.
+*/
+func Compile(file *File) (*src.ObjFunction, []error) {
+	return CompileFile("", file)
+}
+
+// CompileFile is Compile for the script at path: its imports resolve
+// against the directory of path, as src.CompileFile resolves them, or the
+// working directory if path is empty. A module is compiled by src.LoadModule,
+// which prints its compile errors to the VM's output.
+func CompileFile(path string, file *File) (*src.ObjFunction, []error) {
+	u := &unit{names: make(map[string]int), modules: make(map[string]*src.Module), declared: make(map[string]bool)}
+	if path != "" {
+		u.path, _ = filepath.Abs(path)
+	}
+	g := newGenerator("", 0, u)
+	for _, stmt := range file.Stmts {
+		g.stmt(stmt)
+	}
+	function := g.finish(file.EOF)
+	if len(u.errors) > 0 {
+		return nil, u.errors
+	}
+	return function, nil
+}
+
+// unit is the state the generators of one file share.
+type unit struct {
+	path     string                 // The absolute path of the file, or "".
+	errors   []error                // The errors of the whole file.
+	names    map[string]int         // The global slot of each name imported without 'as'.
+	modules  map[string]*src.Module // The module each name given after 'as' stands for.
+	declared map[string]bool        // The names the file declares at top level.
+}
+
+// generator generates the code of one function.
+type generator struct {
+	builder    *src.FunctionBuilder
+	chunk      *src.Chunk
+	locals     []local
+	scopeDepth int
+	enclosing  *generator // The generator of the enclosing function, or nil.
+	file       *unit
+}
+
+// local is a local variable in scope.
+type local struct {
+	name  string
+	depth int
+	info  int // The index of the variable's src.LocalInfo.
+}
+
+func newGenerator(name string, arity int, file *unit) *generator {
+	builder := src.NewFunctionBuilder(name, arity)
+	builder.SetPath(file.path)
+	// Slot 0 holds the function being called.
+	return &generator{builder: builder, chunk: builder.Chunk(), locals: []local{{}}, file: file}
+}
+
+// finish ends the function's code at the position of its last token and
+// returns the function.
+func (g *generator) finish(end Position) *src.ObjFunction {
+	g.emitReturn(end.Line)
+	for _, l := range g.locals[1:] {
+		if info := g.builder.Local(l.info); info.End == -1 {
+			info.End = g.chunk.Count
+		}
+	}
+	if len(g.file.errors) > 0 {
+		return nil
+	}
+	function, err := g.builder.Finish()
+	if err != nil {
+		g.error(end, "", err.Error())
+	}
+	return function
+}
+
+func (g *generator) stmt(stmt Stmt) {
+	switch s := stmt.(type) {
+	case *ExprStmt:
+		g.expr(s.X)
+		g.emit(s.Semicolon.Line, globals.OpPop)
+	case *PrintStmt:
+		g.expr(s.X)
+		g.emit(s.Semicolon.Line, globals.OpPrint)
+	case *VarDecl:
+		global := g.declare(s.Name)
+		if s.Init != nil {
+			g.expr(s.Init)
+		} else {
+			g.emit(s.Name.NamePos.Line, globals.OpNil)
+		}
+		g.define(global, s.Semicolon.Line)
+	case *FunDecl:
+		global := g.declare(s.Name)
+		g.markInitialized()
+		g.function(s)
+		g.define(global, s.Body.Rbrace.Line)
+	case *Block:
+		g.scopeDepth++
+		for _, stmt := range s.Stmts {
+			g.stmt(stmt)
+		}
+		g.endScope(s.Rbrace.Line)
+	case *IfStmt:
+		g.expr(s.Cond)
+		thenJump := g.emitJump(s.Rparen.Line, globals.OpJumpFalse)
+		g.emit(s.Rparen.Line, globals.OpPop)
+		g.stmt(s.Then)
+		line := s.Then.End().Line
+		elseJump := g.emitJump(line, globals.OpJump)
+		g.patchJump(thenJump, s.Then.End())
+		g.emit(line, globals.OpPop)
+		if s.Else != nil {
+			g.stmt(s.Else)
+		}
+		g.patchJump(elseJump, s.End())
+	case *WhileStmt:
+		loopStart := g.chunk.Count
+		g.expr(s.Cond)
+		exitJump := g.emitJump(s.Rparen.Line, globals.OpJumpFalse)
+		g.emit(s.Rparen.Line, globals.OpPop)
+		g.stmt(s.Body)
+		end := s.Body.End()
+		g.emitLoop(loopStart, end)
+		g.patchJump(exitJump, end)
+		g.emit(end.Line, globals.OpPop)
+	case *ForStmt:
+		g.forStmt(s)
+	case *ImportStmt:
+		g.importStmt(s)
+	case *TryStmt:
+		g.tryStmt(s)
+	case *ThrowStmt:
+		g.expr(s.Value)
+		g.emit(s.Semicolon.Line, globals.OpThrow)
+	case *ReturnStmt:
+		if s.Value == nil {
+			g.emitReturn(s.Semicolon.Line)
+		} else {
+			g.expr(s.Value)
+			g.emit(s.Semicolon.Line, globals.OpReturn)
+		}
+	default:
+		g.error(stmt.Pos(), "", "Can't compile a statement with a syntax error.")
+	}
+}
+
+func (g *generator) forStmt(s *ForStmt) {
+	g.scopeDepth++
+	if s.Init != nil {
+		g.stmt(s.Init)
+	}
+
+	loopStart := g.chunk.Count
+	exitJump := -1
+	if s.Cond != nil {
+		g.expr(s.Cond)
+		exitJump = g.emitJump(s.Semicolon.Line, globals.OpJumpFalse)
+		g.emit(s.Semicolon.Line, globals.OpPop)
+	}
+
+	if s.Incr != nil {
+		bodyJump := g.emitJump(s.Semicolon.Line, globals.OpJump)
+		incrementStart := g.chunk.Count
+		g.expr(s.Incr)
+		g.emit(s.Incr.End().Line, globals.OpPop)
+		g.emitLoop(loopStart, s.Rparen)
+		loopStart = incrementStart
+		g.patchJump(bodyJump, s.Rparen)
+	}
+
+	g.stmt(s.Body)
+	end := s.Body.End()
+	g.emitLoop(loopStart, end)
+	if exitJump != -1 {
+		g.patchJump(exitJump, end)
+		g.emit(end.Line, globals.OpPop)
+	}
+	g.endScope(end.Line)
+}
+
+// tryStmt generates a try statement the way the compiler does: the two
+// handlers come first, and the one of a missing clause becomes a jump to the
+// next instruction.
+func (g *generator) tryStmt(s *TryStmt) {
+	finallyHandler := g.emitJump(s.Try.Line, globals.OpTryFinally)
+	catchHandler := g.emitJump(s.Try.Line, globals.OpTry)
+	g.stmt(s.Body)
+
+	if s.CatchBody != nil {
+		g.emit(s.Catch.Line, globals.OpEndTry)
+		exitJump := g.emitJump(s.Catch.Line, globals.OpJump)
+		g.patchJump(catchHandler, s.Catch)
+		g.scopeDepth++
+		g.declare(*s.Name)
+		g.markInitialized()
+		g.stmt(s.CatchBody)
+		g.endScope(s.CatchBody.Rbrace.Line)
+		g.patchJump(exitJump, s.CatchBody.Rbrace)
+	} else {
+		g.removeHandler(catchHandler)
+	}
+
+	if s.FinallyBody == nil {
+		g.removeHandler(finallyHandler)
+		return
+	}
+	g.emit(s.Finally.Line, globals.OpEndTry)
+	g.emit(s.Finally.Line, globals.OpNil)
+	g.emit(s.Finally.Line, globals.OpNil)
+	g.patchJump(finallyHandler, s.Finally)
+	g.scopeDepth++
+	// Two hidden locals hold how the finally block was entered.
+	for i := 0; i < 2; i++ {
+		info := g.builder.AddLocal(src.LocalInfo{Slot: len(g.locals), Start: -1, End: -1})
+		g.locals = append(g.locals, local{depth: g.scopeDepth, info: info})
+	}
+	g.stmt(s.FinallyBody)
+	g.emit(s.FinallyBody.Rbrace.Line, globals.OpEndFinally)
+	// OpEndFinally pops the hidden locals itself.
+	g.scopeDepth--
+	for i := 0; i < 2; i++ {
+		g.builder.Local(g.locals[len(g.locals)-1].info).End = g.chunk.Count
+		g.locals = g.locals[:len(g.locals)-1]
+	}
+}
+
+// removeHandler turns the try instruction whose offset is at the given
+// position into a jump to the next instruction.
+func (g *generator) removeHandler(offset int) {
+	g.chunk.Code[offset-1] = uint8(globals.OpJump)
+	g.chunk.Code[offset] = 0
+	g.chunk.Code[offset+1] = 0
+}
+
+// function generates a function declaration's function and the
+// instruction that loads it.
+func (g *generator) function(s *FunDecl) {
+	f := g.nested(s.Name.Name, &s.Signature)
+	for _, stmt := range s.Body.Stmts {
+		f.stmt(stmt)
+	}
+	g.load(f, s.Body.Rbrace)
+}
+
+// nested returns the generator of a function nested in g's, with its
+// parameters declared and the code that assigns their default values.
+func (g *generator) nested(name string, sig *Signature) *generator {
+	f := newGenerator(name, len(sig.Params), g.file)
+	f.enclosing = g
+	f.builder.SetOptional(sig.Required(), sig.Variadic)
+	f.scopeDepth++
+	for i, param := range sig.Params {
+		f.declare(param)
+		if i < len(sig.Defaults) && sig.Defaults[i] != nil {
+			f.defaultValue(param, sig.Defaults[i])
+		}
+		f.define(0, param.NamePos.Line)
+	}
+	return f
+}
+
+// defaultValue generates the code that assigns the default value of the
+// parameter just declared when the call passes no argument for it. The
+// parameter is not in scope in its own default value.
+func (g *generator) defaultValue(param Ident, value Expr) {
+	slot := len(g.locals) - 1
+	hidden := g.locals[slot]
+	g.locals = g.locals[:slot]
+	line := param.NamePos.Line
+	g.emit(line, globals.OpArgMissing, uint8(slot))
+	jump := g.emitJump(line, globals.OpJumpFalse)
+	g.emit(line, globals.OpPop)
+	g.expr(value)
+	end := value.End()
+	g.emit(end.Line, globals.OpSetLocal, uint8(slot))
+	g.patchJump(jump, end)
+	g.emit(end.Line, globals.OpPop)
+	g.locals = append(g.locals, hidden)
+}
+
+// load finishes the nested function f at end, the position of its last
+// token, and emits the instruction that loads it.
+func (g *generator) load(f *generator, end Position) {
+	if function := f.finish(end); function != nil {
+		g.emit(end.Line, globals.OpConstant, g.makeConstant(src.ObjVal(function), end))
+	}
+}
+
+// lambdaName returns the name the compiler gives an anonymous function that
+// starts at pos.
+func lambdaName(pos Position) string {
+	return "lambda@" + strconv.Itoa(pos.Line)
+}
+
+func (g *generator) expr(expr Expr) {
+	switch x := expr.(type) {
+	case *Literal:
+		line := x.ValuePos.Line
+		switch x.Kind {
+		case globals.TokenNUMBER:
+			value, err := strconv.ParseFloat(x.Value, 64)
+			if err != nil {
+				g.error(x.ValuePos, x.Value, err.Error())
+			}
+			g.emitConstant(src.NumberValue(value), x.ValuePos)
+		case globals.TokenSTRING:
+			g.emitConstant(src.StringValue(x.Value[1:len(x.Value)-1]), x.ValuePos)
+		case globals.TokenTRUE:
+			g.emit(line, globals.OpTrue)
+		case globals.TokenFALSE:
+			g.emit(line, globals.OpFalse)
+		case globals.TokenNIL:
+			g.emit(line, globals.OpNil)
+		}
+	case *Variable:
+		getOp, _, arg := g.variable(x.Name)
+		g.emitVariable(x.Name.NamePos.Line, getOp, arg)
+	case *Assign:
+		getOp, setOp, arg := g.variable(x.Name)
+		g.assign(getOp, setOp, arg, x.Op, x.OpPos, x.Value)
+	case *SetMember:
+		slot, ok := g.moduleMember(x.X, x.Name)
+		if !ok {
+			g.error(x.OpPos, "=", "Invalid assignment target")
+			return
+		}
+		g.assign(globals.OpGetGlobal, globals.OpSetGlobal, slot, x.Op, x.OpPos, x.Value)
+	case *IncDec:
+		// The compiler emits a prefix operator at the name and a postfix one at the operator.
+		var getOp, setOp globals.OpCode
+		var arg int
+		var pos Position
+		switch target := x.X.(type) {
+		case *Variable:
+			getOp, setOp, arg = g.variable(target.Name)
+			pos = target.Name.NamePos
+		case *Member:
+			slot, ok := g.moduleMember(target.X, target.Name)
+			if !ok || target.Optional {
+				g.error(expr.Pos(), "", "Invalid increment target.")
+				return
+			}
+			getOp, setOp, arg = globals.OpGetGlobal, globals.OpSetGlobal, slot
+			pos = target.Name.NamePos
+		default:
+			g.error(expr.Pos(), "", "Invalid increment target.")
+			return
+		}
+		if x.Postfix {
+			pos = x.OpPos
+			g.emitVariable(pos.Line, getOp, arg)
+		}
+		line := pos.Line
+		g.emitVariable(line, getOp, arg)
+		if x.Op == globals.TokenPLUS_PLUS {
+			g.emit(line, globals.OpIncrement)
+		} else {
+			g.emit(line, globals.OpDecrement)
+		}
+		g.emitVariable(line, setOp, arg)
+		if x.Postfix {
+			g.emit(line, globals.OpPop)
+		}
+	case *Unary:
+		g.expr(x.X)
+		if x.Op == globals.TokenMINUS {
+			g.emit(x.X.End().Line, globals.OpNegate)
+		} else {
+			g.emit(x.X.End().Line, globals.OpNot)
+		}
+	case *Binary:
+		g.expr(x.X)
+		g.expr(x.Y)
+		line := x.Y.End().Line
+		switch x.Op {
+		case globals.TokenBANG_EQUAL:
+			g.emit(line, globals.OpEqual, uint8(globals.OpNot))
+		case globals.TokenEQUAL_EQUAL:
+			g.emit(line, globals.OpEqual)
+		case globals.TokenGREATER:
+			g.emit(line, globals.OpGreater)
+		case globals.TokenGREATER_EQUAL:
+			g.emit(line, globals.OpLess, uint8(globals.OpNot))
+		case globals.TokenLESS:
+			g.emit(line, globals.OpLess)
+		case globals.TokenLESS_EQUAL:
+			g.emit(line, globals.OpGreater, uint8(globals.OpNot))
+		case globals.TokenPLUS:
+			g.emit(line, globals.OpAdd)
+		case globals.TokenMINUS:
+			g.emit(line, globals.OpSubtract)
+		case globals.TokenSTAR:
+			g.emit(line, globals.OpMultiply)
+		case globals.TokenSLASH:
+			g.emit(line, globals.OpDivide)
+		case globals.TokenPERCENT:
+			g.emit(line, globals.OpModulo)
+		}
+	case *Logical:
+		g.expr(x.X)
+		line := x.OpPos.Line
+		if x.Op == globals.TokenAND {
+			endJump := g.emitJump(line, globals.OpJumpFalse)
+			g.emit(line, globals.OpPop)
+			g.expr(x.Y)
+			g.patchJump(endJump, x.End())
+		} else {
+			// An or goes on to Y when X is false, and a ?? when X is nil.
+			jumpOp := globals.OpJumpFalse
+			if x.Op == globals.TokenQUESTION_QUESTION {
+				jumpOp = globals.OpJumpNil
+			}
+			elseJump := g.emitJump(line, jumpOp)
+			endJump := g.emitJump(line, globals.OpJump)
+			g.patchJump(elseJump, x.OpPos)
+			g.emit(line, globals.OpPop)
+			g.expr(x.Y)
+			g.patchJump(endJump, x.End())
+		}
+	case *Conditional:
+		g.expr(x.Cond)
+		thenJump := g.emitJump(x.Question.Line, globals.OpJumpFalse)
+		g.emit(x.Question.Line, globals.OpPop)
+		g.expr(x.Then)
+		elseJump := g.emitJump(x.Colon.Line, globals.OpJump)
+		g.patchJump(thenJump, x.Colon)
+		g.emit(x.Colon.Line, globals.OpPop)
+		g.expr(x.Else)
+		g.patchJump(elseJump, x.End())
+	case *Grouping:
+		g.expr(x.X)
+	case *Call, *Member:
+		for _, jump := range g.chain(x) {
+			g.patchJump(jump, x.End())
+		}
+	case *FunLit:
+		f := g.nested(lambdaName(x.Fun), &x.Signature)
+		for _, stmt := range x.Body.Stmts {
+			f.stmt(stmt)
+		}
+		g.load(f, x.Body.Rbrace)
+	case *ArrowFunc:
+		f := g.nested(lambdaName(x.Lparen), &x.Signature)
+		f.expr(x.Body)
+		end := x.Body.End()
+		f.emit(end.Line, globals.OpReturn)
+		g.load(f, end)
+	default:
+		g.error(expr.Pos(), "", "Can't compile an expression with a syntax error.")
+	}
+}
+
+// chain generates a call or member access and the calls and accesses it is
+// made of. It returns the jumps of the ?. accesses among them for the caller
+// to patch to the end of the whole chain, so that nil?.a.b gives nil.
+func (g *generator) chain(expr Expr) []int {
+	switch x := expr.(type) {
+	case *Call:
+		jumps := g.chain(x.Callee)
+		for _, arg := range x.Args {
+			g.expr(arg)
+		}
+		g.emit(x.Rparen.Line, globals.OpCall, uint8(len(x.Args)))
+		return jumps
+	case *Member:
+		// The parser can't tell a module from a variable, so it reads ++m.name as
+		// (++m).name; the compiler increments the member.
+		if inc, ok := x.X.(*IncDec); ok && !inc.Postfix && !x.Optional && g.isModule(inc.X) {
+			g.expr(&IncDec{Op: inc.Op, OpPos: inc.OpPos, X: &Member{X: inc.X, Dot: x.Dot, Name: x.Name}})
+			return nil
+		}
+		if slot, ok := g.moduleMember(x.X, x.Name); ok {
+			if x.Optional {
+				g.error(x.Dot, "?.", "Expect '.' after module name.")
+			}
+			g.emitVariable(x.Name.NamePos.Line, globals.OpGetGlobal, slot)
+			return nil
+		}
+		jumps := g.chain(x.X)
+		name := x.Name.NamePos
+		if x.Optional {
+			jumps = append(jumps, g.emitJump(name.Line, globals.OpJumpNil))
+		}
+		g.emit(name.Line, globals.OpGetProperty, g.makeConstant(src.StringValue(x.Name.Name), name))
+		return jumps
+	}
+	g.expr(expr)
+	return nil
+}
+
+// declare declares a variable in the current scope and returns the global
+// slot to define it in, or 0 for a local.
+func (g *generator) declare(name Ident) int {
+	if g.scopeDepth == 0 {
+		if g.isImported(name.Name) {
+			g.error(name.NamePos, name.Name, "Can't redeclare an imported name.")
+		}
+		g.file.declared[name.Name] = true
+		return g.global(name)
+	}
+	for i := len(g.locals) - 1; i >= 0; i-- {
+		l := &g.locals[i]
+		if l.depth != -1 && l.depth < g.scopeDepth {
+			break
+		}
+		if l.name == name.Name {
+			g.error(name.NamePos, name.Name, "Already variable with this name in this scope")
+		}
+	}
+	if len(g.locals) == src.Uint8Count {
+		g.error(name.NamePos, name.Name, "Too many local variables in function")
+		return 0
+	}
+	info := g.builder.AddLocal(src.LocalInfo{Name: name.Name, Slot: len(g.locals), Start: -1, End: -1})
+	g.locals = append(g.locals, local{name: name.Name, depth: g.scopeDepth, info: info})
+	return 0
+}
+
+// define makes a declared variable available, defining a global with the
+// instruction at the given line.
+func (g *generator) define(global int, line int) {
+	if g.scopeDepth > 0 {
+		g.markInitialized()
+		return
+	}
+	g.emitVariable(line, globals.OpDefineGlobal, global)
+}
+
+// markInitialized records where the last declared local starts holding its value.
+func (g *generator) markInitialized() {
+	if g.scopeDepth == 0 {
+		return
+	}
+	l := &g.locals[len(g.locals)-1]
+	l.depth = g.scopeDepth
+	g.builder.Local(l.info).Start = g.chunk.Count
+}
+
+// endScope leaves a scope, popping its locals with instructions at the given line.
+func (g *generator) endScope(line int) {
+	g.scopeDepth--
+	for len(g.locals) > 0 && g.locals[len(g.locals)-1].depth > g.scopeDepth {
+		g.builder.Local(g.locals[len(g.locals)-1].info).End = g.chunk.Count
+		g.emit(line, globals.OpPop)
+		g.locals = g.locals[:len(g.locals)-1]
+	}
+}
+
+// resolve returns the slot of the local variable with the given name, or -1.
+func (g *generator) resolve(name string) int {
+	for i := len(g.locals) - 1; i >= 0; i-- {
+		if g.locals[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// variable returns the opcodes that read and write the variable name and
+// their operand.
+func (g *generator) variable(name Ident) (getOp, setOp globals.OpCode, arg int) {
+	if slot := g.resolve(name.Name); slot != -1 {
+		return globals.OpGetLocal, globals.OpSetLocal, slot
+	}
+	if slot, ok := g.file.names[name.Name]; ok {
+		return globals.OpGetGlobal, globals.OpSetGlobal, slot
+	}
+	if _, ok := g.file.modules[name.Name]; ok {
+		g.error(name.NamePos, name.Name, "Expect '.' after module name.")
+		return globals.OpGetGlobal, globals.OpSetGlobal, 0
+	}
+	for enclosing := g.enclosing; enclosing != nil; enclosing = enclosing.enclosing {
+		if enclosing.resolve(name.Name) != -1 {
+			g.error(name.NamePos, name.Name, "Can't use local variable '"+name.Name+"' of an enclosing function.")
+			break
+		}
+	}
+	return globals.OpGetGlobal, globals.OpSetGlobal, g.global(name)
+}
+
+// assign generates an assignment to the variable that getOp and setOp read
+// and write: with op TokenEQUAL it stores value, and with a compound
+// assignment operator it combines the variable with value first.
+func (g *generator) assign(getOp, setOp globals.OpCode, arg int, op globals.TokenType, opPos Position, value Expr) {
+	if op != globals.TokenEQUAL {
+		g.emitVariable(opPos.Line, getOp, arg)
+	}
+	g.expr(value)
+	line := value.End().Line
+	if op != globals.TokenEQUAL {
+		g.emit(line, compoundOps[op])
+	}
+	g.emitVariable(line, setOp, arg)
+}
+
+// importStmt generates an import. It loads the module with src.LoadModule,
+// which compiles it the first time the VM imports it, as src.CompileFile does.
+func (g *generator) importStmt(s *ImportStmt) {
+	if g.enclosing != nil || g.scopeDepth > 0 {
+		g.error(s.Import, "import", "Can only import at top level.")
+		return
+	}
+	module, err := src.LoadModule(g.file.path, s.Path.Value[1:len(s.Path.Value)-1])
+	if err != nil {
+		g.error(s.Path.ValuePos, s.Path.Value, err.Error())
+		return
+	}
+	if s.Alias != nil {
+		name := s.Alias.Name
+		if other, ok := g.file.modules[name]; ok && other.Function() != module.Function() {
+			g.error(s.Alias.NamePos, name, fmt.Sprintf("Already imported a module as '%s'.", name))
+			return
+		}
+		if g.file.declared[name] {
+			g.error(s.Alias.NamePos, name, fmt.Sprintf("'%s' is already declared in this file.", name))
+			return
+		}
+		g.file.modules[name] = module
+	} else {
+		members := module.Members()
+		for name, slot := range members {
+			if other, ok := g.file.names[name]; ok && other != slot {
+				g.error(s.Path.ValuePos, s.Path.Value, fmt.Sprintf("'%s' is already imported from another module.", name))
+				return
+			}
+			if g.file.declared[name] {
+				g.error(s.Path.ValuePos, s.Path.Value, fmt.Sprintf("'%s' is already declared in this file.", name))
+				return
+			}
+		}
+		for name, slot := range members {
+			g.file.names[name] = slot
+		}
+	}
+	g.emit(s.Semicolon.Line, globals.OpImport, g.moduleConstant(module, s.Path.ValuePos))
+	g.emit(s.Semicolon.Line, globals.OpPop)
+}
+
+// moduleConstant returns the constant of the chunk that holds the top level
+// of module, adding it the first time the chunk imports the module.
+func (g *generator) moduleConstant(module *src.Module, pos Position) uint8 {
+	constants := &g.chunk.Constants
+	for i := 0; i < constants.Count; i++ {
+		if src.IsFunction(constants.Values[i]) && src.AsFunction(constants.Values[i]) == module.Function() {
+			return uint8(i)
+		}
+	}
+	return g.makeConstant(src.ObjVal(module.Function()), pos)
+}
+
+// isModule reports whether x names a module imported with 'as'.
+func (g *generator) isModule(x Expr) bool {
+	variable, ok := x.(*Variable)
+	if !ok || g.resolve(variable.Name.Name) != -1 {
+		return false
+	}
+	_, ok = g.file.modules[variable.Name.Name]
+	return ok
+}
+
+// moduleMember returns the global slot of x.name if x names a module
+// imported with 'as', and whether it does.
+func (g *generator) moduleMember(x Expr, name Ident) (slot int, ok bool) {
+	if !g.isModule(x) {
+		return 0, false
+	}
+	variable := x.(*Variable)
+	slot, found := g.file.modules[variable.Name.Name].Members()[name.Name]
+	if !found {
+		g.error(name.NamePos, name.Name, fmt.Sprintf("Module '%s' has no member '%s'.", variable.Name.Name, name.Name))
+	}
+	return slot, true
+}
+
+// isImported reports whether name was imported into the file.
+func (g *generator) isImported(name string) bool {
+	_, isName := g.file.names[name]
+	_, isModule := g.file.modules[name]
+	return isName || isModule
+}
+
+// compoundOps maps each compound assignment operator to the opcode that
+// combines the variable with the assigned value.
+var compoundOps = map[globals.TokenType]globals.OpCode{
+	globals.TokenPLUS_EQUAL:    globals.OpAdd,
+	globals.TokenMINUS_EQUAL:   globals.OpSubtract,
+	globals.TokenSTAR_EQUAL:    globals.OpMultiply,
+	globals.TokenSLASH_EQUAL:   globals.OpDivide,
+	globals.TokenPERCENT_EQUAL: globals.OpModulo,
+}
+
+// global returns the slot of a global variable.
+func (g *generator) global(name Ident) int {
+	slot := src.GlobalSlot(name.Name)
+	if slot > math.MaxUint16 {
+		g.error(name.NamePos, name.Name, "Too many global variables")
+		return 0
+	}
+	return slot
+}
+
+// emitVariable emits an instruction that reads or writes a variable. A
+// local's slot takes one byte and a global's two.
+func (g *generator) emitVariable(line int, op globals.OpCode, arg int) {
+	switch op {
+	case globals.OpDefineGlobal, globals.OpGetGlobal, globals.OpSetGlobal, globals.OpSetGlobalPop:
+		g.emit(line, op, uint8(arg>>8), uint8(arg))
+	default:
+		g.emit(line, op, uint8(arg))
+	}
+}
+
+func (g *generator) emit(line int, op globals.OpCode, operands ...uint8) {
+	src.WriteChunk(g.chunk, uint8(op), line)
+	for _, operand := range operands {
+		src.WriteChunk(g.chunk, operand, line)
+	}
+}
+
+func (g *generator) emitReturn(line int) {
+	g.emit(line, globals.OpNil)
+	g.emit(line, globals.OpReturn)
+}
+
+func (g *generator) emitConstant(value src.Value, pos Position) {
+	g.emit(pos.Line, globals.OpConstant, g.makeConstant(value, pos))
+}
+
+func (g *generator) makeConstant(value src.Value, pos Position) uint8 {
+	constant := src.AddConstants(g.chunk, value)
+	if constant > src.StackMax {
+		g.error(pos, "", "Too many constants in one chunk")
+		return 0
+	}
+	return uint8(constant)
+}
+
+// emitJump emits a jump with a placeholder offset and returns the offset's position.
+func (g *generator) emitJump(line int, op globals.OpCode) int {
+	g.emit(line, op, 0xff, 0xff)
+	return g.chunk.Count - 2
+}
+
+// patchJump makes the jump whose offset is at the given position land on
+// the next instruction; pos is where that code comes from.
+func (g *generator) patchJump(offset int, pos Position) {
+	jump := g.chunk.Count - offset - 2
+	if jump > math.MaxUint16 {
+		g.error(pos, "", "Too much code to jump over")
+	}
+	g.chunk.Code[offset] = uint8((jump >> 8) & 0xff)
+	g.chunk.Code[offset+1] = uint8(jump & 0xff)
+}
+
+// emitLoop emits a jump back to loopStart at the line of pos.
+func (g *generator) emitLoop(loopStart int, pos Position) {
+	offset := g.chunk.Count - loopStart + 3
+	if offset > math.MaxUint16 {
+		g.error(pos, "", "Loop body too large")
+	}
+	g.emit(pos.Line, globals.OpLoop, uint8((offset>>8)&0xff), uint8(offset&0xff))
+}
+
+func (g *generator) error(pos Position, token string, message string) {
+	g.file.errors = append(g.file.errors, &Error{Pos: pos, Token: token, Message: message})
+}
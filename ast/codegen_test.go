@@ -0,0 +1,145 @@
+package ast
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+	"github.com/smekuria1/goclox/src"
+)
+
+// snippets cover the constructs the test scripts do not.
+var snippets = map[string]string{
+	"logical":         "var a = true;\nprint a and false or\n  !a;",
+	"locals":          "{\n  var a = 1;\n  {\n    var b = a;\n    a = b = 3;\n  }\n  print a;\n}",
+	"local function":  "{\n  fun inner(x, y) {\n    return x * y;\n  }\n  print inner(2, 3);\n}",
+	"empty for":       "fun f() {\n  for (;;) {\n    return;\n  }\n}\nf();",
+	"for expression":  "var i;\nfor (i = 0; i < 2;) i = i + 1;\nprint i;",
+	"for increment":   "for (var i = 0;; i = i + 1) if (i > 2) print i; else print -i;",
+	"multiline":       "print \"one\ntwo\"\n  + \"three\";\nprint (1 +\n  2) * 3;",
+	"global later":    "fun f() {\n  return later;\n}\nvar later = 1;\nprint f();",
+	"nested calls":    "fun id(x) { return x; }\nprint id(id)(id(1));",
+	"own initializer": "{\n  var a = 1;\n  {\n    var a = a;\n  }\n}",
+}
+
+// importModules are the modules importScript imports.
+var importModules = map[string]string{
+	"lib.clox":   "var count = 0;\nfun add(n) {\n  count = count + n;\n  return count;\n}",
+	"other.clox": "var value = 10;\nfun get() { return value; }",
+}
+
+// importScript uses the members of two modules in every form an import
+// allows.
+const importScript = `import "lib";
+import "other" as o;
+import "other.clox" as o;
+add(2);
+count -= 1;
+print count++;
+o.value = 1;
+o.value += 2;
+print o.value--;
+print ++o.value + o.get();
+fun f() {
+  return o.value * count;
+}
+print f();`
+
+// compileBoth compiles the script at path with src.CompileFile and with
+// Parse and CompileFile, each in a fresh VM, and returns the disassembly of
+// both.
+func compileBoth(t *testing.T, path, source string) (got, want []src.FunctionListing) {
+	t.Helper()
+	src.InitVM()
+	defer src.FreeVM()
+	var chunk src.Chunk
+	src.InitChunk(&chunk)
+	function := src.CompileFile(path, source, &chunk)
+	if function == nil {
+		t.Fatal("src.Compile failed")
+	}
+	want = src.Disassemble(function)
+
+	src.InitVM()
+	file, errs := Parse(source)
+	if len(errs) > 0 {
+		t.Fatalf("Parse: %v", errs)
+	}
+	function, errs = CompileFile(path, file)
+	if len(errs) > 0 {
+		t.Fatalf("CompileFile: %v", errs)
+	}
+	return src.Disassemble(function), want
+}
+
+func TestCompileMatchesCompiler(t *testing.T) {
+	type script struct{ path, source string }
+	scripts := make(map[string]script)
+	for name, source := range snippets {
+		scripts[name] = script{"", source}
+	}
+	paths, err := filepath.Glob("../src/testdata/*.clox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range append(paths, "../test.clox") {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts[filepath.Base(path)] = script{path, string(source)}
+	}
+	dir := t.TempDir()
+	for name, source := range importModules {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scripts["imports"] = script{filepath.Join(dir, "main.clox"), importScript}
+
+	defer func(optimize, super bool) {
+		globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS = optimize, super
+	}(globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS)
+	for _, mode := range []struct {
+		name            string
+		optimize, super bool
+	}{{"plain", false, false}, {"superinstructions", false, true}, {"optimized", true, true}} {
+		globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS = mode.optimize, mode.super
+		for name, script := range scripts {
+			t.Run(mode.name+"/"+name, func(t *testing.T) {
+				got, want := compileBoth(t, script.path, script.source)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("disassembly differs\ngot:  %+v\nwant: %+v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"redeclared local", "{\n  var a;\n  var a;\n}", "Error [line 3], at 'a': Already variable with this name in this scope"},
+		{"repeated parameter", "fun f(a, a) {}", "Error [line 1], at 'a': Already variable with this name in this scope"},
+		{"syntax error", "print ;", "Error [line 1],: Can't compile a statement with a syntax error."},
+		{"enclosing local", "fun outer(x) {\n  return () => x;\n}", "Error [line 2], at 'x': Can't use local variable 'x' of an enclosing function."},
+		{"missing module", "print 1;\nimport \"missing\";", "Error [line 2], at '\"missing\"': Can't find module 'missing'."},
+		{"nested import", "{\n  import \"missing\";\n}", "Error [line 2], at 'import': Can only import at top level."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src.InitVM()
+			defer src.FreeVM()
+			file, _ := Parse(tt.source)
+			function, errs := Compile(file)
+			if function != nil || len(errs) != 1 || errs[0].Error() != tt.want {
+				t.Errorf("Compile() = %v, %v, want the error %q", function, errs, tt.want)
+			}
+		})
+	}
+}
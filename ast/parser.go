@@ -0,0 +1,568 @@
+package ast
+
+import (
+	"fmt"
+
+	"github.com/smekuria1/goclox/globals"
+	"github.com/smekuria1/goclox/src"
+)
+
+// Error is a syntax or compile error at a token.
+type Error struct {
+	Pos     Position
+	Token   string // The offending token, or "" for a token the scanner rejected.
+	AtEnd   bool   // Whether the error is at the end of the file.
+	Message string
+}
+
+// Error formats the error as the compiler in package src prints it.
+func (e *Error) Error() string {
+	switch {
+	case e.AtEnd:
+		return fmt.Sprintf("Error [line %d], at end: %s", e.Pos.Line, e.Message)
+	case e.Token == "":
+		return fmt.Sprintf("Error [line %d],: %s", e.Pos.Line, e.Message)
+	}
+	return fmt.Sprintf("Error [line %d], at '%s': %s", e.Pos.Line, e.Token, e.Message)
+}
+
+// precedence orders the binding strength of operators, as in the compiler.
+type precedence int
+
+const (
+	precNone precedence = iota
+	precAssignment
+	precConditional
+	precCoalesce
+	precOr
+	precAnd
+	precEquality
+	precComparison
+	precTerm
+	precFactor
+	precUnary
+	precCall
+	precPrimary
+)
+
+// infixPrecedence is the precedence of each infix operator.
+var infixPrecedence = map[globals.TokenType]precedence{
+	globals.TokenLeftParen:         precCall,
+	globals.TokenDOT:               precCall,
+	globals.TokenQUESTION_DOT:      precCall,
+	globals.TokenQUESTION:          precConditional,
+	globals.TokenQUESTION_QUESTION: precCoalesce,
+	globals.TokenMINUS:             precTerm,
+	globals.TokenPLUS:              precTerm,
+	globals.TokenSLASH:             precFactor,
+	globals.TokenSTAR:              precFactor,
+	globals.TokenPERCENT:           precFactor,
+	globals.TokenBANG_EQUAL:        precEquality,
+	globals.TokenEQUAL_EQUAL:       precEquality,
+	globals.TokenGREATER:           precComparison,
+	globals.TokenGREATER_EQUAL:     precComparison,
+	globals.TokenLESS:              precComparison,
+	globals.TokenLESS_EQUAL:        precComparison,
+	globals.TokenAND:               precAnd,
+	globals.TokenOR:                precOr,
+}
+
+/*
+Parse parses the source of a script.
+
+It accepts the language the compiler in package src accepts, with the same
+error messages. After an error it skips to the next statement and goes on,
+so one call reports the errors of every statement.
+
+Parameters:
+- source: the source of a script.
+
+Returns:
+- *File: the syntax tree, with a BadExpr or BadStmt where there are errors.
+- []error: the syntax errors, each an *Error, in source order.
+*/
+func Parse(source string) (*File, []error) {
+	p := &parser{source: source}
+	p.scanner.InitScanner(source)
+	p.advance()
+	file := &File{}
+	for !p.match(globals.TokenEOF) {
+		file.Stmts = append(file.Stmts, p.declaration())
+	}
+	file.EOF = p.pos(&p.previous)
+	return file, p.errors
+}
+
+// parser holds the state of Parse.
+type parser struct {
+	source    string
+	scanner   src.Scanner
+	current   src.Token
+	previous  src.Token
+	errors    []error
+	panicMode bool // Set after an error until the parser resynchronizes.
+	functions int  // The number of function bodies being parsed.
+}
+
+func (p *parser) declaration() Stmt {
+	from := p.pos(&p.current)
+	var stmt Stmt
+	if p.match(globals.TokenFUN) {
+		stmt = p.funDecl()
+	} else if p.match(globals.TokenIMPORT) {
+		stmt = p.importStmt()
+	} else if p.match(globals.TokenVAR) {
+		stmt = p.varDecl()
+	} else {
+		stmt = p.statement()
+	}
+	if p.panicMode {
+		p.synchronize()
+		return &BadStmt{From: from, To: p.pos(&p.previous)}
+	}
+	return stmt
+}
+
+func (p *parser) funDecl() Stmt {
+	decl := &FunDecl{Fun: p.pos(&p.previous)}
+	decl.Name = p.ident("Expect function name.")
+	p.consume(globals.TokenLeftParen, "Expect '(' after function name.")
+	decl.Signature = p.params()
+	decl.Body = p.functionBody()
+	return decl
+}
+
+// params parses the parameters of a function up to and including the ')'
+// that ends them.
+func (p *parser) params() Signature {
+	var sig Signature
+	if !p.check(globals.TokenRightParen) {
+		for {
+			rest := p.match(globals.TokenELLIPSIS)
+			if len(sig.Params) == 255 {
+				p.errorAt(&p.current, "Can't have more than 255 parameters.")
+			}
+			sig.Params = append(sig.Params, p.ident("Expect parameter name."))
+			if rest {
+				sig.Variadic = true
+			} else if p.match(globals.TokenEQUAL) {
+				for len(sig.Defaults) < len(sig.Params)-1 {
+					sig.Defaults = append(sig.Defaults, nil)
+				}
+				sig.Defaults = append(sig.Defaults, p.expression())
+			} else if len(sig.Defaults) > 0 {
+				p.errorAt(&p.previous, "Expect '=' after a parameter that follows one with a default value.")
+			}
+			if !p.match(globals.TokenCOMMA) {
+				break
+			}
+			if rest {
+				p.errorAt(&p.current, "A rest parameter must be the last one.")
+			}
+		}
+	}
+	p.consume(globals.TokenRightParen, "Expect ')' after parameters.")
+	return sig
+}
+
+// functionBody parses the block of a function.
+func (p *parser) functionBody() *Block {
+	p.consume(globals.TokenLeftBrace, "Expect '{' before function body.")
+	p.functions++
+	body := p.block()
+	p.functions--
+	return body
+}
+
+// arrowAhead reports whether the '(' just consumed starts the parameters of
+// an arrow function: whether the matching ')' is followed by '=>'. It looks
+// ahead on a copy of the scanner.
+func (p *parser) arrowAhead() bool {
+	lookahead := p.scanner
+	token := p.current
+	for depth := 1; ; token = lookahead.ScanToken(lookahead.Source) {
+		switch token.TOKENType {
+		case globals.TokenLeftParen:
+			depth++
+		case globals.TokenRightParen:
+			if depth--; depth == 0 {
+				return lookahead.ScanToken(lookahead.Source).TOKENType == globals.TokenARROW
+			}
+		case globals.TokenEOF:
+			return false
+		}
+	}
+}
+
+func (p *parser) varDecl() *VarDecl {
+	decl := &VarDecl{Var: p.pos(&p.previous)}
+	decl.Name = p.ident("Expect variable name. ")
+	if p.match(globals.TokenEQUAL) {
+		decl.Init = p.expression()
+	}
+	p.consume(globals.TokenSEMICOLON, "Expect ';' after variable declaration.")
+	decl.Semicolon = p.pos(&p.previous)
+	return decl
+}
+
+// ident consumes a name, reporting message if there is none.
+func (p *parser) importStmt() Stmt {
+	stmt := &ImportStmt{Import: p.pos(&p.previous)}
+	if p.functions > 0 {
+		p.errorAt(&p.previous, "Can only import at top level.")
+	}
+	p.consume(globals.TokenSTRING, "Expect module path after 'import'.")
+	stmt.Path = &Literal{Kind: globals.TokenSTRING, Value: p.text(&p.previous), ValuePos: p.pos(&p.previous)}
+	if p.check(globals.TokenIDENTIFIER) && p.text(&p.current) == "as" {
+		p.advance()
+		alias := p.ident("Expect module name after 'as'.")
+		stmt.Alias = &alias
+	}
+	p.consume(globals.TokenSEMICOLON, "Expect ';' after import.")
+	stmt.Semicolon = p.pos(&p.previous)
+	return stmt
+}
+
+func (p *parser) ident(message string) Ident {
+	p.consume(globals.TokenIDENTIFIER, message)
+	return Ident{Name: p.text(&p.previous), NamePos: p.pos(&p.previous)}
+}
+
+func (p *parser) statement() Stmt {
+	switch {
+	case p.match(globals.TokenPRINT):
+		stmt := &PrintStmt{Print: p.pos(&p.previous), X: p.expression()}
+		p.consume(globals.TokenSEMICOLON, "Expect ';' after value.")
+		stmt.Semicolon = p.pos(&p.previous)
+		return stmt
+	case p.match(globals.TokenLeftBrace):
+		return p.block()
+	case p.match(globals.TokenIF):
+		return p.ifStmt()
+	case p.match(globals.TokenRETURN):
+		return p.returnStmt()
+	case p.match(globals.TokenFOR):
+		return p.forStmt()
+	case p.match(globals.TokenWHILE):
+		return p.whileStmt()
+	case p.match(globals.TokenTRY):
+		return p.tryStmt()
+	case p.match(globals.TokenTHROW):
+		stmt := &ThrowStmt{Throw: p.pos(&p.previous), Value: p.expression()}
+		p.consume(globals.TokenSEMICOLON, "Expect ';' after thrown value.")
+		stmt.Semicolon = p.pos(&p.previous)
+		return stmt
+	}
+	return p.exprStmt()
+}
+
+// block parses the statements of a block whose '{' was just consumed.
+func (p *parser) block() *Block {
+	block := &Block{Lbrace: p.pos(&p.previous)}
+	for !p.check(globals.TokenRightBrace) && !p.check(globals.TokenEOF) {
+		block.Stmts = append(block.Stmts, p.declaration())
+	}
+	p.consume(globals.TokenRightBrace, "Expect '}' after block")
+	block.Rbrace = p.pos(&p.previous)
+	return block
+}
+
+func (p *parser) ifStmt() Stmt {
+	stmt := &IfStmt{If: p.pos(&p.previous)}
+	p.consume(globals.TokenLeftParen, "Expect '(' after 'if'")
+	stmt.Cond = p.expression()
+	p.consume(globals.TokenRightParen, "Expect ')' after condition")
+	stmt.Rparen = p.pos(&p.previous)
+	stmt.Then = p.statement()
+	if p.match(globals.TokenELSE) {
+		stmt.Else = p.statement()
+	}
+	return stmt
+}
+
+func (p *parser) tryStmt() Stmt {
+	stmt := &TryStmt{Try: p.pos(&p.previous)}
+	p.consume(globals.TokenLeftBrace, "Expect '{' after 'try'.")
+	stmt.Body = p.block()
+	if p.match(globals.TokenCATCH) {
+		stmt.Catch = p.pos(&p.previous)
+		p.consume(globals.TokenLeftParen, "Expect '(' after 'catch'.")
+		name := p.ident("Expect exception variable name.")
+		stmt.Name = &name
+		p.consume(globals.TokenRightParen, "Expect ')' after exception variable.")
+		p.consume(globals.TokenLeftBrace, "Expect '{' after catch clause.")
+		stmt.CatchBody = p.block()
+	}
+	if p.match(globals.TokenFINALLY) {
+		stmt.Finally = p.pos(&p.previous)
+		p.consume(globals.TokenLeftBrace, "Expect '{' after 'finally'.")
+		stmt.FinallyBody = p.block()
+	} else if stmt.CatchBody == nil {
+		p.errorAt(&p.current, "Expect 'catch' or 'finally' after try block.")
+	}
+	return stmt
+}
+
+func (p *parser) returnStmt() Stmt {
+	stmt := &ReturnStmt{Return: p.pos(&p.previous)}
+	if p.functions == 0 {
+		p.errorAt(&p.previous, "Can't return from top-level code.")
+	}
+	if !p.match(globals.TokenSEMICOLON) {
+		stmt.Value = p.expression()
+		p.consume(globals.TokenSEMICOLON, "Expect ';' after return value.")
+	}
+	stmt.Semicolon = p.pos(&p.previous)
+	return stmt
+}
+
+func (p *parser) forStmt() Stmt {
+	stmt := &ForStmt{For: p.pos(&p.previous)}
+	p.consume(globals.TokenLeftParen, "Expect '(' after 'for'.")
+	if p.match(globals.TokenSEMICOLON) {
+		// No initializer.
+	} else if p.match(globals.TokenVAR) {
+		stmt.Init = p.varDecl()
+	} else {
+		stmt.Init = p.exprStmt()
+	}
+
+	if !p.match(globals.TokenSEMICOLON) {
+		stmt.Cond = p.expression()
+		p.consume(globals.TokenSEMICOLON, "Expect ';' after loop condition")
+	}
+	stmt.Semicolon = p.pos(&p.previous)
+
+	if !p.match(globals.TokenRightParen) {
+		stmt.Incr = p.expression()
+		p.consume(globals.TokenRightParen, "Expect ')' after for clauses.")
+	}
+	stmt.Rparen = p.pos(&p.previous)
+	stmt.Body = p.statement()
+	return stmt
+}
+
+func (p *parser) whileStmt() Stmt {
+	stmt := &WhileStmt{While: p.pos(&p.previous)}
+	p.consume(globals.TokenLeftParen, "Expect '(' after 'while'")
+	stmt.Cond = p.expression()
+	p.consume(globals.TokenRightParen, "Expect ')' after condition")
+	stmt.Rparen = p.pos(&p.previous)
+	stmt.Body = p.statement()
+	return stmt
+}
+
+func (p *parser) exprStmt() *ExprStmt {
+	stmt := &ExprStmt{X: p.expression()}
+	p.consume(globals.TokenSEMICOLON, "Expect ';' after expression.")
+	stmt.Semicolon = p.pos(&p.previous)
+	return stmt
+}
+
+func (p *parser) expression() Expr {
+	return p.parsePrecedence(precAssignment)
+}
+
+// parsePrecedence parses an expression whose operators bind at least as
+// tightly as prec.
+func (p *parser) parsePrecedence(prec precedence) Expr {
+	p.advance()
+	canAssign := prec <= precAssignment
+	token := p.previous
+	var x Expr
+	switch token.TOKENType {
+	case globals.TokenLeftParen:
+		if p.arrowAhead() {
+			arrow := &ArrowFunc{Lparen: p.pos(&token), Signature: p.params()}
+			p.consume(globals.TokenARROW, "Expect '=>' after parameters.")
+			arrow.Arrow = p.pos(&p.previous)
+			arrow.Body = p.expression()
+			x = arrow
+			break
+		}
+		group := &Grouping{Lparen: p.pos(&token), X: p.expression()}
+		p.consume(globals.TokenRightParen, "Expect ')' after the expression")
+		group.Rparen = p.pos(&p.previous)
+		x = group
+	case globals.TokenFUN:
+		lambda := &FunLit{Fun: p.pos(&token)}
+		p.consume(globals.TokenLeftParen, "Expect '(' after 'fun'.")
+		lambda.Signature = p.params()
+		lambda.Body = p.functionBody()
+		x = lambda
+	case globals.TokenMINUS, globals.TokenBANG:
+		x = &Unary{Op: token.TOKENType, OpPos: p.pos(&token), X: p.parsePrecedence(precUnary)}
+	case globals.TokenPLUS_PLUS, globals.TokenMINUS_MINUS:
+		name := p.ident(fmt.Sprintf("Expect variable name after '%s'.", p.text(&token)))
+		x = &IncDec{Op: token.TOKENType, OpPos: p.pos(&token), X: &Variable{Name: name}}
+	case globals.TokenIDENTIFIER:
+		name := Ident{Name: p.text(&token), NamePos: p.pos(&token)}
+		if canAssign && p.matchAssign() {
+			operator := p.previous
+			x = &Assign{Name: name, Op: operator.TOKENType, OpPos: p.pos(&operator), Value: p.expression()}
+		} else {
+			x = p.postfix(&Variable{Name: name})
+		}
+	case globals.TokenNUMBER, globals.TokenSTRING, globals.TokenTRUE, globals.TokenFALSE, globals.TokenNIL:
+		x = &Literal{Kind: token.TOKENType, Value: p.text(&token), ValuePos: p.pos(&token)}
+	default:
+		p.errorAt(&p.previous, "Expect expression")
+		return &BadExpr{From: p.pos(&token)}
+	}
+
+	for prec <= infixPrecedence[p.current.TOKENType] {
+		p.advance()
+		operator := p.previous
+		switch operator.TOKENType {
+		case globals.TokenLeftParen:
+			x = p.call(x)
+		case globals.TokenDOT:
+			dot := p.pos(&operator)
+			name := p.ident("Expect member name after '.'.")
+			if canAssign && p.matchAssign() {
+				operator := p.previous
+				x = &SetMember{X: x, Name: name, Op: operator.TOKENType, OpPos: p.pos(&operator), Value: p.expression()}
+			} else {
+				x = p.postfix(&Member{X: x, Dot: dot, Name: name})
+			}
+		case globals.TokenAND:
+			x = &Logical{X: x, Op: operator.TOKENType, OpPos: p.pos(&operator), Y: p.parsePrecedence(precAnd)}
+		case globals.TokenOR:
+			x = &Logical{X: x, Op: operator.TOKENType, OpPos: p.pos(&operator), Y: p.parsePrecedence(precOr)}
+		case globals.TokenQUESTION_QUESTION:
+			x = &Logical{X: x, Op: operator.TOKENType, OpPos: p.pos(&operator), Y: p.parsePrecedence(precCoalesce)}
+		case globals.TokenQUESTION_DOT:
+			name := p.ident("Expect property name after '?.'.")
+			x = &Member{X: x, Dot: p.pos(&operator), Name: name, Optional: true}
+		case globals.TokenQUESTION:
+			cond := &Conditional{Cond: x, Question: p.pos(&operator), Then: p.expression()}
+			p.consume(globals.TokenCOLON, "Expect ':' after then branch of conditional expression.")
+			cond.Colon = p.pos(&p.previous)
+			cond.Else = p.parsePrecedence(precConditional)
+			x = cond
+		default:
+			y := p.parsePrecedence(infixPrecedence[operator.TOKENType] + 1)
+			x = &Binary{X: x, Op: operator.TOKENType, OpPos: p.pos(&operator), Y: y}
+		}
+	}
+
+	if p.match(globals.TokenPLUS_PLUS) || p.match(globals.TokenMINUS_MINUS) {
+		p.errorAt(&token, "Invalid increment target.")
+	}
+	if canAssign && p.matchAssign() {
+		p.errorAt(&p.previous, "Invalid assignment target")
+	}
+	return x
+}
+
+// matchAssign consumes '=' or a compound assignment operator if it is next.
+func (p *parser) matchAssign() bool {
+	switch p.current.TOKENType {
+	case globals.TokenEQUAL, globals.TokenPLUS_EQUAL, globals.TokenMINUS_EQUAL, globals.TokenSTAR_EQUAL,
+		globals.TokenSLASH_EQUAL, globals.TokenPERCENT_EQUAL:
+		p.advance()
+		return true
+	}
+	return false
+}
+
+// postfix wraps x in an IncDec if a postfix ++ or -- follows it.
+func (p *parser) postfix(x Expr) Expr {
+	if !p.match(globals.TokenPLUS_PLUS) && !p.match(globals.TokenMINUS_MINUS) {
+		return x
+	}
+	return &IncDec{Op: p.previous.TOKENType, OpPos: p.pos(&p.previous), X: x, Postfix: true}
+}
+
+// call parses the arguments of a call whose '(' was just consumed.
+func (p *parser) call(callee Expr) Expr {
+	call := &Call{Callee: callee, Lparen: p.pos(&p.previous)}
+	if !p.check(globals.TokenRightParen) {
+		for {
+			call.Args = append(call.Args, p.expression())
+			if len(call.Args) == 255 {
+				p.errorAt(&p.current, "Can't have more than 255 arguments.")
+			}
+			if !p.match(globals.TokenCOMMA) {
+				break
+			}
+		}
+	}
+	p.consume(globals.TokenRightParen, "Expect ')' after arguments.")
+	call.Rparen = p.pos(&p.previous)
+	return call
+}
+
+// synchronize skips tokens until the end of a statement, the start of the
+// next one or the end of the block, as the compiler does.
+func (p *parser) synchronize() {
+	p.panicMode = false
+	for p.current.TOKENType != globals.TokenEOF {
+		if p.previous.TOKENType == globals.TokenSEMICOLON {
+			return
+		}
+		switch p.current.TOKENType {
+		case globals.TokenRightBrace, globals.TokenCLASS, globals.TokenFUN, globals.TokenVAR, globals.TokenFOR,
+			globals.TokenIF, globals.TokenWHILE, globals.TokenPRINT, globals.TokenRETURN, globals.TokenIMPORT,
+			globals.TokenTRY, globals.TokenTHROW:
+			return
+		}
+		p.advance()
+	}
+}
+
+func (p *parser) advance() {
+	p.previous = p.current
+	for {
+		p.current = p.scanner.ScanToken(p.scanner.Source)
+		if p.current.TOKENType != globals.TokenERROR {
+			return
+		}
+		p.errorAt(&p.current, p.scanner.Message)
+	}
+}
+
+func (p *parser) check(kind globals.TokenType) bool {
+	return p.current.TOKENType == kind
+}
+
+func (p *parser) match(kind globals.TokenType) bool {
+	if !p.check(kind) {
+		return false
+	}
+	p.advance()
+	return true
+}
+
+func (p *parser) consume(kind globals.TokenType, message string) {
+	if p.check(kind) {
+		p.advance()
+		return
+	}
+	p.errorAt(&p.current, message)
+}
+
+// errorAt records an error at token unless the parser is already in panic mode.
+func (p *parser) errorAt(token *src.Token, message string) {
+	if p.panicMode {
+		return
+	}
+	p.panicMode = true
+	err := &Error{Pos: p.pos(token), Message: message}
+	switch token.TOKENType {
+	case globals.TokenEOF:
+		err.AtEnd = true
+	case globals.TokenERROR:
+	default:
+		err.Token = p.text(token)
+	}
+	p.errors = append(p.errors, err)
+}
+
+func (p *parser) pos(token *src.Token) Position {
+	return Position{Offset: token.Start, Line: token.Line, Column: token.Pos.Column}
+}
+
+func (p *parser) text(token *src.Token) string {
+	return p.source[token.Start : token.Start+token.Length]
+}
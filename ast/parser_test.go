@@ -0,0 +1,120 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+func TestParsePositions(t *testing.T) {
+	source := "fun add(a, b) {\n  return a +\n    b;\n}\nprint add(1, 2);\n"
+	file, errs := Parse(source)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(file.Stmts) != 2 {
+		t.Fatalf("got %d statements, want 2", len(file.Stmts))
+	}
+
+	fun := file.Stmts[0].(*FunDecl)
+	if want := (Ident{Name: "add", NamePos: Position{Offset: 4, Line: 1, Column: 5}}); fun.Name != want {
+		t.Errorf("function name = %+v, want %+v", fun.Name, want)
+	}
+	if want := []Ident{{"a", Position{8, 1, 9}}, {"b", Position{11, 1, 12}}}; !reflect.DeepEqual(fun.Params, want) {
+		t.Errorf("params = %+v, want %+v", fun.Params, want)
+	}
+	if want := (Position{Offset: 36, Line: 4, Column: 1}); fun.End() != want {
+		t.Errorf("function ends at %+v, want %+v", fun.End(), want)
+	}
+
+	ret := fun.Body.Stmts[0].(*ReturnStmt)
+	sum := ret.Value.(*Binary)
+	if sum.Op != globals.TokenPLUS || sum.OpPos != (Position{Offset: 27, Line: 2, Column: 12}) {
+		t.Errorf("operator %v at %+v, want + at offset 27 on line 2", sum.Op, sum.OpPos)
+	}
+	if sum.Pos() != (Position{Offset: 25, Line: 2, Column: 10}) || sum.End() != (Position{Offset: 33, Line: 3, Column: 5}) {
+		t.Errorf("sum spans %+v to %+v", sum.Pos(), sum.End())
+	}
+
+	call := file.Stmts[1].(*PrintStmt).X.(*Call)
+	if len(call.Args) != 2 || call.Lparen != (Position{47, 5, 10}) || call.Rparen != (Position{52, 5, 15}) {
+		t.Errorf("call = %+v", call)
+	}
+	if file.EOF != (Position{Offset: len(source), Line: 6, Column: 1}) {
+		t.Errorf("EOF = %+v", file.EOF)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"missing operand", "print 1 +;", []string{"Error [line 1], at ';': Expect expression"}},
+		{"at end", "print", []string{"Error [line 1], at end: Expect expression"}},
+		{"at the next token", "print 1", []string{"Error [line 1], at end: Expect ';' after value."}},
+		{"bad character", "print #;", []string{"Error [line 1],: Unexpected character."}},
+		{"assignment target", "a + b = c;", []string{"Error [line 1], at '=': Invalid assignment target"}},
+		{"compound assignment target", "a + b += c;", []string{"Error [line 1], at '+=': Invalid assignment target"}},
+		{"increment target", "1++;", []string{"Error [line 1], at '1': Invalid increment target."}},
+		{"decrement target", "print a * (b)--;", []string{"Error [line 1], at '(': Invalid increment target."}},
+		{"conditional without else", "print a ? b;", []string{"Error [line 1], at ';': Expect ':' after then branch of conditional expression."}},
+		{"conditional assignment target", "a ? b : c = d;", []string{"Error [line 1], at '=': Invalid assignment target"}},
+		{"safe access without a name", "print a?.;", []string{"Error [line 1], at ';': Expect property name after '?.'."}},
+		{"increment without a variable", "++1;", []string{"Error [line 1], at '1': Expect variable name after '++'."}},
+		{"top-level return", "return 1;", []string{"Error [line 1], at 'return': Can't return from top-level code."}},
+		{"import in a function", "fun f() { import \"lib\"; }", []string{"Error [line 1], at 'import': Can only import at top level."}},
+		{"import without a path", "import lib;", []string{"Error [line 1], at 'lib': Expect module path after 'import'."}},
+		{"try without a clause", "try {} print 1;", []string{"Error [line 1], at 'print': Expect 'catch' or 'finally' after try block."}},
+		{"arrow without a body", "var f = (a) => ;", []string{"Error [line 1], at ';': Expect expression"}},
+		{"lambda without parameters", "var f = fun {};", []string{"Error [line 1], at '{': Expect '(' after 'fun'."}},
+		{"required after default", "fun f(a = 1, b) {}", []string{"Error [line 1], at 'b': Expect '=' after a parameter that follows one with a default value."}},
+		{"rest before another", "fun f(...a, b) {}", []string{"Error [line 1], at 'b': A rest parameter must be the last one."}},
+		{"several statements", "var = 1;\nprint 2;\nfun (x) {}\nprint ;", []string{
+			"Error [line 1], at '=': Expect variable name. ",
+			"Error [line 3], at '(': Expect function name.",
+			"Error [line 4], at ';': Expect expression",
+		}},
+		{"resumes at keywords", "print 1 print 2; var x = ;", []string{
+			"Error [line 1], at 'print': Expect ';' after value.",
+			"Error [line 1], at ';': Expect expression",
+		}},
+		{"resumes at the end of a block", "{ print 1 } print 2 +;", []string{
+			"Error [line 1], at '}': Expect ';' after value.",
+			"Error [line 1], at ';': Expect expression",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Parse(tt.source)
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("errors = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeepsGoodStatements(t *testing.T) {
+	file, errs := Parse("print 1;\nprint ;\nprint 3;")
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want one", errs)
+	}
+	var kinds []string
+	for _, stmt := range file.Stmts {
+		switch stmt.(type) {
+		case *PrintStmt:
+			kinds = append(kinds, "print")
+		case *BadStmt:
+			kinds = append(kinds, "bad")
+		}
+	}
+	if want := []string{"print", "bad", "print"}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("statements = %v, want %v", kinds, want)
+	}
+}
@@ -0,0 +1,242 @@
+package ast
+
+import (
+	"io"
+	"strings"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// operators maps each operator token to its text.
+var operators = map[globals.TokenType]string{
+	globals.TokenMINUS:             "-",
+	globals.TokenPLUS:              "+",
+	globals.TokenSLASH:             "/",
+	globals.TokenSTAR:              "*",
+	globals.TokenPERCENT:           "%",
+	globals.TokenBANG:              "!",
+	globals.TokenBANG_EQUAL:        "!=",
+	globals.TokenEQUAL_EQUAL:       "==",
+	globals.TokenGREATER:           ">",
+	globals.TokenGREATER_EQUAL:     ">=",
+	globals.TokenLESS:              "<",
+	globals.TokenLESS_EQUAL:        "<=",
+	globals.TokenAND:               "and",
+	globals.TokenOR:                "or",
+	globals.TokenQUESTION_QUESTION: "??",
+	globals.TokenEQUAL:             "=",
+	globals.TokenPLUS_EQUAL:        "+=",
+	globals.TokenMINUS_EQUAL:       "-=",
+	globals.TokenSTAR_EQUAL:        "*=",
+	globals.TokenSLASH_EQUAL:       "/=",
+	globals.TokenPERCENT_EQUAL:     "%=",
+	globals.TokenPLUS_PLUS:         "++",
+	globals.TokenMINUS_MINUS:       "--",
+}
+
+/*
+Fprint writes a node as a parenthesized prefix tree, in the style of the
+AstPrinter of Crafting Interpreters: (+ 1 (* 2 3)).
+
+Expressions print on one line. Each statement nested in a block, function,
+if or loop starts a new line, indented two spaces per level, and the
+statements of a file print one per line. Missing for clauses print as _,
+and a postfix ++ or -- prints as post++ or post--.
+
+Parameters:
+- w: where to write.
+- node: the node to print.
+
+Returns:
+- error: the error of the first write that failed.
+*/
+func Fprint(w io.Writer, node Node) error {
+	var p printer
+	p.node(node)
+	_, err := io.WriteString(w, p.out.String())
+	return err
+}
+
+// Sprint returns a node as Fprint writes it.
+func Sprint(node Node) string {
+	var out strings.Builder
+	Fprint(&out, node)
+	return out.String()
+}
+
+// printer accumulates the output of Fprint.
+type printer struct {
+	out   strings.Builder
+	depth int // The nesting depth of the statement being printed.
+}
+
+func (p *printer) node(node Node) {
+	switch n := node.(type) {
+	case *BadExpr, *BadStmt:
+		p.out.WriteString("(bad)")
+	case *Literal:
+		p.out.WriteString(n.Value)
+	case *Variable:
+		p.out.WriteString(n.Name.Name)
+	case *Assign:
+		p.list(operators[n.Op], n.Name.Name, n.Value)
+	case *Unary:
+		p.list(operators[n.Op], n.X)
+	case *IncDec:
+		if n.Postfix {
+			p.list("post"+operators[n.Op], n.X)
+		} else {
+			p.list(operators[n.Op], n.X)
+		}
+	case *Binary:
+		p.list(operators[n.Op], n.X, n.Y)
+	case *Logical:
+		p.list(operators[n.Op], n.X, n.Y)
+	case *Conditional:
+		p.list("?:", n.Cond, n.Then, n.Else)
+	case *Grouping:
+		p.list("group", n.X)
+	case *Call:
+		items := []any{n.Callee}
+		for _, arg := range n.Args {
+			items = append(items, arg)
+		}
+		p.list("call", items...)
+	case *FunLit:
+		items := []any{params(&n.Signature)}
+		for _, stmt := range n.Body.Stmts {
+			items = append(items, stmt)
+		}
+		p.list("fun", items...)
+	case *ArrowFunc:
+		p.list("=>", params(&n.Signature), n.Body)
+	case *Member:
+		if n.Optional {
+			p.list("?.", n.X, n.Name.Name)
+		} else {
+			p.list(".", n.X, n.Name.Name)
+		}
+	case *SetMember:
+		if n.Op == globals.TokenEQUAL {
+			p.list("set", n.X, n.Name.Name, n.Value)
+		} else {
+			p.list("set"+operators[n.Op], n.X, n.Name.Name, n.Value)
+		}
+
+	case *ExprStmt:
+		p.list("expr", n.X)
+	case *PrintStmt:
+		p.list("print", n.X)
+	case *VarDecl:
+		if n.Init == nil {
+			p.list("var", n.Name.Name)
+		} else {
+			p.list("var", n.Name.Name, n.Init)
+		}
+	case *FunDecl:
+		items := []any{n.Name.Name, params(&n.Signature)}
+		for _, stmt := range n.Body.Stmts {
+			items = append(items, stmt)
+		}
+		p.list("fun", items...)
+	case *Block:
+		items := make([]any, len(n.Stmts))
+		for i, stmt := range n.Stmts {
+			items[i] = stmt
+		}
+		p.list("block", items...)
+	case *IfStmt:
+		if n.Else == nil {
+			p.list("if", n.Cond, n.Then)
+		} else {
+			p.list("if", n.Cond, n.Then, n.Else)
+		}
+	case *WhileStmt:
+		p.list("while", n.Cond, n.Body)
+	case *ForStmt:
+		items := []any{"_", "_", "_", n.Body}
+		if n.Init != nil {
+			items[0] = Sprint(n.Init) // A declaration or expression, which fits on one line.
+		}
+		if n.Cond != nil {
+			items[1] = n.Cond
+		}
+		if n.Incr != nil {
+			items[2] = n.Incr
+		}
+		p.list("for", items...)
+	case *ImportStmt:
+		if n.Alias == nil {
+			p.list("import", n.Path)
+		} else {
+			p.list("import", n.Path, "as", n.Alias.Name)
+		}
+	case *TryStmt:
+		p.out.WriteString("(try")
+		p.depth++
+		p.out.WriteString("\n" + strings.Repeat("  ", p.depth))
+		p.node(n.Body)
+		if n.CatchBody != nil {
+			p.out.WriteString("\n" + strings.Repeat("  ", p.depth))
+			p.list("catch", n.Name.Name, n.CatchBody)
+		}
+		if n.FinallyBody != nil {
+			p.out.WriteString("\n" + strings.Repeat("  ", p.depth))
+			p.list("finally", n.FinallyBody)
+		}
+		p.depth--
+		p.out.WriteByte(')')
+	case *ThrowStmt:
+		p.list("throw", n.Value)
+	case *ReturnStmt:
+		if n.Value == nil {
+			p.list("return")
+		} else {
+			p.list("return", n.Value)
+		}
+	case *File:
+		for i, stmt := range n.Stmts {
+			if i > 0 {
+				p.out.WriteByte('\n')
+			}
+			p.node(stmt)
+		}
+	}
+}
+
+// params returns a function's parameters in parentheses: a parameter with a
+// default value as (= name value) and a rest parameter as ...name.
+func params(sig *Signature) string {
+	names := make([]string, len(sig.Params))
+	for i, param := range sig.Params {
+		names[i] = param.Name
+		if i < len(sig.Defaults) && sig.Defaults[i] != nil {
+			names[i] = "(= " + param.Name + " " + Sprint(sig.Defaults[i]) + ")"
+		}
+	}
+	if sig.Variadic {
+		names[len(names)-1] = "..." + names[len(names)-1]
+	}
+	return "(" + strings.Join(names, " ") + ")"
+}
+
+// list prints a parenthesized list of a head and items, each a string or a
+// Node. Statements nested in a statement go on lines of their own.
+func (p *printer) list(head string, items ...any) {
+	p.out.WriteString("(" + head)
+	for _, item := range items {
+		switch item := item.(type) {
+		case string:
+			p.out.WriteString(" " + item)
+		case Stmt:
+			p.depth++
+			p.out.WriteString("\n" + strings.Repeat("  ", p.depth))
+			p.node(item)
+			p.depth--
+		case Node:
+			p.out.WriteByte(' ')
+			p.node(item)
+		}
+	}
+	p.out.WriteByte(')')
+}
@@ -0,0 +1,37 @@
+package ast
+
+import "testing"
+
+func TestSprint(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"print -1 + 2 * (3 - x);", "(print (+ (- 1) (* 2 (group (- 3 x)))))"},
+		{"a = b = c or d and !e;", "(expr (= a (= b (or c (and d (! e))))))"},
+		{"f(1)(g, \"s\");", "(expr (call (call f 1) g \"s\"))"},
+		{"var x;", "(var x)"},
+		{"if (x) { print 1; } else print 2;", "(if x\n  (block\n    (print 1))\n  (print 2))"},
+		{"for (;;) x;", "(for _ _ _\n  (expr x))"},
+		{"for (var i = 0; i < 2; i = i + 1) {}", "(for (var i 0) (< i 2) (= i (+ i 1))\n  (block))"},
+		{"fun f(a, b) { while (a) return; }", "(fun f (a b)\n  (while a\n    (return)))"},
+		{"try { throw e.message; } catch (e) {} finally { print 1; }", "(try\n  (block\n    (throw (. e message)))\n  (catch e\n    (block))\n  (finally\n    (block\n      (print 1))))"},
+		{"f(fun (a) { return a; }, () => 1, (a, b) => (a));", "(expr (call f (fun (a)\n  (return a)) (=> () 1) (=> (a b) (group a))))"},
+		{"fun f(a, b = a + 1, ...rest) {}", "(fun f (a (= b (+ a 1)) ...rest))"},
+		{"a += b -= c % 2; print -x++ - --y;", "(expr (+= a (-= b (% c 2))))\n(print (- (- (post++ x)) (-- y)))"},
+		{"import \"m.clox\" as m; m.x *= 2; m.y--;", "(import \"m.clox\" as m)\n(expr (set*= m x 2))\n(expr (post-- (. m y)))"},
+		{"print a ? b : c ? d : e ?? f or g;", "(print (?: a b (?: c d (?? e (or f g)))))"},
+		{"print e?.message.length ?? -1;", "(print (?? (. (?. e message) length) (- 1)))"},
+		{"print 1; print 2;", "(print 1)\n(print 2)"},
+		{"import \"lib\"; import \"m.clox\" as m; m.x = m.y;", "(import \"lib\")\n(import \"m.clox\" as m)\n(expr (set m x (. m y)))"},
+	}
+	for _, tt := range tests {
+		file, errs := Parse(tt.source)
+		if len(errs) > 0 {
+			t.Fatalf("Parse(%q): %v", tt.source, errs)
+		}
+		if got := Sprint(file); got != tt.want {
+			t.Errorf("Sprint(%q) =\n%s\nwant\n%s", tt.source, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/smekuria1/goclox/dap"
+	"github.com/smekuria1/goclox/format"
+	"github.com/smekuria1/goclox/globals"
+	"github.com/smekuria1/goclox/internal/diff"
+	"github.com/smekuria1/goclox/lint"
+	"github.com/smekuria1/goclox/lsp"
+	"github.com/smekuria1/goclox/src"
+)
+
+// Exit codes of the subcommands, following the sysexits values clox uses.
+const (
+	exitUsage        = 64
+	exitCompileError = 65
+	exitRuntimeError = 70
+	exitIOError      = 74
+)
+
+// commands maps each subcommand name to the function that runs it.
+var commands = map[string]func(args []string) int{
+	"build":  buildCommand,
+	"run":    runCommand,
+	"disasm": disasmCommand,
+	"debug":  debugCommand,
+	"dap":    dapCommand,
+	"lsp":    lspCommand,
+	"fmt":    fmtCommand,
+	"lint":   lintCommand,
+}
+
+// newCommandFlags returns a flag set for a subcommand with the compiler and
+// VM options every subcommand shares.
+func newCommandFlags(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.BoolVar(&globals.DEBUG_TRACE_EXECUTION, "debugT", false, "Turn on debug trace execution mode")
+	flags.BoolVar(&globals.DEBUG_PRINT_CODE, "debugC", false, "Turn on debug print code mode")
+	flags.BoolVar(&globals.OPTIMIZE_CODE, "O", false, "Optimize compiled bytecode")
+	flags.BoolVar(&globals.REGISTER_VM, "reg", false, "Run on the experimental register VM")
+	flags.BoolVar(&globals.VERIFY_CODE, "verify", false, "Verify compiled bytecode before running it")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: goclox %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// buildCommand compiles a script to a bytecode file.
+//
+// Usage: goclox build [-o out.cloxc] file.clox
+func buildCommand(args []string) int {
+	flags := newCommandFlags("build", "build [-o out.cloxc] file.clox")
+	output := flags.String("o", "", "Path of the bytecode file (default: the input with a .cloxc extension)")
+	if flags.Parse(args) != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	path := flags.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".cloxc"
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	src.InitVM()
+	defer src.FreeVM()
+	var chunk src.Chunk
+	src.InitChunk(&chunk)
+	function := src.CompileFile(path, string(source), &chunk)
+	if function == nil {
+		return exitCompileError
+	}
+
+	var out bytes.Buffer
+	if err := src.WriteBytecode(&out, function); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	if err := os.WriteFile(*output, out.Bytes(), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	return 0
+}
+
+// runCommand runs a script from source or from a bytecode file made by build.
+//
+// Usage: goclox run file, or goclox run - to run the script on standard input.
+func runCommand(args []string) int {
+	flags := newCommandFlags("run", "run file.clox|file.cloxc|-")
+	if flags.Parse(args) != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	src.InitVM()
+	defer src.FreeVM()
+	function, code := loadFunction(flags.Arg(0))
+	if function == nil {
+		return code
+	}
+	result := src.InterpretFunction(function)
+
+	switch result {
+	case src.InterpretCompileError:
+		return exitCompileError
+	case src.InterpretRuntimeError:
+		return exitRuntimeError
+	}
+	return 0
+}
+
+// disasmCommand prints the disassembly of a script and all its functions.
+//
+// Usage: goclox disasm [-format text|json] file
+func disasmCommand(args []string) int {
+	flags := newCommandFlags("disasm", "disasm [-format text|json] file.clox|file.cloxc|-")
+	format := flags.String("format", "text", "Output format: text or json")
+	if flags.Parse(args) != nil || flags.NArg() != 1 || (*format != "text" && *format != "json") {
+		flags.Usage()
+		return exitUsage
+	}
+
+	src.InitVM()
+	defer src.FreeVM()
+	function, code := loadFunction(flags.Arg(0))
+	if function == nil {
+		return code
+	}
+
+	listings := src.Disassemble(function)
+	if *format == "text" {
+		src.WriteDisassembly(os.Stdout, listings)
+		return 0
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(listings); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	return 0
+}
+
+// loadFunction compiles the script at path, or loads it if it holds bytecode.
+// The path - stands for standard input. A script is compiled as it is read,
+// so it never has to fit in memory whole.
+//
+// On failure it reports the problem and returns nil with the exit code to use.
+func loadFunction(path string) (*src.ObjFunction, int) {
+	input, err := openInput(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitIOError
+	}
+	defer input.Close()
+	r := bufio.NewReader(input)
+	magic, err := r.Peek(len(src.BytecodeMagic))
+	if err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, inputError(path, err))
+		return nil, exitIOError
+	}
+	if src.IsBytecode(magic) {
+		function, err := src.ReadBytecode(r)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, exitCompileError
+		}
+		return function, 0
+	}
+	var chunk src.Chunk
+	src.InitChunk(&chunk)
+	if path == "-" {
+		path = ""
+	}
+	function, err := src.CompileReader(path, r, &chunk)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, inputError(path, err))
+		return nil, exitIOError
+	}
+	if function == nil {
+		return nil, exitCompileError
+	}
+	return function, 0
+}
+
+// openInput opens the file at path, or standard input if path is -.
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// inputError describes an error reading the input loadFunction opened for
+// path, which is "" for standard input.
+func inputError(path string, err error) error {
+	if path == "" || path == "-" {
+		return fmt.Errorf("read standard input: %w", err)
+	}
+	return err
+}
+
+// dapCommand serves the Debug Adapter Protocol over stdin and stdout.
+//
+// Usage: goclox dap
+func dapCommand(args []string) int {
+	flags := newCommandFlags("dap", "dap")
+	if flags.Parse(args) != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if err := dap.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	return 0
+}
+
+// lspCommand serves the Language Server Protocol over stdin and stdout.
+//
+// Usage: goclox lsp
+func lspCommand(args []string) int {
+	flags := newCommandFlags("lsp", "lsp")
+	if flags.Parse(args) != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// fmtCommand formats scripts, or standard input if no files are given.
+//
+// Usage: goclox fmt [-w] [-d] [-l] [file.clox...]
+func fmtCommand(args []string) int {
+	flags := newCommandFlags("fmt", "fmt [-w] [-d] [-l] [file.clox...]")
+	write := flags.Bool("w", false, "Write the result back to each file instead of printing it")
+	showDiff := flags.Bool("d", false, "Print a diff of the changes instead of the result")
+	list := flags.Bool("l", false, "List the files whose formatting differs")
+	if flags.Parse(args) != nil || (*write && flags.NArg() == 0) {
+		flags.Usage()
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitIOError
+		}
+		return formatFile("<standard input>", source, *write, *showDiff, *list)
+	}
+	code := 0
+	for _, path := range flags.Args() {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = exitIOError
+			continue
+		}
+		if c := formatFile(path, source, *write, *showDiff, *list); c != 0 {
+			code = c
+		}
+	}
+	return code
+}
+
+// formatFile formats the source of one file and reports the result as the
+// fmt flags ask. It returns the exit code for the file.
+func formatFile(path string, source []byte, write, showDiff, list bool) int {
+	formatted, err := format.Source(string(source))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return exitCompileError
+	}
+	changed := formatted != string(source)
+	if list && changed {
+		fmt.Println(path)
+	}
+	if write && changed {
+		if err := os.WriteFile(path, []byte(formatted), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitIOError
+		}
+	}
+	if showDiff {
+		fmt.Print(diff.Unified(path+".orig", path, string(source), formatted))
+	}
+	if !write && !showDiff && !list {
+		fmt.Print(formatted)
+	}
+	return 0
+}
+
+// lintCommand reports likely mistakes in scripts. It exits with 1 if it
+// finds any.
+//
+// Usage: goclox lint file.clox...
+func lintCommand(args []string) int {
+	flags := newCommandFlags("lint", "lint file.clox...")
+	if flags.Parse(args) != nil || flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = exitIOError
+			continue
+		}
+		findings, diagnostics := lint.File(path, string(source))
+		for _, d := range diagnostics {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", path, d.Line, d.Column, d.Message)
+		}
+		for _, f := range findings {
+			fmt.Printf("%s:%v\n", path, f)
+		}
+		switch {
+		case len(diagnostics) > 0:
+			code = exitCompileError
+		case len(findings) > 0 && code == 0:
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,429 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/smekuria1/goclox/globals"
+	"github.com/smekuria1/goclox/internal/wire"
+	"github.com/smekuria1/goclox/src"
+)
+
+// threadID is the id of the only thread a script has.
+const threadID = 1
+
+// globalsReference is the variables reference of the globals scope. The
+// locals of the frame with id n have reference n+1.
+const globalsReference = 1
+
+var errNotStopped = errors.New("the program is not stopped")
+
+/*
+Server is a Debug Adapter Protocol server for one debugging session.
+
+It handles launch, breakpoints, stepping, stack traces, scopes, variables and
+evaluate requests. Launch compiles the program; configurationDone starts it on
+its own goroutine. While the program is stopped, requests that inspect it are
+handed to that goroutine, which is waiting inside the debugger's OnStop.
+
+The program's output and errors are sent to the client as output events.
+*/
+type Server struct {
+	in  *bufio.Reader
+	mu  sync.Mutex // Guards out and seq.
+	out io.Writer
+	seq int
+
+	function *src.ObjFunction
+	lines    map[string]map[int]bool // The lines that have code in each file, for verifying breakpoints.
+	noDebug  bool
+	debugger *src.Debugger
+	running  bool             // Set once the program has started.
+	paused   atomic.Bool      // Set while the program waits in onStop.
+	work     chan func() bool // Requests for the program's goroutine; a true result resumes it.
+	done     chan struct{}    // Closed when the program has finished.
+}
+
+// NewServer returns a server that reads requests from in and writes to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		work: make(chan func() bool),
+		done: make(chan struct{}),
+	}
+}
+
+// Serve handles requests until the client disconnects or closes its input.
+//
+// It stops a running program before returning.
+func (s *Server) Serve() error {
+	for {
+		content, err := wire.ReadMessage(s.in)
+		if err != nil {
+			s.stopProgram()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			s.stopProgram()
+			return fmt.Errorf("dap: %v", err)
+		}
+		if req.Type == "request" && s.handle(&req) {
+			return nil
+		}
+	}
+}
+
+// handle answers one request. It returns true once the client has disconnected.
+func (s *Server) handle(req *request) bool {
+	switch req.Command {
+	case "initialize":
+		s.respond(req, capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsEvaluateForHovers:        true,
+		}, nil)
+	case "launch":
+		err := s.launch(req)
+		s.respond(req, nil, err)
+		if err == nil {
+			s.event("initialized", nil)
+		}
+	case "setBreakpoints":
+		body, err := s.setBreakpoints(req)
+		s.respond(req, body, err)
+	case "setFunctionBreakpoints":
+		body, err := s.setFunctionBreakpoints(req)
+		s.respond(req, body, err)
+	case "configurationDone":
+		s.respond(req, nil, s.start())
+	case "threads":
+		s.respond(req, map[string]any{"threads": []thread{{ID: threadID, Name: "main"}}}, nil)
+	case "stackTrace":
+		s.whilePaused(req, s.stackTrace)
+	case "scopes":
+		s.whilePaused(req, s.scopes)
+	case "variables":
+		s.whilePaused(req, s.variables)
+	case "evaluate":
+		s.whilePaused(req, s.evaluate)
+	case "continue":
+		s.resume(req, (*src.Debugger).Continue, map[string]any{"allThreadsContinued": true})
+	case "next":
+		s.resume(req, (*src.Debugger).StepOver, nil)
+	case "stepIn":
+		s.resume(req, (*src.Debugger).StepIn, nil)
+	case "stepOut":
+		s.resume(req, (*src.Debugger).StepOut, nil)
+	case "pause":
+		if s.debugger != nil {
+			s.debugger.Pause()
+		}
+		s.respond(req, nil, nil)
+	case "terminate":
+		s.stopProgram()
+		s.respond(req, nil, nil)
+	case "disconnect":
+		s.stopProgram()
+		s.respond(req, nil, nil)
+		return true
+	default:
+		s.respond(req, nil, fmt.Errorf("unsupported request %q", req.Command))
+	}
+	return false
+}
+
+// launch compiles the program named in the request's arguments.
+//
+// The optimizer, superinstructions and the register VM are turned off, since
+// the debugger needs unoptimized stack VM code.
+func (s *Server) launch(req *request) error {
+	if s.function != nil {
+		return errors.New("a program is already launched")
+	}
+	var args launchArguments
+	if err := req.decode(&args); err != nil {
+		return err
+	}
+	source, err := os.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	globals.OPTIMIZE_CODE = false
+	globals.SUPER_INSTRUCTIONS = false
+	globals.REGISTER_VM = false
+
+	src.InitVM()
+	src.SetOutput(outputWriter{s})
+	var chunk src.Chunk
+	src.InitChunk(&chunk)
+	function := src.CompileFile(args.Program, string(source), &chunk)
+	if function == nil {
+		return errors.New("the program has compile errors")
+	}
+
+	s.function, s.noDebug = function, args.NoDebug
+	s.lines = make(map[string]map[int]bool)
+	for _, listing := range src.Disassemble(function) {
+		if s.lines[listing.Path] == nil {
+			s.lines[listing.Path] = make(map[int]bool)
+		}
+		for _, instruction := range listing.Instructions {
+			s.lines[listing.Path][instruction.Line] = true
+		}
+	}
+	s.debugger = src.NewDebugger()
+	s.debugger.StopOnEntry = args.StopOnEntry && !args.NoDebug
+	s.debugger.OnStop = s.onStop
+	return nil
+}
+
+// setBreakpoints replaces the line breakpoints of a source file. Lines without
+// code, and lines of files the program doesn't import, are not verified.
+func (s *Server) setBreakpoints(req *request) (any, error) {
+	if s.debugger == nil {
+		return nil, errors.New("no program is launched")
+	}
+	var args setBreakpointsArguments
+	if err := req.decode(&args); err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []int
+	breakpoints := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, requested := range args.Breakpoints {
+		verified := s.lines[path][requested.Line] && !s.noDebug
+		result := breakpoint{Verified: verified, Line: requested.Line}
+		if verified {
+			lines = append(lines, requested.Line)
+		} else {
+			result.Message = "No code on this line"
+		}
+		breakpoints = append(breakpoints, result)
+	}
+	s.debugger.SetLineBreakpoints(path, lines)
+	return map[string]any{"breakpoints": breakpoints}, nil
+}
+
+// setFunctionBreakpoints replaces the function breakpoints.
+func (s *Server) setFunctionBreakpoints(req *request) (any, error) {
+	if s.debugger == nil {
+		return nil, errors.New("no program is launched")
+	}
+	var args setFunctionBreakpointsArguments
+	if err := req.decode(&args); err != nil {
+		return nil, err
+	}
+	var names []string
+	breakpoints := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, requested := range args.Breakpoints {
+		if !s.noDebug {
+			names = append(names, requested.Name)
+		}
+		breakpoints = append(breakpoints, breakpoint{Verified: !s.noDebug})
+	}
+	s.debugger.SetFunctionBreakpoints(names)
+	return map[string]any{"breakpoints": breakpoints}, nil
+}
+
+// start runs the launched program on a new goroutine. When it finishes, the
+// client gets an exited event with the goclox exit code and a terminated event.
+func (s *Server) start() error {
+	if s.function == nil {
+		return errors.New("no program is launched")
+	}
+	if s.running {
+		return errors.New("the program is already running")
+	}
+	s.running = true
+	src.AttachDebugger(s.debugger)
+	go func() {
+		defer close(s.done)
+		exitCode := 0
+		switch src.InterpretFunction(s.function) {
+		case src.InterpretCompileError:
+			exitCode = 65
+		case src.InterpretRuntimeError:
+			exitCode = 70
+		}
+		s.event("exited", map[string]any{"exitCode": exitCode})
+		s.event("terminated", nil)
+	}()
+	return nil
+}
+
+// onStop tells the client the program stopped, then runs requests from the
+// server's goroutine until one resumes the program.
+func (s *Server) onStop(reason string) {
+	s.paused.Store(true)
+	s.event("stopped", map[string]any{"reason": reason, "threadId": threadID, "allThreadsStopped": true})
+	for handle := range s.work {
+		if handle() {
+			return
+		}
+	}
+}
+
+// whilePaused answers req on the program's goroutine with the result of
+// handle, or with an error if the program is not stopped.
+func (s *Server) whilePaused(req *request, handle func(req *request) (any, error)) {
+	if !s.paused.Load() {
+		s.respond(req, nil, errNotStopped)
+		return
+	}
+	s.work <- func() bool {
+		body, err := handle(req)
+		s.respond(req, body, err)
+		return false
+	}
+}
+
+// resume answers req and lets the stopped program run on after calling step.
+func (s *Server) resume(req *request, step func(*src.Debugger), body any) {
+	if !s.paused.Load() {
+		s.respond(req, nil, errNotStopped)
+		return
+	}
+	s.paused.Store(false)
+	s.work <- func() bool {
+		step(s.debugger)
+		s.respond(req, body, nil)
+		return true
+	}
+}
+
+// stopProgram aborts the program, if it is running, and waits for it to finish.
+func (s *Server) stopProgram() {
+	if !s.running {
+		return
+	}
+	s.debugger.Abort()
+	s.paused.Store(false)
+	// The program may be stopped, or about to stop, in onStop.
+	for {
+		select {
+		case <-s.done:
+			return
+		case s.work <- func() bool { return true }:
+		}
+	}
+}
+
+// stackTrace lists the stopped program's frames. Frame ids start at 1 for the innermost frame.
+func (s *Server) stackTrace(req *request) (any, error) {
+	var args stackTraceArguments
+	if err := req.decode(&args); err != nil {
+		return nil, err
+	}
+	backtrace := s.debugger.Backtrace()
+	frames := []stackFrame{}
+	for i, frame := range backtrace {
+		if i < args.StartFrame || (args.Levels > 0 && len(frames) == args.Levels) {
+			continue
+		}
+		file := source{Name: filepath.Base(frame.Path), Path: frame.Path}
+		frames = append(frames, stackFrame{ID: i + 1, Name: frame.Function, Source: file, Line: frame.Line, Column: 1})
+	}
+	return map[string]any{"stackFrames": frames, "totalFrames": len(backtrace)}, nil
+}
+
+// scopes lists the locals and globals scopes of a frame.
+func (s *Server) scopes(req *request) (any, error) {
+	var args scopesArguments
+	if err := req.decode(&args); err != nil {
+		return nil, err
+	}
+	if args.FrameID < 1 || args.FrameID > len(s.debugger.Backtrace()) {
+		return nil, fmt.Errorf("no frame with id %d", args.FrameID)
+	}
+	return map[string]any{"scopes": []scope{
+		{Name: "Locals", VariablesReference: args.FrameID + 1},
+		{Name: "Globals", VariablesReference: globalsReference},
+	}}, nil
+}
+
+// variables lists the variables of a scope.
+func (s *Server) variables(req *request) (any, error) {
+	var args variablesArguments
+	if err := req.decode(&args); err != nil {
+		return nil, err
+	}
+	var found []src.Variable
+	switch frameIndex := args.VariablesReference - 2; {
+	case args.VariablesReference == globalsReference:
+		found = s.debugger.Globals()
+	case frameIndex >= 0 && frameIndex < len(s.debugger.Backtrace()):
+		found = s.debugger.Locals(frameIndex)
+	default:
+		return nil, fmt.Errorf("no variables with reference %d", args.VariablesReference)
+	}
+	variables := make([]variable, 0, len(found))
+	for _, v := range found {
+		variables = append(variables, variable{Name: v.Name, Value: v.Value})
+	}
+	return map[string]any{"variables": variables}, nil
+}
+
+// evaluate evaluates an expression in a frame, or in the innermost frame if none is given.
+func (s *Server) evaluate(req *request) (any, error) {
+	var args evaluateArguments
+	if err := req.decode(&args); err != nil {
+		return nil, err
+	}
+	frameIndex := 0
+	if args.FrameID > 0 {
+		frameIndex = args.FrameID - 1
+	}
+	result, err := s.debugger.Evaluate(frameIndex, args.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"result": result, "variablesReference": 0}, nil
+}
+
+// respond sends the response to req: a failure with err's message if err is
+// not nil, or a success carrying body.
+func (s *Server) respond(req *request, body any, err error) {
+	message := response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		message.Message = err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	message.Seq = s.seq
+	wire.WriteMessage(s.out, &message)
+}
+
+// event sends an event to the client.
+func (s *Server) event(name string, body any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	wire.WriteMessage(s.out, &event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// outputWriter sends what the VM prints to the client as output events.
+type outputWriter struct {
+	s *Server
+}
+
+// Write implements io.Writer.
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.event("output", map[string]any{"category": "stdout", "output": string(p)})
+	return len(p), nil
+}
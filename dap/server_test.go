@@ -0,0 +1,295 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smekuria1/goclox/internal/wire"
+)
+
+const testProgram = `var g = 1;
+fun add(a, b) {
+  var sum = a + b;
+  return sum;
+}
+{
+  var x = 2;
+  var y = add(x, 3);
+  print y;
+}
+print g;
+`
+
+// testClient talks to a Server over pipes, like an editor would.
+type testClient struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	seq      int
+	messages chan map[string]any
+	events   []map[string]any // Events received but not yet waited for.
+	output   strings.Builder  // The text of every output event.
+	served   chan error
+}
+
+func newTestClient(t *testing.T) *testClient {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &testClient{t: t, in: clientOut, messages: make(chan map[string]any, 100), served: make(chan error, 1)}
+	go func() {
+		c.served <- NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	go func() {
+		defer close(c.messages)
+		r := bufio.NewReader(clientIn)
+		for {
+			content, err := wire.ReadMessage(r)
+			if err != nil {
+				return
+			}
+			var message map[string]any
+			if err := json.Unmarshal(content, &message); err != nil {
+				t.Errorf("bad message %q: %v", content, err)
+				return
+			}
+			c.messages <- message
+		}
+	}()
+	return c
+}
+
+// next returns the next message from the server, failing the test after a timeout.
+func (c *testClient) next() map[string]any {
+	c.t.Helper()
+	select {
+	case message, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("server closed its output")
+		}
+		if message["type"] == "event" && message["event"] == "output" {
+			c.output.WriteString(message["body"].(map[string]any)["output"].(string))
+		}
+		return message
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+	return nil
+}
+
+// request sends a request and returns the body of its successful response.
+func (c *testClient) request(command string, arguments any) map[string]any {
+	c.t.Helper()
+	response := c.send(command, arguments)
+	if response["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, response["message"])
+	}
+	body, _ := response["body"].(map[string]any)
+	return body
+}
+
+// send sends a request and returns its response, keeping events for waitEvent.
+func (c *testClient) send(command string, arguments any) map[string]any {
+	c.t.Helper()
+	c.seq++
+	if err := wire.WriteMessage(c.in, map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": arguments}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		message := c.next()
+		if message["type"] == "event" {
+			c.events = append(c.events, message)
+			continue
+		}
+		if message["request_seq"] == float64(c.seq) {
+			return message
+		}
+	}
+}
+
+// waitEvent returns the body of the next event with the given name, skipping other events.
+func (c *testClient) waitEvent(name string) map[string]any {
+	c.t.Helper()
+	for {
+		var message map[string]any
+		if len(c.events) > 0 {
+			message, c.events = c.events[0], c.events[1:]
+		} else {
+			message = c.next()
+		}
+		if message["type"] == "event" && message["event"] == name {
+			body, _ := message["body"].(map[string]any)
+			return body
+		}
+	}
+}
+
+// writeProgram writes source to a file in a temporary directory and returns its path.
+func writeProgram(t *testing.T, source string) string {
+	path := filepath.Join(t.TempDir(), "program.clox")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fields returns the named fields of each object in list.
+func fields(list any, names ...string) [][]any {
+	var result [][]any
+	for _, item := range list.([]any) {
+		var values []any
+		for _, name := range names {
+			values = append(values, item.(map[string]any)[name])
+		}
+		result = append(result, values)
+	}
+	return result
+}
+
+func TestDebugSession(t *testing.T) {
+	c := newTestClient(t)
+	program := writeProgram(t, testProgram)
+
+	capabilities := c.request("initialize", map[string]any{"adapterID": "goclox"})
+	if capabilities["supportsConfigurationDoneRequest"] != true {
+		t.Errorf("initialize capabilities = %v", capabilities)
+	}
+	c.request("launch", map[string]any{"program": program})
+	c.waitEvent("initialized")
+	body := c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": program}, "breakpoints": []any{map[string]any{"line": 4}, map[string]any{"line": 6}}})
+	if got, want := fields(body["breakpoints"], "line", "verified"), [][]any{{4.0, true}, {6.0, false}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("setBreakpoints = %v, want %v", got, want)
+	}
+	c.request("configurationDone", nil)
+
+	if stopped := c.waitEvent("stopped"); stopped["reason"] != "breakpoint" {
+		t.Errorf("stopped reason = %v, want breakpoint", stopped["reason"])
+	}
+	body = c.request("stackTrace", map[string]any{"threadId": threadID})
+	if got, want := fields(body["stackFrames"], "id", "name", "line"), [][]any{{1.0, "add", 4.0}, {2.0, "script", 8.0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stackTrace = %v, want %v", got, want)
+	}
+	body = c.request("scopes", map[string]any{"frameId": 1})
+	references := fields(body["scopes"], "name", "variablesReference")
+	if want := [][]any{{"Locals", 2.0}, {"Globals", 1.0}}; !reflect.DeepEqual(references, want) {
+		t.Fatalf("scopes = %v, want %v", references, want)
+	}
+	body = c.request("variables", map[string]any{"variablesReference": references[0][1]})
+	if got, want := fields(body["variables"], "name", "value"), [][]any{{"a", "2"}, {"b", "3"}, {"sum", "5"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("locals = %v, want %v", got, want)
+	}
+	body = c.request("variables", map[string]any{"variablesReference": references[1][1]})
+	if got, want := fields(body["variables"], "name", "value"), [][]any{{"add", "add"}, {"g", "1"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("globals = %v, want %v", got, want)
+	}
+	body = c.request("evaluate", map[string]any{"expression": "x * 10", "frameId": 2})
+	if body["result"] != "20" {
+		t.Errorf("evaluate = %v, want 20", body["result"])
+	}
+	if response := c.send("evaluate", map[string]any{"expression": "nope", "frameId": 1}); response["success"] != false {
+		t.Errorf("evaluate of an undefined variable succeeded: %v", response)
+	}
+
+	c.request("stepOut", map[string]any{"threadId": threadID})
+	if stopped := c.waitEvent("stopped"); stopped["reason"] != "step" {
+		t.Errorf("stopped reason = %v, want step", stopped["reason"])
+	}
+	body = c.request("stackTrace", map[string]any{"threadId": threadID})
+	if got, want := fields(body["stackFrames"], "name", "line"), [][]any{{"script", 9.0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stackTrace after stepOut = %v, want %v", got, want)
+	}
+
+	c.request("continue", map[string]any{"threadId": threadID})
+	if exited := c.waitEvent("exited"); exited["exitCode"] != 0.0 {
+		t.Errorf("exit code = %v, want 0", exited["exitCode"])
+	}
+	c.waitEvent("terminated")
+	if got := c.output.String(); got != "5\n1\n" {
+		t.Errorf("output = %q, want %q", got, "5\n1\n")
+	}
+	c.request("disconnect", nil)
+	if err := <-c.served; err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+}
+
+func TestLaunchErrors(t *testing.T) {
+	c := newTestClient(t)
+	c.request("initialize", nil)
+	if response := c.send("launch", map[string]any{"program": writeProgram(t, "print ;")}); response["success"] != false {
+		t.Errorf("launching a program with compile errors succeeded: %v", response)
+	}
+	if got := c.output.String(); !strings.Contains(got, "Expect expression") {
+		t.Errorf("output = %q, want the compile error", got)
+	}
+	if response := c.send("stackTrace", nil); response["success"] != false {
+		t.Errorf("stackTrace without a program succeeded: %v", response)
+	}
+	c.request("disconnect", nil)
+	if err := <-c.served; err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+}
+
+func TestDisconnectStopsProgram(t *testing.T) {
+	c := newTestClient(t)
+	c.request("initialize", nil)
+	c.request("launch", map[string]any{"program": writeProgram(t, "while (true) { print 1; }"), "stopOnEntry": true})
+	c.request("configurationDone", nil)
+	if stopped := c.waitEvent("stopped"); stopped["reason"] != "entry" {
+		t.Errorf("stopped reason = %v, want entry", stopped["reason"])
+	}
+	c.request("continue", map[string]any{"threadId": threadID})
+	c.request("disconnect", nil)
+	if err := <-c.served; err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+}
+
+func TestDebugModules(t *testing.T) {
+	c := newTestClient(t)
+	program := writeProgram(t, "import \"lib/mod.clox\";\nvar a = 1;\nprint twice(a);\n")
+	module := filepath.Join(filepath.Dir(program), "lib", "mod.clox")
+	if err := os.MkdirAll(filepath.Dir(module), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(module, []byte("fun twice(x) {\n  var y = x * 2;\n  return y;\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c.request("initialize", nil)
+	c.request("launch", map[string]any{"program": program})
+	c.waitEvent("initialized")
+	body := c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": module}, "breakpoints": []any{map[string]any{"line": 2}, map[string]any{"line": 6}}})
+	if got, want := fields(body["breakpoints"], "line", "verified"), [][]any{{2.0, true}, {6.0, false}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("setBreakpoints in the module = %v, want %v", got, want)
+	}
+	// The module has code on line 5, but the script doesn't.
+	body = c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": program}, "breakpoints": []any{map[string]any{"line": 5}}})
+	if got, want := fields(body["breakpoints"], "line", "verified"), [][]any{{5.0, false}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("setBreakpoints in the script = %v, want %v", got, want)
+	}
+	c.request("configurationDone", nil)
+
+	c.waitEvent("stopped")
+	body = c.request("stackTrace", map[string]any{"threadId": threadID})
+	frames := body["stackFrames"].([]any)
+	var got [][]any
+	for _, frame := range frames {
+		frame := frame.(map[string]any)
+		got = append(got, []any{frame["name"], frame["source"].(map[string]any)["path"], frame["line"]})
+	}
+	if want := [][]any{{"twice", module, 2.0}, {"script", program, 3.0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stackTrace = %v, want %v", got, want)
+	}
+	c.request("disconnect", nil)
+	if err := <-c.served; err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+}
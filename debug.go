@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/smekuria1/goclox/globals"
+	"github.com/smekuria1/goclox/src"
+)
+
+const debugHelp = `Commands:
+  break, b <line|function>  Set a breakpoint; file:line sets one in an imported file
+  delete, d [line|function] Delete a breakpoint, or all of them
+  continue, c               Run to the next breakpoint
+  step, s                   Run to the next line, entering calls
+  next, n                   Run to the next line of this function
+  out, finish               Run until this function returns
+  backtrace, bt             Show the active calls
+  locals [frame]            Show the locals of a frame (0 is innermost)
+  globals                   Show the defined globals
+  print, p <expression>     Evaluate an expression in the innermost frame
+  quit, q                   Stop the program
+`
+
+// debugCommand runs a script under the interactive debugger.
+//
+// Usage: goclox debug file.clox
+func debugCommand(args []string) int {
+	flags := newCommandFlags("debug", "debug file.clox")
+	if flags.Parse(args) != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	path := flags.Arg(0)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	script, err := filepath.Abs(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	// Locals are only recorded for unoptimized stack VM code.
+	globals.OPTIMIZE_CODE = false
+	globals.SUPER_INSTRUCTIONS = false
+	globals.REGISTER_VM = false
+
+	src.InitVM()
+	defer src.FreeVM()
+	session := &debugSession{
+		debugger: src.NewDebugger(),
+		script:   script,
+		files:    map[string][]string{script: strings.Split(string(source), "\n")},
+		in:       bufio.NewScanner(os.Stdin),
+		out:      os.Stdout,
+		breaks:   make(map[string]bool),
+		breakIn:  make(map[string]bool),
+	}
+	session.debugger.StopOnEntry = true
+	session.debugger.OnStop = session.prompt
+	src.AttachDebugger(session.debugger)
+	fmt.Fprint(session.out, "Type help for a list of commands.\n")
+
+	switch src.InterpretFile(path, string(source)) {
+	case src.InterpretCompileError:
+		return exitCompileError
+	case src.InterpretRuntimeError:
+		return exitRuntimeError
+	}
+	return 0
+}
+
+// debugSession reads debugger commands while the program is stopped.
+type debugSession struct {
+	debugger *src.Debugger
+	script   string              // The absolute path of the script.
+	files    map[string][]string // The lines of each file read so far, for showing where the program stopped.
+	in       *bufio.Scanner
+	out      io.Writer
+	breaks   map[string]bool // Breakpoints as typed: line numbers, file:line and function names.
+	breakIn  map[string]bool // The files that have been given line breakpoints.
+}
+
+// prompt shows where the program stopped and runs commands until one resumes it.
+func (s *debugSession) prompt(reason string) {
+	frame := s.debugger.Backtrace()[0]
+	fmt.Fprintf(s.out, "Stopped (%s) in %s at %s: %s\n", reason, frame.Function, s.location(frame), s.sourceLine(frame.Path, frame.Line))
+	for {
+		fmt.Fprint(s.out, "(goclox) ")
+		if !s.in.Scan() {
+			s.debugger.Abort()
+			return
+		}
+		command, argument, _ := strings.Cut(strings.TrimSpace(s.in.Text()), " ")
+		argument = strings.TrimSpace(argument)
+
+		switch command {
+		case "":
+		case "break", "b":
+			if argument == "" {
+				fmt.Fprintln(s.out, "break needs a line or a function name")
+				break
+			}
+			s.breaks[argument] = true
+			s.applyBreakpoints()
+		case "delete", "d":
+			if argument == "" {
+				s.breaks = make(map[string]bool)
+			}
+			delete(s.breaks, argument)
+			s.applyBreakpoints()
+		case "continue", "c":
+			s.debugger.Continue()
+			return
+		case "step", "s":
+			s.debugger.StepIn()
+			return
+		case "next", "n":
+			s.debugger.StepOver()
+			return
+		case "out", "finish":
+			s.debugger.StepOut()
+			return
+		case "backtrace", "bt":
+			for i, frame := range s.debugger.Backtrace() {
+				fmt.Fprintf(s.out, "#%d %s %s\n", i, frame.Function, s.location(frame))
+			}
+		case "locals":
+			index, err := strconv.Atoi(argument)
+			if argument == "" {
+				index, err = 0, nil
+			}
+			if err != nil || index < 0 || index >= len(s.debugger.Backtrace()) {
+				fmt.Fprintln(s.out, "no such frame")
+				break
+			}
+			printVariables(s.out, s.debugger.Locals(index))
+		case "globals":
+			printVariables(s.out, s.debugger.Globals())
+		case "print", "p":
+			value, err := s.debugger.Evaluate(0, argument)
+			if err != nil {
+				fmt.Fprintln(s.out, err)
+				break
+			}
+			fmt.Fprintln(s.out, value)
+		case "help", "h":
+			fmt.Fprint(s.out, debugHelp)
+		case "quit", "q":
+			s.debugger.Abort()
+			return
+		default:
+			fmt.Fprintf(s.out, "unknown command %q; type help for a list of commands\n", command)
+		}
+	}
+}
+
+// applyBreakpoints passes the typed breakpoints to the debugger.
+func (s *debugSession) applyBreakpoints() {
+	lines := make(map[string][]int)
+	var functions []string
+	for name := range s.breaks {
+		if path, line, ok := s.lineBreakpoint(name); ok {
+			lines[path] = append(lines[path], line)
+		} else {
+			functions = append(functions, name)
+		}
+	}
+	for path := range s.breakIn {
+		if _, ok := lines[path]; !ok {
+			s.debugger.SetLineBreakpoints(path, nil)
+		}
+	}
+	for path, numbers := range lines {
+		s.debugger.SetLineBreakpoints(path, numbers)
+		s.breakIn[path] = true
+	}
+	s.debugger.SetFunctionBreakpoints(functions)
+}
+
+// lineBreakpoint parses a typed line breakpoint: a line of the script, or
+// file:line for a line of another file. The file is found relative to the
+// script's directory, as an import is, and its .clox extension is optional.
+func (s *debugSession) lineBreakpoint(name string) (path string, line int, ok bool) {
+	file, number, found := strings.Cut(name, ":")
+	if !found {
+		file, number = "", name
+	}
+	line, err := strconv.Atoi(number)
+	if err != nil {
+		return "", 0, false
+	}
+	if file == "" {
+		return s.script, line, true
+	}
+	if filepath.Ext(file) == "" {
+		file += ".clox"
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(s.script), file)
+	}
+	return filepath.Clean(file), line, true
+}
+
+// location describes the line frame is at, naming its file unless it is the script.
+func (s *debugSession) location(frame src.StackFrame) string {
+	if frame.Path == s.script || frame.Path == "" {
+		return fmt.Sprintf("line %d", frame.Line)
+	}
+	return fmt.Sprintf("line %d of %s", frame.Line, filepath.Base(frame.Path))
+}
+
+// sourceLine returns the text of a 1-based line of the file at path.
+func (s *debugSession) sourceLine(path string, line int) string {
+	lines, ok := s.files[path]
+	if !ok {
+		source, _ := os.ReadFile(path)
+		lines = strings.Split(string(source), "\n")
+		s.files[path] = lines
+	}
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
+
+// printVariables prints one variable per line.
+func printVariables(out io.Writer, variables []src.Variable) {
+	for _, variable := range variables {
+		fmt.Fprintf(out, "%s = %s\n", variable.Name, variable.Value)
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Package format implements the canonical layout of Lox source code.
+
+The formatter works on the token stream, so it keeps every token and comment
+in order and only rewrites the space between them:
+
+  - statements go on their own lines, indented two spaces per block;
+  - an opening brace ends the line it is on, and a closing brace starts its
+    own line, followed on the same line by an else;
+  - binary operators and assignments have a space on each side, unary
+    operators, calls and grouping parentheses have none;
+  - commas and the semicolons of a for clause are followed by a space;
+  - a run of blank lines between statements becomes a single blank line;
+  - a comment stays at the end of its line or on a line of its own.
+
+A statement whose body is not a block stays on one line, as in
+`if (x) print x; else print y;`. Lines are never wrapped.
+*/
+package format
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smekuria1/goclox/globals"
+	"github.com/smekuria1/goclox/src"
+)
+
+// indentation is the text of one indentation level.
+const indentation = "  "
+
+// Source formats Lox source code.
+//
+// It fails if the source has a token the scanner rejects or unbalanced
+// braces or parentheses; other syntax errors are not detected.
+func Source(source string) (string, error) {
+	tokens, err := scan(source)
+	if err != nil {
+		return "", err
+	}
+	p := printer{source: source}
+	for i := range tokens {
+		if err := p.print(&tokens[i]); err != nil {
+			return "", err
+		}
+	}
+	if p.depth != 0 || p.parens != 0 {
+		return "", fmt.Errorf("line %d: unexpected end of file", tokens[len(tokens)-1].Line)
+	}
+	if p.prev != nil {
+		p.out.WriteByte('\n')
+	}
+	return p.out.String(), nil
+}
+
+// scan returns the tokens of source including comments, without the final EOF token.
+func scan(source string) ([]src.Token, error) {
+	var scanner src.Scanner
+	scanner.InitScanner(source)
+	scanner.Comments = true
+	var tokens []src.Token
+	for {
+		token := scanner.ScanToken(scanner.Source)
+		switch token.TOKENType {
+		case globals.TokenERROR:
+			return nil, fmt.Errorf("line %d: %s", token.Line, scanner.Message)
+		case globals.TokenEOF:
+			return tokens, nil
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+// printer lays out tokens one at a time.
+type printer struct {
+	source       string
+	out          strings.Builder
+	prev         *src.Token // The last token printed, or nil.
+	prevUnary    bool       // Whether prev is a unary operator.
+	depth        int        // The brace depth.
+	parens       int        // The parenthesis depth.
+	newlines     int        // The line breaks owed before the next token: 0, 1, or 2 for a blank line.
+	continuation bool       // Whether a comment broke the current statement, so its next line is indented further.
+}
+
+// print prints token after the space it needs.
+func (p *printer) print(token *src.Token) error {
+	kind := token.TOKENType
+	text := p.source[token.Start : token.Start+token.Length]
+
+	if kind == globals.TokenCOMMENT {
+		p.printComment(token, text)
+		return nil
+	}
+
+	// A line break after a comment always stays.
+	glued := !p.is(globals.TokenCOMMENT)
+	switch kind {
+	case globals.TokenRightBrace:
+		p.depth--
+		if p.depth < 0 {
+			return fmt.Errorf("line %d: unexpected '}'", token.Line)
+		}
+		if p.is(globals.TokenLeftBrace) && glued {
+			p.newlines = 0 // An empty block stays on one line.
+		} else {
+			p.newlines = min(p.newlines, 1)
+		}
+	case globals.TokenRightParen:
+		p.parens--
+		if p.parens < 0 {
+			return fmt.Errorf("line %d: unexpected ')'", token.Line)
+		}
+		if glued {
+			p.newlines = 0
+		}
+	case globals.TokenELSE, globals.TokenSEMICOLON, globals.TokenCOMMA, globals.TokenDOT, globals.TokenQUESTION_DOT:
+		// These continue the line they follow.
+		if glued && (kind != globals.TokenELSE || p.is(globals.TokenRightBrace) || p.is(globals.TokenSEMICOLON)) {
+			p.newlines = 0
+		}
+	case globals.TokenCATCH, globals.TokenFINALLY:
+		// Like else, these follow the '}' of the block before them.
+		if glued && p.is(globals.TokenRightBrace) {
+			p.newlines = 0
+		}
+	}
+
+	if p.newlines > 0 {
+		p.breakLine(token)
+	} else if p.prev != nil && p.spaceBefore(token) {
+		p.out.WriteByte(' ')
+	}
+	p.out.WriteString(text)
+
+	unary := false
+	switch kind {
+	case globals.TokenLeftBrace:
+		p.depth++
+		p.endStatement()
+	case globals.TokenRightBrace:
+		p.endStatement()
+	case globals.TokenLeftParen:
+		p.parens++
+	case globals.TokenSEMICOLON:
+		if p.parens == 0 {
+			p.endStatement()
+		}
+	case globals.TokenBANG:
+		unary = true
+	case globals.TokenMINUS, globals.TokenPLUS_PLUS, globals.TokenMINUS_MINUS:
+		unary = p.prev == nil || !endsValue(p.prev.TOKENType)
+	}
+	p.prev, p.prevUnary = token, unary
+	return nil
+}
+
+// printComment prints a comment at the end of the current line or on a line of its own.
+func (p *printer) printComment(token *src.Token, text string) {
+	switch {
+	case p.prev == nil:
+	case token.Line == p.prev.Line:
+		p.out.WriteByte(' ')
+	default:
+		if p.newlines == 0 {
+			p.continuation = true // The comment interrupts a statement.
+		}
+		p.newlines = max(p.newlines, 1)
+		p.breakLine(token)
+	}
+	p.out.WriteString(strings.TrimRight(text, " \t\r"))
+	if p.newlines == 0 {
+		p.newlines = 1
+		if p.prev != nil && !p.is(globals.TokenCOMMENT) && !p.endedStatement() {
+			p.continuation = true
+		}
+	}
+	p.prev, p.prevUnary = token, false
+}
+
+// breakLine writes the owed line breaks and the indentation of the line token starts.
+// A blank line in the source between statements is kept, except at the start
+// or end of a block.
+func (p *printer) breakLine(token *src.Token) {
+	startLine := token.Line - strings.Count(p.source[token.Start:token.Start+token.Length], "\n")
+	if startLine-p.prev.Line > 1 && !p.is(globals.TokenLeftBrace) && token.TOKENType != globals.TokenRightBrace {
+		p.newlines = 2
+	}
+	p.out.WriteString(strings.Repeat("\n", p.newlines))
+	depth := p.depth
+	if p.continuation {
+		depth++
+	}
+	p.out.WriteString(strings.Repeat(indentation, depth))
+	p.newlines = 0
+}
+
+// endStatement owes a line break after a statement or a brace.
+func (p *printer) endStatement() {
+	p.newlines = 1
+	p.continuation = false
+}
+
+// endedStatement reports whether the last token printed ended a statement or opened a block.
+func (p *printer) endedStatement() bool {
+	return p.is(globals.TokenSEMICOLON) && p.parens == 0 || p.is(globals.TokenLeftBrace) || p.is(globals.TokenRightBrace)
+}
+
+// is reports whether the last token printed has the given type.
+func (p *printer) is(kind globals.TokenType) bool {
+	return p.prev != nil && p.prev.TOKENType == kind
+}
+
+// spaceBefore reports whether a space separates token from the token before it on the same line.
+func (p *printer) spaceBefore(token *src.Token) bool {
+	if p.prevUnary || p.is(globals.TokenLeftParen) || p.is(globals.TokenDOT) || p.is(globals.TokenQUESTION_DOT) ||
+		p.is(globals.TokenELLIPSIS) {
+		return false
+	}
+	switch token.TOKENType {
+	case globals.TokenRightParen, globals.TokenCOMMA, globals.TokenSEMICOLON, globals.TokenDOT, globals.TokenQUESTION_DOT:
+		return false
+	case globals.TokenRightBrace:
+		return !p.is(globals.TokenLeftBrace)
+	case globals.TokenLeftParen:
+		return !endsValue(p.prev.TOKENType) // A call has no space.
+	case globals.TokenPLUS_PLUS, globals.TokenMINUS_MINUS:
+		return !endsValue(p.prev.TOKENType) // Nor does a postfix ++ or --.
+	}
+	return true
+}
+
+// endsValue reports whether a token of the given type can end an operand,
+// so that a following '-' is binary, a following '(' is a call and a
+// following '++' or '--' is postfix.
+func endsValue(kind globals.TokenType) bool {
+	switch kind {
+	case globals.TokenIDENTIFIER, globals.TokenNUMBER, globals.TokenSTRING, globals.TokenRightParen,
+		globals.TokenTRUE, globals.TokenFALSE, globals.TokenNIL, globals.TokenTHIS,
+		globals.TokenPLUS_PLUS, globals.TokenMINUS_MINUS:
+		return true
+	}
+	return false
+}
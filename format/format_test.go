@@ -0,0 +1,119 @@
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"operators", "var x=-a*(b+c)/!d;", "var x = -a * (b + c) / !d;\n"},
+		{"binary minus", "print a-1 - -b;", "print a - 1 - -b;\n"},
+		{"calls and properties", "print f ( a ,b ) . c;", "print f(a, b).c;\n"},
+		{"statements", "var a = 1; print a;", "var a = 1;\nprint a;\n"},
+		{"blocks", "fun f(a){\nif(a){return 1;}else{return 2;}\n}", "fun f(a) {\n  if (a) {\n    return 1;\n  } else {\n    return 2;\n  }\n}\n"},
+		{"try", "try{f();}catch(e){print e;}\nfinally{g();}", "try {\n  f();\n} catch (e) {\n  print e;\n} finally {\n  g();\n}\n"},
+		{"lambdas", "var f=fun(a,b){return a;};\nf((x)=>x+1);", "var f = fun (a, b) {\n  return a;\n};\nf((x) => x + 1);\n"},
+		{"parameters", "fun f(a,b=1,...rest){}", "fun f(a, b = 1, ...rest) {}\n"},
+		{"conditionals", "print a?b:c??d;\nprint e ?. message;", "print a ? b : c ?? d;\nprint e?.message;\n"},
+		{"compound assignment", "a+=b%2;i ++;--j;print a++ - -- b;", "a += b % 2;\ni++;\n--j;\nprint a++ - --b;\n"},
+		{"empty block", "while (true) {\n\n}", "while (true) {}\n"},
+		{"for clauses", "for(var i=0;i<3;i=i+1)print i;", "for (var i = 0; i < 3; i = i + 1) print i;\n"},
+		{"unbraced else", "if (x) print x;\nelse print y;", "if (x) print x; else print y;\n"},
+		{"blank lines", "var a;\n\n\n\nvar b;\n{\n\nvar c;\n\n}", "var a;\n\nvar b;\n{\n  var c;\n}\n"},
+		{"trailing comment", "var a = 1;   // one  \nvar b;", "var a = 1; // one\nvar b;\n"},
+		{"comment lines", "// header\n{\n// inside\nprint 1;\n}", "// header\n{\n  // inside\n  print 1;\n}\n"},
+		{"comment in expression", "f(1, // first\n2);", "f(1, // first\n  2);\n"},
+		{"comment before brace", "{\nprint 1; // done\n}", "{\n  print 1; // done\n}\n"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Source(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Source(%q) =\n%s\nwant\n%s", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"unclosed brace", "{\nprint 1;", "line 2: unexpected end of file"},
+		{"extra brace", "print 1;\n}", "line 2: unexpected '}'"},
+		{"extra paren", "print (1));", "line 1: unexpected ')'"},
+		{"bad character", "var x;\nprint #;", "line 2: Unexpected character."},
+		{"unterminated string", "print \"abc;", "line 1: Unterminated String."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Source(tt.source)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Source(%q) error = %v, want %q", tt.source, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestCorpus formats the repository's scripts and checks that formatting
+// keeps every token and that formatted code is left alone.
+func TestCorpus(t *testing.T) {
+	paths, err := filepath.Glob("../src/testdata/*.clox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = append(paths, "../test.clox")
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			once, err := Source(string(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			twice, err := Source(once)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if twice != once {
+				t.Errorf("formatting is not idempotent:\n%s\nbecame\n%s", once, twice)
+			}
+			if before, after := tokenTexts(t, string(source)), tokenTexts(t, once); !reflect.DeepEqual(before, after) {
+				t.Errorf("formatting changed the tokens from %q to %q", before, after)
+			}
+		})
+	}
+}
+
+// tokenTexts returns the text of each token of source other than comments.
+func tokenTexts(t *testing.T, source string) []string {
+	t.Helper()
+	tokens, err := scan(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, token := range tokens {
+		if token.TOKENType != globals.TokenCOMMENT {
+			texts = append(texts, source[token.Start:token.Start+token.Length])
+		}
+	}
+	return texts
+}
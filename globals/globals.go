@@ -28,6 +28,27 @@ const (
 	OpDivide
 	OpNot
 	OpConstant
+	OpSetLocalPop
+	OpSetGlobalPop
+	OpGetLocal0
+	OpGetLocal1
+	OpGetLocal2
+	OpGetLocal3
+	OpAddConstant
+	OpLessJumpFalse
+	OpIncrementLocal
+	OpImport
+	OpTry
+	OpTryFinally
+	OpEndTry
+	OpEndFinally
+	OpThrow
+	OpGetProperty
+	OpArgMissing
+	OpModulo
+	OpJumpNil
+	OpIncrement
+	OpDecrement
 )
 
 type TokenType int
@@ -45,11 +66,25 @@ const (
 	TokenSEMICOLON
 	TokenSLASH
 	TokenSTAR
+	TokenPERCENT
+	TokenQUESTION
+	TokenCOLON
 
 	TokenBANG
 	TokenBANG_EQUAL
 	TokenEQUAL
 	TokenEQUAL_EQUAL
+	TokenARROW
+	TokenELLIPSIS
+	TokenPLUS_EQUAL
+	TokenMINUS_EQUAL
+	TokenSTAR_EQUAL
+	TokenSLASH_EQUAL
+	TokenPERCENT_EQUAL
+	TokenPLUS_PLUS
+	TokenMINUS_MINUS
+	TokenQUESTION_QUESTION
+	TokenQUESTION_DOT
 	TokenGREATER
 	TokenGREATER_EQUAL
 	TokenLESS
@@ -60,25 +95,42 @@ const (
 	TokenNUMBER
 
 	TokenAND
+	TokenCATCH
 	TokenCLASS
 	TokenELSE
 	TokenFALSE
+	TokenFINALLY
 	TokenFOR
 	TokenFUN
 	TokenIF
+	TokenIMPORT
 	TokenNIL
 	TokenOR
 	TokenPRINT
 	TokenRETURN
 	TokenSUPER
 	TokenTHIS
+	TokenTHROW
 	TokenTRUE
+	TokenTRY
 	TokenVAR
 	TokenWHILE
 
 	TokenERROR
 	TokenEOF
+
+	// Trivia, only scanned when Scanner.Comments or Scanner.Whitespace is set.
+	TokenCOMMENT
+	TokenWHITESPACE
 )
 
 var DEBUG_TRACE_EXECUTION = false
 var DEBUG_PRINT_CODE = false
+var OPTIMIZE_CODE = false
+var SUPER_INSTRUCTIONS = true
+var REGISTER_VM = false
+var VERIFY_CODE = false
+
+// MODULE_PATH lists the directories searched for an imported module that is
+// not found next to the file importing it.
+var MODULE_PATH []string
@@ -0,0 +1,254 @@
+/*
+Package lint reports likely mistakes in Lox source code.
+
+Each finding has a rule ID:
+
+  - unused-variable: a local variable or function that is never read;
+  - unused-parameter: a parameter that is never read;
+  - shadow: a local that hides a local of an enclosing block;
+  - undefined-global: an assignment to a global that is never declared,
+    which fails at run time;
+  - unreachable: a statement after a return in the same block;
+  - arity: a call to a global function with the wrong number of arguments;
+  - self-compare: a comparison of an expression with itself, like x == x.
+
+Names starting with an underscore are exempt from the unused rules.
+
+Comments turn rules off. A `// lint:ignore rule...` comment silences the
+rules it names on its own line, or on the next line if it is the only thing
+on its line. A `// lint:disable rule...` comment silences them for the rest
+of the file. The rule name all stands for every rule.
+*/
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/smekuria1/goclox/globals"
+	"github.com/smekuria1/goclox/src"
+)
+
+// Finding is a likely mistake at a position in the source.
+type Finding struct {
+	Rule    string
+	Start   int // The byte offset of the offending code.
+	Line    int
+	Column  int // The 1-based column of the offending code, in runes.
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", f.Line, f.Column, f.Message, f.Rule)
+}
+
+/*
+Source checks Lox source code.
+
+It analyzes the source with src.Analyze, so it reinitializes the VM. Code with
+compile errors is not checked.
+
+Parameters:
+- source: the source of a script.
+
+Returns:
+- []Finding: the findings that are not silenced, in source order.
+- []src.Diagnostic: the compile errors.
+*/
+func Source(source string) ([]Finding, []src.Diagnostic) {
+	return File("", source)
+}
+
+// File is Source for the script at path, against whose directory its imports resolve.
+func File(path, source string) ([]Finding, []src.Diagnostic) {
+	analysis := src.AnalyzeFile(path, source)
+	if len(analysis.Diagnostics) > 0 {
+		return nil, analysis.Diagnostics
+	}
+
+	c := checker{source: source, analysis: analysis}
+	c.unused()
+	c.shadows()
+	c.undefinedGlobals()
+	c.arity()
+	for _, span := range analysis.Unreachable {
+		c.report("unreachable", span.Start, span.Line, span.Column, "unreachable code after return")
+	}
+	for _, span := range analysis.Tautologies {
+		c.report("self-compare", span.Start, span.Line, span.Column, fmt.Sprintf("comparison of %s with itself", c.source[span.Start:span.Start+span.Length]))
+	}
+
+	silenced := directives(source)
+	findings := c.findings[:0]
+	for _, f := range c.findings {
+		if !silenced(f) {
+			findings = append(findings, f)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+	return findings, nil
+}
+
+// checker collects the findings of one source file.
+type checker struct {
+	source   string
+	analysis *src.Analysis
+	findings []Finding
+}
+
+// report adds a finding about the code at byte offset start, on line and column.
+func (c *checker) report(rule string, start, line, column int, message string) {
+	c.findings = append(c.findings, Finding{
+		Rule:    rule,
+		Start:   start,
+		Line:    line,
+		Column:  column,
+		Message: message,
+	})
+}
+
+// unused reports locals and parameters that are never read.
+func (c *checker) unused() {
+	read := make([]bool, len(c.analysis.Symbols))
+	for _, r := range c.analysis.References {
+		if r.Symbol >= 0 && !r.Assign {
+			read[r.Symbol] = true
+		}
+	}
+	for i, s := range c.analysis.Symbols {
+		if s.Global || read[i] || strings.HasPrefix(s.Name, "_") {
+			continue
+		}
+		if s.Kind == src.SymbolParameter {
+			c.report("unused-parameter", s.Start, s.Line, s.Column, fmt.Sprintf("parameter %s is never used", s.Name))
+		} else {
+			c.report("unused-variable", s.Start, s.Line, s.Column, fmt.Sprintf("%s is declared but never used", s.Name))
+		}
+	}
+}
+
+// shadows reports locals that hide locals of enclosing blocks.
+func (c *checker) shadows() {
+	for _, s := range c.analysis.Symbols {
+		if s.Shadows >= 0 {
+			outer := c.analysis.Symbols[s.Shadows]
+			c.report("shadow", s.Start, s.Line, s.Column, fmt.Sprintf("%s shadows the declaration on line %d", s.Name, outer.Line))
+		}
+	}
+}
+
+// undefinedGlobals reports assignments to globals that are never declared.
+func (c *checker) undefinedGlobals() {
+	for _, r := range c.analysis.References {
+		if r.Assign && r.Symbol == -1 {
+			name := c.source[r.Start : r.Start+r.Length]
+			c.report("undefined-global", r.Start, r.Line, r.Column, fmt.Sprintf("assignment to undefined global %s", name))
+		}
+	}
+}
+
+// arity reports calls with the wrong number of arguments to global functions
+// that are declared once and never reassigned.
+func (c *checker) arity() {
+	known := make(map[int]bool)
+	declarations := make(map[string]int)
+	for i, s := range c.analysis.Symbols {
+		if s.Global {
+			declarations[s.Name]++
+			known[i] = s.Kind == src.SymbolFunction
+		}
+	}
+	for i, s := range c.analysis.Symbols {
+		if declarations[s.Name] > 1 {
+			known[i] = false
+		}
+	}
+	for _, r := range c.analysis.References {
+		if r.Assign && r.Symbol >= 0 {
+			known[r.Symbol] = false
+		}
+	}
+
+	for _, call := range c.analysis.Calls {
+		r := c.analysis.References[call.Reference]
+		if r.Symbol < 0 || !known[r.Symbol] {
+			continue
+		}
+		function := c.analysis.Symbols[r.Symbol]
+		positional := len(function.Params)
+		if function.Variadic {
+			positional--
+		}
+		if call.Args >= function.Required && (call.Args <= positional || function.Variadic) {
+			continue
+		}
+		takes := plural(positional, "argument")
+		if function.Variadic {
+			takes = "at least " + plural(function.Required, "argument")
+		} else if function.Required < positional {
+			takes = fmt.Sprintf("%d to %s", function.Required, takes)
+		}
+		c.report("arity", r.Start, r.Line, r.Column, fmt.Sprintf("%s takes %s but is called with %d", function.Name, takes, call.Args))
+	}
+}
+
+// directives returns a function that reports whether the comments in source
+// silence a finding.
+func directives(source string) func(Finding) bool {
+	ignored := make(map[int]map[string]bool) // Rules silenced by line.
+	disabled := make(map[string]int)         // The first line each rule is disabled on.
+
+	prevLine := 0
+	for _, token := range src.Tokenize(source, true) {
+		switch token.TOKENType {
+		case globals.TokenCOMMENT:
+		case globals.TokenWHITESPACE:
+			continue
+		default:
+			prevLine = token.Line
+			continue
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(source[token.Start:token.Start+token.Length], "//"))
+		directive, rules, _ := strings.Cut(text, " ")
+		switch directive {
+		case "lint:ignore":
+			line := token.Line
+			if prevLine != token.Line {
+				line++ // The comment is on a line of its own.
+			}
+			if ignored[line] == nil {
+				ignored[line] = make(map[string]bool)
+			}
+			for _, rule := range strings.Fields(rules) {
+				ignored[line][rule] = true
+			}
+		case "lint:disable":
+			for _, rule := range strings.Fields(rules) {
+				if _, ok := disabled[rule]; !ok {
+					disabled[rule] = token.Line
+				}
+			}
+		}
+	}
+
+	return func(f Finding) bool {
+		for _, rule := range []string{f.Rule, "all"} {
+			if ignored[f.Line][rule] {
+				return true
+			}
+			if line, ok := disabled[rule]; ok && line <= f.Line {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// plural returns n followed by noun, pluralized if n is not 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,74 @@
+package lint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"clean", "fun add(a, b) {\n  return a + b;\n}\nprint add(1, 2);", nil},
+		{"unused variable", "{\n  var x = 1;\n  x = 2;\n}", []string{"2:7: x is declared but never used (unused-variable)"}},
+		{"only incremented", "{\n  var i = 0;\n  i++;\n  var j = 0;\n  j += 1;\n  print j;\n}\nk -= 1;", []string{
+			"2:7: i is declared but never used (unused-variable)",
+			"8:1: assignment to undefined global k (undefined-global)",
+		}},
+		{"unused parameter", "fun f(a, b) { return a; }\nf(1, 2);", []string{"1:10: parameter b is never used (unused-parameter)"}},
+		{"underscore", "fun f(_a) { var _b; }\nf(1);", nil},
+		{"globals are used elsewhere", "var x = 1;", nil},
+		{"shadow", "{\n  var x = 1;\n  {\n    var x = 2;\n    print x;\n  }\n  print x;\n}", []string{"4:9: x shadows the declaration on line 2 (shadow)"}},
+		{"undefined global", "var a;\na = 1;\nb = 2;", []string{"3:1: assignment to undefined global b (undefined-global)"}},
+		{"global declared later", "fun f() { later = 1; }\nvar later;\nf();", nil},
+		{"unreachable", "fun f() {\n  return 1;\n  print 2;\n  print 3;\n}\nf();", []string{"3:3: unreachable code after return (unreachable)"}},
+		{"return in branch", "fun f(x) {\n  if (x) return 1;\n  return 2;\n}\nf(1);", nil},
+		{"arity", "fun f(a) { return a; }\nprint f();\nprint f(1, 2);\nprint f(1);", []string{
+			"2:7: f takes 1 argument but is called with 0 (arity)",
+			"3:7: f takes 1 argument but is called with 2 (arity)",
+		}},
+		{"optional arity", "fun f(a, b = 1) { return a + b; }\nfun g(a, ...rest) { return a + rest.length; }\nf();\nf(1, 2, 3);\ng();\ng(1, 2, 3);", []string{
+			"3:1: f takes 1 to 2 arguments but is called with 0 (arity)",
+			"4:1: f takes 1 to 2 arguments but is called with 3 (arity)",
+			"5:1: g takes at least 1 argument but is called with 0 (arity)",
+		}},
+		{"reassigned function", "fun f(a) { return a; }\nfun g() {}\nf = g;\nf();", nil},
+		{"self compare", "var x = 1;\nprint x == x;\nprint x + 1 < x + 1;", []string{
+			"2:7: comparison of x with itself (self-compare)",
+			"3:7: comparison of x + 1 with itself (self-compare)",
+		}},
+		{"compare calls and constants", "fun f() { return 1; }\nprint f() == f();\nprint 1 == 1;\nvar x;\nprint x == -x;", nil},
+		{"ignore on line", "b = 1; // lint:ignore undefined-global", nil},
+		{"ignore next line", "// lint:ignore undefined-global\nb = 1;\nc = 2;", []string{"3:1: assignment to undefined global c (undefined-global)"}},
+		{"ignore other rule", "b = 1; // lint:ignore shadow", []string{"1:1: assignment to undefined global b (undefined-global)"}},
+		{"disable", "b = 1;\n// lint:disable undefined-global arity\nc = 2;", []string{"1:1: assignment to undefined global b (undefined-global)"}},
+		{"disable all", "// lint:disable all\nb = 1;", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, diagnostics := Source(tt.source)
+			if len(diagnostics) > 0 {
+				t.Fatalf("compile errors: %v", diagnostics)
+			}
+			var got []string
+			for _, f := range findings {
+				got = append(got, f.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findings = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceCompileErrors(t *testing.T) {
+	findings, diagnostics := Source("{ var x; }\nprint ;")
+	if len(findings) != 0 {
+		t.Errorf("findings = %v, want none for code that does not compile", findings)
+	}
+	if len(diagnostics) != 1 || diagnostics[0].Line != 2 {
+		t.Errorf("diagnostics = %+v, want one on line 2", diagnostics)
+	}
+}
@@ -0,0 +1,178 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/smekuria1/goclox/src"
+)
+
+// document is an open text document and what the compiler made of it.
+type document struct {
+	text       string
+	lineStarts []int // The byte offset at which each line starts.
+	analysis   *src.Analysis
+}
+
+// newDocument analyzes the text of the document at uri and returns it as a document.
+func newDocument(uri, text string) *document {
+	d := &document{text: text, lineStarts: []int{0}, analysis: src.AnalyzeFile(uriPath(uri), text)}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+	return d
+}
+
+// uriPath returns the path of a file URI, against whose directory the
+// document's imports resolve, or "" for any other URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return u.Path
+}
+
+// position converts a byte offset to an LSP position, whose character is
+// counted in UTF-16 code units.
+func (d *document) position(offset int) position {
+	offset = max(0, min(offset, len(d.text)))
+	line := sort.Search(len(d.lineStarts), func(i int) bool { return d.lineStarts[i] > offset }) - 1
+	character := 0
+	for _, r := range d.text[d.lineStarts[line]:offset] {
+		character += utf16Length(r)
+	}
+	return position{Line: line, Character: character}
+}
+
+// offset converts an LSP position to a byte offset, clamping it to the document.
+func (d *document) offset(p position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+	offset := d.lineStarts[p.Line]
+	for character := 0; character < p.Character && offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		character += utf16Length(r)
+		offset += size
+	}
+	return offset
+}
+
+// textRange returns the range of length bytes starting at offset start.
+func (d *document) textRange(start, length int) textRange {
+	return textRange{Start: d.position(start), End: d.position(start + length)}
+}
+
+// utf16Length returns the number of UTF-16 code units that encode r.
+func utf16Length(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// symbols returns the document symbols declared directly in the function
+// with the given symbol index, or at top level for -1, with their children.
+func (d *document) symbols(container int) []documentSymbol {
+	result := []documentSymbol{}
+	for i, symbol := range d.analysis.Symbols {
+		if symbol.Container != container {
+			continue
+		}
+		r := d.textRange(symbol.Start, symbol.Length)
+		item := documentSymbol{Name: symbol.Name, Kind: symbolKindVariable, Range: r, SelectionRange: r}
+		switch symbol.Kind {
+		case src.SymbolFunction:
+			item.Kind = symbolKindFunction
+			item.Detail = "(" + strings.Join(symbol.Params, ", ") + ")"
+			item.Children = d.symbols(i)
+		case src.SymbolParameter:
+			item.Detail = "parameter"
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// symbolAt returns the symbol declared or referenced at offset, with the
+// position of the name found there, or nil.
+func (d *document) symbolAt(offset int) (symbol *src.Symbol, start, length int) {
+	symbols := d.analysis.Symbols
+	for i := range symbols {
+		if symbols[i].Start <= offset && offset <= symbols[i].Start+symbols[i].Length {
+			return &symbols[i], symbols[i].Start, symbols[i].Length
+		}
+	}
+	for _, reference := range d.analysis.References {
+		if reference.Symbol >= 0 && reference.Start <= offset && offset <= reference.Start+reference.Length {
+			return &symbols[reference.Symbol], reference.Start, reference.Length
+		}
+	}
+	return nil, 0, 0
+}
+
+// completions returns the keywords and the globals of the document, sorted by label.
+func (d *document) completions() []completionItem {
+	items := make([]completionItem, 0, len(keywords))
+	for _, keyword := range keywords {
+		items = append(items, completionItem{Label: keyword, Kind: completionKindKeyword})
+	}
+	if d != nil {
+		seen := make(map[string]bool)
+		for i := range d.analysis.Symbols {
+			symbol := &d.analysis.Symbols[i]
+			if !symbol.Global || seen[symbol.Name] {
+				continue
+			}
+			seen[symbol.Name] = true
+			item := completionItem{Label: symbol.Name, Kind: completionKindVariable, Detail: describe(symbol)}
+			if symbol.Kind == src.SymbolFunction {
+				item.Kind = completionKindFunction
+			}
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// describe returns a one-line description of a symbol, such as "fun add(a, b) // arity 2".
+// Parameters with a default value end in '?', and a function that takes any
+// number of arguments from some on has an arity such as 1+.
+func describe(symbol *src.Symbol) string {
+	switch symbol.Kind {
+	case src.SymbolFunction:
+		params := make([]string, len(symbol.Params))
+		copy(params, symbol.Params)
+		positional := len(params)
+		if symbol.Variadic {
+			positional--
+			params[positional] = "..." + params[positional]
+		}
+		for i := symbol.Required; i < positional; i++ {
+			params[i] += "?"
+		}
+		arity := fmt.Sprint(positional)
+		if symbol.Variadic {
+			arity = fmt.Sprintf("%d+", symbol.Required)
+		} else if symbol.Required < positional {
+			arity = fmt.Sprintf("%d-%d", symbol.Required, positional)
+		}
+		return fmt.Sprintf("fun %s(%s) // arity %s", symbol.Name, strings.Join(params, ", "), arity)
+	case src.SymbolParameter:
+		return "parameter " + symbol.Name
+	}
+	if symbol.Global {
+		return "var " + symbol.Name + " // global"
+	}
+	return "var " + symbol.Name
+}
@@ -0,0 +1,193 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/smekuria1/goclox/internal/wire"
+)
+
+// keywords are offered by completion.
+var keywords = []string{
+	"and", "catch", "class", "else", "false", "finally", "for", "fun", "if", "import", "nil", "or",
+	"print", "return", "super", "this", "throw", "true", "try", "var", "while",
+}
+
+// errExitWithoutShutdown is returned by Serve when the client exits without asking to shut down first.
+var errExitWithoutShutdown = errors.New("lsp: exit without shutdown")
+
+/*
+Server is a Language Server Protocol server for .clox files.
+
+Documents are synchronized in full on every change. Each version is analyzed
+with src.Analyze, which uses the process-wide VM, so a process should run one
+server and nothing else.
+*/
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	documents map[string]*document
+	shutdown  bool // Set by the shutdown request.
+}
+
+// NewServer returns a server that reads messages from in and writes to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, documents: make(map[string]*document)}
+}
+
+// Serve handles messages until the client sends exit or closes its input.
+func (s *Server) Serve() error {
+	for {
+		content, err := wire.ReadMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("lsp: %v", err)
+		}
+		var msg message
+		if err := json.Unmarshal(content, &msg); err != nil {
+			s.write(&message{Error: &responseError{Code: codeInvalidRequest, Message: err.Error()}})
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+		result, rpcErr := s.handle(&msg)
+		if msg.ID == nil {
+			continue // Notifications have no response.
+		}
+		response := &message{ID: msg.ID, Result: result, Error: rpcErr}
+		if result == nil && rpcErr == nil {
+			response.Result = json.RawMessage("null")
+		}
+		s.write(response)
+	}
+}
+
+// handle runs one request or notification and returns its result.
+func (s *Server) handle(msg *message) (any, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1, // Full documents.
+				"documentSymbolProvider": true,
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"completionProvider":     map[string]any{},
+			},
+			"serverInfo": map[string]any{"name": "goclox"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params textDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var params textDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		doc := s.documents[params.TextDocument.URI]
+		if doc == nil {
+			return []documentSymbol{}, nil
+		}
+		return doc.symbols(-1), nil
+	case "textDocument/definition", "textDocument/hover":
+		var params positionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		doc := s.documents[params.TextDocument.URI]
+		if doc == nil {
+			return nil, nil
+		}
+		symbol, start, length := doc.symbolAt(doc.offset(params.Position))
+		if symbol == nil {
+			return nil, nil
+		}
+		if msg.Method == "textDocument/hover" {
+			return hover{
+				Contents: markupContent{Kind: "markdown", Value: "```lox\n" + describe(symbol) + "\n```"},
+				Range:    doc.textRange(start, length),
+			}, nil
+		}
+		return location{URI: params.TextDocument.URI, Range: doc.textRange(symbol.Start, symbol.Length)}, nil
+	case "textDocument/completion":
+		var params positionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.documents[params.TextDocument.URI].completions(), nil
+	default:
+		if msg.ID == nil || strings.HasPrefix(msg.Method, "$/") {
+			return nil, nil
+		}
+		return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not supported", msg.Method)}
+	}
+}
+
+// update stores a new version of a document, analyzes it and publishes its diagnostics.
+func (s *Server) update(uri, text string) {
+	doc := newDocument(uri, text)
+	s.documents[uri] = doc
+
+	diagnostics := []diagnostic{}
+	for _, d := range doc.analysis.Diagnostics {
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    doc.textRange(d.Start, d.Length),
+			Severity: severityError,
+			Source:   "goclox",
+			Message:  d.Message,
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+// notify sends a notification to the client.
+func (s *Server) notify(method string, params any) {
+	content, _ := json.Marshal(params)
+	s.write(&message{Method: method, Params: content})
+}
+
+// write sends a message to the client.
+func (s *Server) write(msg *message) {
+	msg.JSONRPC = "2.0"
+	wire.WriteMessage(s.out, msg)
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
@@ -0,0 +1,229 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/smekuria1/goclox/internal/wire"
+	"github.com/smekuria1/goclox/src"
+)
+
+const testURI = "file:///project/main.clox"
+
+const testSource = `var total = 0;
+fun add(a, b) {
+  var sum = a + b;
+  return sum;
+}
+total = add(1, 2);
+`
+
+// testClient talks to a Server over pipes, like an editor would.
+type testClient struct {
+	t             *testing.T
+	in            *io.PipeWriter
+	out           *bufio.Reader
+	id            int
+	notifications []map[string]any // Notifications received while waiting for responses.
+	served        chan error
+}
+
+func newTestClient(t *testing.T) *testClient {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &testClient{t: t, in: clientOut, out: bufio.NewReader(clientIn), served: make(chan error, 1)}
+	go func() {
+		c.served <- NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	return c
+}
+
+// read returns the next message from the server.
+func (c *testClient) read() map[string]any {
+	c.t.Helper()
+	content, err := wire.ReadMessage(c.out)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(content, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// notify sends a notification.
+func (c *testClient) notify(method string, params any) {
+	c.t.Helper()
+	if err := wire.WriteMessage(c.in, map[string]any{"jsonrpc": "2.0", "method": method, "params": params}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and returns its response.
+func (c *testClient) call(method string, params any) map[string]any {
+	c.t.Helper()
+	c.id++
+	if err := wire.WriteMessage(c.in, map[string]any{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.read()
+		if msg["id"] == float64(c.id) {
+			return msg
+		}
+		c.notifications = append(c.notifications, msg)
+	}
+}
+
+// diagnostics opens or changes the test document and returns the messages of the published diagnostics.
+func (c *testClient) diagnostics(method string, params any) [][]any {
+	c.t.Helper()
+	c.notify(method, params)
+	msg := c.read()
+	if msg["method"] != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("got %v, want diagnostics", msg)
+	}
+	var result [][]any
+	for _, d := range msg["params"].(map[string]any)["diagnostics"].([]any) {
+		start := d.(map[string]any)["range"].(map[string]any)["start"].(map[string]any)
+		result = append(result, []any{start["line"], start["character"], d.(map[string]any)["message"]})
+	}
+	return result
+}
+
+// at returns textDocument/position params for a 0-based line and character.
+func at(line, character int) map[string]any {
+	return map[string]any{"textDocument": map[string]any{"uri": testURI}, "position": map[string]any{"line": line, "character": character}}
+}
+
+func TestLanguageServer(t *testing.T) {
+	c := newTestClient(t)
+	capabilities := c.call("initialize", map[string]any{"capabilities": map[string]any{}})["result"].(map[string]any)["capabilities"].(map[string]any)
+	if capabilities["hoverProvider"] != true || capabilities["definitionProvider"] != true {
+		t.Errorf("capabilities = %v", capabilities)
+	}
+	c.notify("initialized", map[string]any{})
+
+	got := c.diagnostics("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": testURI, "languageId": "lox", "version": 1, "text": testSource}})
+	if len(got) != 0 {
+		t.Errorf("diagnostics on open = %v, want none", got)
+	}
+	got = c.diagnostics("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": testURI, "version": 2},
+		"contentChanges": []any{map[string]any{"text": "var x = 1;\nprint x +;\n"}},
+	})
+	if want := [][]any{{1.0, 9.0, "Expect expression"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("diagnostics after change = %v, want %v", got, want)
+	}
+	c.diagnostics("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": testURI, "version": 3},
+		"contentChanges": []any{map[string]any{"text": testSource}},
+	})
+
+	symbols := c.call("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": testURI}})["result"].([]any)
+	var names []any
+	for _, s := range symbols {
+		names = append(names, s.(map[string]any)["name"])
+	}
+	if want := []any{"total", "add"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("document symbols = %v, want %v", names, want)
+	}
+	if children := symbols[1].(map[string]any)["children"].([]any); len(children) != 3 {
+		t.Errorf("add has %d children, want a, b and sum", len(children))
+	}
+
+	// The call to add on line 6 goes to its declaration on line 2.
+	location := c.call("textDocument/definition", at(5, 9))["result"].(map[string]any)
+	if start := location["range"].(map[string]any)["start"]; !reflect.DeepEqual(start, map[string]any{"line": 1.0, "character": 4.0}) {
+		t.Errorf("definition of add starts at %v, want line 1 character 4", start)
+	}
+	// The use of sum on line 4 goes to the local on line 3.
+	location = c.call("textDocument/definition", at(3, 10))["result"].(map[string]any)
+	if start := location["range"].(map[string]any)["start"]; !reflect.DeepEqual(start, map[string]any{"line": 2.0, "character": 6.0}) {
+		t.Errorf("definition of sum starts at %v, want line 2 character 6", start)
+	}
+	if result := c.call("textDocument/definition", at(5, 13))["result"]; result != nil {
+		t.Errorf("definition of a number = %v, want null", result)
+	}
+
+	contents := c.call("textDocument/hover", at(5, 9))["result"].(map[string]any)["contents"].(map[string]any)
+	if want := "```lox\nfun add(a, b) // arity 2\n```"; contents["value"] != want {
+		t.Errorf("hover = %q, want %q", contents["value"], want)
+	}
+
+	items := c.call("textDocument/completion", at(5, 0))["result"].([]any)
+	labels := make(map[any]bool)
+	for _, item := range items {
+		labels[item.(map[string]any)["label"]] = true
+	}
+	for _, label := range []string{"add", "total", "while", "fun"} {
+		if !labels[label] {
+			t.Errorf("completion is missing %q", label)
+		}
+	}
+	if labels["sum"] {
+		t.Errorf("completion offers the local sum")
+	}
+
+	if response := c.call("textDocument/rename", at(0, 0)); response["error"] == nil {
+		t.Errorf("unsupported request succeeded: %v", response)
+	}
+	c.call("shutdown", nil)
+	c.notify("exit", nil)
+	if err := <-c.served; err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := newTestClient(t)
+	c.notify("exit", nil)
+	if err := <-c.served; err != errExitWithoutShutdown {
+		t.Errorf("Serve() = %v, want %v", err, errExitWithoutShutdown)
+	}
+}
+
+func TestDocumentPositions(t *testing.T) {
+	doc := newDocument("file:///test.clox", "var s = \"héllo 😀\";\nprint s;\n")
+	tests := []struct {
+		offset int
+		want   position
+	}{
+		{0, position{0, 0}},
+		{12, position{0, 11}}, // After the two-byte é, which is one UTF-16 unit.
+		{20, position{0, 17}}, // After the four-byte emoji, which is two UTF-16 units.
+		{23, position{1, 0}},
+		{29, position{1, 6}},
+	}
+	for _, tt := range tests {
+		got := doc.position(tt.offset)
+		if got != tt.want {
+			t.Errorf("position(%d) = %v, want %v", tt.offset, got, tt.want)
+		}
+		if back := doc.offset(got); back != tt.offset {
+			t.Errorf("offset(%v) = %d, want %d", got, back, tt.offset)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		symbol src.Symbol
+		want   string
+	}{
+		{src.Symbol{Name: "f", Kind: src.SymbolFunction}, "fun f() // arity 0"},
+		{src.Symbol{Name: "f", Kind: src.SymbolFunction, Params: []string{"a", "b"}, Required: 1}, "fun f(a, b?) // arity 1-2"},
+		{src.Symbol{Name: "f", Kind: src.SymbolFunction, Params: []string{"a", "rest"}, Required: 1, Variadic: true}, "fun f(a, ...rest) // arity 1+"},
+		{src.Symbol{Name: "x", Kind: src.SymbolParameter}, "parameter x"},
+	}
+	for _, tt := range tests {
+		if got := describe(&tt.symbol); got != tt.want {
+			t.Errorf("describe(%+v) = %q, want %q", tt.symbol, got, tt.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/pkg/profile"
 	"github.com/smekuria1/goclox/globals"
@@ -21,24 +22,61 @@ var cpuprof = flag.Bool("cpuprof", false, "write cpu profile to file")
 //var memprof = flag.Bool("memprof", false, "write memory profile to `file`")
 
 func main() {
+	globals.MODULE_PATH = filepath.SplitList(os.Getenv("GOCLOX_PATH"))
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 
 	flag.BoolVar(&globals.DEBUG_TRACE_EXECUTION, "debugT", false, "Turn on debug trace execution mode")
 	flag.BoolVar(&globals.DEBUG_PRINT_CODE, "debugC", false, "Turn on debug print code mode")
+	flag.BoolVar(&globals.OPTIMIZE_CODE, "O", false, "Optimize compiled bytecode")
+	flag.BoolVar(&globals.REGISTER_VM, "reg", false, "Run on the experimental register VM")
+	flag.BoolVar(&globals.VERIFY_CODE, "verify", false, "Verify compiled bytecode before running it")
 	flag.Parse()
 	if *cpuprof {
 		defer profile.Start(profile.ProfilePath(".")).Stop()
 	}
 	if *help {
+		fmt.Println("Usage: goclox [flags] | goclox <command> [flags] file")
+		fmt.Println("Commands:")
+		fmt.Println("  build [-o out.cloxc] file.clox")
+		fmt.Println("    Compile a script to a bytecode file")
+		fmt.Println("  run file.clox|file.cloxc|-")
+		fmt.Println("    Run a script or a bytecode file, or the script on standard input")
+		fmt.Println("  disasm [-format text|json] file.clox|file.cloxc|-")
+		fmt.Println("    Print the disassembly of a script and its functions")
+		fmt.Println("  debug file.clox")
+		fmt.Println("    Run a script under the interactive debugger")
+		fmt.Println("  dap")
+		fmt.Println("    Serve the Debug Adapter Protocol over stdin and stdout")
+		fmt.Println("  lsp")
+		fmt.Println("    Serve the Language Server Protocol over stdin and stdout")
+		fmt.Println("  fmt [-w] [-d] [-l] [file.clox...]")
+		fmt.Println("    Format scripts, or standard input if no files are given")
+		fmt.Println("  lint file.clox...")
+		fmt.Println("    Report likely mistakes in scripts")
+		fmt.Println("Flags:")
 		fmt.Println("-debugT bool")
 		fmt.Println("    Turn on debug trace execution mode")
 		fmt.Println("-debugC bool")
 		fmt.Println("    Turn on debug print code mode")
+		fmt.Println("-O bool")
+		fmt.Println("    Optimize compiled bytecode")
+		fmt.Println("-reg bool")
+		fmt.Println("    Run on the experimental register VM")
+		fmt.Println("-verify bool")
+		fmt.Println("    Verify compiled bytecode before running it")
 		fmt.Println("-file string")
 		fmt.Println("    Path to gocloxfile")
 		fmt.Println("-repl bool")
 		fmt.Println("    Start with REPL mode (default \"0\")")
 		fmt.Println("-help")
 		fmt.Println("    Print this help message")
+		fmt.Println("Environment:")
+		fmt.Println("GOCLOX_PATH")
+		fmt.Println("    Directories to search for imported modules, separated like PATH")
 		return
 	}
 	src.InitVM()
@@ -52,8 +90,13 @@ func main() {
 		}
 
 		fmt.Println("Running file", *filename)
-		source := readFile(*filename)
-		src.Interpret(source)
+		function, code := loadFunction(*filename)
+		if code == exitIOError {
+			os.Exit(code)
+		}
+		if function != nil {
+			src.InterpretFunction(function)
+		}
 
 	}
 
@@ -61,25 +104,6 @@ func main() {
 
 }
 
-func readFile(path string) string {
-	// file, err := os.Open(path)
-	// if err != nil {
-	// 	panic("File not found")
-	// }
-	// scanner := bufio.NewScanner(file)
-	// scanner.Split(bufio.ScanLines)
-	// var lines []string
-	// for scanner.Scan() {
-	// 	if scanner.Text() == "\n" {
-	// 		continue
-	// 	}
-	// 	lines = append(lines, scanner.Text())
-	// }
-	file, _ := os.ReadFile(path)
-	return string(file)
-
-}
-
 func replFunc() {
 	var line string
 	scanner := bufio.NewScanner(os.Stdin)
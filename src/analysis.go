@@ -0,0 +1,315 @@
+package src
+
+import (
+	"io"
+	"os"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// SymbolKind tells what a declaration declares.
+type SymbolKind int
+
+const (
+	SymbolVariable  SymbolKind = iota // A var declaration.
+	SymbolFunction                    // A fun declaration.
+	SymbolParameter                   // A function parameter.
+)
+
+// Symbol is a declared variable, function or parameter.
+type Symbol struct {
+	Name      string
+	Kind      SymbolKind
+	Global    bool     // Whether the symbol is a global rather than a local.
+	Start     int      // The byte offset of the declared name.
+	Length    int      // The length of the declared name in bytes.
+	Line      int      // The line of the declared name.
+	Column    int      // The column of the declared name, in runes.
+	Container int      // The index of the enclosing function's symbol, or -1 at top level.
+	Params    []string // The parameter names of a function.
+	Required  int      // The number of parameters of a function without a default value.
+	Variadic  bool     // Whether the last parameter of a function collects the extra arguments.
+	Shadows   int      // The index of the local symbol an inner local hides, or -1.
+}
+
+// Reference is a use of a variable by name.
+type Reference struct {
+	Name   string
+	Start  int  // The byte offset of the name.
+	Length int  // The length of the name in bytes.
+	Line   int  // The line of the name.
+	Column int  // The column of the name, in runes.
+	Symbol int  // The index of the symbol it refers to, or -1 for an undeclared global.
+	Assign bool // Whether the use assigns to the variable rather than reading it.
+}
+
+// Call is a call whose callee is a variable.
+type Call struct {
+	Reference int // The index of the callee's reference.
+	Args      int // The number of arguments passed.
+}
+
+// Span is a range of the source.
+type Span struct {
+	Start  int // The byte offset of the range.
+	Length int // The length of the range in bytes.
+	Line   int // The line the range starts on.
+	Column int // The column the range starts at, in runes.
+}
+
+// Diagnostic is a compile error at a position in the source.
+type Diagnostic struct {
+	Start   int // The byte offset of the offending token.
+	Length  int // The length of the offending token in bytes.
+	Line    int
+	Column  int // The 1-based column of the offending token, in runes.
+	Message string
+}
+
+// Analysis is what the compiler learned about a source file.
+type Analysis struct {
+	Diagnostics []Diagnostic
+	Symbols     []Symbol
+	References  []Reference
+	Calls       []Call
+	Unreachable []Span // The first statement after a return in each block that has one.
+	Tautologies []Span // The left operands of comparisons of an expression with itself, like x == x.
+
+	tokens []Token // The tokens parsed so far, ending with parser.Current.
+}
+
+// analysis collects the results of Analyze while it compiles, and is nil otherwise.
+var analysis *Analysis
+
+/*
+Analyze compiles source without running it and returns its compile errors,
+declarations, variable references and the suspicious code it noticed.
+
+It reinitializes the VM like InitVM, and prints nothing.
+
+Parameters:
+- source: the source of a script.
+
+Returns:
+- *Analysis: the diagnostics, symbols, references and findings, each in source order.
+*/
+func Analyze(source string) *Analysis {
+	return AnalyzeFile("", source)
+}
+
+// AnalyzeFile is Analyze for the script at path, against whose directory its
+// imports resolve. Only the script itself is analyzed, not the modules it imports.
+func AnalyzeFile(path, source string) *Analysis {
+	InitVM()
+	SetOutput(io.Discard)
+	defer SetOutput(os.Stdout)
+	analysis = &Analysis{}
+	result := analysis
+	defer func() { analysis = nil }()
+
+	var chunk Chunk
+	InitChunk(&chunk)
+	CompileFile(path, source, &chunk)
+
+	// Globals can be used before they are declared, so references to them
+	// are resolved once the whole file is known.
+	declared := make(map[string]int)
+	for i, symbol := range result.Symbols {
+		if _, ok := declared[symbol.Name]; symbol.Global && !ok {
+			declared[symbol.Name] = i
+		}
+	}
+	for i := range result.References {
+		reference := &result.References[i]
+		if reference.Symbol == -1 {
+			if symbol, ok := declared[reference.Name]; ok {
+				reference.Symbol = symbol
+			}
+		}
+	}
+	return result
+}
+
+// declareSymbol records the name just parsed as a declaration of the given
+// kind while Analyze runs. It returns the symbol's index, or -1.
+func declareSymbol(kind SymbolKind) int {
+	name := parser.Previous
+	if analysis == nil || name.TOKENType != globals.TokenIDENTIFIER {
+		return -1
+	}
+	index := len(analysis.Symbols)
+	analysis.Symbols = append(analysis.Symbols, Symbol{
+		Name:      name.Text,
+		Kind:      kind,
+		Global:    current.scopeDepth == 0,
+		Start:     name.Start,
+		Length:    name.Length,
+		Line:      name.Pos.Line,
+		Column:    name.Pos.Column,
+		Container: current.symbol,
+		Shadows:   -1,
+	})
+	if current.scopeDepth > 0 && current.localCount > 0 {
+		if local := &current.locals[current.localCount-1]; local.name == name {
+			local.symbol = index
+			for i := current.localCount - 2; i > 0; i-- {
+				outer := &current.locals[i]
+				if outer.depth < current.scopeDepth && identfierEqual(&outer.name, &name) {
+					analysis.Symbols[index].Shadows = outer.symbol
+					break
+				}
+			}
+		}
+	}
+	if kind == SymbolParameter && current.symbol >= 0 {
+		function := &analysis.Symbols[current.symbol]
+		function.Params = append(function.Params, analysis.Symbols[index].Name)
+	}
+	return index
+}
+
+// declareArity records the arguments the function declared as symbol accepts
+// while Analyze runs.
+func declareArity(symbol int, function *ObjFunction) {
+	if analysis == nil || symbol < 0 {
+		return
+	}
+	analysis.Symbols[symbol].Required = function.minArity
+	analysis.Symbols[symbol].Variadic = function.variadic
+}
+
+// referenceSymbol records a use of name while Analyze runs; local is the
+// slot resolveLocal found for it, or -1 for a global.
+func referenceSymbol(name *Token, local int, assign bool) {
+	if analysis == nil {
+		return
+	}
+	symbol := -1
+	if local != -1 {
+		symbol = current.locals[local].symbol
+	}
+	analysis.References = append(analysis.References, Reference{
+		Name:   name.Text,
+		Start:  name.Start,
+		Length: name.Length,
+		Line:   name.Pos.Line,
+		Column: name.Pos.Column,
+		Symbol: symbol,
+		Assign: assign,
+	})
+}
+
+// calleeReference returns the index of the reference to the variable called
+// by the call whose '(' was just parsed, or -1 if the callee is not a bare
+// variable or Analyze is not running.
+func calleeReference() int {
+	if analysis == nil || len(analysis.References) == 0 {
+		return -1
+	}
+	index := len(analysis.References) - 1
+	reference := &analysis.References[index]
+	// The tokens end with the '(' and the token after it.
+	tokens := analysis.tokens
+	if reference.Assign || len(tokens) < 3 || tokens[len(tokens)-3].Start != reference.Start {
+		return -1
+	}
+	return index
+}
+
+// recordToken adds token, which the parser just reached, to the tokens of
+// the analysis while Analyze runs.
+func recordToken(token *Token) {
+	if analysis == nil {
+		return
+	}
+	analysis.tokens = append(analysis.tokens, *token)
+}
+
+// referenceCall records a call of the variable at reference index callee
+// with args arguments while Analyze runs.
+func referenceCall(callee int, args uint8) {
+	if analysis == nil || callee == -1 {
+		return
+	}
+	analysis.Calls = append(analysis.Calls, Call{Reference: callee, Args: int(args)})
+}
+
+// unreachableStatement records that the statement about to be parsed follows
+// a return in the same block while Analyze runs.
+func unreachableStatement() {
+	if analysis == nil {
+		return
+	}
+	token := &parser.Current
+	analysis.Unreachable = append(analysis.Unreachable, Span{Start: token.Start, Length: token.Length, Line: token.Pos.Line, Column: token.Pos.Column})
+}
+
+// compareOperands records a comparison whose operands are the same
+// expression of variables without side effects while Analyze runs. The left operand starts
+// with token left, and the right one ends with the token just parsed.
+func compareOperands(left, operator *Token) {
+	if analysis == nil || parser.PanicMode {
+		return
+	}
+	// The tokens end with the right operand and parser.Current.
+	tokens := analysis.tokens[:len(analysis.tokens)-1]
+	op := len(tokens) - 1
+	for op >= 0 && tokens[op].Start != operator.Start {
+		op--
+	}
+	start := op - 1
+	for start >= 0 && tokens[start].Start != left.Start {
+		start--
+	}
+	if start < 0 || !sameExpression(tokens[start:op], tokens[op+1:]) {
+		return
+	}
+	analysis.Tautologies = append(analysis.Tautologies, Span{
+		Start:  left.Start,
+		Length: tokens[op-1].End.Offset - left.Start,
+		Line:   left.Pos.Line,
+		Column: left.Pos.Column,
+	})
+}
+
+// sameExpression reports whether two runs of tokens are the same, read a
+// variable and make no calls or assignments, so comparing them is a mistake
+// rather than a test of constants.
+func sameExpression(a, b []Token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	prev := globals.TokenEOF // Nothing scanned yet.
+	variable := false
+	for i := range a {
+		if a[i].TOKENType != b[i].TOKENType || a[i].Text != b[i].Text {
+			return false
+		}
+		switch a[i].TOKENType {
+		case globals.TokenEQUAL:
+			return false
+		case globals.TokenIDENTIFIER:
+			variable = true
+		case globals.TokenLeftParen:
+			if prev == globals.TokenIDENTIFIER || prev == globals.TokenRightParen {
+				return false // A call.
+			}
+		}
+		prev = a[i].TOKENType
+	}
+	return variable
+}
+
+// reportDiagnostic records a compile error at token while Analyze runs.
+func reportDiagnostic(token *Token, message string) {
+	if analysis == nil {
+		return
+	}
+	analysis.Diagnostics = append(analysis.Diagnostics, Diagnostic{
+		Start:   token.Start,
+		Length:  token.Length,
+		Line:    token.Pos.Line,
+		Column:  token.Pos.Column,
+		Message: message,
+	})
+}
@@ -0,0 +1,123 @@
+package src
+
+import (
+	"reflect"
+	"testing"
+)
+
+const analysisSource = `var g = 1;
+fun add(a, b) {
+  var sum = a + b + g;
+  return sum;
+}
+print add(1, later);
+var later = 2;
+`
+
+func TestAnalyzeSymbols(t *testing.T) {
+	analysis := Analyze(analysisSource)
+	if len(analysis.Diagnostics) != 0 {
+		t.Fatalf("Diagnostics = %v, want none", analysis.Diagnostics)
+	}
+
+	type symbol struct {
+		Name      string
+		Kind      SymbolKind
+		Global    bool
+		Line      int
+		Container int
+	}
+	var symbols []symbol
+	for _, s := range analysis.Symbols {
+		symbols = append(symbols, symbol{s.Name, s.Kind, s.Global, s.Line, s.Container})
+	}
+	wantSymbols := []symbol{
+		{"g", SymbolVariable, true, 1, -1},
+		{"add", SymbolFunction, true, 2, -1},
+		{"a", SymbolParameter, false, 2, 1},
+		{"b", SymbolParameter, false, 2, 1},
+		{"sum", SymbolVariable, false, 3, 1},
+		{"later", SymbolVariable, true, 7, -1},
+	}
+	if !reflect.DeepEqual(symbols, wantSymbols) {
+		t.Errorf("Symbols = %v, want %v", symbols, wantSymbols)
+	}
+	if params := analysis.Symbols[1].Params; !reflect.DeepEqual(params, []string{"a", "b"}) {
+		t.Errorf("add Params = %v, want [a b]", params)
+	}
+
+	// Each reference names its symbol, including a global used before its declaration.
+	var references []string
+	for _, r := range analysis.References {
+		name := analysisSource[r.Start : r.Start+r.Length]
+		if r.Symbol < 0 || analysis.Symbols[r.Symbol].Name != name {
+			t.Errorf("reference to %q on line %d resolved to symbol %d", name, r.Line, r.Symbol)
+		}
+		references = append(references, name)
+	}
+	if want := []string{"a", "b", "g", "sum", "add", "later"}; !reflect.DeepEqual(references, want) {
+		t.Errorf("References = %v, want %v", references, want)
+	}
+}
+
+func TestAnalyzeDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []Diagnostic
+	}{
+		{"missing operand", "print 1 +;", []Diagnostic{{Start: 9, Length: 1, Line: 1, Column: 10, Message: "Expect expression"}}},
+		{"missing semicolon", "print 1", []Diagnostic{{Start: 7, Length: 0, Line: 1, Column: 8, Message: "Expect ';' after value."}}},
+		{"at end", "print", []Diagnostic{{Start: 5, Length: 0, Line: 1, Column: 6, Message: "Expect expression"}}},
+		{"bad character", "var x = 1;\n  print #;", []Diagnostic{{Start: 19, Length: 1, Line: 2, Column: 9, Message: "Unexpected character."}}},
+		{"columns in runes", "print \"héllo\" + ;", []Diagnostic{{Start: 17, Length: 1, Line: 1, Column: 17, Message: "Expect expression"}}},
+		{"multi-line string", "print \"a\nb\" +;", []Diagnostic{{Start: 13, Length: 1, Line: 2, Column: 5, Message: "Expect expression"}}},
+		{"recovers at statements", "var = 1;\nprint ;", []Diagnostic{
+			{Start: 4, Length: 1, Line: 1, Column: 5, Message: "Expect variable name. "},
+			{Start: 15, Length: 1, Line: 2, Column: 7, Message: "Expect expression"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Analyze(tt.source).Diagnostics; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diagnostics = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeFindings(t *testing.T) {
+	source := `fun f(a) {
+  {
+    var a = 1;
+    a = a + 1;
+  }
+  return a;
+  print a == a;
+}
+print f(1, 2) < f(1, 2);
+`
+	analysis := Analyze(source)
+	if len(analysis.Diagnostics) != 0 {
+		t.Fatalf("Diagnostics = %v, want none", analysis.Diagnostics)
+	}
+	if shadows := analysis.Symbols[2].Shadows; shadows != 1 {
+		t.Errorf("inner a Shadows = %d, want 1", shadows)
+	}
+	var assigns []bool
+	for _, r := range analysis.References {
+		assigns = append(assigns, r.Assign)
+	}
+	if want := []bool{true, false, false, false, false, false, false}; !reflect.DeepEqual(assigns, want) {
+		t.Errorf("Assign of references = %v, want %v", assigns, want)
+	}
+	if want := []Call{{Reference: 5, Args: 2}, {Reference: 6, Args: 2}}; !reflect.DeepEqual(analysis.Calls, want) {
+		t.Errorf("Calls = %v, want %v", analysis.Calls, want)
+	}
+	if want := []Span{{Start: 63, Length: 5, Line: 7, Column: 3}}; !reflect.DeepEqual(analysis.Unreachable, want) {
+		t.Errorf("Unreachable = %v, want %v", analysis.Unreachable, want)
+	}
+	if want := []Span{{Start: 69, Length: 1, Line: 7, Column: 9}}; !reflect.DeepEqual(analysis.Tautologies, want) {
+		t.Errorf("Tautologies = %v, want %v", analysis.Tautologies, want)
+	}
+}
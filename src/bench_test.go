@@ -0,0 +1,107 @@
+package src
+
+import (
+	"io"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// localLoopSource is the test.clox while loop rewritten over block locals, the
+// shape the superinstructions target.
+const localLoopSource = `
+{
+  var sum = 0;
+  for (var i = 0; i < 100000; i = i + 1) {
+    sum = sum + i;
+  }
+  var x = 100000;
+  while (x > 0) {
+    x = x - 1;
+  }
+}
+`
+
+// benchmarkInterpret runs source b.N times in a fresh VM and reports how many
+// instructions were executed per run and per second alongside the timing.
+func benchmarkInterpret(b *testing.B, source string) {
+	b.Helper()
+	executed := 0
+	for i := 0; i < b.N; i++ {
+		InitVM()
+		SetOutput(io.Discard)
+		if result := Interpret(source); result != InterpretOk {
+			b.Fatalf("Interpret() = %v, want %v", result, InterpretOk)
+		}
+		executed += InstructionCount()
+		FreeVM()
+	}
+	b.ReportMetric(float64(executed)/float64(b.N), "instrs/op")
+	b.ReportMetric(float64(executed)/b.Elapsed().Seconds(), "instrs/s")
+}
+
+func BenchmarkSuperinstructions(b *testing.B) {
+	defer func(saved bool) { globals.SUPER_INSTRUCTIONS = saved }(globals.SUPER_INSTRUCTIONS)
+	for _, bench := range []struct {
+		name   string
+		source string
+	}{
+		{"globals", loopSource},
+		{"locals", localLoopSource},
+	} {
+		for _, super := range []bool{false, true} {
+			name := bench.name + "/generic"
+			if super {
+				name = bench.name + "/super"
+			}
+			b.Run(name, func(b *testing.B) {
+				globals.SUPER_INSTRUCTIONS = super
+				benchmarkInterpret(b, bench.source)
+			})
+		}
+	}
+}
+
+// fibSource exercises calls and returns.
+const fibSource = `
+fun fib(n) {
+  if (n < 2) return n;
+  return fib(n - 1) + fib(n - 2);
+}
+print fib(20);
+`
+
+// concatSource exercises string concatenation and interning.
+const concatSource = `
+var s = "";
+for (var i = 0; i < 2000; i = i + 1) {
+  s = s + "ab";
+}
+print s == s;
+`
+
+// BenchmarkInterpreter tracks interpreter throughput on calls, loops and
+// string building for both backends.
+func BenchmarkInterpreter(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		source string
+	}{
+		{"fib", fibSource},
+		{"loops", localLoopSource},
+		{"globalLoops", loopSource},
+		{"concat", concatSource},
+	} {
+		for _, registers := range []bool{false, true} {
+			name := bench.name + "/stack"
+			if registers {
+				name = bench.name + "/registers"
+			}
+			b.Run(name, func(b *testing.B) {
+				defer func(saved bool) { globals.REGISTER_VM = saved }(globals.REGISTER_VM)
+				globals.REGISTER_VM = registers
+				benchmarkInterpret(b, bench.source)
+			})
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package src
+
+/*
+FunctionBuilder assembles a function whose code is generated outside this
+package, as package ast does from a syntax tree.
+
+The code goes into Chunk with WriteChunk and AddConstants, as the compiler
+writes it. Finish then runs the same passes as the compiler.
+*/
+type FunctionBuilder struct {
+	function *ObjFunction
+}
+
+// NewFunctionBuilder starts a function with the given name and arity. The
+// empty name starts a script.
+func NewFunctionBuilder(name string, arity int) *FunctionBuilder {
+	function := NewFunction()
+	if name != "" {
+		function.name = internString([]byte(name))
+	}
+	function.arity = arity
+	function.minArity = arity
+	return &FunctionBuilder{function: function}
+}
+
+// SetOptional records that only the first required parameters need an
+// argument, the others having a default value, and whether the last one is a
+// rest parameter.
+func (b *FunctionBuilder) SetOptional(required int, variadic bool) {
+	b.function.minArity = required
+	b.function.variadic = variadic
+}
+
+// SetPath records the absolute path of the file the function is in, for debuggers.
+func (b *FunctionBuilder) SetPath(path string) {
+	b.function.path = path
+}
+
+// Chunk returns the chunk that holds the function's code.
+func (b *FunctionBuilder) Chunk() *Chunk {
+	return &b.function.chunk
+}
+
+// AddLocal records a local variable for debuggers and returns its index.
+func (b *FunctionBuilder) AddLocal(info LocalInfo) int {
+	b.function.locals = append(b.function.locals, info)
+	return len(b.function.locals) - 1
+}
+
+// Local returns the local variable with the given index, to update its range.
+func (b *FunctionBuilder) Local(index int) *LocalInfo {
+	return &b.function.locals[index]
+}
+
+// Finish runs the passes the globals flags select and returns the function.
+//
+// Returns:
+// - *ObjFunction: the function, ready to be a constant of another or to run.
+// - error: non-nil if the verifier or the register translation rejects the code.
+func (b *FunctionBuilder) Finish() (*ObjFunction, error) {
+	if err := finishFunction(b.function); err != nil {
+		return nil, err
+	}
+	return b.function, nil
+}
+
+// StringValue returns s as an interned string value.
+func StringValue(s string) Value {
+	return ObjStrValue(internString([]byte(s)))
+}
+
+// GlobalSlot returns the operand that global instructions use for the global
+// variable with the given name, assigning a new slot the first time a name is
+// seen, as the compiler does.
+func GlobalSlot(name string) int {
+	return vm.globalSlot(internString([]byte(name)))
+}
@@ -0,0 +1,327 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	// Imported under another name because compiler.go declares binary.
+	enc "encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// BytecodeMagic starts every compiled bytecode file.
+var BytecodeMagic = []byte("GLXC")
+
+// BytecodeVersion is the version of the bytecode format written by WriteBytecode.
+//
+// ReadBytecode rejects any other version. Bump it whenever the encoding or
+// the opcode numbering changes.
+const BytecodeVersion = 8
+
+// maxBytecodeLength bounds every length read from a bytecode file so that a
+// corrupt file fails cleanly instead of allocating gigabytes.
+const maxBytecodeLength = 1 << 24
+
+// Constant tags of the bytecode format.
+const (
+	constantNumber byte = iota + 1
+	constantString
+	constantFunction
+	constantBool
+	constantNil
+)
+
+// IsBytecode reports whether data starts with BytecodeMagic.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, BytecodeMagic)
+}
+
+/*
+WriteBytecode writes function and every function nested in its constants to w.
+
+The file starts with BytecodeMagic and BytecodeVersion, followed by the names
+of the global slots known to the VM and then the script function. Each
+function is stored as its name, arity, number of required parameters,
+variadic flag, code, line table and constant pool,
+with nested functions written in place.
+
+Global slots only mean something inside the VM that compiled them, so
+ReadBytecode uses the stored names to rebind every global instruction to the
+slots of the VM that loads the file.
+
+Parameters:
+- w: the writer the bytecode goes to.
+- function: the compiled script.
+
+Returns:
+- error: the first write error, if any.
+*/
+func WriteBytecode(w io.Writer, function *ObjFunction) error {
+	bw := &bytecodeWriter{w: bufio.NewWriter(w)}
+	bw.bytes(BytecodeMagic)
+	bw.uint16(BytecodeVersion)
+	bw.uvarint(len(vm.globalNames))
+	for _, name := range vm.globalNames {
+		bw.string(name)
+	}
+	bw.function(function)
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.w.Flush()
+}
+
+// bytecodeWriter encodes the bytecode format and remembers the first error.
+type bytecodeWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (bw *bytecodeWriter) bytes(data []byte) {
+	if bw.err == nil {
+		_, bw.err = bw.w.Write(data)
+	}
+}
+
+func (bw *bytecodeWriter) uint16(value uint16) {
+	bw.bytes(enc.BigEndian.AppendUint16(nil, value))
+}
+
+func (bw *bytecodeWriter) uvarint(value int) {
+	bw.bytes(enc.AppendUvarint(nil, uint64(value)))
+}
+
+func (bw *bytecodeWriter) string(str *ObjectString) {
+	bw.uvarint(str.Length)
+	bw.bytes(str.Chars[:str.Length])
+}
+
+func (bw *bytecodeWriter) function(function *ObjFunction) {
+	if function.name == nil {
+		bw.uvarint(0)
+	} else {
+		bw.uvarint(function.name.Length + 1)
+		bw.bytes(function.name.Chars[:function.name.Length])
+	}
+	bw.uvarint(function.arity)
+	bw.uvarint(function.minArity)
+	if function.variadic {
+		bw.bytes([]byte{1})
+	} else {
+		bw.bytes([]byte{0})
+	}
+
+	chunk := &function.chunk
+	bw.uvarint(chunk.Count)
+	bw.bytes(chunk.Code[:chunk.Count])
+	for _, line := range chunk.Lines[:chunk.Count] {
+		bw.uvarint(line)
+	}
+
+	constants := chunk.Constants.Values[:chunk.Constants.Count]
+	bw.uvarint(len(constants))
+	for _, constant := range constants {
+		switch {
+		case IsNumber(constant):
+			bw.bytes([]byte{constantNumber})
+			bw.bytes(enc.BigEndian.AppendUint64(nil, math.Float64bits(AsNumber(constant))))
+		case IsString(constant):
+			bw.bytes([]byte{constantString})
+			bw.string(AsObjString(constant))
+		case IsFunction(constant):
+			bw.bytes([]byte{constantFunction})
+			bw.function(AsFunction(constant))
+		case IsBool(constant):
+			value := byte(0)
+			if AsBool(constant) {
+				value = 1
+			}
+			bw.bytes([]byte{constantBool, value})
+		case IsNil(constant):
+			bw.bytes([]byte{constantNil})
+		default:
+			if bw.err == nil {
+				bw.err = fmt.Errorf("bytecode: cannot encode constant of type %d", constant.Type)
+			}
+		}
+	}
+}
+
+/*
+ReadBytecode reads a script written by WriteBytecode into the current VM.
+
+Strings are interned and global instructions are rebound to the slots the
+loading VM assigns to the stored names. Every function is checked with
+VerifyChunk, so a corrupt or hand-edited file is rejected here instead of
+crashing the VM. When the register VM is selected the
+register code of every function is rebuilt as the compiler would.
+
+Parameters:
+- r: the reader holding the bytecode.
+
+Returns:
+- *ObjFunction: the script function, ready for InterpretFunction.
+- error: non-nil if the data is not bytecode, has another version or is malformed.
+*/
+func ReadBytecode(r io.Reader) (*ObjFunction, error) {
+	br := &bytecodeReader{r: bufio.NewReader(r)}
+	magic := br.bytes(len(BytecodeMagic))
+	if br.err == nil && !bytes.Equal(magic, BytecodeMagic) {
+		return nil, errors.New("bytecode: not a goclox bytecode file")
+	}
+	if version := br.uint16(); br.err == nil && version != BytecodeVersion {
+		return nil, fmt.Errorf("bytecode: unsupported version %d, want %d", version, BytecodeVersion)
+	}
+
+	count := br.length()
+	for i := 0; i < count && br.err == nil; i++ {
+		slot := vm.globalSlot(br.string())
+		if slot > math.MaxUint16 {
+			br.fail("too many global variables")
+		}
+		br.globals = append(br.globals, slot)
+	}
+	function := br.function()
+	if br.err != nil {
+		return nil, br.err
+	}
+	return function, nil
+}
+
+// bytecodeReader decodes the bytecode format and remembers the first error.
+type bytecodeReader struct {
+	r       *bufio.Reader
+	err     error
+	globals []int // Maps each global slot stored in the file to its slot in the loading VM.
+}
+
+func (br *bytecodeReader) fail(format string, args ...any) {
+	if br.err == nil {
+		br.err = fmt.Errorf("bytecode: "+format, args...)
+	}
+}
+
+func (br *bytecodeReader) bytes(n int) []byte {
+	if br.err != nil {
+		return make([]byte, n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(br.r, data); err != nil {
+		br.fail("truncated file: %v", err)
+	}
+	return data
+}
+
+func (br *bytecodeReader) uint16() uint16 {
+	return enc.BigEndian.Uint16(br.bytes(2))
+}
+
+func (br *bytecodeReader) length() int {
+	if br.err != nil {
+		return 0
+	}
+	value, err := enc.ReadUvarint(br.r)
+	if err != nil {
+		br.fail("truncated file: %v", err)
+		return 0
+	}
+	if value > maxBytecodeLength {
+		br.fail("length %d out of range", value)
+		return 0
+	}
+	return int(value)
+}
+
+func (br *bytecodeReader) string() *ObjectString {
+	return internString(br.bytes(br.length()))
+}
+
+func (br *bytecodeReader) function() *ObjFunction {
+	function := NewFunction()
+	if length := br.length(); length > 0 {
+		function.name = internString(br.bytes(length - 1))
+	}
+	function.arity = br.length()
+	function.minArity = br.length()
+	function.variadic = br.bytes(1)[0] != 0
+	positional := function.arity
+	if function.variadic {
+		positional--
+	}
+	if positional < 0 {
+		br.fail("variadic function without parameters")
+	} else if function.minArity > positional {
+		br.fail("function with %d parameters can't require %d arguments", positional, function.minArity)
+	}
+
+	count := br.length()
+	code := br.bytes(count)
+	lines := make([]int, count)
+	for i := range lines {
+		lines[i] = br.length()
+	}
+	for i := 0; i < count && br.err == nil; i++ {
+		WriteChunk(&function.chunk, code[i], lines[i])
+	}
+	br.rebindGlobals(&function.chunk)
+
+	constants := br.length()
+	for i := 0; i < constants && br.err == nil; i++ {
+		var constant Value
+		switch tag := br.bytes(1)[0]; tag {
+		case constantNumber:
+			constant = NumberValue(math.Float64frombits(enc.BigEndian.Uint64(br.bytes(8))))
+		case constantString:
+			constant = ObjStrValue(br.string())
+		case constantFunction:
+			constant = ObjVal(br.function())
+		case constantBool:
+			constant = BoolValue(br.bytes(1)[0] != 0)
+		case constantNil:
+			constant = NilValue()
+		default:
+			br.fail("unknown constant tag %d", tag)
+		}
+		AddConstants(&function.chunk, constant)
+	}
+
+	if br.err == nil {
+		if err := VerifyChunk(&function.chunk, function.arity); err != nil {
+			br.fail("%v", err)
+		}
+	}
+	if globals.REGISTER_VM && br.err == nil {
+		registers, err := translateRegisters(function)
+		if err != nil {
+			br.fail("%v", err)
+		}
+		function.registers = registers
+	}
+	return function
+}
+
+// rebindGlobals rewrites the slot operand of every global instruction in
+// chunk from the file's numbering to the loading VM's.
+func (br *bytecodeReader) rebindGlobals(chunk *Chunk) {
+	for offset := 0; offset < chunk.Count && br.err == nil; {
+		op := chunk.Code[offset]
+		length := instructionLength(op)
+		if length == 0 || offset+length > chunk.Count {
+			return // Left for VerifyChunk to report.
+		}
+		if isGlobal(op) {
+			slot := int(uint16(chunk.Code[offset+1])<<8 | uint16(chunk.Code[offset+2]))
+			if slot >= len(br.globals) {
+				br.fail("global slot %d out of range at offset %d", slot, offset)
+				return
+			}
+			chunk.Code[offset+1] = uint8(br.globals[slot] >> 8)
+			chunk.Code[offset+2] = uint8(br.globals[slot])
+		}
+		offset += length
+	}
+}
@@ -0,0 +1,113 @@
+package src
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// compileBytecode compiles source in a fresh VM and returns its bytecode file.
+func compileBytecode(t *testing.T, source string) []byte {
+	t.Helper()
+	function := compileSource(t, source, false)
+	var out bytes.Buffer
+	if err := WriteBytecode(&out, function); err != nil {
+		t.Fatalf("WriteBytecode() error = %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.clox"))
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := runSource(t, string(source), false)
+			data := compileBytecode(t, string(source))
+
+			// Load into a VM whose global slots are already partly taken so
+			// that every global instruction has to be rebound.
+			InitVM()
+			defer FreeVM()
+			var out bytes.Buffer
+			SetOutput(&out)
+			Interpret("var unrelated = 1; var other = 2;")
+			out.Reset()
+			function, err := ReadBytecode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("ReadBytecode() error = %v", err)
+			}
+			InterpretFunction(function)
+			if got := out.String(); got != want {
+				t.Errorf("output from bytecode differs\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestReadBytecodeErrors(t *testing.T) {
+	data := compileBytecode(t, "fun f(a) { return a + 1; }\nprint f(2);")
+	wrongVersion := append([]byte{}, data...)
+	wrongVersion[len(BytecodeMagic)+1] = 99
+	// "print 1;" has no globals and a nameless script, so its first opcode
+	// follows the header, the empty globals table, the name, arity, number of
+	// required parameters, variadic flag and code length.
+	badOpcode := compileBytecode(t, "print 1;")
+	badOpcode[len(BytecodeMagic)+8] = 200
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "truncated"},
+		{"not bytecode", []byte("print 1;"), "not a goclox bytecode file"},
+		{"wrong version", wrongVersion, "unsupported version 99"},
+		{"truncated", data[:len(data)-3], "truncated"},
+		{"fails verification", badOpcode, "unknown opcode 200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InitVM()
+			defer FreeVM()
+			_, err := ReadBytecode(bytes.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ReadBytecode() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBytecodeDeadCode(t *testing.T) {
+	defer func(saved bool) { globals.REGISTER_VM = saved }(globals.REGISTER_VM)
+	data := compileBytecode(t, "fun fib(n) {\n  if (n < 2) return n;\n  return fib(n - 1) + fib(n - 2);\n}\nprint fib(10);")
+	// The jump over the else branch follows the return, so nothing runs it
+	// and the verifier doesn't check it. Make it pop more than the stack holds.
+	dead := bytes.Index(data, []byte{byte(globals.OpReturn), byte(globals.OpJump), 0, 1})
+	if dead == -1 {
+		t.Fatal("no jump after a return in the bytecode of fib")
+	}
+	copy(data[dead+1:], []byte{byte(globals.OpPop), byte(globals.OpPop), byte(globals.OpPop)})
+
+	for _, registers := range []bool{false, true} {
+		globals.REGISTER_VM = registers
+		InitVM()
+		var out bytes.Buffer
+		SetOutput(&out)
+		function, err := ReadBytecode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("registers=%v: ReadBytecode() error = %v", registers, err)
+		} else if InterpretFunction(function); out.String() != "55\n" {
+			t.Errorf("registers=%v: output = %q, want \"55\\n\"", registers, out.String())
+		}
+		SetOutput(os.Stdout)
+		FreeVM()
+	}
+}
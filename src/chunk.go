@@ -44,8 +44,8 @@ func WriteChunk(chunk *Chunk, bytecode uint8, line int) {
 	if chunk.Capacity <= chunk.Count+1 {
 		oldcapacity := chunk.Capacity
 		chunk.Capacity = GrowCapacity(oldcapacity)
-		chunk.Code = GrowArrayChunks(chunk.Code, oldcapacity, chunk.Capacity)
-		chunk.Lines = GrowArrayLines(chunk.Lines, oldcapacity, chunk.Capacity)
+		chunk.Code = GrowArray(chunk.Code, oldcapacity, chunk.Capacity)
+		chunk.Lines = GrowArray(chunk.Lines, oldcapacity, chunk.Capacity)
 	}
 
 	chunk.Code[chunk.Count] = bytecode
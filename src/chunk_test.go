@@ -20,3 +20,14 @@ func TestWriteChunk(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkWriteChunk(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var chunk Chunk
+		InitChunk(&chunk)
+		for j := 0; j < 4096; j++ {
+			WriteChunk(&chunk, uint8(j), j)
+		}
+		FreeChunk(&chunk)
+	}
+}
@@ -2,7 +2,9 @@ package src
 
 import (
 	"fmt"
+	"io"
 	"math"
+	"path/filepath"
 	"strconv"
 
 	"github.com/smekuria1/goclox/globals"
@@ -23,6 +25,15 @@ type Parser struct {
 
 	// PanicMode indicates whether the parser is in panic mode.
 	PanicMode bool
+
+	ahead []queuedToken // The tokens after Current that arrowAhead scanned.
+}
+
+// queuedToken is a token scanned ahead of the parser, with the message of an
+// error token, which the scanner only keeps until the next error.
+type queuedToken struct {
+	token   Token
+	message string
 }
 type Precedence int
 
@@ -30,6 +41,8 @@ type Precedence int
 const (
 	PrecNONE Precedence = iota
 	PrecASSIGNMENT
+	PrecCONDITIONAL
+	PrecCOALESCE
 	PrecOR
 	PrecAND
 	PrecEQUALITY
@@ -75,12 +88,15 @@ type Compiler struct {
 	function   *ObjFunction      // Represents the current function being compiled.
 	funcType   FunctionType      // Represents the type of the current function being compiled.
 	encolsing  *Compiler         // Represents the compiler that encloses the current compiler.
+	symbol     int               // The function's index in analysis.Symbols, or -1.
 }
 
 // Local represents a local variable in the compiler.
 type Local struct {
-	name  Token // The name of the local variable.
-	depth int   // The depth of the local variable within the scope.
+	name   Token // The name of the local variable.
+	depth  int   // The depth of the local variable within the scope.
+	info   int   // The index of the variable's LocalInfo in the function.
+	symbol int   // The variable's index in analysis.Symbols, or -1.
 }
 
 // Parsefn represents the parsing function for a specific token type.
@@ -88,6 +104,10 @@ type Parsefn func(canAssign bool)
 
 var parser Parser
 
+// infixStart is the first token of the left operand of the infix expression
+// whose rule parsePrecendece is calling.
+var infixStart Token
+
 // var compilingChunk *Chunk
 var current *Compiler = nil
 
@@ -100,15 +120,17 @@ func InitCompiler(compiler *Compiler, _type FunctionType) {
 	compiler.funcType = _type
 	compiler.localCount = 0
 	compiler.scopeDepth = 0
+	compiler.symbol = -1
 	compiler.function = NewFunction()
+	compiler.function.path = compiling.path
 	current = compiler
 	if _type != TypeScript {
-		current.function.name = copyString(parser.Previous.Start, parser.Previous.Length, ObjStringType)
+		current.function.name = copyString(parser.Previous.Text, ObjStringType)
 	}
 	local := &current.locals[current.localCount]
+	current.localCount++
 	local.depth = 0
-	local.name.Start = 0
-	local.name.Length = 0
+	local.name = Token{}
 }
 
 func currentChunk() *Chunk {
@@ -118,15 +140,44 @@ func currentChunk() *Chunk {
 // Compile compiles the source code into an ObjFunction.
 //
 // It takes a source string and a pointer to a Chunk as parameters and returns a pointer to an ObjFunction.
+// Imports resolve against the working directory.
 func Compile(source string, chunk *Chunk) *ObjFunction {
+	return CompileFile("", source, chunk)
+}
 
+// CompileFile compiles the source of the script at path into an ObjFunction.
+//
+// It is Compile for a script read from a file: imports resolve against the
+// directory of path, or the working directory if path is empty.
+func CompileFile(path, source string, chunk *Chunk) *ObjFunction {
 	scanner.InitScanner(source)
+	return compileScript(path)
+}
+
+// CompileReader is CompileFile for a script read from r while it compiles,
+// so that only a bounded part of the source is held in memory at a time.
+//
+// It returns the error reading r instead of the function if there is one.
+func CompileReader(path string, r io.Reader, chunk *Chunk) (*ObjFunction, error) {
+	scanner.InitReader(r)
+	function := compileScript(path)
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return function, nil
+}
+
+// compileScript compiles the source the scanner was just initialized with as
+// the script at path.
+func compileScript(path string) *ObjFunction {
+	compiling = scriptUnit(path)
 	var compiler Compiler
 	InitCompiler(&compiler, TypeScript)
 	// compilingChunk = chunk
 	parser.HadError = false
 	parser.PanicMode = false
-	advance(*scanner.Source)
+	parser.ahead = nil
+	advance()
 
 	// for i := 0; i < scanner.Line; i+=1 {
 	// 	expression()
@@ -144,6 +195,47 @@ func Compile(source string, chunk *Chunk) *ObjFunction {
 
 }
 
+// compileEval compiles a single expression into a function that returns its value.
+//
+// The function is meant to run on the slots of an existing call frame: locals
+// names those slots, with an empty string for slots the expression may not
+// touch, and the expression can read and assign them by name. Other names
+// resolve to globals.
+//
+// Parameters:
+// - source: the source of the expression.
+// - locals: the name of each frame slot, starting with slot 0.
+//
+// Returns:
+// - *ObjFunction: the compiled function, or nil after reporting a compile error.
+func compileEval(source string, locals []string) *ObjFunction {
+	compiling = scriptUnit("")
+	scanner.InitScanner(source)
+	var compiler Compiler
+	InitCompiler(&compiler, TypeScript)
+	compiler.function.name = internString([]byte("eval"))
+	compiler.scopeDepth = 1
+	compiler.localCount = 0
+	for i, name := range locals {
+		compiler.locals[i] = Local{name: Token{Text: name}, depth: 1}
+		compiler.localCount++
+	}
+	parser.HadError = false
+	parser.PanicMode = false
+	parser.ahead = nil
+	advance()
+
+	expression()
+	consume(globals.TokenEOF, "Expect end of expression.")
+	emitByte(uint8(globals.OpReturn))
+	function := endCompiler()
+
+	if parser.HadError {
+		return nil
+	}
+	return function
+}
+
 // expression is a Go function that parses the precedence of an assignment.
 //
 // It does not take any parameters.
@@ -158,6 +250,8 @@ func expression() {
 func declaration() {
 	if match(globals.TokenFUN) {
 		functionDeclaration()
+	} else if match(globals.TokenIMPORT) {
+		importDeclaration()
 	} else if match(globals.TokenVAR) {
 		varDeclaration()
 	} else {
@@ -179,35 +273,147 @@ func markInitialized() {
 	if current.scopeDepth == 0 {
 		return
 	}
-	current.locals[current.localCount-1].depth = current.scopeDepth
+	local := &current.locals[current.localCount-1]
+	local.depth = current.scopeDepth
+	current.function.locals[local.info].Start = currentChunk().Count
 }
 
 func function(_type FunctionType) {
+	symbol := declareSymbol(SymbolFunction)
 	var compiler Compiler
 	InitCompiler(&compiler, _type)
+	compiler.symbol = symbol
 	beginScope()
 
 	consume(globals.TokenLeftParen, "Expect '(' after function name.")
+	parameters()
+	declareArity(symbol, current.function)
+	consume(globals.TokenLeftBrace, "Expect '{' before function body.")
+	block()
+
+	function := endCompiler()
+	emityBytes(uint8(globals.OpConstant), makeConstant(ObjVal(function)))
+}
+
+/*
+lambda compiles an anonymous function, whose 'fun' was just consumed:
+
+	fun (a, b) { return a + b; }
+
+Like a nested declaration, its body can't see the locals around it.
+*/
+func lambda(canAssign bool) {
+	var compiler Compiler
+	beginLambda(&compiler)
+	consume(globals.TokenLeftParen, "Expect '(' after 'fun'.")
+	parameters()
+	consume(globals.TokenLeftBrace, "Expect '{' before function body.")
+	block()
+
+	function := endCompiler()
+	emityBytes(uint8(globals.OpConstant), makeConstant(ObjVal(function)))
+}
+
+// arrowFunction compiles an arrow function, whose '(' was just consumed,
+// which returns the value of the expression after its '=>':
+//
+//	(a, b) => a + b
+func arrowFunction() {
+	var compiler Compiler
+	beginLambda(&compiler)
+	parameters()
+	consume(globals.TokenARROW, "Expect '=>' after parameters.")
+	expression()
+	emitByte(uint8(globals.OpReturn))
+
+	function := endCompiler()
+	emityBytes(uint8(globals.OpConstant), makeConstant(ObjVal(function)))
+}
+
+// beginLambda starts compiling an anonymous function with compiler. The
+// function is named after the line it starts on, for stack traces.
+func beginLambda(compiler *Compiler) {
+	line := parser.Previous.Pos.Line
+	InitCompiler(compiler, TypeFunction)
+	compiler.function.name = internString([]byte(fmt.Sprintf("lambda@%d", line)))
+	beginScope()
+}
+
+// arrowAhead reports whether the '(' just consumed starts the parameters of
+// an arrow function: whether the matching ')' is followed by '=>'. The tokens
+// it looks at wait in parser.ahead for advance.
+func arrowAhead() bool {
+	token := parser.Current
+	for depth, i := 1, 0; ; i++ {
+		switch token.TOKENType {
+		case globals.TokenLeftParen:
+			depth++
+		case globals.TokenRightParen:
+			if depth--; depth == 0 {
+				return peekToken(i).TOKENType == globals.TokenARROW
+			}
+		case globals.TokenEOF:
+			return false
+		}
+		token = peekToken(i)
+	}
+}
+
+/*
+parameters compiles the parameters of a function up to and including the ')'
+that ends them:
+
+	(a, b = 10, ...rest)
+
+Parameters with a default value follow those without, and a rest parameter
+comes last.
+*/
+func parameters() {
+	function := current.function
 	if !check(globals.TokenRightParen) {
 		for {
-			current.function.arity++
-			if current.function.arity > 255 {
+			rest := match(globals.TokenELLIPSIS)
+			function.arity++
+			if function.arity > 255 {
 				errorAtCurrent("Can't have more than 255 parameters.")
 			}
 			paramConstant := parseVariable("Expect parameter name.")
+			declareSymbol(SymbolParameter)
+			if rest {
+				function.variadic = true
+			} else if match(globals.TokenEQUAL) {
+				defaultValue()
+			} else if function.minArity == function.arity-1 {
+				function.minArity++
+			} else {
+				Error("Expect '=' after a parameter that follows one with a default value.")
+			}
 			defineVariable(paramConstant)
 			if !match(globals.TokenCOMMA) {
 				break
 			}
+			if rest {
+				errorAtCurrent("A rest parameter must be the last one.")
+			}
 		}
 	}
 	consume(globals.TokenRightParen, "Expect ')' after parameters.")
+}
 
-	consume(globals.TokenLeftBrace, "Expect '{' before function body.")
-	block()
-
-	function := endCompiler()
-	emityBytes(uint8(globals.OpConstant), makeConstant(ObjVal(function)))
+// defaultValue compiles the default value of the parameter just declared,
+// which the function assigns to it when called without an argument for it.
+// The parameter is not in scope in its own default value.
+func defaultValue() {
+	slot := current.localCount - 1
+	current.localCount--
+	emityBytes(uint8(globals.OpArgMissing), uint8(slot))
+	jump := emitJump(uint8(globals.OpJumpFalse))
+	emitByte(uint8(globals.OpPop))
+	expression()
+	emityBytes(uint8(globals.OpSetLocal), uint8(slot))
+	patchJump(jump)
+	emitByte(uint8(globals.OpPop))
+	current.localCount++
 }
 
 // varDeclaration is a function that performs variable declaration.
@@ -215,6 +421,7 @@ func function(_type FunctionType) {
 // It takes no parameters and does not return anything.
 func varDeclaration() {
 	global := parseVariable("Expect variable name. ")
+	declareSymbol(SymbolVariable)
 	if match(globals.TokenEQUAL) {
 		expression()
 	} else {
@@ -224,20 +431,29 @@ func varDeclaration() {
 	defineVariable(global)
 }
 
-// parseVariable parses the variable and returns a uint8 value.
+// parseVariable parses the variable and returns its global slot.
 //
 // It takes an `errorMessage` string as a parameter.
 // The function consumes the `globals.TokenIDENTIFIER` and `errorMessage`.
 // It then declares a variable and checks the `current.scopeDepth`.
 // If the `current.scopeDepth` is greater than 0, it returns 0.
-// Otherwise, it returns the identifier constant of `parser.Previous`.
-func parseVariable(errorMessage string) uint8 {
+// Otherwise, it returns the global slot of `parser.Previous`.
+func parseVariable(errorMessage string) int {
 	consume(globals.TokenIDENTIFIER, errorMessage)
 	declareVariable()
 	if current.scopeDepth > 0 {
 		return 0
 	}
-	return identifierConstant(&parser.Previous)
+	name := parser.Previous.Text
+	if isImported(name) {
+		Error("Can't redeclare an imported name.")
+	}
+	global := identifierGlobal(&parser.Previous)
+	compiling.imports.declared[name] = true
+	if compiling.module != nil && parser.Previous.TOKENType == globals.TokenIDENTIFIER {
+		compiling.module.exports[name] = global
+	}
+	return global
 }
 
 // declareVariable is a function that declares a variable.
@@ -274,14 +490,7 @@ func declareVariable() {
 // Returns:
 // - bool: true if the tokens have the same identifier, false otherwise
 func identfierEqual(a, b *Token) bool {
-	if a.Length != b.Length {
-		return false
-	}
-	source := *scanner.Source
-	aChar := source[a.Start : a.Start+a.Length]
-	bChar := source[b.Start : b.Start+b.Length]
-
-	return memcmp([]byte(aChar), []byte(bChar), a.Length) == 0
+	return a.Text == b.Text
 }
 
 // addLocal adds a local variable to the current function.
@@ -298,6 +507,14 @@ func addLocal(name *Token) {
 
 	current.locals[current.localCount].name = *name
 	current.locals[current.localCount].depth = current.scopeDepth
+	current.locals[current.localCount].info = len(current.function.locals)
+	current.locals[current.localCount].symbol = -1
+	current.function.locals = append(current.function.locals, LocalInfo{
+		Name:  name.Text,
+		Slot:  current.localCount,
+		Start: -1,
+		End:   -1,
+	})
 	current.localCount++
 }
 
@@ -309,19 +526,47 @@ func addLocal(name *Token) {
 // Returns:
 // - uint8: the generated constant identifier.
 func identifierConstant(name *Token) uint8 {
-	return makeConstant(ObjStrValue(copyString(name.Start, name.Length, ObjStringType)))
+	return makeConstant(ObjStrValue(copyString(name.Text, ObjStringType)))
+}
+
+// identifierGlobal returns the global slot assigned to an identifier, in the
+// namespace of the module being compiled if it is one.
+//
+// Parameters:
+// - name: a pointer to a Token representing the name of the global.
+//
+// Returns:
+// - int: the slot index used as the 16-bit operand of the global opcodes.
+func identifierGlobal(name *Token) int {
+	slot := vm.globalSlot(internString([]byte(globalName(name.Text))))
+	if slot > math.MaxUint16 {
+		Error("Too many global variables")
+		return 0
+	}
+	return slot
 }
 
 // defineVariable defines a global variable.
 //
-// The function takes a single parameter, `global`, which is of type `uint8`.
+// The function takes a single parameter, `global`, the slot parseVariable returned.
 // It does not return any values.
-func defineVariable(global uint8) {
+func defineVariable(global int) {
 	if current.scopeDepth > 0 {
 		markInitialized()
 		return
 	}
-	emityBytes(uint8(globals.OpDefineGlobal), global)
+	emitVariable(globals.OpDefineGlobal, global)
+}
+
+// emitVariable emits an instruction that reads or writes a variable. A
+// local's slot takes one byte and a global's two.
+func emitVariable(op globals.OpCode, arg int) {
+	if isGlobal(uint8(op)) {
+		emityBytes(uint8(op), uint8(arg>>8))
+		emitByte(uint8(arg))
+	} else {
+		emityBytes(uint8(op), uint8(arg))
+	}
 }
 
 // statement is a Go function that performs a specific task based on the current token.
@@ -338,15 +583,124 @@ func statement() {
 		endScope()
 	} else if match(globals.TokenIF) {
 		ifStatement()
+	} else if match(globals.TokenRETURN) {
+		returnStatement()
 	} else if match(globals.TokenFOR) {
 		forStatement()
 	} else if match(globals.TokenWHILE) {
 		whileStatement()
+	} else if match(globals.TokenTRY) {
+		tryStatement()
+	} else if match(globals.TokenTHROW) {
+		throwStatement()
 	} else {
 		expressionStatement()
 	}
 }
 
+// returnStatement compiles a return statement.
+//
+// A bare `return;` returns nil. Returning from top-level code is a compile error.
+func returnStatement() {
+	if current.funcType == TypeScript {
+		Error("Can't return from top-level code.")
+	}
+	if match(globals.TokenSEMICOLON) {
+		emitReturn()
+	} else {
+		expression()
+		consume(globals.TokenSEMICOLON, "Expect ';' after return value.")
+		emitByte(uint8(globals.OpReturn))
+	}
+}
+
+/*
+tryStatement compiles a try statement, whose 'try' was just consumed:
+
+	try { ... } catch (e) { ... } finally { ... }
+
+Either the catch or the finally clause can be left out. The try block runs
+under two handlers, which are emitted before it is known which clauses
+follow; the handler of a missing clause becomes a jump to the next
+instruction.
+
+A finally block runs with two hidden locals that say how it was entered:
+nil and nil when the try or catch block ended normally, or the exception or
+return value still pending and its completion, which OpEndFinally resumes.
+*/
+func tryStatement() {
+	finallyHandler := emitJump(uint8(globals.OpTryFinally))
+	catchHandler := emitJump(uint8(globals.OpTry))
+	consume(globals.TokenLeftBrace, "Expect '{' after 'try'.")
+	beginScope()
+	block()
+	endScope()
+
+	hasCatch := match(globals.TokenCATCH)
+	if hasCatch {
+		emitByte(uint8(globals.OpEndTry))
+		exitJump := emitJump(uint8(globals.OpJump))
+		patchJump(catchHandler)
+		beginScope()
+		consume(globals.TokenLeftParen, "Expect '(' after 'catch'.")
+		consume(globals.TokenIDENTIFIER, "Expect exception variable name.")
+		declareVariable()
+		declareSymbol(SymbolVariable)
+		markInitialized()
+		consume(globals.TokenRightParen, "Expect ')' after exception variable.")
+		consume(globals.TokenLeftBrace, "Expect '{' after catch clause.")
+		beginScope()
+		block()
+		endScope()
+		endScope()
+		patchJump(exitJump)
+	} else {
+		removeHandler(catchHandler)
+	}
+
+	if !match(globals.TokenFINALLY) {
+		if !hasCatch {
+			errorAtCurrent("Expect 'catch' or 'finally' after try block.")
+		}
+		removeHandler(finallyHandler)
+		return
+	}
+	emitByte(uint8(globals.OpEndTry))
+	emitByte(uint8(globals.OpNil))
+	emitByte(uint8(globals.OpNil))
+	patchJump(finallyHandler)
+	beginScope()
+	addLocal(&Token{})
+	addLocal(&Token{})
+	consume(globals.TokenLeftBrace, "Expect '{' after 'finally'.")
+	beginScope()
+	block()
+	endScope()
+	emitByte(uint8(globals.OpEndFinally))
+	// OpEndFinally pops the hidden locals itself.
+	current.scopeDepth--
+	for i := 0; i < 2 && current.localCount > 0; i++ {
+		current.function.locals[current.locals[current.localCount-1].info].End = currentChunk().Count
+		current.localCount--
+	}
+}
+
+// removeHandler turns the try instruction whose jump operand is at offset
+// into a jump to the next instruction.
+func removeHandler(offset uint32) {
+	code := currentChunk().Code
+	code[offset-1] = uint8(globals.OpJump)
+	code[offset] = 0
+	code[offset+1] = 0
+}
+
+// throwStatement compiles a throw statement, whose 'throw' was just consumed.
+func throwStatement() {
+	expression()
+	consume(globals.TokenSEMICOLON, "Expect ';' after thrown value.")
+	emitByte(uint8(globals.OpThrow))
+}
+
 // forStatement is a function that processes the for loop
 func forStatement() {
 	beginScope()
@@ -472,7 +826,13 @@ func patchJump(offset uint32) {
 //
 // The function does not return anything.
 func block() {
+	returned := false
 	for !check(globals.TokenRightBrace) && !check(globals.TokenEOF) {
+		if returned {
+			unreachableStatement()
+			returned = false
+		}
+		returned = check(globals.TokenRETURN)
 		declaration()
 	}
 
@@ -495,6 +855,7 @@ func beginScope() {
 func endScope() {
 	current.scopeDepth--
 	for current.localCount > 0 && current.locals[current.localCount-1].depth > current.scopeDepth {
+		current.function.locals[current.locals[current.localCount-1].info].End = currentChunk().Count
 		emitByte(uint8(globals.OpPop))
 		current.localCount--
 	}
@@ -506,7 +867,7 @@ func endScope() {
 // It does not return anything.
 func expressionStatement() {
 	expression()
-	consume(globals.TokenSEMICOLON, "Expext ';' after expression")
+	consume(globals.TokenSEMICOLON, "Expect ';' after expression.")
 	emitByte(uint8(globals.OpPop))
 }
 
@@ -518,7 +879,7 @@ func match(_type globals.TokenType) bool {
 	if !check(_type) {
 		return false
 	}
-	advance(*scanner.Source)
+	advance()
 	return true
 }
 
@@ -540,13 +901,11 @@ func printStatement() {
 	emitByte(uint8(globals.OpPrint))
 }
 
-// synchronize is a Go function that synchronizes the parser state.
+// synchronize leaves panic mode by skipping tokens up to a statement boundary.
 //
-// It iterates through the tokens in the parser until it reaches the end of the file or encounters a semicolon.
-// During the iteration, it checks the type of each token and performs specific actions based on the type.
-//
-// No parameters are required for this function.
-// This function does not return any values.
+// It stops after a ';', or before a '}' or a keyword that starts a statement,
+// so that the next declaration parses from there and any further error it
+// finds is reported too.
 func synchronize() {
 	parser.PanicMode = false
 	for parser.Current.TOKENType != globals.TokenEOF {
@@ -554,19 +913,12 @@ func synchronize() {
 			return
 		}
 		switch parser.Current.TOKENType {
-		case globals.TokenCLASS:
-		case globals.TokenFUN:
-		case globals.TokenVAR:
-		case globals.TokenFOR:
-		case globals.TokenIF:
-		case globals.TokenWHILE:
-		case globals.TokenPRINT:
-		case globals.TokenRETURN:
+		case globals.TokenRightBrace, globals.TokenCLASS, globals.TokenFUN, globals.TokenVAR, globals.TokenFOR,
+			globals.TokenIF, globals.TokenWHILE, globals.TokenPRINT, globals.TokenRETURN, globals.TokenIMPORT,
+			globals.TokenTRY, globals.TokenTHROW:
 			return
-		default:
-			// Do nothing.
 		}
-		advance(*scanner.Source)
+		advance()
 	}
 }
 
@@ -577,13 +929,14 @@ func synchronize() {
 func endCompiler() *ObjFunction {
 	emitReturn()
 	function := current.function
-	if globals.DEBUG_PRINT_CODE {
-		if !parser.HadError {
-			if function.name != nil {
-				DisassembleChunk(currentChunk(), string(function.name.Chars))
-			} else {
-				DisassembleChunk(currentChunk(), "script")
-			}
+	for i := range function.locals {
+		if function.locals[i].End == -1 {
+			function.locals[i].End = currentChunk().Count
+		}
+	}
+	if !parser.HadError {
+		if err := finishFunction(function); err != nil {
+			Error(err.Error())
 		}
 	}
 	current = current.encolsing
@@ -591,6 +944,48 @@ func endCompiler() *ObjFunction {
 
 }
 
+// finishFunction runs the passes the globals flags select on a function
+// compiled without errors, and prints its code if asked.
+//
+// Returns:
+// - error: non-nil if the verifier or the register translation rejects the code.
+func finishFunction(function *ObjFunction) error {
+	chunk := &function.chunk
+	if globals.OPTIMIZE_CODE || globals.SUPER_INSTRUCTIONS {
+		// The passes below move code around, so the offsets would be stale.
+		function.locals = nil
+	}
+	if globals.OPTIMIZE_CODE {
+		optimizeChunk(chunk)
+	}
+	if globals.SUPER_INSTRUCTIONS {
+		selectSuperinstructions(chunk)
+	}
+	if globals.VERIFY_CODE {
+		if err := VerifyChunk(chunk, function.arity); err != nil {
+			return err
+		}
+	}
+	if globals.REGISTER_VM {
+		registers, err := translateRegisters(function)
+		if err != nil {
+			return err
+		}
+		function.registers = registers
+	}
+	if globals.DEBUG_PRINT_CODE {
+		name := "script"
+		if function.name != nil {
+			name = AsCString(ObjStrValue(function.name))
+		}
+		DisassembleChunk(chunk, name)
+		if function.registers != nil {
+			DisassembleRegisters(function.registers, chunk, name)
+		}
+	}
+	return nil
+}
+
 // getRule returns the ParseRule associated with the given TokenType.
 //
 // It takes a TokenType as input parameter and returns a pointer to a ParseRule.
@@ -600,10 +995,32 @@ func getRule(tokentype globals.TokenType) *ParseRule {
 }
 
 func call(canAssign bool) {
+	callee := calleeReference()
 	argcount := argumentList()
+	referenceCall(callee, argcount)
 	emityBytes(uint8(globals.OpCall), argcount)
 }
 
+// dot compiles a property access, whose '.' was just consumed.
+func dot(canAssign bool) {
+	consume(globals.TokenIDENTIFIER, "Expect property name after '.'.")
+	emityBytes(uint8(globals.OpGetProperty), identifierConstant(&parser.Previous))
+}
+
+// safeDot compiles a property access whose '?.' was just consumed. It gives
+// nil instead of the property when the object is nil, and then skips the
+// accesses and calls that follow in the chain too, so nil?.a.b is nil.
+func safeDot(canAssign bool) {
+	consume(globals.TokenIDENTIFIER, "Expect property name after '?.'.")
+	endJump := emitJump(uint8(globals.OpJumpNil))
+	emityBytes(uint8(globals.OpGetProperty), identifierConstant(&parser.Previous))
+	for getRule(parser.Current.TOKENType).Precedence >= PrecCALL {
+		advance()
+		getRule(parser.Previous.TOKENType).Infix(canAssign)
+	}
+	patchJump(endJump)
+}
+
 func argumentList() uint8 {
 	argcount := uint8(0)
 	if !check(globals.TokenRightParen) {
@@ -629,9 +1046,13 @@ func argumentList() uint8 {
 // It takes a boolean parameter canAssign, which indicates whether the operation can be assigned to a variable.
 // This function does not return any value.
 func binary(canAssign bool) {
-	operatorType := parser.Previous.TOKENType
+	left, operator := infixStart, parser.Previous
+	operatorType := operator.TOKENType
 	rule := getRule(operatorType)
 	parsePrecendece(rule.Precedence + 1)
+	if rule.Precedence == PrecEQUALITY || rule.Precedence == PrecCOMPARISON {
+		compareOperands(&left, &operator)
+	}
 
 	switch operatorType {
 	case globals.TokenBANG_EQUAL:
@@ -654,6 +1075,8 @@ func binary(canAssign bool) {
 		emitByte(uint8(globals.OpMultiply))
 	case globals.TokenSLASH:
 		emitByte(uint8(globals.OpDivide))
+	case globals.TokenPERCENT:
+		emitByte(uint8(globals.OpModulo))
 	}
 }
 
@@ -681,6 +1104,10 @@ func literal(canAssign bool) {
 //
 // The function does not return any value.
 func grouping(canAssign bool) {
+	if arrowAhead() {
+		arrowFunction()
+		return
+	}
 	expression()
 	consume(globals.TokenRightParen, "Expect ')' after the expression")
 }
@@ -699,8 +1126,7 @@ func emitReturn() {
 // It takes a boolean argument canAssign, which determines whether the function can assign a value.
 // The function does not return anything.
 func number(canAssign bool) {
-	source := *scanner.Source
-	value, err := strconv.ParseFloat(source[parser.Previous.Start:parser.Previous.Start+parser.Previous.Length], 64)
+	value, err := strconv.ParseFloat(parser.Previous.Text, 64)
 	if err != nil {
 		Error(err.Error())
 	}
@@ -713,7 +1139,8 @@ func number(canAssign bool) {
 // It takes a boolean parameter canAssign which determines whether the generated string can be assigned or not.
 // The function does not return any value.
 func stringy(canAssign bool) {
-	emitConstant(ObjStrValue(copyString(parser.Previous.Start+1, parser.Previous.Length-2, ObjStringType)))
+	text := parser.Previous.Text
+	emitConstant(ObjStrValue(copyString(text[1:len(text)-1], ObjStringType)))
 }
 
 // variable is a Go function that takes a boolean parameter canAssign.
@@ -722,34 +1149,94 @@ func variable(canAssign bool) {
 	namedVariable(parser.Previous, canAssign)
 }
 
+// compoundOps maps each compound assignment operator to the opcode that
+// combines the variable with the assigned value.
+var compoundOps = map[globals.TokenType]globals.OpCode{
+	globals.TokenPLUS_EQUAL:    globals.OpAdd,
+	globals.TokenMINUS_EQUAL:   globals.OpSubtract,
+	globals.TokenSTAR_EQUAL:    globals.OpMultiply,
+	globals.TokenSLASH_EQUAL:   globals.OpDivide,
+	globals.TokenPERCENT_EQUAL: globals.OpModulo,
+}
+
 // namedVariable is a function that takes a name Token and a canAssign boolean as parameters.
 //
-// The function resolves the local variable using the current scope and the name Token. If the
-// variable is found in the current scope, it uses the OpGetLocal and OpSetLocal opcodes to get
-// and set the variable value. If the variable is not found in the current scope, it uses the
-// OpGetGlobal and OpSetGlobal opcodes to get and set the variable value. If the canAssign
-// parameter is true and there is an EQUAL token, the function calls the expression() function and
-// emits the set opcode and the argument. Otherwise, it emits the get opcode and the argument.
+// The function resolves the variable with resolveVariable. If the canAssign parameter is true
+// and there is an EQUAL token, the function calls the expression() function and emits the set
+// opcode and the argument. A compound assignment such as += reads the variable, combines it
+// with the expression and sets it, and a postfix ++ or -- sets the variable while leaving its
+// old value. Otherwise, it emits the get opcode and the argument.
 func namedVariable(name Token, canAssign bool) {
-	var (
-		getOp globals.OpCode
-		setOp globals.OpCode
-	)
-
-	arg := resolveLocal(current, &name)
-	if arg != -1 {
-		getOp = globals.OpGetLocal
-		setOp = globals.OpSetLocal
+	_, compound := compoundOps[parser.Current.TOKENType]
+	assign := canAssign && (compound || check(globals.TokenEQUAL)) ||
+		check(globals.TokenPLUS_PLUS) || check(globals.TokenMINUS_MINUS)
+	getOp, setOp, arg := resolveVariable(&name, assign)
+
+	// The member of an imported module follows the name, so look again.
+	op, compound := compoundOps[parser.Current.TOKENType]
+	if canAssign && match(globals.TokenEQUAL) {
+		expression()
+		emitVariable(setOp, arg)
+	} else if canAssign && compound {
+		match(parser.Current.TOKENType)
+		emitVariable(getOp, arg)
+		expression()
+		emitByte(uint8(op))
+		emitVariable(setOp, arg)
+	} else if match(globals.TokenPLUS_PLUS) || match(globals.TokenMINUS_MINUS) {
+		emitVariable(getOp, arg)
+		emitVariable(getOp, arg)
+		emitStep(parser.Previous.TOKENType)
+		emitVariable(setOp, arg)
+		emitByte(uint8(globals.OpPop))
 	} else {
-		arg = int(identifierConstant(&name))
-		getOp = globals.OpGetGlobal
-		setOp = globals.OpSetGlobal
+		emitVariable(getOp, arg)
 	}
-	if match(globals.TokenEQUAL) && canAssign {
-		expression()
-		emityBytes(uint8(setOp), uint8(arg))
+}
+
+// resolveVariable returns the opcodes that read and write the variable name
+// refers to and their operand. assign tells the analysis whether the use
+// writes the variable.
+func resolveVariable(name *Token, assign bool) (getOp, setOp globals.OpCode, arg int) {
+	if local := resolveLocal(current, name); local != -1 {
+		referenceSymbol(name, local, assign)
+		return globals.OpGetLocal, globals.OpSetLocal, local
+	}
+	if slot, ok := importedVariable(name); ok {
+		return globals.OpGetGlobal, globals.OpSetGlobal, slot
+	}
+	// Functions don't capture variables, so a local of an enclosing function
+	// is out of reach, and falling back to a global of the same name would
+	// quietly use another variable.
+	for enclosing := current.encolsing; enclosing != nil; enclosing = enclosing.encolsing {
+		if resolveLocal(enclosing, name) != -1 {
+			Error(fmt.Sprintf("Can't use local variable '%s' of an enclosing function.", name.Text))
+			break
+		}
+	}
+	referenceSymbol(name, -1, assign)
+	return globals.OpGetGlobal, globals.OpSetGlobal, identifierGlobal(name)
+}
+
+// increment compiles a prefix ++ or --, whose operator was just consumed. It
+// leaves the new value of the variable.
+func increment(canAssign bool) {
+	operator := parser.Previous
+	consume(globals.TokenIDENTIFIER, fmt.Sprintf("Expect variable name after '%s'.", operator.Text))
+	name := parser.Previous
+	getOp, setOp, arg := resolveVariable(&name, true)
+	emitVariable(getOp, arg)
+	emitStep(operator.TOKENType)
+	emitVariable(setOp, arg)
+}
+
+// emitStep adds 1 to the value on top of the stack for a ++ operator, or
+// subtracts 1 for a --.
+func emitStep(operator globals.TokenType) {
+	if operator == globals.TokenPLUS_PLUS {
+		emitByte(uint8(globals.OpIncrement))
 	} else {
-		emityBytes(uint8(getOp), uint8(arg))
+		emitByte(uint8(globals.OpDecrement))
 	}
 }
 
@@ -812,6 +1299,35 @@ func or(canAssign bool) {
 	patchJump(endJump)
 }
 
+// coalesce compiles a ?? b, which only evaluates b if a is nil.
+func coalesce(canAssign bool) {
+	elseJump := emitJump(uint8(globals.OpJumpNil))
+	endJump := emitJump(uint8(globals.OpJump))
+
+	patchJump(elseJump)
+	emitByte(uint8(globals.OpPop))
+
+	parsePrecendece(PrecCOALESCE)
+	patchJump(endJump)
+}
+
+// conditional compiles cond ? a : b, whose '?' was just consumed. The else
+// branch binds as loosely as the conditional so that conditionals nest to the
+// right.
+func conditional(canAssign bool) {
+	thenJump := emitJump(uint8(globals.OpJumpFalse))
+	emitByte(uint8(globals.OpPop))
+	expression()
+	consume(globals.TokenCOLON, "Expect ':' after then branch of conditional expression.")
+
+	elseJump := emitJump(uint8(globals.OpJump))
+	patchJump(thenJump)
+	emitByte(uint8(globals.OpPop))
+
+	parsePrecendece(PrecCONDITIONAL)
+	patchJump(elseJump)
+}
+
 // parsePrecendece parses the precedence of a given Precedence.
 //
 // It advances the scanner source and retrieves the prefix rule for the
@@ -830,23 +1346,30 @@ func or(canAssign bool) {
 // assignment target.
 func parsePrecendece(precedence Precedence) {
 
-	advance(*scanner.Source)
+	advance()
 	prefixRule := getRule(parser.Previous.TOKENType).Prefix
 	if prefixRule == nil {
 		Error("Expect expression")
 		return
 	}
 	canAssign := precedence <= PrecASSIGNMENT
+	start := parser.Previous
 	prefixRule(canAssign)
 
 	for precedence <= getRule(parser.Current.TOKENType).Precedence {
-		advSource := *scanner.Source
-		advance(advSource[parser.Current.Start:])
+		advance()
 		infixRule := getRule(parser.Previous.TOKENType).Infix
+		infixStart = start
 		infixRule(canAssign)
 	}
 
-	if canAssign && match(globals.TokenEQUAL) {
+	// A variable takes its postfix ++ or -- itself, so one left here follows
+	// something else.
+	if match(globals.TokenPLUS_PLUS) || match(globals.TokenMINUS_MINUS) {
+		errorAt(&start, "Invalid increment target.")
+	}
+	if _, compound := compoundOps[parser.Current.TOKENType]; canAssign && (compound || check(globals.TokenEQUAL)) {
+		match(parser.Current.TOKENType)
 		Error("Invalid assignment target")
 	}
 }
@@ -890,12 +1413,11 @@ func emityBytes(bytecode1, bytecode2 uint8) {
 // Return type: None.
 func consume(tokentype globals.TokenType, message string) {
 	if parser.Current.TOKENType == tokentype {
-		source := *scanner.Source
-		advance(source[parser.Current.Length:])
+		advance()
 		return
 	}
 
-	Error(message)
+	errorAtCurrent(message)
 }
 
 // emitByte writes a bytecode to the compiling chunk.
@@ -906,23 +1428,47 @@ func emitByte(bytecode uint8) {
 	WriteChunk(currentChunk(), bytecode, parser.Previous.Line)
 }
 
-// advance advances the parser to the next token in the source string.
-//
-// Parameters:
-// - source: a string representing the source code to be parsed.
+// advance advances the parser to the next token, reporting the error tokens
+// on the way. The scanner keeps the lines from the previous token's on, for
+// error snippets.
 //
 // Return type: None.
-func advance(source string) {
+func advance() {
 	parser.Previous = parser.Current
 
 	for {
-		parser.Current = scanner.ScanToken(&source)
+		var message string
+		parser.Current, message = nextToken()
 		if parser.Current.TOKENType != globals.TokenERROR {
 			break
 		}
 
-		errorAtCurrent(source[parser.Current.Start:])
+		errorAtCurrent(message)
+	}
+	scanner.Keep(parser.Previous.Pos.Line)
+	recordToken(&parser.Current)
+}
+
+// nextToken returns the token after parser.Current, and the message of an
+// error token.
+func nextToken() (Token, string) {
+	if len(parser.ahead) > 0 {
+		next := parser.ahead[0]
+		parser.ahead = parser.ahead[1:]
+		return next.token, next.message
 	}
+	token := scanner.ScanToken(scanner.Source)
+	return token, scanner.Message
+}
+
+// peekToken returns the token i+1 places after parser.Current without
+// consuming it.
+func peekToken(i int) Token {
+	for len(parser.ahead) <= i {
+		token := scanner.ScanToken(scanner.Source)
+		parser.ahead = append(parser.ahead, queuedToken{token, scanner.Message})
+	}
+	return parser.ahead[i].token
 }
 
 // errorAtCurrent is a function that takes a message as a parameter and calls the errorAt function with the parser.Current variable and the message. It does not return any value.
@@ -941,7 +1487,9 @@ func Error(message string) {
 	errorAt(&parser.Previous, message)
 }
 
-// errorAt prints an Error message and sets the parser in panic mode.
+// errorAt prints an Error message to the VM's output, followed by the source
+// line with the token marked and a hint if there is one, and sets the parser
+// in panic mode.
 //
 // It takes a token pointer and a message string as parameters.
 // It does not return anything.
@@ -950,17 +1498,23 @@ func errorAt(token *Token, message string) {
 		return
 	}
 	parser.PanicMode = true
-	fmt.Printf("Error [line %d],", token.Line)
-	source := *scanner.Source
+	reportDiagnostic(token, message)
+	line := errorLine(&scanner, token)
+	if compiling != nil && compiling.module != nil {
+		fmt.Fprintf(vm.out, "Error [line %d of %s],", line, filepath.Base(compiling.module.path))
+	} else {
+		fmt.Fprintf(vm.out, "Error [line %d],", line)
+	}
 	if token.TOKENType == globals.TokenEOF {
-		fmt.Printf(" at end")
+		fmt.Fprintf(vm.out, " at end")
 	} else if token.TOKENType == globals.TokenERROR {
 		//
 	} else {
-		fmt.Printf(" at '%s'", string(source[token.Start:token.Start+token.Length]))
+		fmt.Fprintf(vm.out, " at '%s'", token.Text)
 	}
 
-	fmt.Printf(": %s\n", message)
+	fmt.Fprintf(vm.out, ": %s\n", message)
+	writeSnippet(vm.out, &scanner, token, hints[message])
 	parser.HadError = true
 }
 
@@ -970,45 +1524,63 @@ func errorAt(token *Token, message string) {
 // No return type.
 func init() {
 	rules = map[globals.TokenType]ParseRule{
-		globals.TokenLeftParen:     {grouping, call, PrecNONE},
-		globals.TokenRightParen:    {nil, nil, PrecNONE},
-		globals.TokenLeftBrace:     {nil, nil, PrecNONE},
-		globals.TokenRightBrace:    {nil, nil, PrecNONE},
-		globals.TokenCOMMA:         {nil, nil, PrecNONE},
-		globals.TokenDOT:           {nil, nil, PrecNONE},
-		globals.TokenMINUS:         {unary, binary, PrecTERM},
-		globals.TokenPLUS:          {nil, binary, PrecTERM},
-		globals.TokenSEMICOLON:     {nil, nil, PrecNONE},
-		globals.TokenSLASH:         {nil, binary, PrecFACTOR},
-		globals.TokenSTAR:          {nil, binary, PrecFACTOR},
-		globals.TokenBANG:          {unary, nil, PrecNONE},
-		globals.TokenBANG_EQUAL:    {nil, binary, PrecEQUALITY},
-		globals.TokenEQUAL:         {nil, nil, PrecNONE},
-		globals.TokenEQUAL_EQUAL:   {nil, binary, PrecEQUALITY},
-		globals.TokenGREATER:       {nil, binary, PrecCOMPARISON},
-		globals.TokenGREATER_EQUAL: {nil, binary, PrecCOMPARISON},
-		globals.TokenLESS:          {nil, binary, PrecCOMPARISON},
-		globals.TokenLESS_EQUAL:    {nil, binary, PrecCOMPARISON},
-		globals.TokenIDENTIFIER:    {variable, nil, PrecNONE},
-		globals.TokenSTRING:        {stringy, nil, PrecNONE},
-		globals.TokenNUMBER:        {number, nil, PrecNONE},
-		globals.TokenAND:           {nil, and, PrecNONE},
-		globals.TokenCLASS:         {nil, nil, PrecNONE},
-		globals.TokenELSE:          {nil, nil, PrecNONE},
-		globals.TokenFALSE:         {literal, nil, PrecNONE},
-		globals.TokenFOR:           {nil, nil, PrecNONE},
-		globals.TokenFUN:           {nil, nil, PrecNONE},
-		globals.TokenIF:            {nil, nil, PrecNONE},
-		globals.TokenNIL:           {literal, nil, PrecNONE},
-		globals.TokenOR:            {nil, or, PrecNONE},
-		globals.TokenPRINT:         {nil, nil, PrecNONE},
-		globals.TokenRETURN:        {nil, nil, PrecNONE},
-		globals.TokenSUPER:         {nil, nil, PrecNONE},
-		globals.TokenTHIS:          {nil, nil, PrecNONE},
-		globals.TokenTRUE:          {literal, nil, PrecNONE},
-		globals.TokenVAR:           {nil, nil, PrecNONE},
-		globals.TokenWHILE:         {nil, nil, PrecNONE},
-		globals.TokenERROR:         {nil, nil, PrecNONE},
-		globals.TokenEOF:           {nil, nil, PrecNONE},
+		globals.TokenLeftParen:         {grouping, call, PrecCALL},
+		globals.TokenRightParen:        {nil, nil, PrecNONE},
+		globals.TokenLeftBrace:         {nil, nil, PrecNONE},
+		globals.TokenRightBrace:        {nil, nil, PrecNONE},
+		globals.TokenCOMMA:             {nil, nil, PrecNONE},
+		globals.TokenDOT:               {nil, dot, PrecCALL},
+		globals.TokenMINUS:             {unary, binary, PrecTERM},
+		globals.TokenPLUS:              {nil, binary, PrecTERM},
+		globals.TokenSEMICOLON:         {nil, nil, PrecNONE},
+		globals.TokenSLASH:             {nil, binary, PrecFACTOR},
+		globals.TokenSTAR:              {nil, binary, PrecFACTOR},
+		globals.TokenPERCENT:           {nil, binary, PrecFACTOR},
+		globals.TokenQUESTION:          {nil, conditional, PrecCONDITIONAL},
+		globals.TokenCOLON:             {nil, nil, PrecNONE},
+		globals.TokenBANG:              {unary, nil, PrecNONE},
+		globals.TokenBANG_EQUAL:        {nil, binary, PrecEQUALITY},
+		globals.TokenEQUAL:             {nil, nil, PrecNONE},
+		globals.TokenEQUAL_EQUAL:       {nil, binary, PrecEQUALITY},
+		globals.TokenARROW:             {nil, nil, PrecNONE},
+		globals.TokenPLUS_EQUAL:        {nil, nil, PrecNONE},
+		globals.TokenMINUS_EQUAL:       {nil, nil, PrecNONE},
+		globals.TokenSTAR_EQUAL:        {nil, nil, PrecNONE},
+		globals.TokenSLASH_EQUAL:       {nil, nil, PrecNONE},
+		globals.TokenPERCENT_EQUAL:     {nil, nil, PrecNONE},
+		globals.TokenPLUS_PLUS:         {increment, nil, PrecNONE},
+		globals.TokenMINUS_MINUS:       {increment, nil, PrecNONE},
+		globals.TokenQUESTION_QUESTION: {nil, coalesce, PrecCOALESCE},
+		globals.TokenQUESTION_DOT:      {nil, safeDot, PrecCALL},
+		globals.TokenGREATER:           {nil, binary, PrecCOMPARISON},
+		globals.TokenGREATER_EQUAL:     {nil, binary, PrecCOMPARISON},
+		globals.TokenLESS:              {nil, binary, PrecCOMPARISON},
+		globals.TokenLESS_EQUAL:        {nil, binary, PrecCOMPARISON},
+		globals.TokenIDENTIFIER:        {variable, nil, PrecNONE},
+		globals.TokenSTRING:            {stringy, nil, PrecNONE},
+		globals.TokenNUMBER:            {number, nil, PrecNONE},
+		globals.TokenAND:               {nil, and, PrecAND},
+		globals.TokenCATCH:             {nil, nil, PrecNONE},
+		globals.TokenCLASS:             {nil, nil, PrecNONE},
+		globals.TokenELSE:              {nil, nil, PrecNONE},
+		globals.TokenFALSE:             {literal, nil, PrecNONE},
+		globals.TokenFINALLY:           {nil, nil, PrecNONE},
+		globals.TokenFOR:               {nil, nil, PrecNONE},
+		globals.TokenFUN:               {lambda, nil, PrecNONE},
+		globals.TokenIF:                {nil, nil, PrecNONE},
+		globals.TokenIMPORT:            {nil, nil, PrecNONE},
+		globals.TokenNIL:               {literal, nil, PrecNONE},
+		globals.TokenOR:                {nil, or, PrecOR},
+		globals.TokenPRINT:             {nil, nil, PrecNONE},
+		globals.TokenRETURN:            {nil, nil, PrecNONE},
+		globals.TokenSUPER:             {nil, nil, PrecNONE},
+		globals.TokenTHIS:              {nil, nil, PrecNONE},
+		globals.TokenTHROW:             {nil, nil, PrecNONE},
+		globals.TokenTRUE:              {literal, nil, PrecNONE},
+		globals.TokenTRY:               {nil, nil, PrecNONE},
+		globals.TokenVAR:               {nil, nil, PrecNONE},
+		globals.TokenWHILE:             {nil, nil, PrecNONE},
+		globals.TokenERROR:             {nil, nil, PrecNONE},
+		globals.TokenEOF:               {nil, nil, PrecNONE},
 	}
 }
@@ -0,0 +1,213 @@
+package src
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+const functionBody = `
+   var x = n;
+   var sum = 0;
+   while (x > 0) {
+      sum = sum + x * 2;
+      x = x - 1;
+   }
+`
+
+func BenchmarkCompile(b *testing.B) {
+	var builder strings.Builder
+	for i := 0; i < 100; i++ {
+		builder.WriteString("fun f" + strconv.Itoa(i) + "(n) {" + functionBody + "}\n")
+	}
+	source := builder.String()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		InitVM()
+		var chunk Chunk
+		InitChunk(&chunk)
+		if Compile(source, &chunk) == nil {
+			b.Fatal("Compile() failed")
+		}
+		FreeVM()
+	}
+}
+
+func TestCompileReportsEveryError(t *testing.T) {
+	source := "var = 1;\nprint 2\nprint 4; {\n\tprint x +;\n  print 3 }\nf()\nprint \"ok\";\nprint 1++;\nfun f(a, a) {}\n"
+	want := `Error [line 1], at '=': Expect variable name. 
+ 1 | var = 1;
+   |     ^
+Error [line 3], at 'print': Expect ';' after value.
+ 3 | print 4; {
+   | ^^^^^
+   = hint: end the statement with ';'
+Error [line 4], at ';': Expect expression
+ 4 | 	print x +;
+   | 	         ^
+   = hint: an expression starts with a value, a name, '(', '-' or '!'
+Error [line 5], at '}': Expect ';' after value.
+ 5 |   print 3 }
+   |           ^
+   = hint: end the statement with ';'
+Error [line 7], at 'print': Expect ';' after expression.
+ 7 | print "ok";
+   | ^^^^^
+   = hint: end the statement with ';'
+Error [line 8], at '1': Invalid increment target.
+ 8 | print 1++;
+   |       ^
+   = hint: only a variable can be incremented or decremented
+Error [line 9], at 'a': Already variable with this name in this scope
+ 9 | fun f(a, a) {}
+   |          ^
+   = hint: pick another name, or leave out 'var' to assign to the variable
+`
+	// A reader handing out one byte at a time makes the scanner refill its
+	// buffer in the middle of every token and line.
+	compilers := map[string]func(chunk *Chunk) *ObjFunction{
+		"string": func(chunk *Chunk) *ObjFunction { return Compile(source, chunk) },
+		"reader": func(chunk *Chunk) *ObjFunction {
+			function, err := CompileReader("", iotest.OneByteReader(strings.NewReader(source)), chunk)
+			if err != nil {
+				t.Fatalf("CompileReader() error = %v", err)
+			}
+			return function
+		},
+	}
+	for name, compile := range compilers {
+		t.Run(name, func(t *testing.T) {
+			InitVM()
+			defer FreeVM()
+			var out bytes.Buffer
+			SetOutput(&out)
+			defer SetOutput(os.Stdout)
+			var chunk Chunk
+			InitChunk(&chunk)
+			if compile(&chunk) != nil {
+				t.Fatal("Compile() succeeded")
+			}
+			if got := out.String(); got != want {
+				t.Errorf("Compile() printed\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestCompileReader(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.clox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			InitVM()
+			var chunk Chunk
+			InitChunk(&chunk)
+			want := Disassemble(CompileFile(file, string(source), &chunk))
+			FreeVM()
+
+			InitVM()
+			defer FreeVM()
+			function, err := CompileReader(file, iotest.HalfReader(bytes.NewReader(source)), &chunk)
+			if err != nil || function == nil {
+				t.Fatalf("CompileReader() = %v, %v", function, err)
+			}
+			if got := Disassemble(function); !reflect.DeepEqual(got, want) {
+				t.Errorf("CompileReader() code =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestCompileReaderBuffer(t *testing.T) {
+	source := strings.Repeat("var total = 0; // a comment to make the line longer\n", 2000) +
+		"print (total, ignored) => total;\nprint \"" + strings.Repeat("x", 3*bufferSize) + "\";\n"
+	InitVM()
+	defer FreeVM()
+	var chunk Chunk
+	InitChunk(&chunk)
+	if _, err := CompileReader("", strings.NewReader(source), &chunk); err != nil {
+		t.Fatal(err)
+	}
+	// Only the long string at the end needs more than one buffer.
+	if size := len(*scanner.Source); size > 2*(3*bufferSize+2) || size >= len(source)/4 {
+		t.Errorf("the scanner buffers %d bytes of a %d byte source", size, len(source))
+	}
+}
+
+func TestCompileReaderError(t *testing.T) {
+	errRead := errors.New("disk on fire")
+	InitVM()
+	defer FreeVM()
+	SetOutput(io.Discard)
+	defer SetOutput(os.Stdout)
+	var chunk Chunk
+	InitChunk(&chunk)
+	function, err := CompileReader("", io.MultiReader(strings.NewReader("print 1;\nprint"), iotest.ErrReader(errRead)), &chunk)
+	if function != nil || err != errRead {
+		t.Errorf("CompileReader() = %v, %v, want the read error", function, err)
+	}
+}
+
+func TestWriteSnippet(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		token  Token
+		want   string
+	}{
+		{"wide token", "print total;", Token{Start: 6, Length: 5, Line: 1, Pos: Position{6, 1, 7}}, " 1 | print total;\n   |       ^^^^^\n"},
+		{"multibyte", "print \"é\" x;", Token{Start: 10, Length: 1, Line: 1, Pos: Position{10, 1, 10}}, " 1 | print \"é\" x;\n   |          ^\n"},
+		{"end of file", "print 1\n\n", Token{TOKENType: globals.TokenEOF, Start: 9, Line: 3, Pos: Position{9, 3, 1}}, " 1 | print 1\n   |        ^\n"},
+		{"multiline token", "x;\nprint \"a\nb\";", Token{Start: 9, Length: 5, Line: 3, Pos: Position{9, 2, 7}}, " 2 | print \"a\n   |       ^^\n"},
+		{"wide gutter", strings.Repeat("\n", 10) + "x", Token{Start: 10, Length: 1, Line: 11, Pos: Position{10, 11, 1}}, " 11 | x\n    | ^\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s Scanner
+			s.InitScanner(tt.source)
+			for s.ScanToken(s.Source).TOKENType != globals.TokenEOF {
+			}
+			var out bytes.Buffer
+			writeSnippet(&out, &s, &tt.token, "")
+			if got := out.String(); got != tt.want {
+				t.Errorf("writeSnippet() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorLineMatchesSnippet(t *testing.T) {
+	want := `Error [line 2],: Unterminated String.
+ 2 | print "abc
+   |       ^^^^
+   = hint: close the string with '"'
+`
+	InitVM()
+	defer FreeVM()
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+	var chunk Chunk
+	InitChunk(&chunk)
+	if Compile("var a;\nprint \"abc\ndef", &chunk) != nil {
+		t.Fatal("Compile() succeeded")
+	}
+	if got := out.String(); got != want {
+		t.Errorf("Compile() printed\n%s\nwant\n%s", got, want)
+	}
+}
@@ -2,8 +2,8 @@ package src
 
 import (
 	"fmt"
-
-	"github.com/smekuria1/goclox/globals"
+	"io"
+	"os"
 )
 
 // DisassembleChunk prints the disassembled instructions of a given chunk.
@@ -27,116 +27,149 @@ func DisassembleChunk(chunk *Chunk, name string) {
 // Return:
 // - An integer representing the new offset after processing the instruction.
 func DisassembleInstruction(chunk *Chunk, offset int) int {
-	fmt.Printf("%04d ", offset)
+	instruction, next := decodeInstruction(chunk, offset)
+	sameLine := offset > 0 && chunk.Lines[offset] == chunk.Lines[offset-1]
+	writeInstruction(os.Stdout, instruction, sameLine)
+	return next
+}
 
-	if offset > 0 && chunk.Lines[offset] == chunk.Lines[offset-1] {
-		fmt.Printf(" | ")
-	} else {
-		fmt.Printf("%4d ", chunk.Lines[offset])
+// WriteDisassembly writes listings in the text format DisassembleChunk prints.
+//
+// Parameters:
+// - w: the writer the text goes to.
+// - listings: the listings to write, typically from Disassemble.
+func WriteDisassembly(w io.Writer, listings []FunctionListing) {
+	for _, listing := range listings {
+		fmt.Fprintf(w, "== %s ==\n", listing.Name)
+		for i, instruction := range listing.Instructions {
+			sameLine := i > 0 && instruction.Line == listing.Instructions[i-1].Line
+			writeInstruction(w, instruction, sameLine)
+		}
 	}
+}
 
-	instruction := chunk.Code[offset]
+// writeInstruction writes one instruction as a line of disassembly.
+//
+// sameLine replaces the line number with a bar when the previous instruction
+// came from the same source line.
+func writeInstruction(w io.Writer, instruction Instruction, sameLine bool) {
+	fmt.Fprintf(w, "%04d ", instruction.Offset)
+	if sameLine {
+		fmt.Fprintf(w, " | ")
+	} else {
+		fmt.Fprintf(w, "%4d ", instruction.Line)
+	}
 
-	switch instruction {
-	case uint8(globals.OpReturn):
-		return simpleInstruction("OpReturn", offset)
-	case uint8(globals.OpConstant):
-		return constantInstruction("OpConstant", chunk, offset)
-	case uint8(globals.OpNil):
-		return simpleInstruction("OpNil", offset)
-	case uint8(globals.OpTrue):
-		return simpleInstruction("OpTrue", offset)
-	case uint8(globals.OpFalse):
-		return simpleInstruction("OpFalse", offset)
-	case uint8(globals.OpNegate):
-		return simpleInstruction("OpNegate", offset)
-	case uint8(globals.OpAdd):
-		return simpleInstruction("OpAdd", offset)
-	case uint8(globals.OpSubtract):
-		return simpleInstruction("OpSubtract", offset)
-	case uint8(globals.OpMultiply):
-		return simpleInstruction("OpMultiply", offset)
-	case uint8(globals.OpDivide):
-		return simpleInstruction("OpDivide", offset)
-	case uint8(globals.OpNot):
-		return simpleInstruction("OpNot", offset)
-	case uint8(globals.OpEqual):
-		return simpleInstruction("OpEqual", offset)
-	case uint8(globals.OpGreater):
-		return simpleInstruction("OpGreater", offset)
-	case uint8(globals.OpLess):
-		return simpleInstruction("OpLess", offset)
-	case uint8(globals.OpPrint):
-		return simpleInstruction("OpPrint", offset)
-	case uint8(globals.OpPop):
-		return simpleInstruction("OpPop", offset)
-	case uint8(globals.OpDefineGlobal):
-		return constantInstruction("OpDefineGlobal", chunk, offset)
-	case uint8(globals.OpGetGlobal):
-		return constantInstruction("OpGetGlobal", chunk, offset)
-	case uint8(globals.OpSetGlobal):
-		return constantInstruction("OpSetGlobal", chunk, offset)
-	case uint8(globals.OpGetLocal):
-		return byteInstruction("OpGetLocal", chunk, offset)
-	case uint8(globals.OpSetLocal):
-		return byteInstruction("OpSetLocal", chunk, offset)
-	case uint8(globals.OpJump):
-		return jumpInstruction("OpJump", 1, chunk, offset)
-	case uint8(globals.OpJumpFalse):
-		return jumpInstruction("OpJumpElse", 1, chunk, offset)
-	case uint8(globals.OpLoop):
-		return jumpInstruction("OpLoop", -1, chunk, offset)
-	case uint8(globals.OpCall):
-		return byteInstruction("OpCall", chunk, offset)
+	switch opcodeFormats[instruction.op].kind {
+	case formatSimple:
+		fmt.Fprintf(w, "%s\n", instruction.Opcode)
+	case formatConstant, formatGlobal:
+		fmt.Fprintf(w, "%-16s %4d '%s'\n", instruction.Opcode, instruction.Operands[0], instruction.Value)
+	case formatByte:
+		fmt.Fprintf(w, "%-16s %4d\n", instruction.Opcode, instruction.Operands[0])
+	case formatIncrement:
+		fmt.Fprintf(w, "%-16s %4d %4d '%s'\n", instruction.Opcode, instruction.Operands[0], instruction.Operands[1], instruction.Value)
+	case formatJump:
+		fmt.Fprintf(w, "%-16s %4d -> %d\n", instruction.Opcode, instruction.Offset, *instruction.Target)
 	default:
-		fmt.Println("Unknown opcode ", instruction)
-		return offset + 1
+		fmt.Fprintf(w, "Unknown opcode  %d\n", instruction.op)
 	}
-
 }
 
-// simpleInstruction prints the given opcode and returns the offset incremented by 1.
+// DisassembleRegisters prints the register code of a function.
 //
 // Parameters:
-// - opcode: a string representing the opcode to be printed.
-// - offset: an integer representing the current offset.
-//
-// Returns:
-// - an integer representing the new offset after incrementing it by 1.
-func simpleInstruction(opcode string, offset int) int {
-	fmt.Printf("%s\n", opcode)
-	return offset + 1
-}
+// - code: the register code to print.
+// - chunk: the stack chunk whose constant pool the register code shares.
+// - name: the name printed in the header.
+func DisassembleRegisters(code *RegisterChunk, chunk *Chunk, name string) {
+	fmt.Printf("== %s (registers: %d) ==\n", name, code.Registers)
 
-// constantInstruction prints an opcode and its corresponding constant value.
-//
-// It takes in the opcode string, the chunk pointer, and the offset integer as parameters.
-// It returns an integer representing the updated offset.
-func constantInstruction(opcode string, chunk *Chunk, offset int) int {
-	constant := chunk.Code[offset+1]
-	fmt.Printf("%-16s %4d '", opcode, constant)
-	PrintValue(chunk.Constants.Values[constant])
-	fmt.Printf("'\n")
-	return offset + 2
+	for pc := range code.Code {
+		DisassembleRegisterInstruction(code, chunk, pc)
+	}
 }
 
-// byteInstruction prints the opcode and slot of a byte instruction.
+// DisassembleRegisterInstruction prints the register instruction at pc.
 //
-// It takes the following parameter(s):
-// - opcode: a string representing the opcode of the byte instruction.
-// - chunk: a pointer to the Chunk struct representing the chunk of code being executed.
-// - offset: an integer representing the offset of the current byte instruction in the chunk.
-//
-// It returns an integer representing the updated offset after processing the byte instruction.
-func byteInstruction(opcode string, chunk *Chunk, offset int) int {
-	slot := chunk.Code[offset+1]
-	fmt.Printf("%-16s %4d\n", opcode, slot)
-	return offset + 2
-}
+// Register operands print as R<n> and constant operands as K<n> followed by
+// the constant's value.
+func DisassembleRegisterInstruction(code *RegisterChunk, chunk *Chunk, pc int) {
+	fmt.Printf("%04d ", pc)
+	if pc > 0 && code.Lines[pc] == code.Lines[pc-1] {
+		fmt.Printf(" | ")
+	} else {
+		fmt.Printf("%4d ", code.Lines[pc])
+	}
+
+	ins := code.Code[pc]
+	fmt.Printf("%-16s", ins.Op)
+	register := func(r int) { fmt.Printf(" R%d", r) }
+	operand := func(rk int) {
+		if rk < rkConstant {
+			register(rk)
+			return
+		}
+		fmt.Printf(" K%d '", rk-rkConstant)
+		PrintValue(chunk.Constants.Values[rk-rkConstant])
+		fmt.Printf("'")
+	}
+	global := func(slot int) {
+		fmt.Printf(" G%d", slot)
+		if slot < len(vm.globalNames) {
+			fmt.Printf(" '")
+			PrintValue(ObjStrValue(vm.globalNames[slot]))
+			fmt.Printf("'")
+		}
+	}
 
-// jumpInstruction
-func jumpInstruction(name string, sign int, chunk *Chunk, offset int) int {
-	jump := uint16(chunk.Code[offset+1])<<8 | uint16(chunk.Code[offset+2])
-	fmt.Printf("%-16s %4d -> %d\n", name, offset, offset+3+sign*int(jump))
-	return offset + 3
+	switch ins.Op {
+	case RegMove, RegNegate, RegNot, RegImport, RegIncrement, RegDecrement:
+		register(ins.A)
+		operand(ins.B)
+	case RegLoadNil:
+		register(ins.A)
+	case RegLoadBool:
+		register(ins.A)
+		fmt.Printf(" %v", ins.B != 0)
+	case RegGetGlobal:
+		register(ins.A)
+		global(ins.B)
+	case RegDefineGlobal, RegSetGlobal:
+		global(ins.A)
+		operand(ins.B)
+	case RegAdd, RegSubtract, RegMultiply, RegDivide, RegModulo, RegEqual, RegGreater, RegLess:
+		register(ins.A)
+		operand(ins.B)
+		operand(ins.C)
+	case RegPrint, RegReturn, RegThrow:
+		operand(ins.A)
+	case RegEndFinally:
+		register(ins.A)
+	case RegGetProperty:
+		register(ins.A)
+		operand(ins.B)
+		operand(rkConstant + ins.C)
+	case RegArgMissing:
+		register(ins.A)
+		register(ins.B)
+	case RegTry:
+		fmt.Printf(" -> %04d depth %d", ins.A, ins.B)
+		if ins.C != 0 {
+			fmt.Printf(" finally")
+		}
+	case RegJump:
+		fmt.Printf(" -> %04d", ins.A)
+	case RegJumpFalse, RegJumpNil:
+		operand(ins.A)
+		fmt.Printf(" -> %04d", ins.B)
+	case RegJumpNotLess:
+		operand(ins.A)
+		operand(ins.B)
+		fmt.Printf(" -> %04d", ins.C)
+	case RegCall:
+		register(ins.A)
+		fmt.Printf(" %d", ins.B)
+	}
+	fmt.Printf("\n")
 }
@@ -0,0 +1,341 @@
+package src
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// StepMode tells a Debugger when to stop next.
+type StepMode int
+
+const (
+	// StepContinue runs until a breakpoint or a pause request.
+	StepContinue StepMode = iota
+	// StepIn stops at the next source line, in any function.
+	StepIn
+	// StepOver stops at the next source line of the current function or its callers.
+	StepOver
+	// StepOut stops at the next source line after the current function returns.
+	StepOut
+)
+
+// Stop reasons passed to Debugger.OnStop.
+const (
+	StopEntry              = "entry"
+	StopBreakpoint         = "breakpoint"
+	StopFunctionBreakpoint = "function breakpoint"
+	StopStep               = "step"
+	StopPause              = "pause"
+)
+
+// StackFrame describes one active call in a backtrace.
+type StackFrame struct {
+	Function string // The function name, or "script" for top-level code.
+	Line     int    // The line being executed.
+	Path     string // The absolute path of the function's file, or "" for a script not read from a file.
+}
+
+// Variable is a named value shown by a debugger.
+type Variable struct {
+	Name  string
+	Value string
+}
+
+/*
+Debugger pauses a running program at source lines and lets its caller inspect
+the paused state.
+
+Attach it with AttachDebugger before Interpret. The VM then reports every new
+source line to the debugger, which decides from the breakpoints and the
+current StepMode whether to stop. On a stop it calls OnStop on the VM's
+goroutine; the program stays paused until OnStop returns, and inside OnStop
+Backtrace, Locals, Globals and Evaluate describe the paused program. The step
+methods choose how far the program runs once OnStop returns.
+
+Locals are only known for functions compiled without the optimizer and
+superinstructions, which move code around.
+*/
+type Debugger struct {
+	// OnStop is called with a Stop reason each time the program pauses.
+	OnStop func(reason string)
+	// StopOnEntry pauses the program before its first instruction.
+	StopOnEntry bool
+
+	mu        sync.Mutex              // Guards lines and functions.
+	lines     map[string]map[int]bool // Line breakpoints, by the absolute path of their file.
+	functions map[string]bool         // Function breakpoints, by name.
+	mode      StepMode
+	stepDepth int         // The frame count when the current step started.
+	positions []position  // The last position seen in each active frame, by depth.
+	pause     atomic.Bool // Set by Pause, possibly from another goroutine.
+	abort     atomic.Bool // Set by Abort, possibly from another goroutine.
+	started   bool        // Set after the first instruction.
+}
+
+// position is where a frame was last seen.
+type position struct {
+	line int
+	ip   int
+}
+
+// NewDebugger returns a Debugger with no breakpoints.
+func NewDebugger() *Debugger {
+	return &Debugger{lines: make(map[string]map[int]bool), functions: make(map[string]bool)}
+}
+
+// AttachDebugger makes the VM report to debugger, or to no debugger if it is nil.
+//
+// InitVM detaches any debugger. Only the stack VM supports debugging.
+func AttachDebugger(debugger *Debugger) {
+	vm.debugger = debugger
+}
+
+// SetLineBreakpoints replaces the line breakpoints in the file at path, which
+// is "" for a script not read from a file. Breakpoints in other files stay.
+//
+// It is safe to call from any goroutine.
+func (d *Debugger) SetLineBreakpoints(path string, lines []int) {
+	breakpoints := make(map[int]bool)
+	for _, line := range lines {
+		breakpoints[line] = true
+	}
+	if path != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	d.mu.Lock()
+	// onInstruction reads the map it got without the lock, so replace it.
+	files := make(map[string]map[int]bool, len(d.lines)+1)
+	for file, lines := range d.lines {
+		files[file] = lines
+	}
+	files[path] = breakpoints
+	d.lines = files
+	d.mu.Unlock()
+}
+
+// SetFunctionBreakpoints replaces the function breakpoints.
+//
+// It is safe to call from any goroutine.
+func (d *Debugger) SetFunctionBreakpoints(names []string) {
+	breakpoints := make(map[string]bool)
+	for _, name := range names {
+		breakpoints[name] = true
+	}
+	d.mu.Lock()
+	d.functions = breakpoints
+	d.mu.Unlock()
+}
+
+// Continue resumes until the next breakpoint.
+func (d *Debugger) Continue() { d.resume(StepContinue) }
+
+// StepIn resumes until the next source line, entering calls.
+func (d *Debugger) StepIn() { d.resume(StepIn) }
+
+// StepOver resumes until the next source line of the current function or a caller.
+func (d *Debugger) StepOver() { d.resume(StepOver) }
+
+// StepOut resumes until the current function has returned.
+func (d *Debugger) StepOut() { d.resume(StepOut) }
+
+// Pause asks the running program to stop at its next source line.
+//
+// It is safe to call from any goroutine.
+func (d *Debugger) Pause() { d.pause.Store(true) }
+
+// Abort stops the program at its next instruction; Interpret then returns InterpretAborted.
+//
+// It is safe to call from any goroutine.
+func (d *Debugger) Abort() { d.abort.Store(true) }
+
+func (d *Debugger) resume(mode StepMode) {
+	d.mode = mode
+	d.stepDepth = vm.frameCount
+}
+
+// onInstruction is called by run before each instruction of frame, with
+// frame.ip and vm.stackTop up to date. It returns false to abort the program.
+func (d *Debugger) onInstruction(frame *CallFrame) bool {
+	if d.abort.Load() {
+		return false
+	}
+	depth := vm.frameCount
+	ip := frame.ip
+	line := frame.function.chunk.Lines[ip]
+
+	// A frame reaches a new line when it starts, when its line changes, or
+	// when it jumps back to run the same line again.
+	for len(d.positions) < depth {
+		d.positions = append(d.positions, position{line: -1})
+	}
+	d.positions = d.positions[:depth]
+	last := &d.positions[depth-1]
+	if ip == 0 {
+		*last = position{line: -1}
+	}
+	newLine := line != last.line || ip < last.ip
+	*last = position{line: line, ip: ip}
+	if !newLine {
+		return true
+	}
+
+	d.mu.Lock()
+	lines, functions := d.lines, d.functions
+	d.mu.Unlock()
+	reason := ""
+	switch {
+	case d.pause.Swap(false):
+		reason = StopPause
+	case !d.started && d.StopOnEntry:
+		reason = StopEntry
+	case ip == 0 && functions[functionName(frame.function)]:
+		reason = StopFunctionBreakpoint
+	case lines[frame.function.path][line]:
+		reason = StopBreakpoint
+	case d.mode == StepIn,
+		d.mode == StepOver && depth <= d.stepDepth,
+		d.mode == StepOut && depth < d.stepDepth:
+		reason = StopStep
+	}
+	d.started = true
+	if reason == "" {
+		return true
+	}
+
+	d.resume(StepContinue)
+	if d.OnStop != nil {
+		d.OnStop(reason)
+	}
+	return !d.abort.Load()
+}
+
+// Backtrace returns the active calls of the paused program, innermost first.
+func (d *Debugger) Backtrace() []StackFrame {
+	frames := make([]StackFrame, 0, vm.frameCount)
+	for i := vm.frameCount - 1; i >= 0; i-- {
+		frame := &vm.frame[i]
+		frames = append(frames, StackFrame{Function: functionName(frame.function), Line: frameLine(frame, i), Path: frame.function.path})
+	}
+	return frames
+}
+
+// Locals returns the local variables in scope in the given frame, where 0 is
+// the innermost frame as in Backtrace. A shadowed variable is left out.
+func (d *Debugger) Locals(frameIndex int) []Variable {
+	slots := d.liveLocals(frameIndex)
+	var locals []Variable
+	base := vm.frame[vm.frameCount-1-frameIndex].slots
+	for slot, name := range slots {
+		if name != "" {
+			locals = append(locals, Variable{Name: name, Value: valueString(vm.stack[base+slot])})
+		}
+	}
+	return locals
+}
+
+// Globals returns the defined global variables, sorted by name.
+func (d *Debugger) Globals() []Variable {
+	var variables []Variable
+	for slot, name := range vm.globalNames {
+		if value := vm.globalValues[slot]; !IsUndefined(value) {
+			variables = append(variables, Variable{Name: AsCString(ObjStrValue(name)), Value: valueString(value)})
+		}
+	}
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+	return variables
+}
+
+// Evaluate compiles and runs expression in the given frame, where 0 is the
+// innermost frame as in Backtrace, and returns the printed value.
+//
+// The expression can read and assign the frame's locals and any global.
+// Breakpoints are ignored while it runs.
+func (d *Debugger) Evaluate(frameIndex int, expression string) (string, error) {
+	if frameIndex < 0 || frameIndex >= vm.frameCount {
+		return "", errors.New("no such frame")
+	}
+	frame := &vm.frame[vm.frameCount-1-frameIndex]
+	slots := d.liveLocals(frameIndex)
+	if vm.frameCount == FrameMax {
+		return "", errors.New("stack overflow")
+	}
+
+	// Compile errors and runtime errors are printed to vm.out; capture them.
+	var messages bytes.Buffer
+	out, debugger := vm.out, vm.debugger
+	frameCount, stackTop, entryFrames := vm.frameCount, vm.stackTop, vm.entryFrames
+	vm.out, vm.debugger = &messages, nil
+	defer func() {
+		vm.out, vm.debugger = out, debugger
+		vm.frameCount, vm.stackTop, vm.entryFrames = frameCount, stackTop, entryFrames
+	}()
+
+	function := compileEval(expression, slots)
+	if function == nil {
+		return "", errors.New(strings.TrimSpace(messages.String()))
+	}
+	eval := &vm.frame[vm.frameCount]
+	eval.function = function
+	eval.ip = 0
+	eval.slots = frame.slots
+	eval.handlers = eval.handlers[:0]
+	vm.entryFrames = vm.frameCount
+	vm.frameCount++
+	if vm.run() != InterpretOk {
+		message, _, _ := strings.Cut(messages.String(), "\n")
+		return "", errors.New(message)
+	}
+	return valueString(vm.result), nil
+}
+
+// liveLocals returns the name of each slot of the given frame that holds a
+// local variable in scope, or "" for other slots and for functions without
+// local variable information.
+func (d *Debugger) liveLocals(frameIndex int) []string {
+	index := vm.frameCount - 1 - frameIndex
+	frame := &vm.frame[index]
+
+	// The frame's slots end where the next frame's begin, or at the stack top.
+	height := vm.stackTop - frame.slots
+	offset := frame.ip
+	if index < vm.frameCount-1 {
+		height = vm.frame[index+1].slots - frame.slots
+		offset = frame.ip - 1 // Callers are stopped inside their OpCall.
+	}
+
+	slots := make([]string, max(height, 1))
+	seen := make(map[string]bool)
+	// Later locals shadow earlier ones with the same name.
+	for i := len(frame.function.locals) - 1; i >= 0; i-- {
+		local := frame.function.locals[i]
+		if local.Start < 0 || offset < local.Start || offset >= local.End || local.Slot >= height || seen[local.Name] {
+			continue
+		}
+		seen[local.Name] = true
+		slots[local.Slot] = local.Name
+	}
+	return slots
+}
+
+// functionName returns the name of function, or "script" for top-level code.
+func functionName(function *ObjFunction) string {
+	if function.name == nil {
+		return "script"
+	}
+	return AsCString(ObjStrValue(function.name))
+}
+
+// frameLine returns the line frame is executing; index is its position in vm.frame.
+func frameLine(frame *CallFrame, index int) int {
+	if index == vm.frameCount-1 {
+		return frame.function.chunk.Lines[frame.ip]
+	}
+	return frame.function.chunk.Lines[frame.ip-1]
+}
@@ -0,0 +1,197 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+const debuggerSource = `var g = 1;
+fun add(a, b) {
+  var sum = a + b;
+  return sum;
+}
+{
+  var x = 2;
+  var y = add(x, 3);
+  print y;
+}
+print g;
+`
+
+// debugSource runs source under a debugger set up by setup. Each stop is
+// recorded as "reason function:line" and then handled by onStop, if set.
+func debugSource(t *testing.T, source string, setup func(d *Debugger), onStop func(d *Debugger)) (stops []string, output string, result InterpretResult) {
+	t.Helper()
+	defer func(optimize, super bool) {
+		globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS = optimize, super
+	}(globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS)
+	globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS = false, false
+
+	InitVM()
+	defer FreeVM()
+	var out bytes.Buffer
+	SetOutput(&out)
+	debugger := NewDebugger()
+	debugger.OnStop = func(reason string) {
+		frame := debugger.Backtrace()[0]
+		stops = append(stops, fmt.Sprintf("%s %s:%d", reason, frame.Function, frame.Line))
+		if onStop != nil {
+			onStop(debugger)
+		}
+	}
+	if setup != nil {
+		setup(debugger)
+	}
+	AttachDebugger(debugger)
+	result = Interpret(source)
+	return stops, out.String(), result
+}
+
+func TestDebuggerStops(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(d *Debugger)
+		step  func(d *Debugger)
+		want  []string
+	}{
+		{
+			name:  "line breakpoints",
+			setup: func(d *Debugger) { d.SetLineBreakpoints("", []int{4, 9}) },
+			want:  []string{"breakpoint add:4", "breakpoint script:9"},
+		},
+		{
+			name:  "function breakpoint",
+			setup: func(d *Debugger) { d.SetFunctionBreakpoints([]string{"add"}) },
+			want:  []string{"function breakpoint add:3"},
+		},
+		{
+			name:  "step in",
+			setup: func(d *Debugger) { d.SetLineBreakpoints("", []int{8}) },
+			step:  (*Debugger).StepIn,
+			want:  []string{"breakpoint script:8", "step add:3", "step add:4", "step script:9", "step script:10", "step script:11", "step script:12"},
+		},
+		{
+			name:  "step over",
+			setup: func(d *Debugger) { d.SetLineBreakpoints("", []int{7}) },
+			step:  (*Debugger).StepOver,
+			want:  []string{"breakpoint script:7", "step script:8", "step script:9", "step script:10", "step script:11", "step script:12"},
+		},
+		{
+			name:  "step out",
+			setup: func(d *Debugger) { d.SetLineBreakpoints("", []int{3}) },
+			step:  (*Debugger).StepOut,
+			want:  []string{"breakpoint add:3", "step script:9"},
+		},
+		{
+			name:  "stop on entry",
+			setup: func(d *Debugger) { d.StopOnEntry = true },
+			want:  []string{"entry script:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stops, output, result := debugSource(t, debuggerSource, tt.setup, tt.step)
+			if !reflect.DeepEqual(stops, tt.want) {
+				t.Errorf("stops = %q, want %q", stops, tt.want)
+			}
+			if result != InterpretOk || output != "5\n1\n" {
+				t.Errorf("Interpret = %v with output %q, want %v with %q", result, output, InterpretOk, "5\n1\n")
+			}
+		})
+	}
+}
+
+func TestDebuggerInspection(t *testing.T) {
+	var backtrace []StackFrame
+	var locals, callerLocals, globalVariables []Variable
+	var evaluated []string
+	setup := func(d *Debugger) { d.SetLineBreakpoints("", []int{4}) }
+	onStop := func(d *Debugger) {
+		backtrace = d.Backtrace()
+		locals = d.Locals(0)
+		callerLocals = d.Locals(1)
+		globalVariables = d.Globals()
+		for _, expression := range []string{"a + b * g", "x", "sum = 40", "missing", "1 +"} {
+			value, err := d.Evaluate(0, expression)
+			if err != nil {
+				value = "error: " + err.Error()
+			}
+			evaluated = append(evaluated, value)
+		}
+	}
+	_, output, result := debugSource(t, debuggerSource, setup, onStop)
+
+	if want := []StackFrame{{"add", 4, ""}, {"script", 8, ""}}; !reflect.DeepEqual(backtrace, want) {
+		t.Errorf("Backtrace() = %v, want %v", backtrace, want)
+	}
+	if want := []Variable{{"a", "2"}, {"b", "3"}, {"sum", "5"}}; !reflect.DeepEqual(locals, want) {
+		t.Errorf("Locals(0) = %v, want %v", locals, want)
+	}
+	if want := []Variable{{"x", "2"}}; !reflect.DeepEqual(callerLocals, want) {
+		t.Errorf("Locals(1) = %v, want %v", callerLocals, want)
+	}
+	if want := []Variable{{"add", "add"}, {"g", "1"}}; !reflect.DeepEqual(globalVariables, want) {
+		t.Errorf("Globals() = %v, want %v", globalVariables, want)
+	}
+	wantEvaluated := []string{"5", "error: Undefined variable 'x'.", "40", "error: Undefined variable 'missing'.", "error: "}
+	for i, want := range wantEvaluated {
+		if i >= len(evaluated) || !strings.HasPrefix(evaluated[i], want) {
+			t.Errorf("Evaluate results = %q, want prefixes %q", evaluated, wantEvaluated)
+			break
+		}
+	}
+	// The assignment to sum changes what add returns.
+	if result != InterpretOk || output != "40\n1\n" {
+		t.Errorf("Interpret = %v with output %q, want %v with %q", result, output, InterpretOk, "40\n1\n")
+	}
+}
+
+func TestDebuggerAbort(t *testing.T) {
+	setup := func(d *Debugger) { d.StopOnEntry = true }
+	stops, output, result := debugSource(t, debuggerSource, setup, (*Debugger).Abort)
+	if len(stops) != 1 || output != "" || result != InterpretAborted {
+		t.Errorf("got stops %q, output %q, result %v; want one stop, no output and %v", stops, output, result, InterpretAborted)
+	}
+}
+
+func TestDebuggerModules(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/mod.clox": "fun twice(x) {\n  var y = x * 2;\n  return y;\n}\n",
+		"main.clox":    "import \"lib/mod.clox\";\nvar a = 1;\nprint twice(a);\n",
+	})
+	script, module := filepath.Join(dir, "main.clox"), filepath.Join(dir, "lib", "mod.clox")
+	defer func(optimize, super bool) {
+		globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS = optimize, super
+	}(globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS)
+	globals.OPTIMIZE_CODE, globals.SUPER_INSTRUCTIONS = false, false
+
+	InitVM()
+	defer FreeVM()
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+	var stops [][]StackFrame
+	debugger := NewDebugger()
+	debugger.OnStop = func(reason string) { stops = append(stops, debugger.Backtrace()) }
+	// Line 3 of the script has code too, but only the module's line 3 has a breakpoint.
+	debugger.SetLineBreakpoints(module, []int{3})
+	AttachDebugger(debugger)
+	source, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := InterpretFile(script, string(source)); result != InterpretOk || out.String() != "2\n" {
+		t.Errorf("InterpretFile() = %v with output %q", result, out.String())
+	}
+	want := [][]StackFrame{{{"twice", 3, module}, {"script", 3, script}}}
+	if !reflect.DeepEqual(stops, want) {
+		t.Errorf("stops = %v, want %v", stops, want)
+	}
+}
@@ -0,0 +1,173 @@
+package src
+
+import (
+	"strings"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// Instruction is one disassembled instruction.
+type Instruction struct {
+	Offset   int    `json:"offset"`             // The offset of the opcode in the chunk.
+	Line     int    `json:"line"`               // The source line the instruction was compiled from.
+	Opcode   string `json:"opcode"`             // The opcode name, as the text disassembly prints it.
+	Operands []int  `json:"operands,omitempty"` // The raw operand bytes, or the 16-bit offset of a jump.
+	Value    string `json:"value,omitempty"`    // The printed constant or global name an operand refers to.
+	Target   *int   `json:"target,omitempty"`   // The offset a jump lands on.
+
+	op uint8 // The raw opcode, kept for formatting.
+}
+
+// FunctionListing is the disassembly of one function.
+type FunctionListing struct {
+	Name         string        `json:"name"`               // The function name, or "script" for top-level code.
+	Arity        int           `json:"arity"`              // The number of parameters, a rest parameter included.
+	Required     int           `json:"required"`           // The number of parameters without a default value.
+	Variadic     bool          `json:"variadic,omitempty"` // Whether the last parameter collects the extra arguments.
+	Path         string        `json:"path,omitempty"`     // The absolute path of the function's file, if it was compiled from one.
+	Instructions []Instruction `json:"instructions"`
+}
+
+// Disassemble decodes function and every function nested in its constants.
+//
+// Parameters:
+// - function: the function to disassemble, usually a compiled script.
+//
+// Returns:
+// - []FunctionListing: the listing of function followed by those of its nested functions, depth first.
+func Disassemble(function *ObjFunction) []FunctionListing {
+	listing := FunctionListing{Name: "script", Arity: function.arity, Required: function.minArity, Variadic: function.variadic, Path: function.path}
+	if function.name != nil {
+		listing.Name = AsCString(ObjStrValue(function.name))
+	}
+	chunk := &function.chunk
+	for offset := 0; offset < chunk.Count; {
+		var instruction Instruction
+		instruction, offset = decodeInstruction(chunk, offset)
+		listing.Instructions = append(listing.Instructions, instruction)
+	}
+
+	listings := []FunctionListing{listing}
+	for _, constant := range chunk.Constants.Values[:chunk.Constants.Count] {
+		if IsFunction(constant) {
+			listings = append(listings, Disassemble(AsFunction(constant))...)
+		}
+	}
+	return listings
+}
+
+// Kinds of instruction layout, which decide how operands are decoded and printed.
+const (
+	formatUnknown = iota
+	formatSimple
+	formatConstant
+	formatGlobal
+	formatByte
+	formatIncrement
+	formatJump
+)
+
+// opcodeFormat names an opcode and describes its operands.
+type opcodeFormat struct {
+	name string
+	kind int
+	sign int // The direction of a jump: 1 forward, -1 backward.
+}
+
+// opcodeFormats describes every opcode the disassembler knows.
+var opcodeFormats = map[uint8]opcodeFormat{
+	uint8(globals.OpReturn):         {"OpReturn", formatSimple, 0},
+	uint8(globals.OpConstant):       {"OpConstant", formatConstant, 0},
+	uint8(globals.OpNil):            {"OpNil", formatSimple, 0},
+	uint8(globals.OpTrue):           {"OpTrue", formatSimple, 0},
+	uint8(globals.OpFalse):          {"OpFalse", formatSimple, 0},
+	uint8(globals.OpNegate):         {"OpNegate", formatSimple, 0},
+	uint8(globals.OpAdd):            {"OpAdd", formatSimple, 0},
+	uint8(globals.OpSubtract):       {"OpSubtract", formatSimple, 0},
+	uint8(globals.OpMultiply):       {"OpMultiply", formatSimple, 0},
+	uint8(globals.OpDivide):         {"OpDivide", formatSimple, 0},
+	uint8(globals.OpNot):            {"OpNot", formatSimple, 0},
+	uint8(globals.OpEqual):          {"OpEqual", formatSimple, 0},
+	uint8(globals.OpGreater):        {"OpGreater", formatSimple, 0},
+	uint8(globals.OpLess):           {"OpLess", formatSimple, 0},
+	uint8(globals.OpPrint):          {"OpPrint", formatSimple, 0},
+	uint8(globals.OpPop):            {"OpPop", formatSimple, 0},
+	uint8(globals.OpDefineGlobal):   {"OpDefineGlobal", formatGlobal, 0},
+	uint8(globals.OpGetGlobal):      {"OpGetGlobal", formatGlobal, 0},
+	uint8(globals.OpSetGlobal):      {"OpSetGlobal", formatGlobal, 0},
+	uint8(globals.OpSetGlobalPop):   {"OpSetGlobalPop", formatGlobal, 0},
+	uint8(globals.OpGetLocal):       {"OpGetLocal", formatByte, 0},
+	uint8(globals.OpSetLocal):       {"OpSetLocal", formatByte, 0},
+	uint8(globals.OpSetLocalPop):    {"OpSetLocalPop", formatByte, 0},
+	uint8(globals.OpJump):           {"OpJump", formatJump, 1},
+	uint8(globals.OpJumpFalse):      {"OpJumpElse", formatJump, 1},
+	uint8(globals.OpLoop):           {"OpLoop", formatJump, -1},
+	uint8(globals.OpCall):           {"OpCall", formatByte, 0},
+	uint8(globals.OpGetLocal0):      {"OpGetLocal0", formatSimple, 0},
+	uint8(globals.OpGetLocal1):      {"OpGetLocal1", formatSimple, 0},
+	uint8(globals.OpGetLocal2):      {"OpGetLocal2", formatSimple, 0},
+	uint8(globals.OpGetLocal3):      {"OpGetLocal3", formatSimple, 0},
+	uint8(globals.OpAddConstant):    {"OpAddConstant", formatConstant, 0},
+	uint8(globals.OpLessJumpFalse):  {"OpLessJumpElse", formatJump, 1},
+	uint8(globals.OpIncrementLocal): {"OpIncrementLocal", formatIncrement, 0},
+	uint8(globals.OpImport):         {"OpImport", formatConstant, 0},
+	uint8(globals.OpTry):            {"OpTry", formatJump, 1},
+	uint8(globals.OpTryFinally):     {"OpTryFinally", formatJump, 1},
+	uint8(globals.OpEndTry):         {"OpEndTry", formatSimple, 0},
+	uint8(globals.OpEndFinally):     {"OpEndFinally", formatSimple, 0},
+	uint8(globals.OpThrow):          {"OpThrow", formatSimple, 0},
+	uint8(globals.OpGetProperty):    {"OpGetProperty", formatConstant, 0},
+	uint8(globals.OpArgMissing):     {"OpArgMissing", formatByte, 0},
+	uint8(globals.OpModulo):         {"OpModulo", formatSimple, 0},
+	uint8(globals.OpJumpNil):        {"OpJumpNil", formatJump, 1},
+	uint8(globals.OpIncrement):      {"OpIncrement", formatSimple, 0},
+	uint8(globals.OpDecrement):      {"OpDecrement", formatSimple, 0},
+}
+
+// decodeInstruction decodes the instruction at offset.
+//
+// Unknown opcodes decode as a one-byte instruction with an empty Opcode.
+// Returns the instruction and the offset of the next one.
+func decodeInstruction(chunk *Chunk, offset int) (Instruction, int) {
+	op := chunk.Code[offset]
+	format := opcodeFormats[op]
+	instruction := Instruction{Offset: offset, Line: chunk.Lines[offset], Opcode: format.name, op: op}
+
+	switch format.kind {
+	case formatConstant:
+		constant := int(chunk.Code[offset+1])
+		instruction.Operands = []int{constant}
+		instruction.Value = valueString(chunk.Constants.Values[constant])
+		return instruction, offset + 2
+	case formatGlobal:
+		slot := int(uint16(chunk.Code[offset+1])<<8 | uint16(chunk.Code[offset+2]))
+		instruction.Operands = []int{slot}
+		if slot < len(vm.globalNames) {
+			instruction.Value = AsCString(ObjStrValue(vm.globalNames[slot]))
+		}
+		return instruction, offset + 3
+	case formatByte:
+		instruction.Operands = []int{int(chunk.Code[offset+1])}
+		return instruction, offset + 2
+	case formatIncrement:
+		slot, constant := int(chunk.Code[offset+1]), int(chunk.Code[offset+2])
+		instruction.Operands = []int{slot, constant}
+		instruction.Value = valueString(chunk.Constants.Values[constant])
+		return instruction, offset + 3
+	case formatJump:
+		jump := int(uint16(chunk.Code[offset+1])<<8 | uint16(chunk.Code[offset+2]))
+		target := offset + 3 + format.sign*jump
+		instruction.Operands = []int{jump}
+		instruction.Target = &target
+		return instruction, offset + 3
+	default:
+		return instruction, offset + 1
+	}
+}
+
+// valueString returns value as print would show it.
+func valueString(value Value) string {
+	var text strings.Builder
+	FprintValue(&text, value)
+	return text.String()
+}
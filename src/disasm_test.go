@@ -0,0 +1,89 @@
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisassembleNestedFunctions(t *testing.T) {
+	function := compileSource(t, "fun outer(a) {\n  fun inner() { return 1; }\n  return inner;\n}\nprint outer;", false)
+	listings := Disassemble(function)
+
+	var names []string
+	for _, listing := range listings {
+		names = append(names, listing.Name)
+	}
+	if got, want := strings.Join(names, ","), "script,outer,inner"; got != want {
+		t.Fatalf("Disassemble() listed %s, want %s", got, want)
+	}
+	if listings[1].Arity != 1 {
+		t.Errorf("outer arity = %d, want 1", listings[1].Arity)
+	}
+
+	first := listings[0].Instructions[0]
+	if first.Opcode != "OpConstant" || first.Value != "outer" || first.Line != 4 {
+		t.Errorf("first instruction = %+v, want OpConstant 'outer' on line 4", first)
+	}
+}
+
+func TestDisassembleJumpTargets(t *testing.T) {
+	function := compileSource(t, "if (true) print 1; else print 2;", false)
+	listing := Disassemble(function)[0]
+	offsets := make(map[int]bool)
+	for _, instruction := range listing.Instructions {
+		offsets[instruction.Offset] = true
+	}
+	jumps := 0
+	for _, instruction := range listing.Instructions {
+		if instruction.Target == nil {
+			continue
+		}
+		jumps++
+		if !offsets[*instruction.Target] {
+			t.Errorf("%s at %d targets %d, which is not an instruction", instruction.Opcode, instruction.Offset, *instruction.Target)
+		}
+	}
+	if jumps != 2 {
+		t.Errorf("found %d jumps, want 2", jumps)
+	}
+}
+
+func TestWriteDisassembly(t *testing.T) {
+	function := compileSource(t, "var x = 1;\nprint x;", false)
+	var out strings.Builder
+	WriteDisassembly(&out, Disassemble(function))
+	want := `== script ==
+0000    1 OpConstant          0 '1'
+0002  | OpDefineGlobal      0 'x'
+0005    2 OpGetGlobal         0 'x'
+0008  | OpPrint
+0009  | OpNil
+0010  | OpReturn
+`
+	if out.String() != want {
+		t.Errorf("WriteDisassembly() =\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestWriteDisassemblyNilJumps(t *testing.T) {
+	function := compileSource(t, "var e;\nprint e?.message ?? 0;", false)
+	var out strings.Builder
+	WriteDisassembly(&out, Disassemble(function))
+	want := `== script ==
+0000    1 OpNil
+0001  | OpDefineGlobal      0 'e'
+0004    2 OpGetGlobal         0 'e'
+0007  | OpJumpNil           7 -> 12
+0010  | OpGetProperty       0 'message'
+0012  | OpJumpNil          12 -> 18
+0015  | OpJump             15 -> 21
+0018  | OpPop
+0019  | OpConstant          1 '0'
+0021  | OpPrint
+0022  | OpNil
+0023  | OpReturn
+`
+	if out.String() != want {
+		t.Errorf("WriteDisassembly() =\n%s\nwant:\n%s", out.String(), want)
+	}
+}
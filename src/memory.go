@@ -1,12 +1,9 @@
 package src
 
 import (
-	"log"
-	"reflect"
+	"unsafe"
 )
 
-var logger = log.Default()
-
 // GrowCapacity returns the new capacity after growing the old capacity.
 //
 // oldcap - the old capacity (int)
@@ -19,83 +16,56 @@ func GrowCapacity(oldcap int) int {
 	return oldcap * 2
 }
 
-// GrowArrayChunks is a Go function that takes in a code slice of uint8, along with the oldcap and newcap as integers.
-//
-// It returns a new code slice of the same type ([]uint8).
-func GrowArrayChunks(code []uint8, oldcap, newcap int) []uint8 {
-	return Reallocate(code, oldcap, newcap).([]uint8)
-}
-
-// GrowArrayValueArray returns a new array of Values with a larger capacity.
+// GrowArray returns a copy of array resized from oldcap to newcap elements.
 //
 // Parameters:
-// - valarray: The original array of Values.
-// - oldcap: The old capacity of the array.
-// - newcap: The new capacity of the array.
-//
-// Return type:
-// - []Value: The new array of Values.
-func GrowArrayValueArray(valarray []Value, oldcap, newcap int) []Value {
-	return Reallocate(valarray, oldcap, newcap).([]Value)
-}
-
-// GrowArrayLines returns a new slice with the same elements as the original slice, but with a larger capacity.
-//
-// It takes in three parameters:
-// - lines: a slice of integers representing the original array
-// - oldcap: an integer representing the old capacity of the array
-// - newcap: an integer representing the new capacity of the array
+// - array: the slice currently backing the dynamic array.
+// - oldcap: the old capacity of the array.
+// - newcap: the new capacity of the array.
 //
-// It returns a new slice of integers with the same elements as the original slice, but with a larger capacity.
-func GrowArrayLines(lines []int, oldcap, newcap int) []int {
-	return Reallocate(lines, oldcap, newcap).([]int)
-}
-
-// GrowArrayEntries returns a new slice of Entry with a larger capacity.
-//
-// The function takes in the following parameters:
-// - entries: a slice of Entry that contains the current entries.
-// - oldcap: an integer that represents the old capacity of the slice.
-// - newcap: an integer that represents the new capacity of the slice.
-//
-// The function returns a new slice of Entry with the updated capacity.
-func GrowArrayEntries(entries []Entry, oldcap, newcap int) []Entry {
-	return Reallocate(entries, oldcap, newcap).([]Entry)
+// Returns the resized slice, with the first oldcap elements preserved.
+func GrowArray[T any](array []T, oldcap, newcap int) []T {
+	return Reallocate(array, oldcap, newcap)
 }
 
 // FreeArray releases the memory occupied by the given array.
 //
 // The function takes two parameters:
-// - `array` of type `any`, which is the array to be freed.
+// - `array`, which is the array to be freed.
 // - `cap` of type `int`, which is the capacity of the array.
 //
 // The function does not return anything.
-func FreeArray(array any, cap int) {
+func FreeArray[T any](array []T, cap int) {
 	Reallocate(array, cap, 0)
 }
 
-// Reallocate reallocates the memory of a pointer to a new size.
+// bytesAllocated tracks how many bytes the dynamic arrays managed through
+// Reallocate currently hold, so a collector can decide when to run.
+var bytesAllocated int
+
+// Reallocate resizes array from oldSize to newSize elements.
 //
 // It takes in three parameters:
-// - pointer: the pointer to reallocate the memory for.
-// - oldSize: the current size of the memory block.
-// - newSize: the new size of the memory block.
+// - array: the slice to reallocate.
+// - oldSize: the current number of elements of the array.
+// - newSize: the new number of elements of the array.
 //
-// It returns an interface{} which is the reallocated pointer.
-func Reallocate(pointer interface{}, oldSize, newSize int) interface{} {
-	oldptrvalue := reflect.ValueOf(pointer)
+// It returns the reallocated slice, or nil when newSize is 0. The change in
+// size is recorded in bytesAllocated.
+func Reallocate[T any](array []T, oldSize, newSize int) []T {
+	var zero T
+	bytesAllocated += (newSize - oldSize) * int(unsafe.Sizeof(zero))
 	if newSize == 0 {
-		return reflect.Zero(reflect.TypeOf(oldptrvalue)).Interface()
-	}
-	newptrvalue := reflect.MakeSlice(oldptrvalue.Type(), newSize, newSize)
-	if newptrvalue.IsNil() {
-		logger.Fatalln("Realloc eror")
+		return nil
 	}
-	//fmt.Println("Growing Array")
-	reflect.Copy(newptrvalue, oldptrvalue)
-
-	return newptrvalue.Interface()
+	newArray := make([]T, newSize)
+	copy(newArray, array)
+	return newArray
+}
 
+// BytesAllocated returns the number of bytes currently held by dynamic arrays.
+func BytesAllocated() int {
+	return bytesAllocated
 }
 
 // FreeObjects frees all objects in the linked list starting from the given object.
@@ -0,0 +1,356 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// module is a script compiled to be imported.
+type module struct {
+	name     string         // The namespace of the module's globals, unique in the VM.
+	path     string         // The absolute path of the module's file.
+	function *ObjFunction   // The compiled top level of the module.
+	exports  map[string]int // The global slot of each name the module declares at top level.
+}
+
+// imports holds the names a file has imported, and the names it declared at
+// top level itself, which no import may rebind.
+type imports struct {
+	names    map[string]int     // The global slot of each name imported without 'as'.
+	modules  map[string]*module // The module each name given after 'as' stands for.
+	declared map[string]bool    // The names the file declared at top level.
+}
+
+func newImports() *imports {
+	return &imports{names: make(map[string]int), modules: make(map[string]*module), declared: make(map[string]bool)}
+}
+
+// unit is a file being compiled, the script or a module it imports.
+type unit struct {
+	path     string   // The absolute path of the file, or "" for a script not read from a file.
+	dir      string   // The directory the file's imports resolve against.
+	module   *module  // The module being compiled, or nil for the script.
+	importer *unit    // The file whose import is being compiled, or nil for the script.
+	imports  *imports // The names the file has imported.
+}
+
+// compiling is the file being compiled.
+var compiling *unit
+
+// scriptUnit returns the unit of the script at path, which shares the VM's
+// imports so that a REPL line can use what an earlier line imported.
+func scriptUnit(path string) *unit {
+	script := &unit{dir: filepath.Dir(path), imports: vm.imports}
+	if path != "" {
+		script.path, _ = filepath.Abs(path)
+	}
+	return script
+}
+
+/*
+importDeclaration compiles an import, whose 'import' was just consumed:
+
+	import "path/to/lib.clox";
+	import "lib" as name;
+
+The first form makes the globals the module declares at top level usable by
+their own names, the second as name.member. Either way the module's top level
+runs when the import is first reached and never again.
+*/
+func importDeclaration() {
+	if current.funcType != TypeScript || current.scopeDepth > 0 {
+		Error("Can only import at top level.")
+	}
+	consume(globals.TokenSTRING, "Expect module path after 'import'.")
+	pathToken := parser.Previous
+	var alias *Token
+	if check(globals.TokenIDENTIFIER) && parser.Current.Text == "as" {
+		advance()
+		consume(globals.TokenIDENTIFIER, "Expect module name after 'as'.")
+		name := parser.Previous
+		alias = &name
+	}
+	consume(globals.TokenSEMICOLON, "Expect ';' after import.")
+	if parser.PanicMode {
+		return
+	}
+
+	path := pathToken.Text
+	module := loadModule(&pathToken, path[1:len(path)-1])
+	if module == nil {
+		return
+	}
+	if alias != nil {
+		name := alias.Text
+		if other, ok := compiling.imports.modules[name]; ok && other != module {
+			errorAt(alias, fmt.Sprintf("Already imported a module as '%s'.", name))
+			return
+		}
+		if compiling.imports.declared[name] {
+			errorAt(alias, fmt.Sprintf("'%s' is already declared in this file.", name))
+			return
+		}
+		compiling.imports.modules[name] = module
+	} else {
+		for name, slot := range module.exports {
+			if other, ok := compiling.imports.names[name]; ok && other != slot {
+				errorAt(&pathToken, fmt.Sprintf("'%s' is already imported from another module.", name))
+				return
+			}
+			if compiling.imports.declared[name] {
+				errorAt(&pathToken, fmt.Sprintf("'%s' is already declared in this file.", name))
+				return
+			}
+		}
+		for name, slot := range module.exports {
+			compiling.imports.names[name] = slot
+		}
+	}
+	emityBytes(uint8(globals.OpImport), moduleConstant(module))
+	emitByte(uint8(globals.OpPop))
+}
+
+// moduleConstant returns the constant of the current chunk that holds the top
+// level of module, adding it the first time the chunk imports the module, so
+// that importing it again doesn't store its code twice.
+func moduleConstant(module *module) uint8 {
+	constants := &currentChunk().Constants
+	for i := 0; i < constants.Count; i++ {
+		if IsFunction(constants.Values[i]) && AsFunction(constants.Values[i]) == module.function {
+			return uint8(i)
+		}
+	}
+	return makeConstant(ObjVal(module.function))
+}
+
+// loadModule returns the module at path, compiling it the first time it is
+// imported. It reports an error at token and returns nil if the module can't
+// be found or read, is part of an import cycle or fails to compile.
+func loadModule(token *Token, path string) *module {
+	module, err := openModule(path)
+	if err == errModuleFailed {
+		parser.HadError = true
+	} else if err != nil {
+		errorAt(token, err.Error())
+	}
+	return module
+}
+
+// errModuleFailed is the error of a module that failed to compile, whose own
+// errors have been printed.
+var errModuleFailed = errors.New("the module has compile errors")
+
+// openModule returns the module an import of path in the file being compiled
+// names, compiling it the first time. The error says why it can't.
+func openModule(path string) (*module, error) {
+	file, ok := findModule(path)
+	if !ok {
+		return nil, fmt.Errorf("Can't find module '%s'.", path)
+	}
+	if module, ok := vm.modules[file]; ok {
+		return module, nil
+	}
+	for u := compiling; u != nil; u = u.importer {
+		if u.path == file {
+			return nil, errors.New("Import cycle: " + importChain(file) + ".")
+		}
+	}
+	source, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read module '%s': %v", path, err)
+	}
+	defer source.Close()
+
+	module := &module{name: moduleName(file), path: file, exports: make(map[string]int)}
+	ok, err = compileModule(module, source)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read module '%s': %v", path, err)
+	}
+	if !ok {
+		return nil, errModuleFailed
+	}
+	vm.modules[file] = module
+	return module, nil
+}
+
+/*
+Module is a module loaded for an import in code generated outside this
+package, as package ast does from a syntax tree.
+*/
+type Module struct {
+	module *module
+}
+
+/*
+LoadModule loads the module that an import of path in the script at from
+names, as CompileFile does for the imports of the script. It compiles the
+module and the modules it imports the first time the VM loads them, printing
+their compile errors to the VM's output.
+
+Parameters:
+- from: the path of the importing script, or "" to resolve path against the
+working directory.
+- path: the path the import names.
+
+Returns:
+- *Module: the module.
+- error: non-nil if the module can't be found or read, is part of an import
+cycle or fails to compile.
+*/
+func LoadModule(from, path string) (*Module, error) {
+	saved := compiling
+	defer func() { compiling = saved }()
+	compiling = scriptUnit(from)
+	module, err := openModule(path)
+	if err == errModuleFailed {
+		return nil, fmt.Errorf("Module '%s' has compile errors.", path)
+	} else if err != nil {
+		return nil, err
+	}
+	return &Module{module: module}, nil
+}
+
+// Function returns the top level of the module, the constant of OpImport.
+func (m *Module) Function() *ObjFunction {
+	return m.module.function
+}
+
+// Members returns the global slot of each name the module declares at top level.
+func (m *Module) Members() map[string]int {
+	return maps.Clone(m.module.exports)
+}
+
+// findModule returns the absolute path of the file an import of path names.
+// A relative path is looked up next to the importing file and then in each
+// directory of globals.MODULE_PATH, with the .clox extension added if it has none.
+func findModule(path string) (string, bool) {
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = []string{filepath.Join(compiling.dir, path)}
+		for _, dir := range globals.MODULE_PATH {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+	for _, candidate := range candidates {
+		names := []string{candidate}
+		if filepath.Ext(candidate) == "" {
+			names = append(names, candidate+".clox")
+		}
+		for _, name := range names {
+			if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+				if abs, err := filepath.Abs(name); err == nil {
+					return abs, true
+				}
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// importChain describes the cycle of imports that leads back to file.
+func importChain(file string) string {
+	chain := []string{filepath.Base(file)}
+	for u := compiling; u != nil; u = u.importer {
+		chain = append(chain, filepath.Base(u.path))
+		if u.path == file {
+			break
+		}
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return strings.Join(chain, " -> ")
+}
+
+// moduleName returns a namespace for the module in file that no other module
+// of the VM uses: the file name without its extension, numbered if need be.
+func moduleName(file string) string {
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	name := base
+	for n := 2; ; n++ {
+		taken := false
+		for _, other := range vm.modules {
+			if other.name == name {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return name
+		}
+		name = base + strconv.Itoa(n)
+	}
+}
+
+// compileModule compiles the source of module, read from source as it goes,
+// in place of the file being compiled, which it restores afterwards. It
+// reports whether it succeeded, and the error reading source if any.
+func compileModule(module *module, source io.Reader) (bool, error) {
+	savedScanner, savedParser, savedCurrent := scanner, parser, current
+	savedInfix, savedUnit, savedAnalysis := infixStart, compiling, analysis
+	defer func() {
+		scanner, parser, current = savedScanner, savedParser, savedCurrent
+		infixStart, compiling, analysis = savedInfix, savedUnit, savedAnalysis
+	}()
+
+	current = nil
+	analysis = nil // Only the file being analyzed is.
+	compiling = &unit{path: module.path, dir: filepath.Dir(module.path), module: module, importer: savedUnit, imports: newImports()}
+	scanner.InitReader(source)
+	parser = Parser{}
+	var compiler Compiler
+	InitCompiler(&compiler, TypeScript)
+	compiler.function.name = internString([]byte(module.name))
+	advance()
+	for !match(globals.TokenEOF) {
+		declaration()
+	}
+	module.function = endCompiler()
+	return !parser.HadError, scanner.Err()
+}
+
+// importedVariable resolves name if it is an imported name or a module given
+// a name with 'as', followed by '.' and a member. It returns the global slot
+// the name refers to and whether it was.
+func importedVariable(name *Token) (int, bool) {
+	text := name.Text
+	module, isModule := compiling.imports.modules[text]
+	if !isModule {
+		slot, ok := compiling.imports.names[text]
+		return slot, ok
+	}
+	consume(globals.TokenDOT, "Expect '.' after module name.")
+	consume(globals.TokenIDENTIFIER, "Expect member name after '.'.")
+	member := parser.Previous.Text
+	slot, ok := module.exports[member]
+	if !ok {
+		Error(fmt.Sprintf("Module '%s' has no member '%s'.", text, member))
+	}
+	return slot, true
+}
+
+// isImported reports whether name was imported into the file being compiled.
+func isImported(name string) bool {
+	_, isName := compiling.imports.names[name]
+	_, isModule := compiling.imports.modules[name]
+	return isName || isModule
+}
+
+// globalName returns the name of the global a top-level name of the file
+// being compiled stands for: the name itself in the script and the name in
+// the module's namespace in a module.
+func globalName(name string) string {
+	if compiling == nil || compiling.module == nil {
+		return name
+	}
+	return compiling.module.name + "." + name
+}
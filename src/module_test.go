@@ -0,0 +1,335 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// writeModules writes each source to a file of the given name in a new
+// temporary directory and returns the directory.
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// interpretFile runs the script at path in a fresh VM and returns its result and output.
+func interpretFile(t *testing.T, path string) (InterpretResult, string) {
+	t.Helper()
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitVM()
+	defer FreeVM()
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+	result := InterpretFile(path, string(source))
+	return result, out.String()
+}
+
+const counterModule = `
+var count = 0;
+fun inc() {
+  count = count + 1;
+  return count;
+}
+print "loaded";
+`
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		want   string
+		result InterpretResult
+	}{
+		{
+			"runs once",
+			map[string]string{
+				"lib.clox":  counterModule,
+				"main.clox": "import \"lib\";\nimport \"lib.clox\" as l;\nprint inc();\nprint l.inc();\nprint count;\n",
+			},
+			"loaded\n1\n2\n2\n", InterpretOk,
+		},
+		{
+			"own namespace",
+			map[string]string{
+				"lib.clox":  counterModule,
+				"main.clox": "import \"lib\" as l;\nvar count = 10;\nl.count = 5;\nprint l.inc();\nprint count;\n",
+			},
+			"loaded\n6\n10\n", InterpretOk,
+		},
+		{
+			"compound assignment on members",
+			map[string]string{
+				"lib.clox":  "var count = 0;",
+				"main.clox": "import \"lib\" as l;\nl.count += 5;\nprint l.count++;\nprint ++l.count;\nl.count -= 1;\nprint l.count--;\nl.count *= 3;\nl.count %= 4;\nprint l.count;\n",
+			},
+			"5\n7\n6\n3\n", InterpretOk,
+		},
+		{
+			"relative to the importer",
+			map[string]string{
+				"util/lib.clox":  counterModule,
+				"util/wrap.clox": "import \"lib\";\nfun twice() { inc(); return inc(); }\n",
+				"main.clox":      "import \"util/wrap\";\nprint twice();\n",
+			},
+			"loaded\n2\n", InterpretOk,
+		},
+		{
+			"same base name",
+			map[string]string{
+				"a/lib.clox": "var x = \"a\";",
+				"b/lib.clox": "var x = \"b\";",
+				"main.clox":  "import \"a/lib\" as a;\nimport \"b/lib\" as b;\nprint a.x + b.x;\n",
+			},
+			"ab\n", InterpretOk,
+		},
+		{
+			"missing",
+			map[string]string{"main.clox": "import \"nowhere\";"},
+			"Error [line 1], at '\"nowhere\"': Can't find module 'nowhere'.\n", InterpretCompileError,
+		},
+		{
+			"cycle",
+			map[string]string{
+				"a.clox":    "import \"b\";",
+				"b.clox":    "import \"a\";",
+				"main.clox": "import \"a\";",
+			},
+			"Error [line 1 of b.clox], at '\"a\"': Import cycle: a.clox -> b.clox -> a.clox.\n", InterpretCompileError,
+		},
+		{
+			"cycle through the script",
+			map[string]string{
+				"b.clox":    "import \"main\";",
+				"main.clox": "import \"b\";",
+			},
+			"Error [line 1 of b.clox], at '\"main\"': Import cycle: main.clox -> b.clox -> main.clox.\n", InterpretCompileError,
+		},
+		{
+			"error in the module",
+			map[string]string{
+				"lib.clox":  "var x = 1;\nprint x +;\n",
+				"main.clox": "import \"lib\";",
+			},
+			"Error [line 2 of lib.clox], at ';': Expect expression\n", InterpretCompileError,
+		},
+		{
+			"no member",
+			map[string]string{
+				"lib.clox":  counterModule,
+				"main.clox": "import \"lib\" as l;\nprint l.dec();\n",
+			},
+			"Error [line 2], at 'dec': Module 'l' has no member 'dec'.\n", InterpretCompileError,
+		},
+		{
+			"module without a member",
+			map[string]string{
+				"lib.clox":  counterModule,
+				"main.clox": "import \"lib\" as l;\nprint l;\n",
+			},
+			"Error [line 2], at ';': Expect '.' after module name.\n", InterpretCompileError,
+		},
+		{
+			"redeclared",
+			map[string]string{
+				"lib.clox":  counterModule,
+				"main.clox": "import \"lib\";\nfun inc() {}\n",
+			},
+			"Error [line 2], at 'inc': Can't redeclare an imported name.\n", InterpretCompileError,
+		},
+		{
+			"declared before",
+			map[string]string{
+				"lib.clox":  counterModule,
+				"main.clox": "var count = 100;\nimport \"lib\";\nprint count;\n",
+			},
+			"Error [line 2], at '\"lib\"': 'count' is already declared in this file.\n", InterpretCompileError,
+		},
+		{
+			"alias declared before",
+			map[string]string{
+				"lib.clox":  counterModule,
+				"main.clox": "fun l() {}\nimport \"lib\" as l;\n",
+			},
+			"Error [line 2], at 'l': 'l' is already declared in this file.\n", InterpretCompileError,
+		},
+		{
+			"not at top level",
+			map[string]string{
+				"lib.clox":  counterModule,
+				"main.clox": "{\n  import \"lib\";\n}\n",
+			},
+			"Error [line 2], at 'import': Can only import at top level.\n", InterpretCompileError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModules(t, tt.files)
+			result, got := interpretFile(t, filepath.Join(dir, "main.clox"))
+			if result == InterpretCompileError {
+				got, _, _ = strings.Cut(got, "\n")
+				got += "\n" // Only the error's header, not its snippet.
+			}
+			if result != tt.result || got != tt.want {
+				t.Errorf("got %v with output\n%s\nwant %v with output\n%s", result, got, tt.result, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportFromModulePath(t *testing.T) {
+	defer func(saved []string) { globals.MODULE_PATH = saved }(globals.MODULE_PATH)
+	library := writeModules(t, map[string]string{"lib.clox": counterModule})
+	globals.MODULE_PATH = []string{filepath.Join(library, "missing"), library}
+
+	dir := writeModules(t, map[string]string{"main.clox": "import \"lib\";\nprint inc();\n"})
+	if result, got := interpretFile(t, filepath.Join(dir, "main.clox")); result != InterpretOk || got != "loaded\n1\n" {
+		t.Errorf("got %v with output %q", result, got)
+	}
+}
+
+func TestImportManyGlobals(t *testing.T) {
+	// 400 globals in all: more than a byte can number.
+	files := make(map[string]string)
+	var main strings.Builder
+	for _, name := range []string{"a", "b", "c"} {
+		var module strings.Builder
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(&module, "var %s%d = %d;\n", name, i, i)
+		}
+		files[name+".clox"] = module.String()
+		fmt.Fprintf(&main, "import \"%s\" as %s;\n", name, name)
+	}
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&main, "var m%d = %d;\n", i, i)
+	}
+	main.WriteString("print a.a99 + b.b1 + c.c50 + m98;\n")
+	files["main.clox"] = main.String()
+	path := filepath.Join(writeModules(t, files), "main.clox")
+
+	defer func(saved bool) { globals.REGISTER_VM = saved }(globals.REGISTER_VM)
+	for _, registers := range []bool{false, true} {
+		globals.REGISTER_VM = registers
+		if result, got := interpretFile(t, path); result != InterpretOk || got != "248\n" {
+			t.Errorf("registers=%v: got %v with output %q, want \"248\\n\"", registers, result, got)
+		}
+	}
+}
+
+func TestImportSharesConstant(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib.clox":  counterModule,
+		"main.clox": "import \"lib\";\nimport \"lib\" as l;\nimport \"lib.clox\";\n",
+	})
+	path := filepath.Join(dir, "main.clox")
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitVM()
+	defer FreeVM()
+	var chunk Chunk
+	InitChunk(&chunk)
+	function := CompileFile(path, string(source), &chunk)
+	if function == nil {
+		t.Fatal("CompileFile() failed")
+	}
+	var names []string
+	for _, listing := range Disassemble(function) {
+		names = append(names, listing.Name)
+	}
+	if want := []string{"script", "lib", "inc"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("functions = %v, want %v", names, want)
+	}
+}
+
+func TestImportPersistsAcrossLines(t *testing.T) {
+	dir := writeModules(t, map[string]string{"lib.clox": counterModule})
+	InitVM()
+	defer FreeVM()
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+
+	for _, line := range []string{"import \"lib\";", "print inc();", "import \"lib\" as l;", "print l.inc();"} {
+		if result := InterpretFile(filepath.Join(dir, "repl"), line); result != InterpretOk {
+			t.Fatalf("InterpretFile(%q) = %v, output %q", line, result, out.String())
+		}
+	}
+	if got, want := out.String(), "loaded\n1\n2\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestImportBackends(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib.clox":  counterModule,
+		"main.clox": "import \"lib\" as l;\nimport \"lib\";\nfor (var i = 0; i < 3; i = i + 1) print l.inc() + inc();\n",
+	})
+	path := filepath.Join(dir, "main.clox")
+	_, want := interpretFile(t, path)
+	if !strings.HasPrefix(want, "loaded\n3\n") {
+		t.Fatalf("output = %q", want)
+	}
+
+	defer func(optimize, registers bool) {
+		globals.OPTIMIZE_CODE, globals.REGISTER_VM = optimize, registers
+	}(globals.OPTIMIZE_CODE, globals.REGISTER_VM)
+	for _, registers := range []bool{false, true} {
+		for _, optimize := range []bool{false, true} {
+			globals.OPTIMIZE_CODE, globals.REGISTER_VM = optimize, registers
+			if _, got := interpretFile(t, path); got != want {
+				t.Errorf("output (optimize=%v, registers=%v) = %q, want %q", optimize, registers, got, want)
+			}
+		}
+	}
+	globals.OPTIMIZE_CODE, globals.REGISTER_VM = false, false
+
+	// Bytecode carries the modules it imports.
+	source, _ := os.ReadFile(path)
+	InitVM()
+	var chunk Chunk
+	InitChunk(&chunk)
+	function := CompileFile(path, string(source), &chunk)
+	var data bytes.Buffer
+	if err := WriteBytecode(&data, function); err != nil {
+		t.Fatalf("WriteBytecode() error = %v", err)
+	}
+	InitVM()
+	defer FreeVM()
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+	Interpret("var count = 100;")
+	loaded, err := ReadBytecode(&data)
+	if err != nil {
+		t.Fatalf("ReadBytecode() error = %v", err)
+	}
+	if err := VerifyChunk(&loaded.chunk, 0); err != nil {
+		t.Errorf("VerifyChunk() error = %v", err)
+	}
+	InterpretFunction(loaded)
+	if got := out.String(); got != want {
+		t.Errorf("output from bytecode = %q, want %q", got, want)
+	}
+}
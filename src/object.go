@@ -2,6 +2,7 @@ package src
 
 import (
 	"bytes"
+	"unsafe"
 )
 
 type ObjType int
@@ -9,6 +10,8 @@ type ObjType int
 const (
 	ObjStringType ObjType = iota // The type of the string object.
 	ObjFunctionType
+	ObjErrorType
+	ObjListType
 )
 
 // Obj represents an object in the code.
@@ -18,14 +21,48 @@ type Obj struct {
 }
 
 // ObjFunction represents a function object in the code.
+//
+// obj must stay the first field, see Value.
 type ObjFunction struct {
+	obj       Obj
+	arity     int  // The number of parameters, a rest parameter included.
+	minArity  int  // The number of parameters without a default value.
+	variadic  bool // Whether the last parameter collects the extra arguments in a list.
+	chunk     Chunk
+	name      *ObjectString
+	registers *RegisterChunk // The register code, set when compiled for the register VM.
+	locals    []LocalInfo    // The local variables, for debuggers; nil once the code is optimized.
+	path      string         // The absolute path of the file the function is in, or "".
+}
+
+// LocalInfo records where a local variable lives, for debuggers.
+type LocalInfo struct {
+	Name  string // The variable name.
+	Slot  int    // The frame slot holding the variable.
+	Start int    // The offset of the first instruction at which the variable holds its value.
+	End   int    // The offset of the instruction that drops it, or the chunk length.
+}
+
+// ObjError is the value a runtime error throws.
+//
+// obj must stay the first field, see Value.
+type ObjError struct {
+	obj     Obj
+	message *ObjectString // The error message, as an uncaught error prints it.
+	trace   *ObjectString // The call frames active where the error was raised, innermost first, one per line.
+}
+
+// ObjList is the list a rest parameter collects the extra arguments of a call in.
+//
+// obj must stay the first field, see Value.
+type ObjList struct {
 	obj   Obj
-	arity int
-	chunk Chunk
-	name  *ObjectString
+	items []Value
 }
 
 // ObjectString represents a string object in the code.
+//
+// Obj must stay the first field, see Value.
 type ObjectString struct {
 	Obj    Obj    // The object representing the string.
 	Length int    // The length of the string.
@@ -54,7 +91,7 @@ func NewFunction() *ObjFunction {
 // value Value
 // *ObjFunction
 func AsFunction(value Value) *ObjFunction {
-	return value.As.(*ObjFunction)
+	return (*ObjFunction)(unsafe.Pointer(value.obj))
 }
 
 // IsFunction checks if the given value is a function.
@@ -65,6 +102,50 @@ func IsFunction(value Value) bool {
 	return IsObjType(value, ObjFunctionType)
 }
 
+// newError returns an error object with the given message and trace.
+func newError(message, trace string) *ObjError {
+	return &ObjError{
+		obj:     allocateObject(ObjErrorType),
+		message: internString([]byte(message)),
+		trace:   internString([]byte(trace)),
+	}
+}
+
+// ErrorValue returns the Value referencing the error object.
+func ErrorValue(value *ObjError) Value {
+	return Value{Type: ValObj, obj: &value.obj}
+}
+
+// AsError returns the ObjError from the given Value.
+func AsError(value Value) *ObjError {
+	return (*ObjError)(unsafe.Pointer(value.obj))
+}
+
+// IsError checks if the given value is an error object.
+func IsError(value Value) bool {
+	return IsObjType(value, ObjErrorType)
+}
+
+// newList returns a list holding a copy of items.
+func newList(items []Value) *ObjList {
+	return &ObjList{obj: allocateObject(ObjListType), items: append([]Value(nil), items...)}
+}
+
+// ListValue returns the Value referencing the list.
+func ListValue(list *ObjList) Value {
+	return Value{Type: ValObj, obj: &list.obj}
+}
+
+// AsList returns the ObjList from the given Value.
+func AsList(value Value) *ObjList {
+	return (*ObjList)(unsafe.Pointer(value.obj))
+}
+
+// IsList checks if the given value is a list.
+func IsList(value Value) bool {
+	return IsObjType(value, ObjListType)
+}
+
 // OBJStrType returns the ObjType of the given Value.
 //
 // It takes a single parameter:
@@ -84,7 +165,7 @@ func OBJType(value Value) ObjType {
 // Returns:
 // - bool: True if the value is of the specified object type, false otherwise.
 func IsObjType(value Value, objType ObjType) bool {
-	return IsValObj(value) && AsObjString(value).Obj.Type == objType
+	return (IsValObj(value) || value.Type == ValObjStr) && AsObj(value).Type == objType
 }
 
 // IsString checks if the given value is a string.
@@ -100,7 +181,7 @@ func IsString(value Value) bool {
 // value: The Value to convert to an ObjectString.
 // Returns: A pointer to the ObjectString representation of the Value.
 func AsObjString(value Value) *ObjectString {
-	return value.As.(*ObjectString)
+	return (*ObjectString)(unsafe.Pointer(value.obj))
 }
 
 // AsCString returns a string representation of a given Value as a C string.
@@ -112,14 +193,13 @@ func AsCString(value Value) string {
 	return string(objString[:len(objString)-1])
 }
 
-// copyString is a function that creates a new ObjectString by copying a substring of a source string.
+// copyString is a function that creates a new ObjectString by copying the text of a token.
 //
-// It takes the starting index of the substring, the length of the substring, the source string, and the type of object as parameters.
+// It takes the characters to copy and the type of object as parameters.
 // It returns a pointer to the newly created ObjectString.
-func copyString(start, length int, _type ObjType) *ObjectString {
-	source := *scanner.Source
+func copyString(chars string, _type ObjType) *ObjectString {
+	length := len(chars)
 	heapChars := make([]byte, length+1)
-	chars := source[start : start+length]
 	hash := hashString([]byte(chars), length)
 	interned := tableFindString(vm.strings, []byte(chars), length, hash)
 	if interned != nil {
@@ -129,6 +209,20 @@ func copyString(start, length int, _type ObjType) *ObjectString {
 	return allocateString(heapChars, length, _type, hash)
 }
 
+// internString returns the interned string with the given characters, allocating it if needed.
+//
+// chars holds the characters without a trailing NUL byte.
+func internString(chars []byte) *ObjectString {
+	length := len(chars)
+	hash := hashString(chars, length)
+	if interned := tableFindString(vm.strings, chars, length, hash); interned != nil {
+		return interned
+	}
+	heapChars := make([]byte, length+1)
+	copy(heapChars, chars)
+	return allocateString(heapChars, length, ObjStringType, hash)
+}
+
 // tableFindString finds a string in a table.
 //
 // Parameters:
@@ -0,0 +1,53 @@
+package src
+
+import "github.com/smekuria1/goclox/globals"
+
+// instructionLength returns the size in bytes of an instruction, opcode included.
+//
+// Parameters:
+// - op: the opcode of the instruction.
+//
+// Returns:
+// - int: the instruction length, or 0 if op is not a known opcode.
+func instructionLength(op uint8) int {
+	switch globals.OpCode(op) {
+	case globals.OpReturn, globals.OpNegate, globals.OpPrint, globals.OpPop,
+		globals.OpNil, globals.OpTrue, globals.OpFalse, globals.OpEqual,
+		globals.OpGreater, globals.OpLess, globals.OpAdd, globals.OpSubtract,
+		globals.OpMultiply, globals.OpDivide, globals.OpNot,
+		globals.OpGetLocal0, globals.OpGetLocal1, globals.OpGetLocal2, globals.OpGetLocal3,
+		globals.OpEndTry, globals.OpEndFinally, globals.OpThrow, globals.OpModulo,
+		globals.OpIncrement, globals.OpDecrement:
+		return 1
+	case globals.OpConstant, globals.OpGetLocal, globals.OpSetLocal, globals.OpCall,
+		globals.OpSetLocalPop, globals.OpAddConstant, globals.OpImport,
+		globals.OpGetProperty, globals.OpArgMissing:
+		return 2
+	case globals.OpJump, globals.OpJumpFalse, globals.OpLoop, globals.OpLessJumpFalse,
+		globals.OpIncrementLocal, globals.OpTry, globals.OpTryFinally, globals.OpJumpNil,
+		globals.OpDefineGlobal, globals.OpGetGlobal, globals.OpSetGlobal, globals.OpSetGlobalPop:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// isJump reports whether op carries a 16-bit jump offset. The offset of a try
+// instruction leads to its handler.
+func isJump(op uint8) bool {
+	switch globals.OpCode(op) {
+	case globals.OpJump, globals.OpJumpFalse, globals.OpLoop, globals.OpLessJumpFalse,
+		globals.OpTry, globals.OpTryFinally, globals.OpJumpNil:
+		return true
+	}
+	return false
+}
+
+// isGlobal reports whether op carries a 16-bit global slot.
+func isGlobal(op uint8) bool {
+	switch globals.OpCode(op) {
+	case globals.OpDefineGlobal, globals.OpGetGlobal, globals.OpSetGlobal, globals.OpSetGlobalPop:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,419 @@
+package src
+
+import (
+	"math"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// instruction is a decoded bytecode instruction used by the optimizer.
+type instruction struct {
+	op       uint8 // The opcode of the instruction.
+	operand  int   // The first operand byte, or the index of the target instruction for jumps.
+	operand2 int   // The second operand byte of two-operand instructions.
+	line     int   // The source line the instruction was compiled from.
+	removed  bool  // Marks an instruction dropped by the current pass.
+}
+
+// optimizeChunk rewrites the chunk of a compiled function in place.
+//
+// It folds arithmetic, comparisons and string concatenation on constant
+// operands, drops jumps to the next instruction and code that can never be
+// reached, fuses a store followed by OpPop into a single instruction, and
+// finally drops constants no instruction refers to anymore. Rewrites never
+// cross a jump target, so every path through the chunk computes the same
+// values as before.
+//
+// Parameters:
+// - chunk: the chunk to optimize.
+func optimizeChunk(chunk *Chunk) {
+	code := decodeChunk(chunk)
+	for changed := true; changed; {
+		targets := jumpTargets(code)
+		changed = foldConstants(chunk, code, targets)
+		changed = removeDeadCode(code, targets) || changed
+		changed = fuseStores(code, targets) || changed
+		code = compact(code)
+	}
+	compactConstants(chunk, code)
+	encodeChunk(chunk, code)
+}
+
+// selectSuperinstructions replaces common instruction sequences in the chunk
+// with the specialized opcodes that do the same work in one dispatch.
+//
+// It rewrites:
+// - OpGetLocal 0-3 into OpGetLocal0..OpGetLocal3
+// - OpGetLocal n, OpConstant k, OpAdd, OpSetLocal n, OpPop into OpIncrementLocal n k
+// - OpConstant k, OpAdd into OpAddConstant k
+// - OpLess, OpJumpFalse into OpLessJumpFalse
+//
+// Like optimizeChunk, no sequence that a jump lands inside is rewritten.
+//
+// Parameters:
+// - chunk: the chunk to rewrite.
+func selectSuperinstructions(chunk *Chunk) {
+	code := decodeChunk(chunk)
+	targets := jumpTargets(code)
+	inside := func(start, end int) bool {
+		for i := start + 1; i < end; i++ {
+			if targets[i] {
+				return true
+			}
+		}
+		return false
+	}
+	is := func(i int, op globals.OpCode) bool {
+		return i < len(code) && code[i].op == uint8(op)
+	}
+
+	for i := 0; i < len(code); i++ {
+		switch {
+		case is(i, globals.OpGetLocal) && is(i+1, globals.OpConstant) && is(i+2, globals.OpAdd) &&
+			(is(i+3, globals.OpSetLocal) && is(i+4, globals.OpPop) && !inside(i, i+5) ||
+				is(i+3, globals.OpSetLocalPop) && !inside(i, i+4)) &&
+			code[i+3].operand == code[i].operand:
+			code[i] = instruction{op: uint8(globals.OpIncrementLocal), operand: code[i].operand,
+				operand2: code[i+1].operand, line: code[i].line}
+			end := i + 4
+			if is(i+3, globals.OpSetLocal) {
+				end = i + 5
+			}
+			for j := i + 1; j < end; j++ {
+				code[j].removed = true
+			}
+			i = end - 1
+		case is(i, globals.OpGetLocal) && code[i].operand < 4:
+			code[i].op = uint8(globals.OpGetLocal0) + uint8(code[i].operand)
+		case is(i, globals.OpConstant) && is(i+1, globals.OpAdd) && !inside(i, i+2):
+			code[i].op = uint8(globals.OpAddConstant)
+			code[i+1].removed = true
+			i++
+		case is(i, globals.OpLess) && is(i+1, globals.OpJumpFalse) && !inside(i, i+2):
+			code[i] = instruction{op: uint8(globals.OpLessJumpFalse), operand: code[i+1].operand, line: code[i].line}
+			code[i+1].removed = true
+			i++
+		}
+	}
+	encodeChunk(chunk, compact(code))
+}
+
+// decodeChunk splits the bytecode of a chunk into instructions.
+//
+// Jump operands are converted from relative byte offsets to the index of the
+// instruction they land on, so instructions can be added or removed without
+// breaking control flow.
+func decodeChunk(chunk *Chunk) []instruction {
+	var code []instruction
+	indexAt := make(map[int]int)
+	offsets := []int{}
+	for offset := 0; offset < chunk.Count; {
+		op := chunk.Code[offset]
+		length := max(instructionLength(op), 1)
+		ins := instruction{op: op, line: chunk.Lines[offset]}
+		switch {
+		case isJump(op), isGlobal(op):
+			ins.operand = int(uint16(chunk.Code[offset+1])<<8 | uint16(chunk.Code[offset+2]))
+		case length == 2:
+			ins.operand = int(chunk.Code[offset+1])
+		case length == 3:
+			ins.operand = int(chunk.Code[offset+1])
+			ins.operand2 = int(chunk.Code[offset+2])
+		}
+		indexAt[offset] = len(code)
+		offsets = append(offsets, offset)
+		code = append(code, ins)
+		offset += length
+	}
+	indexAt[chunk.Count] = len(code)
+
+	for i := range code {
+		if !isJump(code[i].op) {
+			continue
+		}
+		target := offsets[i] + 3 + code[i].operand
+		if code[i].op == uint8(globals.OpLoop) {
+			target = offsets[i] + 3 - code[i].operand
+		}
+		code[i].operand = indexAt[target]
+	}
+	return code
+}
+
+// encodeChunk replaces the bytecode and line table of the chunk with code.
+func encodeChunk(chunk *Chunk, code []instruction) {
+	offsets := make([]int, len(code)+1)
+	for i, ins := range code {
+		offsets[i+1] = offsets[i] + instructionLength(ins.op)
+	}
+
+	FreeArray(chunk.Code, chunk.Capacity)
+	FreeArray(chunk.Lines, chunk.Capacity)
+	chunk.Code, chunk.Lines = nil, nil
+	chunk.Count, chunk.Capacity = 0, 0
+	for i, ins := range code {
+		WriteChunk(chunk, ins.op, ins.line)
+		switch {
+		case isJump(ins.op):
+			jump := offsets[ins.operand] - offsets[i+1]
+			if ins.op == uint8(globals.OpLoop) {
+				jump = -jump
+			}
+			WriteChunk(chunk, uint8((jump>>8)&0xff), ins.line)
+			WriteChunk(chunk, uint8(jump&0xff), ins.line)
+		case isGlobal(ins.op):
+			WriteChunk(chunk, uint8((ins.operand>>8)&0xff), ins.line)
+			WriteChunk(chunk, uint8(ins.operand&0xff), ins.line)
+		case instructionLength(ins.op) == 2:
+			WriteChunk(chunk, uint8(ins.operand), ins.line)
+		case instructionLength(ins.op) == 3:
+			WriteChunk(chunk, uint8(ins.operand), ins.line)
+			WriteChunk(chunk, uint8(ins.operand2), ins.line)
+		}
+	}
+}
+
+// jumpTargets returns the set of instruction indexes some jump lands on.
+func jumpTargets(code []instruction) map[int]bool {
+	targets := make(map[int]bool)
+	for _, ins := range code {
+		if isJump(ins.op) {
+			targets[ins.operand] = true
+		}
+	}
+	return targets
+}
+
+// compact drops removed instructions and retargets jumps accordingly.
+//
+// A jump to a removed instruction lands on the next instruction that is kept.
+func compact(code []instruction) []instruction {
+	newIndex := make([]int, len(code)+1)
+	kept := code[:0:0]
+	for i, ins := range code {
+		newIndex[i] = len(kept)
+		if !ins.removed {
+			kept = append(kept, ins)
+		}
+	}
+	newIndex[len(code)] = len(kept)
+	for i := range kept {
+		if isJump(kept[i].op) {
+			kept[i].operand = newIndex[kept[i].operand]
+		}
+	}
+	return kept
+}
+
+// constantOperand returns the value an instruction pushes if it only loads a constant.
+func constantOperand(chunk *Chunk, ins instruction) (Value, bool) {
+	switch globals.OpCode(ins.op) {
+	case globals.OpConstant:
+		return chunk.Constants.Values[ins.operand], true
+	case globals.OpNil:
+		return NilValue(), true
+	case globals.OpTrue:
+		return BoolValue(true), true
+	case globals.OpFalse:
+		return BoolValue(false), true
+	}
+	return Value{}, false
+}
+
+// loadConstant returns an instruction pushing value, adding it to the constant pool if needed.
+//
+// It returns false if the constant pool is full.
+func loadConstant(chunk *Chunk, value Value, line int) (instruction, bool) {
+	switch {
+	case IsNil(value):
+		return instruction{op: uint8(globals.OpNil), line: line}, true
+	case IsBool(value) && AsBool(value):
+		return instruction{op: uint8(globals.OpTrue), line: line}, true
+	case IsBool(value):
+		return instruction{op: uint8(globals.OpFalse), line: line}, true
+	}
+	if chunk.Constants.Count > StackMax-1 {
+		return instruction{}, false
+	}
+	index := AddConstants(chunk, value)
+	return instruction{op: uint8(globals.OpConstant), operand: index, line: line}, true
+}
+
+// foldBinary evaluates a binary opcode on two constant operands.
+//
+// It returns false when the operation is not foldable or would fail at
+// runtime, leaving the error to be reported when the code runs.
+func foldBinary(op uint8, a, b Value) (Value, bool) {
+	if IsString(a) && IsString(b) && op == uint8(globals.OpAdd) {
+		return ObjStrValue(concatenate(AsObjString(a), AsObjString(b))), true
+	}
+	if op == uint8(globals.OpEqual) {
+		return BoolValue(valuesEqual(a, b)), true
+	}
+	if !IsNumber(a) || !IsNumber(b) {
+		return Value{}, false
+	}
+	x, y := AsNumber(a), AsNumber(b)
+	switch globals.OpCode(op) {
+	case globals.OpAdd:
+		return NumberValue(x + y), true
+	case globals.OpSubtract:
+		return NumberValue(x - y), true
+	case globals.OpMultiply:
+		return NumberValue(x * y), true
+	case globals.OpDivide:
+		return NumberValue(x / y), true
+	case globals.OpModulo:
+		return NumberValue(math.Mod(x, y)), true
+	case globals.OpGreater:
+		return BoolValue(x > y), true
+	case globals.OpLess:
+		return BoolValue(x < y), true
+	}
+	return Value{}, false
+}
+
+// foldUnary evaluates a unary opcode on a constant operand.
+func foldUnary(op uint8, a Value) (Value, bool) {
+	switch globals.OpCode(op) {
+	case globals.OpNot:
+		return BoolValue(isFalsey(a)), true
+	case globals.OpNegate:
+		if IsNumber(a) {
+			return NumberValue(-AsNumber(a)), true
+		}
+	}
+	return Value{}, false
+}
+
+// foldConstants replaces operations on constant operands with their result.
+//
+// It returns true if any instruction was rewritten.
+func foldConstants(chunk *Chunk, code []instruction, targets map[int]bool) bool {
+	changed := false
+	for i := 0; i < len(code); i++ {
+		a, ok := constantOperand(chunk, code[i])
+		if !ok {
+			continue
+		}
+		if i+1 < len(code) && !targets[i+1] {
+			if result, ok := foldUnary(code[i+1].op, a); ok {
+				if folded, ok := loadConstant(chunk, result, code[i].line); ok {
+					code[i] = folded
+					code[i+1].removed = true
+					changed = true
+					i++
+					continue
+				}
+			}
+			if code[i+1].op == uint8(globals.OpPop) {
+				code[i].removed = true
+				code[i+1].removed = true
+				changed = true
+				i++
+				continue
+			}
+		}
+		if i+2 >= len(code) || targets[i+1] || targets[i+2] {
+			continue
+		}
+		b, ok := constantOperand(chunk, code[i+1])
+		if !ok {
+			continue
+		}
+		if result, ok := foldBinary(code[i+2].op, a, b); ok {
+			if folded, ok := loadConstant(chunk, result, code[i].line); ok {
+				code[i] = folded
+				code[i+1].removed = true
+				code[i+2].removed = true
+				changed = true
+				i += 2
+			}
+		}
+	}
+	return changed
+}
+
+// removeDeadCode drops jumps to the next instruction and instructions that
+// follow an unconditional transfer of control without being a jump target.
+//
+// It returns true if any instruction was removed.
+func removeDeadCode(code []instruction, targets map[int]bool) bool {
+	changed := false
+	for i := 0; i < len(code); i++ {
+		if code[i].removed {
+			continue
+		}
+		switch globals.OpCode(code[i].op) {
+		case globals.OpJump:
+			if code[i].operand == i+1 {
+				code[i].removed = true
+				changed = true
+				continue
+			}
+		case globals.OpLoop, globals.OpReturn, globals.OpThrow:
+		default:
+			continue
+		}
+		for i+1 < len(code) && !targets[i+1] {
+			i++
+			code[i].removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// fuseStores turns a store immediately followed by OpPop into the popping form of the store.
+//
+// It returns true if any instruction was fused.
+func fuseStores(code []instruction, targets map[int]bool) bool {
+	changed := false
+	for i := 0; i+1 < len(code); i++ {
+		if code[i].removed || code[i+1].removed || code[i+1].op != uint8(globals.OpPop) || targets[i+1] {
+			continue
+		}
+		switch globals.OpCode(code[i].op) {
+		case globals.OpSetLocal:
+			code[i].op = uint8(globals.OpSetLocalPop)
+		case globals.OpSetGlobal:
+			code[i].op = uint8(globals.OpSetGlobalPop)
+		case globals.OpGetLocal, globals.OpGetLocal0, globals.OpGetLocal1, globals.OpGetLocal2, globals.OpGetLocal3:
+			code[i].removed = true
+		default:
+			continue
+		}
+		code[i+1].removed = true
+		changed = true
+		i++
+	}
+	return changed
+}
+
+// compactConstants drops constants that no instruction loads anymore and
+// renumbers the constant operands to match.
+func compactConstants(chunk *Chunk, code []instruction) {
+	newIndex := make([]int, chunk.Constants.Count)
+	for i := range newIndex {
+		newIndex[i] = -1
+	}
+	var constants ValueArray
+	InitValueArray(&constants)
+	remap := func(old int) int {
+		if newIndex[old] == -1 {
+			WriteValueArray(&constants, chunk.Constants.Values[old])
+			newIndex[old] = constants.Count - 1
+		}
+		return newIndex[old]
+	}
+	for i := range code {
+		switch globals.OpCode(code[i].op) {
+		case globals.OpConstant, globals.OpAddConstant, globals.OpImport, globals.OpGetProperty:
+			code[i].operand = remap(code[i].operand)
+		case globals.OpIncrementLocal:
+			code[i].operand2 = remap(code[i].operand2)
+		}
+	}
+	FreeValueArray(&chunk.Constants)
+	chunk.Constants = constants
+}
@@ -0,0 +1,141 @@
+package src
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// runSource interprets source in a fresh VM and returns everything it printed.
+func runSource(t *testing.T, source string, optimize bool) string {
+	t.Helper()
+	defer func(saved bool) { globals.OPTIMIZE_CODE = saved }(globals.OPTIMIZE_CODE)
+	globals.OPTIMIZE_CODE = optimize
+
+	InitVM()
+	defer FreeVM()
+	var out bytes.Buffer
+	SetOutput(&out)
+	Interpret(source)
+	return out.String()
+}
+
+// compileSource compiles source in a fresh VM and returns the script function.
+func compileSource(t *testing.T, source string, optimize bool) *ObjFunction {
+	t.Helper()
+	defer func(saved bool) { globals.OPTIMIZE_CODE = saved }(globals.OPTIMIZE_CODE)
+	globals.OPTIMIZE_CODE = optimize
+
+	InitVM()
+	var chunk Chunk
+	InitChunk(&chunk)
+	function := Compile(source, &chunk)
+	if function == nil {
+		t.Fatalf("Compile(%q) failed", source)
+	}
+	return function
+}
+
+func TestOptimizePreservesOutput(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.clox"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no test scripts found: %v", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := runSource(t, string(source), false)
+			got := runSource(t, string(source), true)
+			if got != want {
+				t.Errorf("optimized output differs\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSuperinstructionsPreserveOutput(t *testing.T) {
+	defer func(saved bool) { globals.SUPER_INSTRUCTIONS = saved }(globals.SUPER_INSTRUCTIONS)
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.clox"))
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			globals.SUPER_INSTRUCTIONS = false
+			want := runSource(t, string(source), false)
+			globals.SUPER_INSTRUCTIONS = true
+			for _, optimize := range []bool{false, true} {
+				if got := runSource(t, string(source), optimize); got != want {
+					t.Errorf("output with superinstructions (optimize=%v) differs\ngot:\n%s\nwant:\n%s", optimize, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectSuperinstructions(t *testing.T) {
+	chunk := &compileSource(t, "{ var i = 0; while (i < 3) i = i + 1; }", false).chunk
+	var got []globals.OpCode
+	for offset := 0; offset < chunk.Count; offset += instructionLength(chunk.Code[offset]) {
+		got = append(got, globals.OpCode(chunk.Code[offset]))
+	}
+	want := []globals.OpCode{
+		globals.OpConstant, globals.OpGetLocal1, globals.OpConstant, globals.OpLessJumpFalse,
+		globals.OpPop, globals.OpIncrementLocal, globals.OpLoop, globals.OpPop, globals.OpPop,
+		globals.OpNil, globals.OpReturn,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got opcodes %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got opcodes %v, want %v", got, want)
+		}
+	}
+}
+
+func TestOptimizeChunk(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []globals.OpCode
+	}{
+		{"folds arithmetic", "print 2 * 3 + 1;", []globals.OpCode{globals.OpConstant, globals.OpPrint, globals.OpNil, globals.OpReturn}},
+		{"folds comparisons", "print !(1 < 2);", []globals.OpCode{globals.OpFalse, globals.OpPrint, globals.OpNil, globals.OpReturn}},
+		{"folds concatenation", `print "a" + "b";`, []globals.OpCode{globals.OpConstant, globals.OpPrint, globals.OpNil, globals.OpReturn}},
+		{"drops constant statements", "1 + 2;", []globals.OpCode{globals.OpNil, globals.OpReturn}},
+		{"fuses global stores", "var a; a = 1;", []globals.OpCode{globals.OpNil, globals.OpDefineGlobal, globals.OpConstant, globals.OpSetGlobalPop, globals.OpNil, globals.OpReturn}},
+		{"fuses local stores", "{ var a; a = 1; }", []globals.OpCode{globals.OpNil, globals.OpConstant, globals.OpSetLocalPop, globals.OpPop, globals.OpNil, globals.OpReturn}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := &compileSource(t, tt.source, true).chunk
+			var got []globals.OpCode
+			for offset := 0; offset < chunk.Count; offset += instructionLength(chunk.Code[offset]) {
+				got = append(got, globals.OpCode(chunk.Code[offset]))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got opcodes %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got opcodes %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOptimizeDropsUnusedConstants(t *testing.T) {
+	chunk := &compileSource(t, "print 1 + 2 + 3 + 4;", true).chunk
+	if chunk.Constants.Count != 1 || AsNumber(chunk.Constants.Values[0]) != 10 {
+		t.Errorf("got %d constants, want only the folded 10", chunk.Constants.Count)
+	}
+}
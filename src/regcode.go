@@ -0,0 +1,495 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// RegOp is an opcode of the experimental register instruction set.
+//
+// Register operands index the slots of the running call frame, which are the
+// same slots the compiler assigns to locals in Compiler.locals. Operands
+// documented as RK hold either a register or, when at least rkConstant, the
+// constant at index operand-rkConstant in the function's constant pool.
+type RegOp uint8
+
+const (
+	RegMove         RegOp = iota // R[A] = RK(B)
+	RegLoadNil                   // R[A] = nil
+	RegLoadBool                  // R[A] = B != 0
+	RegGetGlobal                 // R[A] = globals[B]
+	RegDefineGlobal              // globals[A] = RK(B)
+	RegSetGlobal                 // globals[A] = RK(B), failing if the global is undefined
+	RegAdd                       // R[A] = RK(B) + RK(C)
+	RegSubtract                  // R[A] = RK(B) - RK(C)
+	RegMultiply                  // R[A] = RK(B) * RK(C)
+	RegDivide                    // R[A] = RK(B) / RK(C)
+	RegEqual                     // R[A] = RK(B) == RK(C)
+	RegGreater                   // R[A] = RK(B) > RK(C)
+	RegLess                      // R[A] = RK(B) < RK(C)
+	RegNegate                    // R[A] = -RK(B)
+	RegNot                       // R[A] = !RK(B)
+	RegPrint                     // print RK(A)
+	RegJump                      // pc = A
+	RegJumpFalse                 // if RK(A) is falsey, pc = B
+	RegJumpNotLess               // if !(RK(A) < RK(B)), pc = C
+	RegCall                      // R[A] = R[A](R[A+1], ..., R[A+B])
+	RegReturn                    // return RK(A)
+	RegImport                    // R[A] = the result of running module RK(B) the first time, or nil
+	RegTry                       // install a handler at pc A for frame depth B, running a finally block if C != 0
+	RegEndTry                    // remove the innermost handler
+	RegEndFinally                // end a finally block entered with completion R[A+1] of value R[A]
+	RegThrow                     // throw RK(A)
+	RegGetProperty               // R[A] = the property of RK(B) named by constant C
+	RegArgMissing                // R[A] = whether the call left parameter R[B] without an argument
+	RegModulo                    // R[A] = RK(B) % RK(C)
+	RegJumpNil                   // if RK(A) is nil, pc = B
+	RegIncrement                 // R[A] = RK(B) + 1
+	RegDecrement                 // R[A] = RK(B) - 1
+)
+
+// rkConstant is added to a constant index to mark an RK operand as a constant.
+const rkConstant = 1 << 16
+
+var regOpNames = [...]string{
+	RegMove:         "RegMove",
+	RegLoadNil:      "RegLoadNil",
+	RegLoadBool:     "RegLoadBool",
+	RegGetGlobal:    "RegGetGlobal",
+	RegDefineGlobal: "RegDefineGlobal",
+	RegSetGlobal:    "RegSetGlobal",
+	RegAdd:          "RegAdd",
+	RegSubtract:     "RegSubtract",
+	RegMultiply:     "RegMultiply",
+	RegDivide:       "RegDivide",
+	RegEqual:        "RegEqual",
+	RegGreater:      "RegGreater",
+	RegLess:         "RegLess",
+	RegNegate:       "RegNegate",
+	RegNot:          "RegNot",
+	RegPrint:        "RegPrint",
+	RegJump:         "RegJump",
+	RegJumpFalse:    "RegJumpFalse",
+	RegJumpNotLess:  "RegJumpNotLess",
+	RegCall:         "RegCall",
+	RegReturn:       "RegReturn",
+	RegImport:       "RegImport",
+	RegTry:          "RegTry",
+	RegEndTry:       "RegEndTry",
+	RegEndFinally:   "RegEndFinally",
+	RegThrow:        "RegThrow",
+	RegGetProperty:  "RegGetProperty",
+	RegArgMissing:   "RegArgMissing",
+	RegModulo:       "RegModulo",
+	RegJumpNil:      "RegJumpNil",
+	RegIncrement:    "RegIncrement",
+	RegDecrement:    "RegDecrement",
+}
+
+// String returns the name of the opcode.
+func (op RegOp) String() string {
+	if int(op) < len(regOpNames) {
+		return regOpNames[op]
+	}
+	return fmt.Sprintf("RegOp(%d)", op)
+}
+
+// RegInstr is a single three-address register instruction.
+type RegInstr struct {
+	Op      RegOp
+	A, B, C int
+}
+
+// RegisterChunk holds the register code of a function.
+//
+// It shares the constant pool of the function's stack chunk.
+type RegisterChunk struct {
+	Code      []RegInstr // The register instructions.
+	Lines     []int      // The source line of each instruction.
+	Registers int        // The number of frame slots the code uses, the callee slot included.
+}
+
+// regBinaryOps maps a stack binary opcode to its register counterpart.
+var regBinaryOps = map[globals.OpCode]RegOp{
+	globals.OpAdd:      RegAdd,
+	globals.OpSubtract: RegSubtract,
+	globals.OpMultiply: RegMultiply,
+	globals.OpDivide:   RegDivide,
+	globals.OpModulo:   RegModulo,
+	globals.OpEqual:    RegEqual,
+	globals.OpGreater:  RegGreater,
+	globals.OpLess:     RegLess,
+}
+
+// regUnaryOps maps a stack unary opcode to its register counterpart.
+var regUnaryOps = map[globals.OpCode]RegOp{
+	globals.OpNegate:    RegNegate,
+	globals.OpNot:       RegNot,
+	globals.OpIncrement: RegIncrement,
+	globals.OpDecrement: RegDecrement,
+}
+
+// regTranslator turns the stack code of one function into register code.
+//
+// Every value the stack code would push gets the frame slot at its stack depth
+// as its home register. Reads of locals and constants are not copied there
+// straight away: the slot's entry in stack holds the RK operand that produces
+// the value, and a move is only emitted when the value must live in its home
+// register, at calls, jumps, jump targets and before the source is overwritten.
+// This is what turns `a + b` over locals into a single RegAdd.
+type regTranslator struct {
+	code    []instruction  // The decoded stack code.
+	out     *RegisterChunk // The register code being emitted.
+	line    int            // The line of the stack instruction being translated.
+	stack   []int          // The RK operand of each stack slot; a slot is materialized when it holds its own index.
+	pcOf    []int          // The register pc of each stack instruction.
+	patches []regPatch     // Jump operands waiting for the register pc of their target.
+	err     error          // The first stack underflow, if the code has one.
+}
+
+// regPatch records a jump operand that refers to a stack instruction index.
+type regPatch struct {
+	pc     int // The register instruction to patch.
+	which  int // 0 for operand A, 1 for B, 2 for C.
+	target int // The stack instruction index the jump lands on.
+}
+
+// translateRegisters compiles the stack chunk of function into register code.
+//
+// Parameters:
+// - function: the function whose chunk is translated.
+//
+// Returns:
+// - *RegisterChunk: the register code.
+// - error: non-nil if the chunk uses an opcode the register backend does not support, pops more values than
+// it pushed or needs too many registers.
+func translateRegisters(function *ObjFunction) (*RegisterChunk, error) {
+	t := &regTranslator{
+		code: decodeChunk(&function.chunk),
+		out:  &RegisterChunk{},
+	}
+	for slot := 0; slot <= function.arity; slot++ {
+		t.stack = append(t.stack, slot)
+	}
+	t.out.Registers = len(t.stack)
+	t.pcOf = make([]int, len(t.code)+1)
+
+	labels, fused := t.jumpShapes()
+	loops := make(map[int]bool)
+	for _, ins := range t.code {
+		if globals.OpCode(ins.op) == globals.OpLoop {
+			loops[ins.operand] = true
+		}
+	}
+	depthAt := make(map[int]int)
+	reachable := true
+	for i := 0; i < len(t.code); i++ {
+		ins := t.code[i]
+		t.line = ins.line
+		if labels[i] {
+			if reachable {
+				t.flush()
+			} else if depth, ok := depthAt[i]; ok {
+				t.reset(depth)
+				reachable = true
+			} else if loops[i] {
+				// A loop further on jumps back here with the stack as it is.
+				reachable = true
+			}
+		}
+		t.pcOf[i] = len(t.out.Code)
+		if !reachable {
+			// Nothing runs this code, and the verifier doesn't check it, so
+			// the stack model may not fit it.
+			continue
+		}
+
+		switch op := globals.OpCode(ins.op); op {
+		case globals.OpConstant:
+			t.push(rkConstant + ins.operand)
+		case globals.OpNil:
+			t.emit(RegLoadNil, len(t.stack), 0, 0)
+			t.push(len(t.stack))
+		case globals.OpTrue, globals.OpFalse:
+			value := 0
+			if op == globals.OpTrue {
+				value = 1
+			}
+			t.emit(RegLoadBool, len(t.stack), value, 0)
+			t.push(len(t.stack))
+		case globals.OpPop:
+			t.pop()
+		case globals.OpGetLocal, globals.OpGetLocal0, globals.OpGetLocal1, globals.OpGetLocal2, globals.OpGetLocal3:
+			slot := ins.operand
+			if op != globals.OpGetLocal {
+				slot = int(op - globals.OpGetLocal0)
+			}
+			t.materialize(slot)
+			t.push(slot)
+		case globals.OpSetLocal, globals.OpSetLocalPop:
+			t.setLocal(ins.operand, t.top())
+			if op == globals.OpSetLocalPop {
+				t.pop()
+			}
+		case globals.OpIncrementLocal:
+			t.materialize(ins.operand)
+			t.setLocal(ins.operand, -1)
+			t.emit(RegAdd, ins.operand, ins.operand, rkConstant+ins.operand2)
+		case globals.OpGetGlobal:
+			t.emit(RegGetGlobal, len(t.stack), ins.operand, 0)
+			t.push(len(t.stack))
+		case globals.OpDefineGlobal:
+			t.emit(RegDefineGlobal, ins.operand, t.pop(), 0)
+		case globals.OpSetGlobal, globals.OpSetGlobalPop:
+			t.emit(RegSetGlobal, ins.operand, t.top(), 0)
+			if op == globals.OpSetGlobalPop {
+				t.pop()
+			}
+		case globals.OpAdd, globals.OpSubtract, globals.OpMultiply, globals.OpDivide,
+			globals.OpModulo, globals.OpEqual, globals.OpGreater, globals.OpLess:
+			b := t.pop()
+			a := t.pop()
+			t.emit(regBinaryOps[op], len(t.stack), a, b)
+			t.push(len(t.stack))
+		case globals.OpAddConstant:
+			a := t.pop()
+			t.emit(RegAdd, len(t.stack), a, rkConstant+ins.operand)
+			t.push(len(t.stack))
+		case globals.OpNegate, globals.OpNot, globals.OpIncrement, globals.OpDecrement:
+			regOp := regUnaryOps[op]
+			operand := t.pop()
+			t.emit(regOp, len(t.stack), operand, 0)
+			t.push(len(t.stack))
+		case globals.OpPrint:
+			t.emit(RegPrint, t.pop(), 0, 0)
+		case globals.OpJump, globals.OpLoop:
+			t.flush()
+			t.jump(RegJump, 0, ins.operand, 0, 0, 0)
+			depthAt[ins.operand] = len(t.stack)
+			reachable = false
+		case globals.OpJumpFalse, globals.OpLessJumpFalse:
+			target := ins.operand
+			if fused[i] {
+				if op == globals.OpLessJumpFalse {
+					b := t.pop()
+					a := t.pop()
+					t.flush()
+					t.jump(RegJumpNotLess, 2, target+1, a, b, 0)
+				} else {
+					condition := t.pop()
+					t.flush()
+					t.jump(RegJumpFalse, 1, target+1, condition, 0, 0)
+				}
+				depthAt[target+1] = len(t.stack)
+				i++
+				t.pcOf[i] = len(t.out.Code)
+				continue
+			}
+			if op == globals.OpLessJumpFalse {
+				b := t.pop()
+				a := t.pop()
+				t.emit(RegLess, len(t.stack), a, b)
+				t.push(len(t.stack))
+			}
+			t.flush()
+			t.jump(RegJumpFalse, 1, target, len(t.stack)-1, 0, 0)
+			depthAt[target] = len(t.stack)
+		case globals.OpJumpNil:
+			t.flush()
+			t.jump(RegJumpNil, 1, ins.operand, len(t.stack)-1, 0, 0)
+			depthAt[ins.operand] = len(t.stack)
+		case globals.OpCall:
+			t.flush()
+			callee := len(t.stack) - ins.operand - 1
+			if callee < 0 {
+				t.underflow()
+				break
+			}
+			t.emit(RegCall, callee, ins.operand, 0)
+			t.stack = t.stack[:callee]
+			t.push(callee)
+		case globals.OpImport:
+			t.flush()
+			t.emit(RegImport, len(t.stack), rkConstant+ins.operand, 0)
+			t.push(len(t.stack))
+		case globals.OpTry, globals.OpTryFinally:
+			t.flush()
+			finally, entered := 0, 1
+			if op == globals.OpTryFinally {
+				finally, entered = 1, 2
+			}
+			t.jump(RegTry, 0, ins.operand, 0, len(t.stack), finally)
+			depthAt[ins.operand] = len(t.stack) + entered
+		case globals.OpEndTry:
+			t.emit(RegEndTry, 0, 0, 0)
+		case globals.OpEndFinally:
+			t.flush()
+			t.pop()
+			t.emit(RegEndFinally, t.pop(), 0, 0)
+		case globals.OpThrow:
+			t.emit(RegThrow, t.pop(), 0, 0)
+			reachable = false
+		case globals.OpGetProperty:
+			object := t.pop()
+			t.emit(RegGetProperty, len(t.stack), object, ins.operand)
+			t.push(len(t.stack))
+		case globals.OpArgMissing:
+			t.materialize(ins.operand)
+			t.emit(RegArgMissing, len(t.stack), ins.operand, 0)
+			t.push(len(t.stack))
+		case globals.OpReturn:
+			t.emit(RegReturn, t.pop(), 0, 0)
+			reachable = false
+		default:
+			return nil, fmt.Errorf("register backend does not support opcode %d", ins.op)
+		}
+		if t.err != nil {
+			return nil, fmt.Errorf("%v at instruction %d", t.err, i)
+		}
+	}
+	t.pcOf[len(t.code)] = len(t.out.Code)
+
+	for _, patch := range t.patches {
+		ins := &t.out.Code[patch.pc]
+		target := t.pcOf[patch.target]
+		switch patch.which {
+		case 0:
+			ins.A = target
+		case 1:
+			ins.B = target
+		default:
+			ins.C = target
+		}
+	}
+	if t.out.Registers > StackMax {
+		return nil, fmt.Errorf("function needs %d registers, more than the %d a frame can hold", t.out.Registers, StackMax)
+	}
+	return t.out, nil
+}
+
+// jumpShapes finds the stack instructions that start a basic block and the
+// conditional jumps whose condition is popped on both paths.
+//
+// A fused jump consumes its condition, so neither path has to keep it in a
+// register. The jump then lands on the instruction after the target's pop.
+func (t *regTranslator) jumpShapes() (labels, fused map[int]bool) {
+	labels = jumpTargets(t.code)
+	candidates := make(map[int]bool)
+	isPop := func(i int) bool {
+		return i < len(t.code) && globals.OpCode(t.code[i].op) == globals.OpPop
+	}
+	for i, ins := range t.code {
+		op := globals.OpCode(ins.op)
+		if (op == globals.OpJumpFalse || op == globals.OpLessJumpFalse) &&
+			isPop(i+1) && !labels[i+1] && isPop(ins.operand) {
+			candidates[i] = true
+		}
+	}
+	for i := range candidates {
+		labels[t.code[i].operand+1] = true
+	}
+	fused = make(map[int]bool)
+	for i := range candidates {
+		if !labels[i+1] {
+			fused[i] = true
+		}
+	}
+	return labels, fused
+}
+
+// emit appends a register instruction tagged with the current line.
+func (t *regTranslator) emit(op RegOp, a, b, c int) {
+	t.out.Code = append(t.out.Code, RegInstr{Op: op, A: a, B: b, C: c})
+	t.out.Lines = append(t.out.Lines, t.line)
+}
+
+// jump emits a jump whose operand number which refers to stack instruction target.
+func (t *regTranslator) jump(op RegOp, which, target, a, b, c int) {
+	t.patches = append(t.patches, regPatch{pc: len(t.out.Code), which: which, target: target})
+	t.emit(op, a, b, c)
+}
+
+// push records a new stack value produced by operand.
+func (t *regTranslator) push(operand int) {
+	t.stack = append(t.stack, operand)
+	if len(t.stack) > t.out.Registers {
+		t.out.Registers = len(t.stack)
+	}
+}
+
+// pop drops the top stack value and returns its operand.
+func (t *regTranslator) pop() int {
+	operand := t.top()
+	if len(t.stack) > 0 {
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+	return operand
+}
+
+// top returns the operand of the top stack value. On an empty stack it
+// records the underflow in t.err and returns register 0.
+func (t *regTranslator) top() int {
+	if len(t.stack) == 0 {
+		t.underflow()
+		return 0
+	}
+	return t.stack[len(t.stack)-1]
+}
+
+// underflow records that the code uses more values than the stack holds.
+func (t *regTranslator) underflow() {
+	if t.err == nil {
+		t.err = errors.New("stack underflow")
+	}
+}
+
+// materialize moves the value of stack slot into its home register.
+func (t *regTranslator) materialize(slot int) {
+	if slot >= len(t.stack) {
+		t.underflow()
+		return
+	}
+	if t.stack[slot] != slot {
+		t.emit(RegMove, slot, t.stack[slot], 0)
+		t.stack[slot] = slot
+	}
+}
+
+// flush materializes every stack value, the state jumps and calls expect.
+func (t *regTranslator) flush() {
+	for slot := range t.stack {
+		t.materialize(slot)
+	}
+}
+
+// reset starts a block reached only by jumps with depth materialized values.
+func (t *regTranslator) reset(depth int) {
+	t.stack = t.stack[:0]
+	for slot := 0; slot < depth; slot++ {
+		t.stack = append(t.stack, slot)
+	}
+}
+
+// setLocal stores operand into local slot, first saving any pending read of
+// the slot's old value. A negative operand only does the saving, for
+// instructions that write the register themselves.
+func (t *regTranslator) setLocal(slot, operand int) {
+	if slot >= len(t.stack) {
+		t.underflow()
+		return
+	}
+	for other := range t.stack {
+		if other != slot && t.stack[other] == slot {
+			t.materialize(other)
+			if operand == slot {
+				operand = other
+			}
+		}
+	}
+	if operand >= 0 && operand != slot {
+		t.emit(RegMove, slot, operand, 0)
+	}
+	t.stack[slot] = slot
+}
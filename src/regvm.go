@@ -0,0 +1,231 @@
+package src
+
+import (
+	"fmt"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// rk resolves an RK operand against the registers of the running frame and
+// the constant pool of its function.
+func rk(registers, constants []Value, operand int) Value {
+	if operand >= rkConstant {
+		return constants[operand-rkConstant]
+	}
+	return registers[operand]
+}
+
+// traceRegisters prints the registers of frame and the instruction about to run.
+func (vm *VM) traceRegisters(frame *CallFrame, pc int) {
+	code := frame.function.registers
+	fmt.Printf("     ")
+	for slot := 0; slot < code.Registers; slot++ {
+		fmt.Print("[")
+		PrintValue(vm.stack[frame.slots+slot])
+		fmt.Print("]")
+	}
+	fmt.Print("\n")
+	DisassembleRegisterInstruction(code, &frame.function.chunk, pc)
+}
+
+/*
+runRegisters executes the register code of the active call frame until the
+outermost call returns or a runtime error occurs.
+
+It is the register counterpart of run and keeps the same frame, global and
+error conventions: the registers of a frame are its stack slots, starting with
+the callee, and a frame's ip is the index of its next register instruction.
+
+Returns:
+- InterpretResult: Indicates the result of the interpretation, such as success, Error, or runtime Error.
+*/
+func (vm *VM) runRegisters() InterpretResult {
+	trace := globals.DEBUG_TRACE_EXECUTION
+
+	frame := &vm.frame[vm.frameCount-1]
+	code := frame.function.registers.Code
+	constants := frame.function.chunk.Constants.Values
+	pc := frame.ip
+	registers := vm.stack[frame.slots:]
+	executed := 0
+	defer func() { vm.instructionCount += executed }()
+
+	for {
+		if trace {
+			vm.traceRegisters(frame, pc)
+		}
+
+		ins := &code[pc]
+		pc++
+		executed++
+		switch ins.Op {
+		case RegMove:
+			registers[ins.A] = rk(registers, constants, ins.B)
+		case RegLoadNil:
+			registers[ins.A] = NilValue()
+		case RegLoadBool:
+			registers[ins.A] = BoolValue(ins.B != 0)
+		case RegGetGlobal:
+			value := vm.globalValues[ins.B]
+			if IsUndefined(value) {
+				vm.fail(frame, pc, "Undefined variable", "'"+AsCString(ObjStrValue(vm.globalNames[ins.B]))+"'.")
+				goto unwound
+			}
+			registers[ins.A] = value
+		case RegDefineGlobal:
+			vm.globalValues[ins.A] = rk(registers, constants, ins.B)
+		case RegSetGlobal:
+			if IsUndefined(vm.globalValues[ins.A]) {
+				vm.fail(frame, pc, "Undefined variable", "'"+AsCString(ObjStrValue(vm.globalNames[ins.A]))+"'.")
+				goto unwound
+			}
+			vm.globalValues[ins.A] = rk(registers, constants, ins.B)
+		case RegAdd:
+			result, ok := addValues(rk(registers, constants, ins.B), rk(registers, constants, ins.C))
+			if !ok {
+				vm.fail(frame, pc, "Operands must be two numbers or two strings.")
+				goto unwound
+			}
+			registers[ins.A] = result
+		case RegSubtract, RegMultiply, RegDivide, RegModulo, RegGreater, RegLess:
+			a, b := rk(registers, constants, ins.B), rk(registers, constants, ins.C)
+			if !IsNumber(a) || !IsNumber(b) {
+				vm.fail(frame, pc, "Operands must be numbers.")
+				goto unwound
+			}
+			registers[ins.A] = numberOp(regNumberOps[ins.Op], AsNumber(a), AsNumber(b))
+		case RegEqual:
+			registers[ins.A] = BoolValue(valuesEqual(rk(registers, constants, ins.B), rk(registers, constants, ins.C)))
+		case RegNegate:
+			value := rk(registers, constants, ins.B)
+			if !IsNumber(value) {
+				vm.fail(frame, pc, "Operand must be a number.")
+				goto unwound
+			}
+			registers[ins.A] = NumberValue(-AsNumber(value))
+		case RegIncrement, RegDecrement:
+			value := rk(registers, constants, ins.B)
+			if !IsNumber(value) {
+				vm.fail(frame, pc, "Operand must be a number.")
+				goto unwound
+			}
+			registers[ins.A] = NumberValue(AsNumber(value) + step(ins.Op == RegIncrement))
+		case RegNot:
+			registers[ins.A] = BoolValue(isFalsey(rk(registers, constants, ins.B)))
+		case RegPrint:
+			FprintValue(vm.out, rk(registers, constants, ins.A))
+			fmt.Fprintf(vm.out, "\n")
+		case RegJump:
+			pc = ins.A
+		case RegJumpFalse:
+			if isFalsey(rk(registers, constants, ins.A)) {
+				pc = ins.B
+			}
+		case RegJumpNil:
+			if IsNil(rk(registers, constants, ins.A)) {
+				pc = ins.B
+			}
+		case RegJumpNotLess:
+			a, b := rk(registers, constants, ins.A), rk(registers, constants, ins.B)
+			if !IsNumber(a) || !IsNumber(b) {
+				vm.fail(frame, pc, "Operands must be numbers.")
+				goto unwound
+			}
+			if !(AsNumber(a) < AsNumber(b)) {
+				pc = ins.C
+			}
+		case RegCall:
+			callee := registers[ins.A]
+			if IsObjType(callee, ObjFunctionType) && AsFunction(callee).registers == nil {
+				vm.fail(frame, pc, "Function was not compiled for the register VM.")
+				goto unwound
+			}
+			frame.ip = pc
+			vm.stackTop = frame.slots + ins.A + ins.B + 1
+			if !callValue(callee, ins.B) {
+				goto unwound
+			}
+			goto reload
+		case RegImport:
+			module := AsFunction(rk(registers, constants, ins.B))
+			if vm.imported[module.name] {
+				registers[ins.A] = NilValue()
+				break
+			}
+			if module.registers == nil {
+				vm.fail(frame, pc, "Function was not compiled for the register VM.")
+				goto unwound
+			}
+			vm.imported[module.name] = true
+			registers[ins.A] = ObjVal(module)
+			frame.ip = pc
+			vm.stackTop = frame.slots + ins.A + 1
+			if !callValue(registers[ins.A], 0) {
+				goto unwound
+			}
+			goto reload
+		case RegTry:
+			frame.handlers = append(frame.handlers, handler{ip: ins.A, depth: ins.B, finally: ins.C != 0})
+		case RegEndTry:
+			frame.handlers = frame.handlers[:len(frame.handlers)-1]
+		case RegEndFinally:
+			if completion := registers[ins.A+1]; !IsNil(completion) {
+				frame.ip = pc
+				vm.stackTop = frame.slots + ins.A
+				if AsNumber(completion) == completionThrow {
+					vm.throw(registers[ins.A], vm.trace())
+					goto unwound
+				}
+				if vm.returnFrom(registers[ins.A]) {
+					return InterpretOk
+				}
+				goto reload
+			}
+		case RegThrow:
+			frame.ip = pc
+			vm.throw(rk(registers, constants, ins.A), vm.trace())
+			goto unwound
+		case RegGetProperty:
+			value, message := getProperty(rk(registers, constants, ins.B), AsObjString(constants[ins.C]))
+			if message != "" {
+				vm.fail(frame, pc, message)
+				goto unwound
+			}
+			registers[ins.A] = value
+		case RegArgMissing:
+			registers[ins.A] = BoolValue(IsUndefined(registers[ins.B]))
+		case RegReturn:
+			frame.ip = pc
+			if vm.returnFrom(rk(registers, constants, ins.A)) {
+				return InterpretOk
+			}
+			goto reload
+		default:
+			vm.fail(frame, pc, fmt.Sprintf("Unknown register opcode %d.", ins.Op))
+			goto unwound
+		}
+		continue
+
+		// Calls, returns and exceptions change the running frame.
+	unwound:
+		if !vm.running() {
+			return InterpretRuntimeError
+		}
+	reload:
+		frame = &vm.frame[vm.frameCount-1]
+		code = frame.function.registers.Code
+		constants = frame.function.chunk.Constants.Values
+		pc = frame.ip
+		registers = vm.stack[frame.slots:]
+	}
+}
+
+// regNumberOps maps the numeric register opcodes to the stack opcodes numberOp understands.
+var regNumberOps = [...]globals.OpCode{
+	RegSubtract: globals.OpSubtract,
+	RegMultiply: globals.OpMultiply,
+	RegDivide:   globals.OpDivide,
+	RegModulo:   globals.OpModulo,
+	RegGreater:  globals.OpGreater,
+	RegLess:     globals.OpLess,
+}
@@ -0,0 +1,84 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// runBothBackends runs source on the stack VM and on the register VM.
+func runBothBackends(t *testing.T, source string, optimize bool) (stack, registers string) {
+	t.Helper()
+	defer func(saved bool) { globals.REGISTER_VM = saved }(globals.REGISTER_VM)
+	globals.REGISTER_VM = false
+	stack = runSource(t, source, optimize)
+	globals.REGISTER_VM = true
+	registers = runSource(t, source, optimize)
+	return stack, registers
+}
+
+func TestRegisterVMMatchesStackVM(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.clox"))
+	sources := map[string]string{
+		"undefined global":  "print missing;",
+		"assign undefined":  "missing = 1;",
+		"arity":             "fun f(a) { return a; }\nprint f(1, 2);",
+		"not callable":      "var x = 1;\nx();",
+		"nested error":      "fun f() {\n  return -\"a\";\n}\nfun g() { return f(); }\ng();",
+		"assign read order": "{\n  var a = 1;\n  print a + (a = 5) + a;\n}",
+		"and or":            "{\n  var a = nil;\n  print a or 2 and 3;\n  print (a == nil) and \"yes\";\n}",
+	}
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[filepath.Base(file)] = string(source)
+	}
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			for _, optimize := range []bool{false, true} {
+				want, got := runBothBackends(t, source, optimize)
+				if got != want {
+					t.Errorf("register VM output (optimize=%v) differs\ngot:\n%s\nwant:\n%s", optimize, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslateRegisters(t *testing.T) {
+	defer func(saved bool) { globals.REGISTER_VM = saved }(globals.REGISTER_VM)
+	globals.REGISTER_VM = true
+	function := compileSource(t, "{ var a = 1; var b = 2; print a + b; }", false)
+	code := function.registers
+	if code == nil {
+		t.Fatal("script has no register code")
+	}
+	want := RegInstr{Op: RegAdd, A: 3, B: 1, C: 2}
+	found := false
+	for _, ins := range code.Code {
+		if ins == want {
+			found = true
+		}
+		if ins.Op == RegMove && (ins.B == 1 || ins.B == 2) {
+			t.Errorf("local copied with %+v, want it read in place", ins)
+		}
+	}
+	if !found {
+		t.Errorf("register code %+v has no %+v", code.Code, want)
+	}
+}
+
+func TestTranslateRegistersUnderflow(t *testing.T) {
+	InitVM()
+	defer FreeVM()
+	function := NewFunction()
+	function.chunk = *buildChunk([]globals.OpCode{globals.OpPop, globals.OpPop, globals.OpNil, globals.OpReturn})
+	if _, err := translateRegisters(function); err == nil || !strings.Contains(err.Error(), "stack underflow") {
+		t.Errorf("translateRegisters() error = %v, want a stack underflow", err)
+	}
+}
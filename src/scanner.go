@@ -2,6 +2,9 @@ package src
 
 import (
 	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/smekuria1/goclox/globals"
 )
@@ -11,7 +14,40 @@ type Scanner struct {
 	Start   int     // Start represents the start position of the scanner.
 	Current int     // Current represents the current position of the scanner.
 	Line    int     // Line represents the current line number.
-	Source  *string // Source is a pointer to the source code being scanned.
+	Column  int     // Column is the column of the current position, in runes, from 1.
+	Source  *string // Source is a pointer to the source code being scanned, or to the buffered part of it.
+	Message string  // Message describes the last error token.
+
+	// Comments makes the scanner return comments as TokenCOMMENT tokens
+	// instead of skipping them, for tools that keep them. InitScanner clears it.
+	Comments bool
+	// Whitespace makes the scanner return each run of spaces, tabs and line
+	// breaks as a TokenWHITESPACE token. InitScanner clears it.
+	Whitespace bool
+
+	startLine   int // The line of the token being scanned.
+	startColumn int // The column of the token being scanned.
+
+	reader    io.Reader // The rest of the source when scanning a reader, or nil.
+	streaming bool      // Whether the source comes from a reader.
+	base      int       // The offset in the source of the first byte of *Source.
+	err       error     // The first error reading from reader.
+	lines     []int     // The offset of the start of each line from firstLine on.
+	firstLine int       // The first line whose start is in lines.
+	tokenLine int       // The line of the last token returned.
+	keep      int       // The first line Keep asked to keep, or 0.
+	last      Position  // The end of the last token or comment.
+}
+
+// bufferSize is the number of bytes a Scanner reads from a reader at a time.
+// Its buffer only grows past this to hold a longer token or kept lines.
+const bufferSize = 4096
+
+// Position is a place in the source.
+type Position struct {
+	Offset int // The byte offset, from 0.
+	Line   int // The line, from 1.
+	Column int // The column in runes, from 1.
 }
 
 // Token represents a lexical token in the code.
@@ -19,7 +55,10 @@ type Token struct {
 	TOKENType globals.TokenType // Represents the type of the token.
 	Start     int               // Represents the starting position of the token.
 	Length    int               // Represents the length of the token.
-	Line      int               // Represents the line number where the token is found.
+	Line      int               // Represents the line number where the token ends.
+	Pos       Position          // The position of the token's first character.
+	End       Position          // The position just after the token's last character.
+	Text      string            // The source text of the token.
 }
 
 // InitScanner initializes the Scanner struct with the given source.
@@ -29,10 +68,159 @@ type Token struct {
 //
 // Return type: none.
 func (scanner *Scanner) InitScanner(source string) {
+	scanner.Comments = false
+	scanner.Whitespace = false
 	scanner.Start = 0
 	scanner.Current = 0
 	scanner.Source = &source
 	scanner.Line = 1
+	scanner.Column = 1
+	scanner.reader = nil
+	scanner.streaming = false
+	scanner.base = 0
+	scanner.err = nil
+	scanner.lines = []int{0}
+	scanner.firstLine = 1
+	scanner.tokenLine = 1
+	scanner.keep = 0
+	scanner.last = Position{Line: 1, Column: 1}
+}
+
+/*
+InitReader initializes the Scanner to scan the source read from r.
+
+The scanner reads r as it needs more of the source. It keeps buffered only
+the token being scanned and the lines from that of the last token or the one
+passed to Keep on, so a source of any size is scanned in bounded memory. Tokens carry their own text,
+and their offsets count from the start of the source, as with InitScanner. A
+read error ends the source early; Err returns it.
+
+Parameters:
+- r: the reader of the source.
+*/
+func (scanner *Scanner) InitReader(r io.Reader) {
+	scanner.InitScanner("")
+	scanner.reader = r
+	scanner.streaming = true
+}
+
+// Err returns the first error other than io.EOF that reading the source
+// returned, or nil.
+func (scanner *Scanner) Err() error {
+	return scanner.err
+}
+
+// Keep asks a scanner reading a reader to keep the text of the lines from
+// line on for LineText, besides that of the line of the last token it
+// returned, until the next call. Text before both may be dropped. The text
+// of a string being scanned all stays available.
+func (scanner *Scanner) Keep(line int) {
+	scanner.keep = line
+}
+
+/*
+LineText returns the text of a line scanned so far, without its line break,
+reading the rest of the line if it has not been read yet.
+
+Parameters:
+- line: the line, from 1.
+
+Returns:
+- string: the text of the line.
+- int: the offset in the source of the line's first byte.
+- bool: whether the text is still buffered.
+*/
+func (scanner *Scanner) LineText(line int) (string, int, bool) {
+	i := line - scanner.firstLine
+	if i < 0 || i >= len(scanner.lines) || scanner.lines[i] < scanner.base {
+		return "", 0, false
+	}
+	start := scanner.lines[i]
+	for {
+		text := (*scanner.Source)[start-scanner.base:]
+		if end := strings.IndexByte(text, '\n'); end != -1 {
+			return strings.TrimRight(text[:end], "\r"), start, true
+		}
+		if !scanner.fill(start) {
+			return strings.TrimRight(text, "\r"), start, true
+		}
+	}
+}
+
+// LastEnd returns the position just after the last token or comment scanned
+// other than whitespace, where the text of the source ends.
+func (scanner *Scanner) LastEnd() Position {
+	return scanner.last
+}
+
+// fill reads more of the source from the reader, dropping the buffered bytes
+// before from, the token being scanned and the lines to keep. It reports
+// whether it read any.
+func (scanner *Scanner) fill(from int) bool {
+	if scanner.reader == nil {
+		return false
+	}
+	first := scanner.tokenLine
+	if scanner.keep > 0 {
+		first = min(first, scanner.keep)
+	}
+	if i := first - scanner.firstLine; i > 0 && i < len(scanner.lines) {
+		scanner.lines = scanner.lines[i:]
+		scanner.firstLine = first
+	}
+	from = min(from, scanner.Start, scanner.lines[0])
+	keep := (*scanner.Source)[from-scanner.base:]
+	buf := make([]byte, max(bufferSize, 2*len(keep)))
+	copy(buf, keep)
+	n, err := 0, error(nil)
+	for n == 0 && err == nil {
+		n, err = scanner.reader.Read(buf[len(keep):])
+	}
+	if err != nil {
+		if err != io.EOF {
+			scanner.err = err
+		}
+		scanner.reader = nil
+	}
+	source := string(buf[:len(keep)+n])
+	scanner.Source = &source
+	scanner.base = from
+	return n > 0
+}
+
+// at returns the byte at offset i of the source, which must be buffered.
+func (scanner *Scanner) at(i int) byte {
+	return (*scanner.Source)[i-scanner.base]
+}
+
+/*
+Tokenize scans source to the end and returns its tokens, ending with the
+TokenEOF token. A character the scanner rejects becomes a TokenERROR token
+and scanning goes on after it.
+
+With trivia, comments and whitespace come back too, as TokenCOMMENT and
+TokenWHITESPACE tokens, so the tokens cover the whole source without gaps.
+
+Parameters:
+- source: the source to scan.
+- trivia: whether to include comments and whitespace.
+
+Returns:
+- []Token: the tokens in source order.
+*/
+func Tokenize(source string, trivia bool) []Token {
+	var scanner Scanner
+	scanner.InitScanner(source)
+	scanner.Comments = trivia
+	scanner.Whitespace = trivia
+	var tokens []Token
+	for {
+		token := scanner.ScanToken(scanner.Source)
+		tokens = append(tokens, token)
+		if token.TOKENType == globals.TokenEOF {
+			return tokens
+		}
+	}
 }
 
 // ScanToken scans the source string and returns a Token.
@@ -44,8 +232,12 @@ func (scanner *Scanner) InitScanner(source string) {
 // It returns a Token based on the current character in the source string.
 // The Token represents the type of the current character.
 func (scanner *Scanner) ScanToken(source *string) Token {
+	if scanner.Whitespace && scanner.isSpace(scanner.peek()) {
+		scanner.mark()
+		return scanner.whitespace()
+	}
 	scanner.skipWhitespace()
-	scanner.Start = scanner.Current
+	scanner.mark()
 	if scanner.isAtEnd() {
 		return makeToken(globals.TokenEOF, scanner)
 	}
@@ -70,15 +262,68 @@ func (scanner *Scanner) ScanToken(source *string) Token {
 	case ',':
 		return makeToken(globals.TokenCOMMA, scanner)
 	case '.':
+		if scanner.peek() == '.' && scanner.peekNext() == '.' {
+			scanner.advance()
+			scanner.advance()
+			return makeToken(globals.TokenELLIPSIS, scanner)
+		}
 		return makeToken(globals.TokenDOT, scanner)
 	case '-':
-		return makeToken(globals.TokenMINUS, scanner)
+		return makeToken(
+			func() globals.TokenType {
+				if scanner.match('-') {
+					return globals.TokenMINUS_MINUS
+				}
+				if scanner.match('=') {
+					return globals.TokenMINUS_EQUAL
+				}
+				return globals.TokenMINUS
+			}(), scanner)
 	case '+':
-		return makeToken(globals.TokenPLUS, scanner)
+		return makeToken(
+			func() globals.TokenType {
+				if scanner.match('+') {
+					return globals.TokenPLUS_PLUS
+				}
+				if scanner.match('=') {
+					return globals.TokenPLUS_EQUAL
+				}
+				return globals.TokenPLUS
+			}(), scanner)
 	case '/':
+		if scanner.Comments && scanner.match('/') {
+			for scanner.peek() != '\n' && !scanner.isAtEnd() {
+				scanner.advance()
+			}
+			return makeToken(globals.TokenCOMMENT, scanner)
+		}
+		if scanner.match('=') {
+			return makeToken(globals.TokenSLASH_EQUAL, scanner)
+		}
 		return makeToken(globals.TokenSLASH, scanner)
 	case '*':
+		if scanner.match('=') {
+			return makeToken(globals.TokenSTAR_EQUAL, scanner)
+		}
 		return makeToken(globals.TokenSTAR, scanner)
+	case ':':
+		return makeToken(globals.TokenCOLON, scanner)
+	case '?':
+		return makeToken(
+			func() globals.TokenType {
+				if scanner.match('?') {
+					return globals.TokenQUESTION_QUESTION
+				}
+				if scanner.match('.') {
+					return globals.TokenQUESTION_DOT
+				}
+				return globals.TokenQUESTION
+			}(), scanner)
+	case '%':
+		if scanner.match('=') {
+			return makeToken(globals.TokenPERCENT_EQUAL, scanner)
+		}
+		return makeToken(globals.TokenPERCENT, scanner)
 	case '!':
 		return makeToken(
 			func() globals.TokenType {
@@ -93,6 +338,9 @@ func (scanner *Scanner) ScanToken(source *string) Token {
 				if scanner.match('=') {
 					return globals.TokenEQUAL_EQUAL
 				}
+				if scanner.match('>') {
+					return globals.TokenARROW
+				}
 				return globals.TokenEQUAL
 			}(), scanner)
 	case '<':
@@ -114,12 +362,38 @@ func (scanner *Scanner) ScanToken(source *string) Token {
 	case '"':
 		return scanner.checkString()
 	default:
-		// Handle other cases or return an Error token
+		// The error token covers all the bytes of a character outside ASCII.
+		for !scanner.isAtEnd() && !utf8.RuneStart(scanner.at(scanner.Current)) {
+			scanner.advance()
+		}
 		return makeErrorToken("Unexpected character.", scanner)
 	}
 
 }
 
+// mark starts a token at the current position.
+func (scanner *Scanner) mark() {
+	scanner.Start = scanner.Current
+	scanner.startLine = scanner.Line
+	scanner.startColumn = scanner.Column
+}
+
+// isSpace reports whether c is a space, tab, carriage return or line break.
+func (scanner *Scanner) isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// whitespace scans a run of whitespace and returns it as a TokenWHITESPACE token.
+func (scanner *Scanner) whitespace() Token {
+	for scanner.isSpace(scanner.peek()) {
+		if scanner.peek() == '\n' {
+			scanner.Line++
+		}
+		scanner.advance()
+	}
+	return makeToken(globals.TokenWHITESPACE, scanner)
+}
+
 // identifier scans and returns an identifier Token.
 //
 // The identifier function scans the input string and checks if the current character is an alphabetic character or a digit. It continues scanning until it reaches a non-alphanumeric character. It then returns a Token representing the identifier.
@@ -138,16 +412,31 @@ func (scanner *Scanner) identifier() Token {
 // No parameters.
 // Returns the TokenType of the identifier.
 func (scanner *Scanner) identifierType() globals.TokenType {
-	source := *scanner.Source
-	switch source[scanner.Start] {
+	switch scanner.at(scanner.Start) {
 	case 'a':
 		return scanner.checkKeyword(1, 2, "nd", globals.TokenAND)
 	case 'c':
-		return scanner.checkKeyword(1, 4, "lass", globals.TokenCLASS)
+		if scanner.Current-scanner.Start > 1 {
+			switch scanner.at(scanner.Start + 1) {
+			case 'a':
+				return scanner.checkKeyword(2, 3, "tch", globals.TokenCATCH)
+			case 'l':
+				return scanner.checkKeyword(2, 3, "ass", globals.TokenCLASS)
+			}
+		}
+		return globals.TokenIDENTIFIER
 	case 'e':
 		return scanner.checkKeyword(1, 3, "lse", globals.TokenELSE)
 	case 'i':
-		return scanner.checkKeyword(1, 1, "f", globals.TokenIF)
+		if scanner.Current-scanner.Start > 1 {
+			switch scanner.at(scanner.Start + 1) {
+			case 'f':
+				return scanner.checkKeyword(2, 0, "", globals.TokenIF)
+			case 'm':
+				return scanner.checkKeyword(2, 4, "port", globals.TokenIMPORT)
+			}
+		}
+		return globals.TokenIDENTIFIER
 	case 'n':
 		return scanner.checkKeyword(1, 2, "il", globals.TokenNIL)
 	case 'o':
@@ -162,15 +451,37 @@ func (scanner *Scanner) identifierType() globals.TokenType {
 		return scanner.checkKeyword(1, 2, "ar", globals.TokenVAR)
 	case 'w':
 		return scanner.checkKeyword(1, 4, "hile", globals.TokenWHILE)
+	case 't':
+		if scanner.Current-scanner.Start > 2 {
+			switch scanner.at(scanner.Start + 1) {
+			case 'h':
+				switch scanner.at(scanner.Start + 2) {
+				case 'i':
+					return scanner.checkKeyword(3, 1, "s", globals.TokenTHIS)
+				case 'r':
+					return scanner.checkKeyword(3, 2, "ow", globals.TokenTHROW)
+				}
+			case 'r':
+				switch scanner.at(scanner.Start + 2) {
+				case 'u':
+					return scanner.checkKeyword(3, 1, "e", globals.TokenTRUE)
+				case 'y':
+					return scanner.checkKeyword(3, 0, "", globals.TokenTRY)
+				}
+			}
+		}
+		return globals.TokenIDENTIFIER
 	case 'f':
 		// firstCheck := scanner.checkKeyword(1, 4, "alse", globals.TokenFALSE)
 		// if firstCheck == globals.TokenIDENTIFIER {
 		// 	firstCheck = scanner.checkKeyword(1, 2, "or", globals.TokenFOR)
 		// }
 		// return firstCheck
-		switch source[scanner.Start+1] {
+		switch scanner.at(scanner.Start + 1) {
 		case 'a':
 			return scanner.checkKeyword(2, 3, "lse", globals.TokenFALSE)
+		case 'i':
+			return scanner.checkKeyword(2, 5, "nally", globals.TokenFINALLY)
 		case 'o':
 			return scanner.checkKeyword(2, 1, "r", globals.TokenFOR)
 		case 'u':
@@ -199,7 +510,7 @@ func (scanner *Scanner) identifierType() globals.TokenType {
 // Returns the token type corresponding to the keyword if the substring matches the keyword, otherwise returns globals.TokenIDENTIFIER.
 func (scanner *Scanner) checkKeyword(start, length int, rest string, tokenType globals.TokenType) globals.TokenType {
 	if scanner.Current-scanner.Start == start+length &&
-		bytes.Equal([]byte((*scanner.Source)[scanner.Start+start:scanner.Start+start+length]), []byte(rest)) {
+		bytes.Equal([]byte((*scanner.Source)[scanner.Start-scanner.base+start:scanner.Start-scanner.base+start+length]), []byte(rest)) {
 		return tokenType
 	}
 	return globals.TokenIDENTIFIER
@@ -270,27 +581,42 @@ func (scanner *Scanner) checkString() Token {
 // If the scanner has reached the end, it returns 0 or any appropriate value to indicate the end.
 func (scanner *Scanner) advance() rune {
 	if !scanner.isAtEnd() {
+		c := scanner.at(scanner.Current)
 		scanner.Current++
-		ret := *scanner.Source
-		return rune(ret[scanner.Current-1])
+		scanner.stepColumn(c)
+		return rune(c)
 	}
 	return 0 // or any appropriate value to indicate the end
 }
 
+// stepColumn moves Column past the byte c just consumed. A line break starts
+// the next line, and only the first byte of a UTF-8 sequence starts a rune.
+func (scanner *Scanner) stepColumn(c byte) {
+	switch {
+	case c == '\n':
+		scanner.Column = 1
+		scanner.lines = append(scanner.lines, scanner.Current)
+	case utf8.RuneStart(c):
+		scanner.Column++
+	}
+}
+
 // skipWhitespace skips over any whitespace characters in the input string.
 // It advances the scanner's position until a non-whitespace character is encountered.
 func (scanner *Scanner) skipWhitespace() {
 	for {
+		scanner.Start = scanner.Current // Skipped text need not stay buffered.
 		c := scanner.peek()
 		switch c {
 		case ' ', '\r', '\t':
 			scanner.advance()
 		case '/':
-			if scanner.peekNext() == '/' {
+			if scanner.peekNext() == '/' && !scanner.Comments {
 				// A comment goes until the end of the line.
 				for scanner.peek() != '\n' && !scanner.isAtEnd() {
 					scanner.advance()
 				}
+				scanner.last = Position{Offset: scanner.Current, Line: scanner.Line, Column: scanner.Column}
 			} else {
 				return
 			}
@@ -310,11 +636,10 @@ func (scanner *Scanner) skipWhitespace() {
 // and returns 0 or any appropriate value to indicate the end.
 // Otherwise, it returns the rune at the next position.
 func (scanner *Scanner) peekNext() rune {
-	if scanner.isAtEnd() || scanner.Current+1 >= len(*scanner.Source) {
+	if scanner.isAtEnd() || scanner.Current+1 >= scanner.base+len(*scanner.Source) && !scanner.fill(scanner.Start) {
 		return 0 // or any appropriate value to indicate the end
 	}
-	check := *scanner.Source
-	return rune(check[scanner.Current+1])
+	return rune(scanner.at(scanner.Current + 1))
 }
 
 // peek returns the next rune in the input without consuming it.
@@ -326,8 +651,7 @@ func (scanner *Scanner) peek() rune {
 	if scanner.isAtEnd() {
 		return 0 // or any appropriate value to indicate the end
 	}
-	check := *scanner.Source
-	return rune(check[scanner.Current])
+	return rune(scanner.at(scanner.Current))
 }
 
 // match checks if the next character in the source matches the expected rune.
@@ -338,12 +662,12 @@ func (scanner *Scanner) match(expected rune) bool {
 	if scanner.isAtEnd() {
 		return false
 	}
-	check := *scanner.Source
-	if rune(check[scanner.Current]) != expected {
+	if rune(scanner.at(scanner.Current)) != expected {
 		return false
 	}
 
 	scanner.Current++
+	scanner.stepColumn(byte(expected))
 	return true
 }
 
@@ -352,7 +676,7 @@ func (scanner *Scanner) match(expected rune) bool {
 // It returns a boolean value indicating whether the scanner's current position is greater than or equal to
 // the length of the source string.
 func (scanner *Scanner) isAtEnd() bool {
-	return scanner.Current >= len(*scanner.Source)
+	return scanner.Current >= scanner.base+len(*scanner.Source) && !scanner.fill(scanner.Start)
 }
 
 // makeToken creates a token of the given type using the provided scanner.
@@ -370,11 +694,30 @@ func makeToken(tokentype globals.TokenType, scanner *Scanner) Token {
 	token.Start = scanner.Start
 	token.Length = scanner.Current - scanner.Start
 	token.Line = scanner.Line
+	token.Pos = Position{Offset: scanner.Start, Line: scanner.startLine, Column: scanner.startColumn}
+	token.End = Position{Offset: scanner.Current, Line: scanner.Line, Column: scanner.Column}
+	token.Text = scanner.text()
+	scanner.tokenLine = token.Pos.Line
+	if tokentype != globals.TokenEOF && tokentype != globals.TokenWHITESPACE {
+		scanner.last = token.End
+	}
 	return token
 }
 
+// text returns the text of the token being scanned. Text read from a reader
+// is copied, so a token kept by the caller doesn't hold on to the buffer.
+func (scanner *Scanner) text() string {
+	text := (*scanner.Source)[scanner.Start-scanner.base : scanner.Current-scanner.base]
+	if scanner.streaming {
+		return strings.Clone(text)
+	}
+	return text
+}
+
 // makeErrorToken creates an Error token with the given message and scanner.
 //
+// The token spans the offending characters and the message is kept in scanner.Message.
+//
 // Parameters:
 //
 // - message: the Error message for the token.
@@ -387,7 +730,13 @@ func makeErrorToken(message string, scanner *Scanner) Token {
 	var token Token
 	token.TOKENType = globals.TokenERROR
 	token.Start = scanner.Start
-	token.Length = len(message)
+	token.Length = scanner.Current - scanner.Start
 	token.Line = scanner.Line
+	token.Pos = Position{Offset: scanner.Start, Line: scanner.startLine, Column: scanner.startColumn}
+	token.End = Position{Offset: scanner.Current, Line: scanner.Line, Column: scanner.Column}
+	token.Text = scanner.text()
+	scanner.tokenLine = token.Pos.Line
+	scanner.last = token.End
+	scanner.Message = message
 	return token
 }
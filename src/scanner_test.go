@@ -1,8 +1,14 @@
 package src
 
 import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/smekuria1/goclox/globals"
 )
@@ -326,3 +332,212 @@ func Test_makeErrorToken(t *testing.T) {
 		})
 	}
 }
+
+// scanned is what TestTokenize checks of a token.
+type scanned struct {
+	Kind     globals.TokenType
+	Text     string
+	Line     int
+	Pos, End Position
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		trivia bool
+		want   []scanned
+	}{
+		{"multi-line string", "print \"a\nbc\";\nx", false, []scanned{
+			{globals.TokenPRINT, "print", 1, Position{0, 1, 1}, Position{5, 1, 6}},
+			{globals.TokenSTRING, "\"a\nbc\"", 2, Position{6, 1, 7}, Position{12, 2, 4}},
+			{globals.TokenSEMICOLON, ";", 2, Position{12, 2, 4}, Position{13, 2, 5}},
+			{globals.TokenIDENTIFIER, "x", 3, Position{14, 3, 1}, Position{15, 3, 2}},
+			{globals.TokenEOF, "", 3, Position{15, 3, 2}, Position{15, 3, 2}},
+		}},
+		{"columns in runes", "\"héllo\" + 世;", false, []scanned{
+			{globals.TokenSTRING, "\"héllo\"", 1, Position{0, 1, 1}, Position{8, 1, 8}},
+			{globals.TokenPLUS, "+", 1, Position{9, 1, 9}, Position{10, 1, 10}},
+			{globals.TokenERROR, "世", 1, Position{11, 1, 11}, Position{14, 1, 12}},
+			{globals.TokenSEMICOLON, ";", 1, Position{14, 1, 12}, Position{15, 1, 13}},
+			{globals.TokenEOF, "", 1, Position{15, 1, 13}, Position{15, 1, 13}},
+		}},
+		{"unterminated string", "x \"ab\nc", false, []scanned{
+			{globals.TokenIDENTIFIER, "x", 1, Position{0, 1, 1}, Position{1, 1, 2}},
+			{globals.TokenERROR, "\"ab\nc", 2, Position{2, 1, 3}, Position{7, 2, 2}},
+			{globals.TokenEOF, "", 2, Position{7, 2, 2}, Position{7, 2, 2}},
+		}},
+		{"operators", "a+=b++%--c", false, []scanned{
+			{globals.TokenIDENTIFIER, "a", 1, Position{0, 1, 1}, Position{1, 1, 2}},
+			{globals.TokenPLUS_EQUAL, "+=", 1, Position{1, 1, 2}, Position{3, 1, 4}},
+			{globals.TokenIDENTIFIER, "b", 1, Position{3, 1, 4}, Position{4, 1, 5}},
+			{globals.TokenPLUS_PLUS, "++", 1, Position{4, 1, 5}, Position{6, 1, 7}},
+			{globals.TokenPERCENT, "%", 1, Position{6, 1, 7}, Position{7, 1, 8}},
+			{globals.TokenMINUS_MINUS, "--", 1, Position{7, 1, 8}, Position{9, 1, 10}},
+			{globals.TokenIDENTIFIER, "c", 1, Position{9, 1, 10}, Position{10, 1, 11}},
+			{globals.TokenEOF, "", 1, Position{10, 1, 11}, Position{10, 1, 11}},
+		}},
+		{"question marks", "a?b:c??d?.e", false, []scanned{
+			{globals.TokenIDENTIFIER, "a", 1, Position{0, 1, 1}, Position{1, 1, 2}},
+			{globals.TokenQUESTION, "?", 1, Position{1, 1, 2}, Position{2, 1, 3}},
+			{globals.TokenIDENTIFIER, "b", 1, Position{2, 1, 3}, Position{3, 1, 4}},
+			{globals.TokenCOLON, ":", 1, Position{3, 1, 4}, Position{4, 1, 5}},
+			{globals.TokenIDENTIFIER, "c", 1, Position{4, 1, 5}, Position{5, 1, 6}},
+			{globals.TokenQUESTION_QUESTION, "??", 1, Position{5, 1, 6}, Position{7, 1, 8}},
+			{globals.TokenIDENTIFIER, "d", 1, Position{7, 1, 8}, Position{8, 1, 9}},
+			{globals.TokenQUESTION_DOT, "?.", 1, Position{8, 1, 9}, Position{10, 1, 11}},
+			{globals.TokenIDENTIFIER, "e", 1, Position{10, 1, 11}, Position{11, 1, 12}},
+			{globals.TokenEOF, "", 1, Position{11, 1, 12}, Position{11, 1, 12}},
+		}},
+		{"trivia", "a // é\n\tb", true, []scanned{
+			{globals.TokenIDENTIFIER, "a", 1, Position{0, 1, 1}, Position{1, 1, 2}},
+			{globals.TokenWHITESPACE, " ", 1, Position{1, 1, 2}, Position{2, 1, 3}},
+			{globals.TokenCOMMENT, "// é", 1, Position{2, 1, 3}, Position{7, 1, 7}},
+			{globals.TokenWHITESPACE, "\n\t", 2, Position{7, 1, 7}, Position{9, 2, 2}},
+			{globals.TokenIDENTIFIER, "b", 2, Position{9, 2, 2}, Position{10, 2, 3}},
+			{globals.TokenEOF, "", 2, Position{10, 2, 3}, Position{10, 2, 3}},
+		}},
+		{"no trivia", "a // é\n\tb", false, []scanned{
+			{globals.TokenIDENTIFIER, "a", 1, Position{0, 1, 1}, Position{1, 1, 2}},
+			{globals.TokenIDENTIFIER, "b", 2, Position{9, 2, 2}, Position{10, 2, 3}},
+			{globals.TokenEOF, "", 2, Position{10, 2, 3}, Position{10, 2, 3}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []scanned
+			for _, token := range Tokenize(tt.source, tt.trivia) {
+				text := tt.source[token.Start : token.Start+token.Length]
+				got = append(got, scanned{token.TOKENType, text, token.Line, token.Pos, token.End})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeTriviaCoversSource(t *testing.T) {
+	source := "fun f(a) {\r\n  // ü\n  return a + \"x\ny\";\n}\n\n"
+	var text strings.Builder
+	for _, token := range Tokenize(source, true) {
+		text.WriteString(source[token.Start : token.Start+token.Length])
+	}
+	if text.String() != source {
+		t.Errorf("tokens cover %q, want %q", text.String(), source)
+	}
+}
+
+// scanReader scans r to the end with trivia, returning what TestTokenize checks.
+func scanReader(r io.Reader) ([]scanned, *Scanner) {
+	var scanner Scanner
+	scanner.InitReader(r)
+	scanner.Comments = true
+	scanner.Whitespace = true
+	var tokens []scanned
+	for {
+		token := scanner.ScanToken(scanner.Source)
+		tokens = append(tokens, scanned{token.TOKENType, token.Text, token.Line, token.Pos, token.End})
+		if token.TOKENType == globals.TokenEOF {
+			return tokens, &scanner
+		}
+	}
+}
+
+func TestScannerReader(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.clox"))
+	sources := []string{"print \"héllo\nwörld\"; // ü\n\tvar x = 1.5 >= 2;", "a 世 \"unterminated"}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, string(data))
+	}
+	for i, source := range sources {
+		var want []scanned
+		for _, token := range Tokenize(source, true) {
+			want = append(want, scanned{token.TOKENType, token.Text, token.Line, token.Pos, token.End})
+		}
+		readers := map[string]io.Reader{
+			"whole":    strings.NewReader(source),
+			"one byte": iotest.OneByteReader(strings.NewReader(source)),
+			"half":     iotest.HalfReader(strings.NewReader(source)),
+		}
+		for name, r := range readers {
+			got, scanner := scanReader(r)
+			if scanner.Err() != nil {
+				t.Errorf("source %d, %s reader: Err() = %v", i, name, scanner.Err())
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("source %d, %s reader: tokens =\n%v\nwant\n%v", i, name, got, want)
+			}
+		}
+	}
+}
+
+func TestScannerReaderBuffer(t *testing.T) {
+	source := strings.Repeat("var total = total + 1; // count\n", 1000)
+	longString := "\"" + strings.Repeat("x", 3*bufferSize) + "\""
+	var scanner Scanner
+	scanner.InitReader(strings.NewReader(source + "print " + longString + ";"))
+	for {
+		token := scanner.ScanToken(scanner.Source)
+		if token.TOKENType == globals.TokenSTRING {
+			if token.Text != longString {
+				t.Errorf("long string = %d bytes, want %d", len(token.Text), len(longString))
+			}
+		} else if token.Start < len(source) && len(*scanner.Source) > bufferSize {
+			t.Fatalf("buffer holds %d bytes scanning a %d byte token", len(*scanner.Source), token.Length)
+		}
+		if token.TOKENType == globals.TokenEOF {
+			break
+		}
+	}
+}
+
+func TestScannerReaderError(t *testing.T) {
+	errRead := errors.New("disk on fire")
+	var scanner Scanner
+	scanner.InitReader(io.MultiReader(strings.NewReader("print 1"), iotest.ErrReader(errRead)))
+	var kinds []globals.TokenType
+	for {
+		token := scanner.ScanToken(scanner.Source)
+		kinds = append(kinds, token.TOKENType)
+		if token.TOKENType == globals.TokenEOF {
+			break
+		}
+	}
+	if want := []globals.TokenType{globals.TokenPRINT, globals.TokenNUMBER, globals.TokenEOF}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("tokens = %v, want %v", kinds, want)
+	}
+	if scanner.Err() != errRead {
+		t.Errorf("Err() = %v, want %v", scanner.Err(), errRead)
+	}
+}
+
+func TestScannerLineText(t *testing.T) {
+	source := "var a = 1;\nvar b =\n  a;\n" + strings.Repeat("print a;\n", 1000) + "print b"
+	var scanner Scanner
+	scanner.InitReader(iotest.OneByteReader(strings.NewReader(source)))
+	scanner.Keep(2)
+	for token := scanner.ScanToken(scanner.Source); token.TOKENType != globals.TokenEOF; token = scanner.ScanToken(scanner.Source) {
+	}
+	tests := []struct {
+		line  int
+		text  string
+		start int
+		ok    bool
+	}{
+		{1, "", 0, false},
+		{2, "var b =", 11, true},
+		{3, "  a;", 19, true},
+		{1004, "print b", len(source) - 7, true},
+	}
+	for _, tt := range tests {
+		text, start, ok := scanner.LineText(tt.line)
+		if text != tt.text || start != tt.start || ok != tt.ok {
+			t.Errorf("LineText(%d) = %q, %d, %v, want %q, %d, %v", tt.line, text, start, ok, tt.text, tt.start, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// endStatement is the hint for a statement missing its ';'.
+const endStatement = "end the statement with ';'"
+
+// hints suggests a fix for the compile errors whose cause is usually plain.
+var hints = map[string]string{
+	"Expect expression":                             "an expression starts with a value, a name, '(', '-' or '!'",
+	"Expect ';' after value.":                       endStatement,
+	"Expect ';' after expression.":                  endStatement,
+	"Expect ';' after variable declaration.":        endStatement,
+	"Expect ';' after return value.":                endStatement,
+	"Expect '}' after block":                        "every '{' needs a matching '}'",
+	"Expect ')' after the expression":               "every '(' needs a matching ')'",
+	"Expect ')' after arguments.":                   "separate the arguments with ',' and close the call with ')'",
+	"Expect ')' after parameters.":                  "separate the parameters with ',' and close the list with ')'",
+	"Invalid assignment target":                     "only a variable can be assigned to",
+	"Invalid increment target.":                     "only a variable can be incremented or decremented",
+	"Can't return from top-level code.":             "return only works inside a function",
+	"Already variable with this name in this scope": "pick another name, or leave out 'var' to assign to the variable",
+	"Unterminated String.":                          "close the string with '\"'",
+}
+
+// errorLine returns the line an error at token is reported on: the line the
+// token starts on, or for the end of the file, the line the source's text
+// ends on.
+func errorLine(scanner *Scanner, token *Token) int {
+	if token.TOKENType != globals.TokenEOF {
+		return token.Pos.Line
+	}
+	return scanner.LastEnd().Line
+}
+
+/*
+writeSnippet writes the source line of token with a caret under each of its
+characters, and the hint if it is not empty:
+
+	3 | print x
+	  |       ^
+	  = hint: end the statement with ';'
+
+At the end of the file the caret goes just after the source's text. Only the
+first line of a token that spans lines is shown, and nothing is if scanner
+no longer keeps the line.
+*/
+func writeSnippet(w io.Writer, scanner *Scanner, token *Token, hint string) {
+	start, length := token.Pos.Offset, token.Length
+	if token.TOKENType == globals.TokenEOF {
+		start, length = scanner.LastEnd().Offset, 0
+	}
+	line := errorLine(scanner, token)
+	text, lineStart, ok := scanner.LineText(line)
+	if !ok {
+		return
+	}
+	column := min(max(start-lineStart, 0), len(text))
+
+	// Tabs in front of the token stay tabs, so the caret lines up.
+	var pad strings.Builder
+	for _, r := range text[:column] {
+		if r == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	carets := max(1, utf8.RuneCountInString(text[column:min(column+length, len(text))]))
+
+	number := strconv.Itoa(line)
+	gutter := strings.Repeat(" ", len(number))
+	fmt.Fprintf(w, " %s | %s\n", number, text)
+	fmt.Fprintf(w, " %s | %s%s\n", gutter, pad.String(), strings.Repeat("^", carets))
+	if hint != "" {
+		fmt.Fprintf(w, " %s = hint: %s\n", gutter, hint)
+	}
+}
@@ -10,8 +10,8 @@ const TableMaxLoad float32 = 0.75
 
 // Table is a struct representing a table data structure.
 type Table struct {
-	capacity float32 // The maximum capacity of the table.
-	count    float32 // The current count of entries in the table.
+	capacity int     // The maximum capacity of the table.
+	count    int     // The current count of entries in the table, tombstones included.
 	entries  []Entry // The array of entries in the table.
 }
 
@@ -36,7 +36,7 @@ func (table *Table) InitTable() {
 // No parameters.
 // No return types.
 func (table *Table) Freetable() {
-	FreeArray(table.entries, int(table.capacity))
+	FreeArray(table.entries, table.capacity)
 	table.InitTable()
 }
 
@@ -49,29 +49,28 @@ func (table *Table) Freetable() {
 // Returns:
 // - bool: true if the key is a new key in the table, false otherwise.
 func (table *Table) TableSet(key *ObjectString, value Value) bool {
-	if table.count+1 > table.capacity*TableMaxLoad {
-		oldcap := table.capacity
-		capacity := GrowCapacity(int(table.capacity))
-		table.adjustTable(int(oldcap), capacity)
+	if float32(table.count+1) > float32(table.capacity)*TableMaxLoad {
+		table.adjustTable(GrowCapacity(table.capacity))
 	}
-	entry, index := table.findEntry(int(table.capacity), key)
+	entry := findEntry(table.entries, table.capacity, key)
 	isNewKey := entry.key == nil
 	if isNewKey && IsNil(entry.value) {
 		table.count++
 	}
-	table.entries[index].key = key
-	table.entries[index].value = value
+	entry.key = key
+	entry.value = value
 	return isNewKey
 }
 
 // TableDelete deletes an entry from the Table.
 //
 // It takes a key of type *ObjectString as a parameter and returns a boolean value indicating whether the deletion was successful.
+// The entry is replaced by a tombstone so probe sequences running through it stay intact.
 func (table *Table) TableDelete(key *ObjectString) bool {
 	if table.count == 0 {
 		return false
 	}
-	entry, _ := table.findEntry(int(table.capacity), key)
+	entry := findEntry(table.entries, table.capacity, key)
 	if entry.key == nil {
 		return false
 	}
@@ -86,8 +85,8 @@ func (table *Table) TableDelete(key *ObjectString) bool {
 // It takes a pointer to the Table struct named "from" as a parameter.
 // It does not return anything.
 func (table *Table) TableAddAll(from *Table) {
-	for i := 0; i < int(from.capacity); i++ {
-		entry := from.entries[i]
+	for i := 0; i < from.capacity; i++ {
+		entry := &from.entries[i]
 		if entry.key != nil {
 			table.TableSet(entry.key, entry.value)
 		}
@@ -98,12 +97,12 @@ func (table *Table) TableAddAll(from *Table) {
 //
 // The function takes two parameters: key, a pointer to an ObjectString, and value, a pointer to a Value.
 // It returns a boolean value indicating whether the key was found in the Table.
-func (table Table) TableGet(key *ObjectString, value *Value) bool {
+func (table *Table) TableGet(key *ObjectString, value *Value) bool {
 	if table.count == 0 {
 		return false
 	}
 
-	entry, _ := table.findEntry(int(table.capacity), key)
+	entry := findEntry(table.entries, table.capacity, key)
 	if entry.key == nil {
 		return false
 	}
@@ -111,59 +110,62 @@ func (table Table) TableGet(key *ObjectString, value *Value) bool {
 	return true
 }
 
-// findEntry finds the entry in the table with the given capacity and key.
+// findEntry finds the slot in entries for the given key.
 //
 // Parameters:
-// - capacity: the capacity of the table
+// - entries: the backing array to probe
+// - capacity: the capacity of entries
 // - key: the key to search for
 //
 // Returns:
-// - entry: the entry found
-// - index: the index of the entry
-func (table *Table) findEntry(capacity int, key *ObjectString) (*Entry, uint32) {
+// - entry: the entry holding key, or the slot where key should be inserted,
+// preferring the first tombstone passed on the way
+func findEntry(entries []Entry, capacity int, key *ObjectString) *Entry {
 	index := key.Hash % uint32(capacity)
 	var tombstone *Entry
 	for {
-		entry := table.entries[index]
+		entry := &entries[index]
 		if entry.key == nil {
 			if IsNil(entry.value) {
 				if tombstone != nil {
-					return tombstone, index
+					return tombstone
 				}
-				return &entry, index
+				return entry
 			}
 			if tombstone == nil {
-				tombstone = &entry
+				tombstone = entry
 			}
 
 		} else if entry.key == key {
-			return &entry, index
+			return entry
 		}
 		index = (index + 1) % uint32(capacity)
 	}
 }
 
-func (table *Table) adjustTable(oldcap, capacity int) {
-	entries := GrowArrayEntries(table.entries, oldcap, capacity)
+// adjustTable grows the table to capacity and reinserts every live entry,
+// dropping tombstones along the way.
+func (table *Table) adjustTable(capacity int) {
+	entries := GrowArray[Entry](nil, 0, capacity)
 
 	for i := 0; i < capacity; i++ {
 		entries[i].key = nil
 		entries[i].value = NilValue()
 	}
 	table.count = 0
-	for i := 0; i < int(table.capacity); i++ {
-		entry := table.entries[i]
+	for i := 0; i < table.capacity; i++ {
+		entry := &table.entries[i]
 		if entry.key == nil {
 			continue
 		}
 
-		dest, _ := table.findEntry(capacity, entry.key)
+		dest := findEntry(entries, capacity, entry.key)
 		dest.key = entry.key
 		dest.value = entry.value
 		table.count++
 
 	}
-	FreeArray(table.entries, int(table.capacity))
+	FreeArray(table.entries, table.capacity)
 	table.entries = entries
-	table.capacity = float32(capacity)
+	table.capacity = capacity
 }
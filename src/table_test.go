@@ -0,0 +1,65 @@
+package src
+
+import (
+	"strconv"
+	"testing"
+)
+
+// newTestKey builds an uninterned string key for exercising Table directly.
+func newTestKey(s string) *ObjectString {
+	chars := append([]byte(s), 0)
+	return &ObjectString{Length: len(s), Chars: chars, Hash: hashString(chars, len(s))}
+}
+
+func TestTableSetGetAcrossGrowth(t *testing.T) {
+	var table Table
+	table.InitTable()
+	keys := make([]*ObjectString, 100)
+	for i := range keys {
+		keys[i] = newTestKey("key" + strconv.Itoa(i))
+		if !table.TableSet(keys[i], NumberValue(float64(i))) {
+			t.Fatalf("TableSet(%d) reported an existing key", i)
+		}
+	}
+	for i, key := range keys {
+		var value Value
+		if !table.TableGet(key, &value) {
+			t.Fatalf("TableGet(%d) did not find key", i)
+		}
+		if AsNumber(value) != float64(i) {
+			t.Errorf("TableGet(%d) = %v, want %d", i, AsNumber(value), i)
+		}
+	}
+}
+
+func TestTableDelete(t *testing.T) {
+	var table Table
+	table.InitTable()
+	key := newTestKey("gone")
+	table.TableSet(key, BoolValue(true))
+	if !table.TableDelete(key) {
+		t.Fatal("TableDelete() = false, want true")
+	}
+	var value Value
+	if table.TableGet(key, &value) {
+		t.Error("TableGet() found a deleted key")
+	}
+	if !table.TableSet(key, NilValue()) {
+		t.Error("TableSet() after delete should report a new key")
+	}
+}
+
+func BenchmarkTableSet(b *testing.B) {
+	keys := make([]*ObjectString, 1000)
+	for i := range keys {
+		keys[i] = newTestKey("key" + strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var table Table
+		table.InitTable()
+		for j, key := range keys {
+			table.TableSet(key, NumberValue(float64(j)))
+		}
+	}
+}
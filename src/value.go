@@ -3,6 +3,8 @@ package src
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"os"
 )
 
 type ValueType int
@@ -14,12 +16,19 @@ const (
 	ValObjStr
 	ValObj
 	ValNumber
+	ValUndefined // Marks a global slot that has been assigned but not defined yet.
 )
 
-// Value represents a value in the language
+// Value represents a value in the language.
+//
+// Numbers and booleans live inline in num, so creating them never allocates
+// and reading them needs no type assertion. Objects are referenced through
+// their Obj header, which is always the first field of the concrete object
+// struct so the header pointer can be converted back to the full object.
 type Value struct {
-	Type ValueType   // The type of the value
-	As   interface{} // The value
+	Type ValueType // The type of the value
+	num  float64   // The payload for ValNumber, and ValBool as 0 or 1
+	obj  *Obj      // The header of the referenced object for ValObj and ValObjStr
 }
 
 // ValueArray represents an array of values.
@@ -31,7 +40,10 @@ type ValueArray struct {
 
 // BoolValue returns a Value with Type ValBool and As value.
 func BoolValue(value bool) Value {
-	return Value{Type: ValBool, As: value}
+	if value {
+		return Value{Type: ValBool, num: 1}
+	}
+	return Value{Type: ValBool}
 }
 
 // ObjStrValue returns a Value with Type ValObjStr and As value.
@@ -39,7 +51,7 @@ func BoolValue(value bool) Value {
 // value: a pointer to an ObjectString.
 // Returns: a Value.
 func ObjStrValue(value *ObjectString) Value {
-	return Value{Type: ValObjStr, As: value}
+	return Value{Type: ValObjStr, obj: &value.Obj}
 }
 
 // ObjFunctionValue returns the value of the ObjFunction.
@@ -47,14 +59,14 @@ func ObjStrValue(value *ObjectString) Value {
 // value *ObjFunction - the ObjFunction parameter
 // Value - the return type
 func ObjFunctionValue(value *ObjFunction) Value {
-	return Value{Type: ValObj, As: value}
+	return Value{Type: ValObj, obj: &value.obj}
 }
 
 // OBJ_VAL description of the Go function.
 //
 // It takes a parameter object of type *Obj and returns a Value type.
 func ObjVal(object *ObjFunction) Value {
-	return Value{Type: ValueType(ValObj), As: object}
+	return ObjFunctionValue(object)
 }
 
 // NilValue returns a Value with Type ValNil and a nil As field.
@@ -62,7 +74,14 @@ func ObjVal(object *ObjFunction) Value {
 // NilValue does not take any parameters.
 // It returns a Value.
 func NilValue() Value {
-	return Value{Type: ValNil, As: nil}
+	return Value{Type: ValNil}
+}
+
+// UndefinedValue returns the sentinel stored in global slots that have no definition yet.
+//
+// It never reaches user code: reading or assigning a global that still holds it is a runtime error.
+func UndefinedValue() Value {
+	return Value{Type: ValUndefined}
 }
 
 // NumberValue creates a Value struct with the given float64 value.
@@ -73,7 +92,7 @@ func NilValue() Value {
 // Returns:
 // The created Value struct.
 func NumberValue(value float64) Value {
-	return Value{Type: ValNumber, As: value}
+	return Value{Type: ValNumber, num: value}
 }
 
 // AsBool returns the boolean value of the given Value.
@@ -83,7 +102,7 @@ func NumberValue(value float64) Value {
 //
 // It returns a boolean value.
 func AsBool(value Value) bool {
-	return value.As.(bool)
+	return value.num != 0
 }
 
 // AsObj returns the *Obj value from the given Value.
@@ -93,7 +112,7 @@ func AsBool(value Value) bool {
 //
 // It returns a *Obj.
 func AsObj(value Value) *Obj {
-	return value.As.(*Obj)
+	return value.obj
 }
 
 // AsNumber returns the value of the input parameter as a float64.
@@ -101,7 +120,7 @@ func AsObj(value Value) *Obj {
 // value: The value to be converted.
 // Returns: The value as a float64.
 func AsNumber(value Value) float64 {
-	return value.As.(float64)
+	return value.num
 }
 
 // IsBool checks if the given value is a boolean.
@@ -127,6 +146,14 @@ func IsValObj(value Value) bool {
 	return value.Type == ValObj
 }
 
+// IsUndefined checks if the given value is the undefined global sentinel.
+//
+// value: the value to be checked.
+// bool: true if the value is UndefinedValue, false otherwise.
+func IsUndefined(value Value) bool {
+	return value.Type == ValUndefined
+}
+
 // IsNumber checks if the given value is of type number.
 //
 // value: the value to be checked.
@@ -154,7 +181,7 @@ func WriteValueArray(array *ValueArray, val Value) {
 	if array.Capacity < array.Count+1 {
 		oldCap := array.Capacity
 		array.Capacity = GrowCapacity(oldCap)
-		array.Values = GrowArrayValueArray(array.Values, oldCap, array.Capacity)
+		array.Values = GrowArray(array.Values, oldCap, array.Capacity)
 	}
 	array.Values[array.Count] = val
 	array.Count++
@@ -169,39 +196,64 @@ func FreeValueArray(array *ValueArray) {
 	InitValueArray(array)
 }
 
-// PrintValue prints the value of a given Value object.
+// PrintValue prints the value of a given Value object to standard output.
 //
 // It takes a Value object as a parameter and prints its value based on its type:
 func PrintValue(value Value) {
+	FprintValue(os.Stdout, value)
+}
+
+// FprintValue writes the value of a given Value object to w.
+//
+// It takes a writer and a Value object as parameters and prints the value based on its type.
+func FprintValue(w io.Writer, value Value) {
 	switch value.Type {
 	case ValBool:
-		fmt.Print(AsBool(value))
+		fmt.Fprint(w, AsBool(value))
 	case ValNil:
-		fmt.Print("nil")
+		fmt.Fprint(w, "nil")
 	case ValNumber:
-		fmt.Print(AsNumber(value))
+		fmt.Fprint(w, AsNumber(value))
 	case ValObjStr:
-		printObjectStr(value)
+		printObjectStr(w, value)
 	case ValObj:
-		printFunction(AsFunction(value))
+		if IsError(value) {
+			printObjectStr(w, ObjStrValue(AsError(value).message))
+		} else if IsList(value) {
+			printList(w, AsList(value))
+		} else {
+			printFunction(w, AsFunction(value))
+		}
 	}
 }
 
 // printObjectStr prints the string representation of an object.
 //
-// It takes a Value object as a parameter.
+// It takes a writer and a Value object as parameters.
 // It does not return anything.
-func printObjectStr(object Value) {
-	fmt.Printf("%s", AsCString(object))
+func printObjectStr(w io.Writer, object Value) {
+	fmt.Fprintf(w, "%s", AsCString(object))
+}
+
+// printList prints the items of a list between brackets, separated by commas.
+func printList(w io.Writer, list *ObjList) {
+	fmt.Fprint(w, "[")
+	for i, item := range list.items {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
+		}
+		FprintValue(w, item)
+	}
+	fmt.Fprint(w, "]")
 }
 
 // printFunction prints the function object.
-func printFunction(function *ObjFunction) {
+func printFunction(w io.Writer, function *ObjFunction) {
 
 	if function.name == nil {
-		fmt.Printf("<script>")
+		fmt.Fprintf(w, "<script>")
 	} else {
-		fmt.Printf("%s", string(function.name.Chars))
+		fmt.Fprintf(w, "%s", AsCString(ObjStrValue(function.name)))
 	}
 }
 
@@ -226,6 +278,8 @@ func valuesEqual(a, b Value) bool {
 		bString := removeNullBytes(AsObjString(b).Chars)
 
 		return bytes.Equal(aString, bString)
+	case ValObj:
+		return AsObj(a) == AsObj(b)
 	}
 
 	return false
@@ -0,0 +1,78 @@
+package src
+
+import "testing"
+
+// loopSource mirrors the while loop in test.clox without the per-iteration
+// print so the benchmark measures the interpreter rather than stdout.
+const loopSource = `
+var x = 100000;
+var sum = 0;
+while (x > 0) {
+   sum = sum + x * 2;
+   x = x - 1;
+}
+`
+
+func TestValueRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value Value
+		check func(Value) bool
+	}{
+		{"number", NumberValue(4.5), func(v Value) bool { return IsNumber(v) && AsNumber(v) == 4.5 }},
+		{"true", BoolValue(true), func(v Value) bool { return IsBool(v) && AsBool(v) }},
+		{"false", BoolValue(false), func(v Value) bool { return IsBool(v) && !AsBool(v) }},
+		{"nil", NilValue(), func(v Value) bool { return IsNil(v) && !IsNumber(v) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.check(tt.value) {
+				t.Errorf("round trip failed for %v", tt.value)
+			}
+		})
+	}
+}
+
+func TestValuesEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Value
+		want bool
+	}{
+		{"numbers", NumberValue(1), NumberValue(1), true},
+		{"different numbers", NumberValue(1), NumberValue(2), false},
+		{"bools", BoolValue(true), BoolValue(true), true},
+		{"bool and number", BoolValue(true), NumberValue(1), false},
+		{"nils", NilValue(), NilValue(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := valuesEqual(tt.a, tt.b); got != tt.want {
+				t.Errorf("valuesEqual() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkNumberArithmetic(b *testing.B) {
+	InitVM()
+	defer FreeVM()
+	for i := 0; i < b.N; i++ {
+		vm.Push(NumberValue(float64(i)))
+		vm.Push(NumberValue(2))
+		b2 := vm.Pop()
+		a := vm.Pop()
+		vm.Push(NumberValue(AsNumber(a) * AsNumber(b2)))
+		vm.Pop()
+	}
+}
+
+func BenchmarkInterpretLoop(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		InitVM()
+		if result := Interpret(loopSource); result != InterpretOk {
+			b.Fatalf("Interpret() = %v, want %v", result, InterpretOk)
+		}
+		FreeVM()
+	}
+}
@@ -0,0 +1,242 @@
+package src
+
+import (
+	"fmt"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// VerifyError describes why a chunk failed verification.
+type VerifyError struct {
+	Offset  int    // The offset of the offending instruction.
+	Message string // What is wrong with it.
+}
+
+// Error implements the error interface.
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("invalid bytecode at offset %04d: %s", e.Offset, e.Message)
+}
+
+// stackEffect describes how an instruction uses the value stack.
+type stackEffect struct {
+	needs int // How many values must be on the stack, above the callee slot.
+	delta int // How the stack depth changes.
+}
+
+// stackEffects holds the stack effect of every opcode with a fixed one.
+// OpCall depends on its operand and is handled by VerifyChunk itself.
+var stackEffects = map[globals.OpCode]stackEffect{
+	globals.OpReturn:         {1, -1},
+	globals.OpNegate:         {1, 0},
+	globals.OpNot:            {1, 0},
+	globals.OpPrint:          {1, -1},
+	globals.OpPop:            {1, -1},
+	globals.OpDefineGlobal:   {1, -1},
+	globals.OpGetGlobal:      {0, 1},
+	globals.OpSetGlobal:      {1, 0},
+	globals.OpSetGlobalPop:   {1, -1},
+	globals.OpGetLocal:       {0, 1},
+	globals.OpSetLocal:       {1, 0},
+	globals.OpSetLocalPop:    {1, -1},
+	globals.OpGetLocal0:      {0, 1},
+	globals.OpGetLocal1:      {0, 1},
+	globals.OpGetLocal2:      {0, 1},
+	globals.OpGetLocal3:      {0, 1},
+	globals.OpIncrementLocal: {0, 0},
+	globals.OpNil:            {0, 1},
+	globals.OpTrue:           {0, 1},
+	globals.OpFalse:          {0, 1},
+	globals.OpConstant:       {0, 1},
+	globals.OpJumpFalse:      {1, 0},
+	globals.OpJump:           {0, 0},
+	globals.OpLoop:           {0, 0},
+	globals.OpEqual:          {2, -1},
+	globals.OpGreater:        {2, -1},
+	globals.OpLess:           {2, -1},
+	globals.OpAdd:            {2, -1},
+	globals.OpSubtract:       {2, -1},
+	globals.OpMultiply:       {2, -1},
+	globals.OpDivide:         {2, -1},
+	globals.OpAddConstant:    {1, 0},
+	globals.OpLessJumpFalse:  {2, -1},
+	globals.OpImport:         {0, 1},
+	globals.OpTry:            {0, 0},
+	globals.OpTryFinally:     {0, 0},
+	globals.OpEndTry:         {0, 0},
+	globals.OpEndFinally:     {2, -2},
+	globals.OpThrow:          {1, -1},
+	globals.OpGetProperty:    {1, 0},
+	globals.OpArgMissing:     {0, 1},
+	globals.OpModulo:         {2, -1},
+	globals.OpJumpNil:        {1, 0},
+	globals.OpIncrement:      {1, 0},
+	globals.OpDecrement:      {1, 0},
+}
+
+/*
+VerifyChunk checks that chunk is safe for the VM to run as the body of a
+function taking arity arguments.
+
+It checks that:
+  - every opcode is known and its operands fit inside the chunk,
+  - constant operands index the constant pool and global operands a global slot of the VM,
+  - imports name a function constant and property reads a string constant,
+  - jumps and exception handlers land on the start of an instruction,
+  - local slots exist at the point they are used,
+  - every path through the code leaves the stack at the same depth where paths
+    meet, never pops below the frame's callee slot, stays within StackMax and
+    ends in a return instead of running off the end of the chunk.
+
+Nested functions are not followed; verify each function's chunk on its own.
+
+Parameters:
+- chunk: the chunk to check.
+- arity: the number of parameters of the function that owns chunk.
+
+Returns:
+- error: a *VerifyError for the first problem found, or nil.
+*/
+func VerifyChunk(chunk *Chunk, arity int) error {
+	fail := func(offset int, format string, args ...any) error {
+		return &VerifyError{Offset: offset, Message: fmt.Sprintf(format, args...)}
+	}
+	if chunk.Count == 0 {
+		return fail(0, "empty chunk")
+	}
+
+	// Decode every instruction, checking opcodes and operands.
+	boundary := make([]bool, chunk.Count+1)
+	targets := make(map[int]int)
+	for offset := 0; offset < chunk.Count; {
+		op := chunk.Code[offset]
+		length := instructionLength(op)
+		if length == 0 {
+			return fail(offset, "unknown opcode %d", op)
+		}
+		if offset+length > chunk.Count {
+			return fail(offset, "operands run past the end of the chunk")
+		}
+		boundary[offset] = true
+
+		switch globals.OpCode(op) {
+		case globals.OpConstant, globals.OpAddConstant:
+			if int(chunk.Code[offset+1]) >= chunk.Constants.Count {
+				return fail(offset, "constant %d out of range", chunk.Code[offset+1])
+			}
+		case globals.OpIncrementLocal:
+			if int(chunk.Code[offset+2]) >= chunk.Constants.Count {
+				return fail(offset, "constant %d out of range", chunk.Code[offset+2])
+			}
+		case globals.OpImport:
+			constant := int(chunk.Code[offset+1])
+			if constant >= chunk.Constants.Count || !IsFunction(chunk.Constants.Values[constant]) {
+				return fail(offset, "constant %d is not a module", constant)
+			}
+		case globals.OpGetProperty:
+			constant := int(chunk.Code[offset+1])
+			if constant >= chunk.Constants.Count || !IsString(chunk.Constants.Values[constant]) {
+				return fail(offset, "constant %d is not a property name", constant)
+			}
+		case globals.OpDefineGlobal, globals.OpGetGlobal, globals.OpSetGlobal, globals.OpSetGlobalPop:
+			slot := int(uint16(chunk.Code[offset+1])<<8 | uint16(chunk.Code[offset+2]))
+			if slot >= len(vm.globalNames) {
+				return fail(offset, "global slot %d out of range", slot)
+			}
+		}
+		if isJump(op) {
+			jump := int(uint16(chunk.Code[offset+1])<<8 | uint16(chunk.Code[offset+2]))
+			target := offset + 3 + jump
+			if op == uint8(globals.OpLoop) {
+				target = offset + 3 - jump
+			}
+			targets[offset] = target
+		}
+		offset += length
+	}
+	for offset, target := range targets {
+		if target < 0 || target >= chunk.Count || !boundary[target] {
+			return fail(offset, "jump target %d is not the start of an instruction", target)
+		}
+	}
+
+	// Walk every reachable path, tracking the stack depth. Slot 0 holds
+	// the callee and the parameters follow it.
+	depthAt := make(map[int]int)
+	work := []int{0}
+	depthAt[0] = arity + 1
+	flow := func(from, to, depth int) error {
+		if to >= chunk.Count {
+			return fail(from, "execution runs past the end of the chunk")
+		}
+		if seen, ok := depthAt[to]; ok {
+			if seen != depth {
+				return fail(to, "stack depth %d here does not match depth %d reached from offset %04d", seen, depth, from)
+			}
+			return nil
+		}
+		depthAt[to] = depth
+		work = append(work, to)
+		return nil
+	}
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+		depth := depthAt[offset]
+		op := globals.OpCode(chunk.Code[offset])
+
+		effect, ok := stackEffects[op]
+		if op == globals.OpCall {
+			argcount := int(chunk.Code[offset+1])
+			effect = stackEffect{argcount + 1, -argcount}
+		} else if !ok {
+			return fail(offset, "opcode %d has no stack effect", op)
+		}
+		if depth-1 < effect.needs {
+			return fail(offset, "stack underflow: needs %d values, has %d", effect.needs, depth-1)
+		}
+
+		slot := -1
+		switch op {
+		case globals.OpGetLocal, globals.OpSetLocal, globals.OpSetLocalPop, globals.OpIncrementLocal, globals.OpArgMissing:
+			slot = int(chunk.Code[offset+1])
+		case globals.OpGetLocal0, globals.OpGetLocal1, globals.OpGetLocal2, globals.OpGetLocal3:
+			slot = int(op - globals.OpGetLocal0)
+		}
+		if slot >= depth {
+			return fail(offset, "local slot %d out of range for stack depth %d", slot, depth)
+		}
+
+		next := depth + effect.delta
+		if next > StackMax {
+			return fail(offset, "stack depth %d exceeds %d", next, StackMax)
+		}
+		following := offset + instructionLength(uint8(op))
+		switch op {
+		case globals.OpReturn, globals.OpThrow:
+			continue
+		case globals.OpTry:
+			// The handler starts with the exception pushed.
+			if err := flow(offset, targets[offset], next+1); err != nil {
+				return err
+			}
+		case globals.OpTryFinally:
+			// A finally block starts with a value and its completion pushed.
+			if err := flow(offset, targets[offset], next+2); err != nil {
+				return err
+			}
+		case globals.OpJump, globals.OpLoop:
+			if err := flow(offset, targets[offset], next); err != nil {
+				return err
+			}
+			continue
+		case globals.OpJumpFalse, globals.OpLessJumpFalse, globals.OpJumpNil:
+			if err := flow(offset, targets[offset], next); err != nil {
+				return err
+			}
+		}
+		if err := flow(offset, following, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
+
+// buildChunk returns a chunk holding code, all on line 1, and constants.
+func buildChunk(code []globals.OpCode, constants ...Value) *Chunk {
+	var chunk Chunk
+	InitChunk(&chunk)
+	for _, b := range code {
+		WriteChunk(&chunk, uint8(b), 1)
+	}
+	for _, constant := range constants {
+		AddConstants(&chunk, constant)
+	}
+	return &chunk
+}
+
+func TestVerifyChunk(t *testing.T) {
+	const (
+		ret    = globals.OpReturn
+		null   = globals.OpNil
+		pop    = globals.OpPop
+		cnst   = globals.OpConstant
+		local  = globals.OpGetLocal
+		jump   = globals.OpJump
+		jfalse = globals.OpJumpFalse
+		add    = globals.OpAdd
+		call   = globals.OpCall
+	)
+	tests := []struct {
+		name  string
+		code  []globals.OpCode
+		arity int
+		want  string // Empty if the chunk is valid.
+	}{
+		{"valid", []globals.OpCode{cnst, 0, cnst, 0, add, ret}, 0, ""},
+		{"parameters are locals", []globals.OpCode{local, 2, ret}, 2, ""},
+		{"empty", nil, 0, "empty chunk"},
+		{"unknown opcode", []globals.OpCode{200}, 0, "unknown opcode 200"},
+		{"truncated operand", []globals.OpCode{null, cnst}, 0, "past the end of the chunk"},
+		{"constant out of range", []globals.OpCode{cnst, 5, ret}, 0, "constant 5 out of range"},
+		{"global out of range", []globals.OpCode{globals.OpGetGlobal, 0, 9, ret}, 0, "global slot 9 out of range"},
+		{"jump past end", []globals.OpCode{jump, 0, 9, null, ret}, 0, "jump target"},
+		{"jump into operand", []globals.OpCode{jump, 0, 1, cnst, 0, ret}, 0, "jump target"},
+		{"underflow", []globals.OpCode{pop, null, ret}, 0, "stack underflow"},
+		{"local out of range", []globals.OpCode{local, 3, ret}, 1, "local slot 3 out of range"},
+		{"falls off end", []globals.OpCode{null, pop}, 0, "runs past the end"},
+		{"unbalanced merge", []globals.OpCode{null, jfalse, 0, 1, null, null, ret}, 0, "does not match"},
+		{"call underflow", []globals.OpCode{null, call, 2, ret}, 0, "stack underflow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InitVM()
+			defer FreeVM()
+			err := VerifyChunk(buildChunk(tt.code, NumberValue(1)), tt.arity)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("VerifyChunk() error = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("VerifyChunk() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompiledCodeVerifies(t *testing.T) {
+	defer func(saved bool) { globals.VERIFY_CODE = saved }(globals.VERIFY_CODE)
+	globals.VERIFY_CODE = true
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.clox"))
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, optimize := range []bool{false, true} {
+			// compileSource fails the test if verification reports an error.
+			compileSource(t, string(source), optimize)
+		}
+	}
+}
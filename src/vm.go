@@ -1,14 +1,16 @@
 package src
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
+	"io"
+	"math"
+	"os"
+	"strings"
 
 	"github.com/smekuria1/goclox/globals"
 )
 
-// StackMax represents the maximum size of the stack.
+// StackMax represents the maximum number of stack slots a single call frame can address.
 const StackMax = 256
 
 // FrameMax represents the maximum number of call frames.
@@ -16,16 +18,29 @@ const FrameMax = 64
 
 // VM represents a virtual machine.
 type VM struct {
-	chunk *Chunk // Stores the bytecode of the program being executed.
-	//ip             []uint8 // Keeps track of the current instruction pointer.
-	//instructionPtr int
 	frame      [FrameMax]CallFrame // Stores the call frames of the virtual machine.
 	frameCount int                 // Keeps track of the number of call frames.
 	stack      []Value             // Stores the values of the virtual machine's stack.
 	stackTop   int                 // Keeps track of the top of the stack.
 	objects    *Obj                // Stores a linked list of all dynamically allocated objects.
 	strings    *Table              // Stores a table of string objects.
-	globals    *Table              // Stores a table of global variables.
+
+	globalSlots  *Table          // Maps each global name to its slot in globalValues.
+	globalNames  []*ObjectString // Stores the name of each global slot.
+	globalValues []Value         // Stores the value of each global slot, UndefinedValue until defined.
+
+	modules  map[string]*module     // Holds the modules compiled since InitVM, by path.
+	imports  *imports               // Holds the names scripts have imported, kept from one REPL line to the next.
+	imported map[*ObjectString]bool // Records the modules whose top level has run, by name.
+
+	out io.Writer // Receives the output of print statements and runtime errors.
+
+	instructionCount int  // Counts the instructions executed since InitVM.
+	registerMode     bool // Set while the register backend runs the program.
+
+	debugger    *Debugger // Is consulted before every instruction when attached.
+	entryFrames int       // The frame count at which run returns; non-zero while a debugger evaluates an expression.
+	result      Value     // The value returned by the function that ended run.
 
 }
 
@@ -41,32 +56,70 @@ const (
 
 	// InterpretRuntimeError indicates a runtime Error during interpretation
 	InterpretRuntimeError
+
+	// InterpretAborted indicates that an attached debugger stopped the program
+	InterpretAborted
 )
 
+// CallFrame represents a function call in progress.
 type CallFrame struct {
 	function *ObjFunction // Stores the function object of the function being called.
-	slots    []Value      // Stores the slots of the call frame.
-	// slotTop  int          // Keeps track of the top of the slots.
-	fp    []uint8 // Stores the frame pointers of the call frame.
-	fpPtr int     //  tracks the current frame pointer
+	ip       int          // Tracks the index of the next instruction in the function's code.
+	slots    int          // Stores the index of the frame's first stack slot, which holds the callee.
+	handlers []handler    // Stores the exception handlers of the try statements the frame is in, innermost last.
+}
+
+// handler is an exception handler installed by OpTry or OpTryFinally.
+type handler struct {
+	ip      int  // The instruction the handler starts at.
+	depth   int  // The frame's stack depth, callee slot included, when the handler was installed.
+	finally bool // Whether the handler runs a finally block, which returns enter too.
 }
 
+// The completions a finally block is entered with, pushed above the value
+// they carry. Falling into the block from its try or catch block pushes nil twice.
+const (
+	completionThrow  = 1 // The value is an exception to throw again.
+	completionReturn = 2 // The value is the frame's return value.
+)
+
 var vm VM
 
 // InitVM initializes the virtual machine.
 //
-// It resets the stack, clears the objects, and initializes the strings and globals tables.
+// It resets the stack, clears the objects, and initializes the strings table, the global slots and the module cache.
 func InitVM() {
 	vm.ResetStack()
 	// vm.instructionPtr = 0
 	vm.objects = nil
 	vm.strings = &Table{}
-	vm.globals = &Table{}
-	vm.stack = make([]Value, StackMax)
-	vm.globals.InitTable()
+	vm.globalSlots = &Table{}
+	vm.globalNames = nil
+	vm.globalValues = nil
+	vm.modules = make(map[string]*module)
+	vm.imports = newImports()
+	vm.imported = make(map[*ObjectString]bool)
+	vm.stack = make([]Value, FrameMax*StackMax)
+	vm.out = os.Stdout
+	vm.instructionCount = 0
+	vm.debugger = nil
+	vm.entryFrames = 0
+	vm.globalSlots.InitTable()
 	vm.strings.InitTable()
 }
 
+// SetOutput redirects the output of print statements and runtime errors to w.
+//
+// InitVM resets the output to os.Stdout.
+func SetOutput(w io.Writer) {
+	vm.out = w
+}
+
+// InstructionCount returns the number of instructions executed since InitVM.
+func InstructionCount() int {
+	return vm.instructionCount
+}
+
 // ResetStack resets the stack of the VM.
 //
 // No parameters.
@@ -76,26 +129,41 @@ func (vm *VM) ResetStack() {
 	vm.frameCount = 0
 }
 
-// FreeVM frees the virtual machine by calling the Freetable method on the vm.strings and vm.globals variables,
-// and the FreeObjects function on the vm.objects variable.
+// FreeVM frees the virtual machine by calling the Freetable method on the vm.strings and vm.globalSlots variables,
+// dropping the global values, and calling the FreeObjects function on the vm.objects variable.
 //
 // No parameters.
 // No return value.
 func FreeVM() {
 
 	vm.strings.Freetable()
-	vm.globals.Freetable()
+	vm.globalSlots.Freetable()
+	vm.globalNames = nil
+	vm.globalValues = nil
 	FreeObjects(vm.objects)
 }
 
+// globalSlot returns the slot index of the global variable with the given name.
+//
+// Slots are assigned the first time a name is seen and never change, so code
+// compiled on one REPL line keeps addressing the same global on the next.
+// A new slot holds UndefinedValue until an OpDefineGlobal stores into it.
+func (vm *VM) globalSlot(name *ObjectString) int {
+	var slot Value
+	if vm.globalSlots.TableGet(name, &slot) {
+		return int(AsNumber(slot))
+	}
+	index := len(vm.globalValues)
+	vm.globalSlots.TableSet(name, NumberValue(float64(index)))
+	vm.globalNames = append(vm.globalNames, name)
+	vm.globalValues = append(vm.globalValues, UndefinedValue())
+	return index
+}
+
 // Push pushes a value onto the stack.
 //
 // value: the value to be pushed onto the stack.
 func (vm *VM) Push(value Value) {
-	// //DEBATING HOW TO HANDLE OVERFLOW
-	// if vm.stackTop+1 >= StackMax {
-
-	// }
 	vm.stack[vm.stackTop] = value
 	vm.stackTop++
 }
@@ -109,15 +177,16 @@ func (vm *VM) Pop() Value {
 	return vm.stack[vm.stackTop]
 }
 
-// Peek returns the value at the top of the stack without removing it.
+// Peek returns a value from the stack without removing it.
 //
-// It does not take any parameters.
+// It takes an optional distance from the top of the stack, which defaults to 0.
 // It returns a Value.
-func (vm *VM) Peek(index ...int) Value {
-	if vm.stackTop != 0 {
-		return vm.stack[vm.stackTop-1]
+func (vm *VM) Peek(distance ...int) Value {
+	offset := 0
+	if len(distance) > 0 {
+		offset = distance[0]
 	}
-	return vm.stack[vm.stackTop]
+	return vm.stack[vm.stackTop-1-offset]
 }
 
 // Interpret interprets the given source code and returns the interpretation result.
@@ -128,310 +197,591 @@ func (vm *VM) Peek(index ...int) Value {
 // Return type:
 // - InterpretResult: The result of the interpretation.
 func Interpret(source string) InterpretResult {
+	return InterpretFile("", source)
+}
+
+// InterpretFile is Interpret for the script at path, against whose directory
+// its imports resolve.
+func InterpretFile(path, source string) InterpretResult {
 	var chunk Chunk
 	InitChunk(&chunk)
-	function := Compile(source, &chunk)
+	function := CompileFile(path, source, &chunk)
 	if function == nil {
 		FreeChunk(&chunk)
 		return InterpretCompileError
 	}
-	vm.Push(ObjVal(function))
-	callValue(ObjVal(function), 0)
-	result := vm.run()
+	result := InterpretFunction(function)
 	FreeChunk(&chunk)
 	return result
 
 }
 
-// BinaryOp performs a binary operation on the top two values in the VM's stack
-// using the provided operation function.
+// InterpretFunction runs an already compiled script, such as one loaded by ReadBytecode.
 //
 // Parameters:
-// - op: The operation function that takes two values and returns a value.
+// - function: the script function to run.
 //
-// Return type: error
-func (vm *VM) BinaryOp(op func(Value, Value) Value, offset ...int) error {
-	b := vm.Pop()
-	a := vm.Pop()
-	if a.Type != ValNumber || b.Type != ValNumber {
-		return errors.New("cannot compare non numeric")
+// Return type:
+// - InterpretResult: The result of the interpretation.
+func InterpretFunction(function *ObjFunction) InterpretResult {
+	vm.Push(ObjVal(function))
+	callValue(ObjVal(function), 0)
+	if !globals.REGISTER_VM {
+		return vm.run()
 	}
-	vm.Push(op(a, b))
-	return nil
-}
-
-// ReadByteVM reads a single byte from the VM's instruction pointer.
-//
-// No parameters.
-// Returns a uint8 value.
-func (frame *CallFrame) ReadByteVM() uint8 {
-	// Dereference the slice pointer and take the address of the first element.
-	//result := (*uint8)(unsafe.Pointer(&(vm.ip)[0]))
-	result := frame.fp[frame.fpPtr]
-	// Increment the slice pointer to point to the next element.
-	//vm.ip = (vm.ip)[1:]
-
-	frame.fpPtr++
-
-	return result
+	vm.registerMode = true
+	defer func() { vm.registerMode = false }()
+	return vm.runRegisters()
 }
 
-// ReadConstant retrieves a constant value from the chunk's constant pool.
-//
-// It reads a byte from the VM's instruction stream and uses it as an index
-// into the constant pool. The constant value at that index is then returned.
-// The constant pool is stored in the `Values` field of the `Constants`
-// field of the `chunk` field of the `VM` struct.
+// runtimeError handles runtime errors in the VM.
 //
-// Returns the constant value retrieved from the constant pool.
-func (frame *CallFrame) ReadConstant() Value {
-	result := frame.function.chunk.Constants.Values[frame.ReadByteVM()]
-	return result
+// It takes the parts of the message, which are joined with spaces.
+// It throws an error object with the message and a trace of the call frames
+// of the current run, and reports whether a handler caught it, see throw.
+// The ip of every frame must be up to date.
+func (vm *VM) runtimeError(message ...string) bool {
+	return vm.throw(ErrorValue(newError(strings.Join(message, " "), vm.trace())), "")
 }
 
-// runtimeError handles runtime errors in the VM.
-//
-// It takes the offset and runoffset integers as parameters.
-// It does not return anything.
-func (vm *VM) runtimeError(offset int, runoffset int, message ...string) {
-
-	// frame := &vm.frame[vm.frameCount-1]
-	// instruction := frame.fp[frame.fpPtr]
-	// line := frame.function.chunk.Lines[int(instruction)]
-	// fmt.Printf("%s line[%d]\n", message[0], line)
-	// if len(message) > 1 {
-	// 	fmt.Printf("%s", message[1])
-	// }
-	for i := vm.frameCount - 1; i >= 0; i-- {
+// trace describes the call frames of the current run, innermost first, one per line.
+func (vm *VM) trace() string {
+	var trace strings.Builder
+	for i := vm.frameCount - 1; i >= vm.entryFrames; i-- {
 		frame := &vm.frame[i]
 		function := frame.function
-		instruction := frame.fp[frame.fpPtr]
-
-		fmt.Printf("[line %d] in %s\n", function.chunk.Lines[int(instruction)], string(function.name.Chars))
+		fmt.Fprintf(&trace, "[line %d] in ", frame.line())
 		if function.name == nil {
-			fmt.Printf("script\n")
+			fmt.Fprintf(&trace, "script")
 		} else {
-			fmt.Printf("function %s\n", string(function.name.Chars))
+			fmt.Fprintf(&trace, "%s()", AsCString(ObjStrValue(function.name)))
+		}
+		if i > vm.entryFrames {
+			trace.WriteByte('\n')
+		}
+	}
+	return trace.String()
+}
+
+/*
+throw unwinds the call frames of the current run to the innermost exception
+handler and enters it with value on the stack.
+
+If no handler is left, it prints value as uncaught, followed by the trace of
+an error object or else the given trace, and resets the stack.
+
+Returns:
+- bool: true if a handler caught value, false if it was uncaught.
+*/
+func (vm *VM) throw(value Value, trace string) bool {
+	for vm.frameCount > vm.entryFrames {
+		frame := &vm.frame[vm.frameCount-1]
+		if n := len(frame.handlers); n > 0 {
+			h := frame.handlers[n-1]
+			frame.handlers = frame.handlers[:n-1]
+			vm.enterHandler(frame, h, value, completionThrow)
+			return true
 		}
+		vm.frameCount--
 	}
 
+	if IsError(value) {
+		fmt.Fprintln(vm.out, AsCString(ObjStrValue(AsError(value).message)))
+		trace = AsCString(ObjStrValue(AsError(value).trace))
+	} else {
+		fmt.Fprint(vm.out, "Uncaught exception: ")
+		FprintValue(vm.out, value)
+		fmt.Fprintln(vm.out)
+	}
+	fmt.Fprintln(vm.out, trace)
 	vm.ResetStack()
+	return false
+}
+
+// enterHandler continues frame at handler h, with the stack cut back to the
+// depth h was installed at and value pushed. A finally block also gets the
+// completion that entered it.
+func (vm *VM) enterHandler(frame *CallFrame, h handler, value Value, completion int) {
+	vm.stackTop = frame.slots + h.depth
+	vm.Push(value)
+	if h.finally {
+		vm.Push(NumberValue(float64(completion)))
+	}
+	frame.ip = h.ip
+}
+
+// returnFrom returns result from the running frame. If the frame is inside a
+// try statement with a finally block, the block runs first and returns again
+// when it ends.
+//
+// It reports whether the return ended the current run, leaving result in vm.result.
+func (vm *VM) returnFrom(result Value) bool {
+	frame := &vm.frame[vm.frameCount-1]
+	for n := len(frame.handlers); n > 0; n-- {
+		h := frame.handlers[n-1]
+		frame.handlers = frame.handlers[:n-1]
+		if h.finally {
+			vm.enterHandler(frame, h, result, completionReturn)
+			return false
+		}
+	}
+	vm.frameCount--
+	vm.stackTop = frame.slots
+	if vm.frameCount == vm.entryFrames {
+		vm.result = result
+		return true
+	}
+	vm.Push(result)
+	return false
+}
+
+// running reports whether a frame of the current run is left, which after a
+// runtime error means that a handler caught it.
+func (vm *VM) running() bool {
+	return vm.frameCount > vm.entryFrames
 }
 
-// ReadShort reads a 16-bit value from the VM's instruction stream.
-func (frame *CallFrame) ReadShort() uint16 {
-	value := uint16(frame.fp[frame.fpPtr])<<8 | uint16(frame.fp[frame.fpPtr+1])
+// getProperty returns the property called name of object. Only error objects
+// and lists have properties: the message and trace of an error and the length
+// of a list.
+//
+// It returns the message of the runtime error to raise if there is no such property.
+func getProperty(object Value, name *ObjectString) (Value, string) {
+	switch property := AsCString(ObjStrValue(name)); {
+	case IsError(object) && property == "message":
+		return ObjStrValue(AsError(object).message), ""
+	case IsError(object) && property == "trace":
+		return ObjStrValue(AsError(object).trace), ""
+	case IsList(object) && property == "length":
+		return NumberValue(float64(len(AsList(object).items))), ""
+	case !IsError(object) && !IsList(object):
+		return Value{}, "Only errors and lists have properties."
+	}
+	return Value{}, "Undefined property '" + AsCString(ObjStrValue(name)) + "'."
+}
 
-	frame.fpPtr += 2
+// line returns the source line of the instruction the frame executed last.
+func (frame *CallFrame) line() int {
+	if vm.registerMode {
+		return frame.function.registers.Lines[frame.ip-1]
+	}
+	return frame.function.chunk.Lines[frame.ip-1]
+}
 
-	return value
+// fail stores ip into the running frame and raises a runtime error. The run
+// goes on at the handler that caught it, if running says one did.
+func (vm *VM) fail(frame *CallFrame, ip int, message ...string) {
+	frame.ip = ip
+	vm.runtimeError(message...)
 }
 
+// callValue calls calle with the argcount arguments above it on the stack.
+//
+// It returns false after raising a runtime error if calle is not callable
+// or the call fails.
 func callValue(calle Value, argcount int) bool {
-	if IsValObj(calle) {
-		switch AsFunction(calle).obj.Type {
-		case ObjFunctionType:
-			return fcall(AsFunction(calle), argcount)
-		default:
-			break
-		}
+	if IsObjType(calle, ObjFunctionType) {
+		return fcall(AsFunction(calle), argcount)
 	}
-	vm.runtimeError(0, 0, "Can only call functions and classes.")
+	vm.runtimeError("Can only call functions and classes.")
 	return false
 }
 
+// fcall pushes a new call frame for function.
+//
+// The callee and its argcount arguments must be the topmost values on the
+// stack; they become the first slots of the new frame. Parameters left
+// without an argument hold UndefinedValue until the function assigns their
+// default, and a rest parameter gets a list of the extra arguments.
 func fcall(function *ObjFunction, argcount int) bool {
-	if argcount != function.arity {
-		vm.runtimeError(0, 0, "Expected", strconv.Itoa(function.arity), "arguments but got", strconv.Itoa(argcount))
+	positional := function.arity
+	if function.variadic {
+		positional--
+	}
+	if argcount < function.minArity || argcount > positional && !function.variadic {
+		vm.runtimeError(arityMessage(function, argcount))
 		return false
 	}
-	if vm.frameCount == FrameMax {
-		vm.runtimeError(0, 0, "Stack overflow.")
+	if vm.frameCount == FrameMax || vm.stackTop+StackMax > len(vm.stack) {
+		vm.runtimeError("Stack overflow.")
 		return false
 	}
 
+	slots := vm.stackTop - argcount - 1
+	for ; argcount < positional; argcount++ {
+		vm.Push(UndefinedValue())
+	}
+	if function.variadic {
+		rest := newList(vm.stack[slots+1+positional : vm.stackTop])
+		vm.stackTop = slots + 1 + positional
+		vm.Push(ListValue(rest))
+	}
+
 	frame := &vm.frame[vm.frameCount]
 	frame.function = function
-	frame.fp = function.chunk.Code
-
-	frame.slots = vm.stack[vm.stackTop-argcount-1:]
+	frame.ip = 0
+	frame.slots = slots
+	frame.handlers = frame.handlers[:0]
 	vm.frameCount++
 	return true
 }
 
+// arityMessage describes the number of arguments function accepts, for a call
+// with argcount arguments that doesn't fit.
+func arityMessage(function *ObjFunction, argcount int) string {
+	switch {
+	case function.variadic:
+		return fmt.Sprintf("Expected at least %d arguments but got %d.", function.minArity, argcount)
+	case function.minArity < function.arity:
+		return fmt.Sprintf("Expected %d to %d arguments but got %d.", function.minArity, function.arity, argcount)
+	}
+	return fmt.Sprintf("Expected %d arguments but got %d.", function.arity, argcount)
+}
+
+// traceInstruction prints the stack and the instruction about to run.
+func (vm *VM) traceInstruction(frame *CallFrame, ip, sp int) {
+	fmt.Printf("     ")
+	for slot := 0; slot < sp; slot++ {
+		fmt.Print("[")
+		PrintValue(vm.stack[slot])
+		fmt.Print("]")
+	}
+	fmt.Print("\n")
+	DisassembleInstruction(&frame.function.chunk, ip)
+}
+
 /*
-run executes the bytecode in the VM's chunk until an Error occurs or the program completes.
-During execution, the function interprets each bytecode instruction, performing the
-corresponding operations such as pushing constants onto the stack, performing binary
-operations, and handling control flow instructions. If debugging is enabled, it prints
-the stack and disassembled instructions at each step.
+run executes the bytecode of the active call frame until the outermost call
+returns or a runtime error occurs.
+
+run is re-entrant: it returns once a return leaves vm.entryFrames frames,
+which lets a paused debugger run an expression on top of the stopped program.
 
-Parameters:
-- vm: A pointer to the Virtual Machine executing the bytecode.
+The state the dispatch loop touches on every instruction (the code and
+constants of the running function, the instruction index, the frame base and
+the stack top) is kept in locals and only written back to the frame and VM
+around calls, returns and errors. Operands are decoded inline. Trace mode is
+read once before the loop starts.
 
 Returns:
 - InterpretResult: Indicates the result of the interpretation, such as success, Error, or runtime Error.
 */
 func (vm *VM) run() InterpretResult {
+	trace := globals.DEBUG_TRACE_EXECUTION
 
 	frame := &vm.frame[vm.frameCount-1]
+	code := frame.function.chunk.Code
+	constants := frame.function.chunk.Constants.Values
+	ip := frame.ip
+	base := frame.slots
+	stack := vm.stack
+	sp := vm.stackTop
+	executed := 0
+	defer func() { vm.instructionCount += executed }()
+
+	debugger := vm.debugger
+	hooked := trace || debugger != nil
 
-	offset := 0
-	runoffset := 0
 	for {
-		if globals.DEBUG_TRACE_EXECUTION {
-			fmt.Printf("     ")
-			for slot := 0; slot < int(vm.stackTop); slot++ {
-				fmt.Print("[")
-				PrintValue(vm.stack[slot])
-				fmt.Print("]")
-
+		if hooked {
+			if trace {
+				vm.traceInstruction(frame, ip, sp)
+			}
+			if debugger != nil {
+				frame.ip = ip
+				vm.stackTop = sp
+				if !debugger.onInstruction(frame) {
+					vm.ResetStack()
+					return InterpretAborted
+				}
 			}
-			fmt.Print("\n")
-			offset = DisassembleInstruction(&frame.function.chunk, frame.fpPtr)
 		}
 
-		instruction := frame.ReadByteVM()
-		//fmt.Printf("instruction: %v\n", instruction)
+		instruction := globals.OpCode(code[ip])
+		ip++
+		executed++
 		switch instruction {
-		case uint8(globals.OpConstant):
-			constant := frame.ReadConstant()
-			vm.Push(constant)
-			runoffset += 2
-			//break
-		case uint8(globals.OpNil):
-			vm.Push(NilValue())
-			runoffset++
-		case uint8(globals.OpTrue):
-			vm.Push(BoolValue(true))
-			runoffset++
-		case uint8(globals.OpFalse):
-			vm.Push(BoolValue(false))
-			runoffset++
-		case uint8(globals.OpEqual):
-			b := vm.Pop()
-			a := vm.Pop()
-			vm.Push(BoolValue(valuesEqual(a, b)))
-			runoffset++
-		case uint8(globals.OpPrint):
-			PrintValue(vm.Pop())
-			fmt.Printf("\n")
-			runoffset++
-		case uint8(globals.OpPop):
-			vm.Pop()
-			runoffset++
-		case uint8(globals.OpCall):
-			argcount := frame.ReadByteVM()
-			if !callValue(vm.Peek(int(argcount)), int(argcount)) {
-				return InterpretRuntimeError
+		case globals.OpConstant:
+			stack[sp] = constants[code[ip]]
+			sp++
+			ip++
+		case globals.OpNil:
+			stack[sp] = NilValue()
+			sp++
+		case globals.OpTrue:
+			stack[sp] = BoolValue(true)
+			sp++
+		case globals.OpFalse:
+			stack[sp] = BoolValue(false)
+			sp++
+		case globals.OpPop:
+			sp--
+		case globals.OpGetLocal:
+			stack[sp] = stack[base+int(code[ip])]
+			sp++
+			ip++
+		case globals.OpGetLocal0, globals.OpGetLocal1, globals.OpGetLocal2, globals.OpGetLocal3:
+			stack[sp] = stack[base+int(instruction-globals.OpGetLocal0)]
+			sp++
+		case globals.OpSetLocal:
+			stack[base+int(code[ip])] = stack[sp-1]
+			ip++
+		case globals.OpSetLocalPop:
+			sp--
+			stack[base+int(code[ip])] = stack[sp]
+			ip++
+		case globals.OpGetGlobal:
+			slot := int(uint16(code[ip])<<8 | uint16(code[ip+1]))
+			ip += 2
+			value := vm.globalValues[slot]
+			if IsUndefined(value) {
+				vm.fail(frame, ip, "Undefined variable", "'"+AsCString(ObjStrValue(vm.globalNames[slot]))+"'.")
+				goto unwound
 			}
-			runoffset += 1
-		case uint8(globals.OpGetGlobal):
-			name := frame.readString()
-			var value Value
-			runoffset += 2
-			if !vm.globals.TableGet(name, &value) {
-				vm.runtimeError(offset, runoffset, "Undefined Variable")
-				return InterpretRuntimeError
+			stack[sp] = value
+			sp++
+		case globals.OpDefineGlobal:
+			sp--
+			vm.globalValues[int(uint16(code[ip])<<8|uint16(code[ip+1]))] = stack[sp]
+			ip += 2
+		case globals.OpSetGlobal, globals.OpSetGlobalPop:
+			slot := int(uint16(code[ip])<<8 | uint16(code[ip+1]))
+			ip += 2
+			if IsUndefined(vm.globalValues[slot]) {
+				vm.fail(frame, ip, "Undefined variable", "'"+AsCString(ObjStrValue(vm.globalNames[slot]))+"'.")
+				goto unwound
 			}
-			vm.Push(value)
-		case uint8(globals.OpSetGlobal):
-			runoffset += 2
-			name := frame.readString()
-			if vm.globals.TableSet(name, vm.Peek()) {
-				vm.globals.TableDelete(name)
-				vm.runtimeError(offset, runoffset, "Undefined variable", string(name.Chars))
-				return InterpretRuntimeError
+			vm.globalValues[slot] = stack[sp-1]
+			if instruction == globals.OpSetGlobalPop {
+				sp--
 			}
-		case uint8(globals.OpDefineGlobal):
-			runoffset += 2
-			name := frame.readString()
-			peeked := vm.Peek()
-			vm.globals.TableSet(name, peeked)
-			vm.Pop()
-
-		case uint8(globals.OpGetLocal):
-			runoffset += 2
-			slot := frame.ReadByteVM()
-			vm.Push(frame.slots[slot])
-		case uint8(globals.OpSetLocal):
-			runoffset += 2
-			slot := frame.ReadByteVM()
-			frame.slots[slot] = vm.Peek()
-		case uint8(globals.OpReturn):
-			result := vm.Pop()
-			vm.frameCount--
-			if vm.frameCount == 0 {
-				return InterpretOk
+		case globals.OpEqual:
+			sp--
+			stack[sp-1] = BoolValue(valuesEqual(stack[sp-1], stack[sp]))
+		case globals.OpGreater, globals.OpLess, globals.OpSubtract, globals.OpMultiply, globals.OpDivide, globals.OpModulo:
+			a, b := stack[sp-2], stack[sp-1]
+			if !IsNumber(a) || !IsNumber(b) {
+				vm.fail(frame, ip, "Operands must be numbers.")
+				goto unwound
+			}
+			sp--
+			stack[sp-1] = numberOp(instruction, AsNumber(a), AsNumber(b))
+		case globals.OpAdd:
+			result, ok := addValues(stack[sp-2], stack[sp-1])
+			if !ok {
+				vm.fail(frame, ip, "Operands must be two numbers or two strings.")
+				goto unwound
+			}
+			sp--
+			stack[sp-1] = result
+		case globals.OpAddConstant:
+			result, ok := addValues(stack[sp-1], constants[code[ip]])
+			ip++
+			if !ok {
+				vm.fail(frame, ip, "Operands must be two numbers or two strings.")
+				goto unwound
 			}
-			vm.stack = frame.slots
-			vm.Push(result)
-			frame = &vm.frame[vm.frameCount-1]
-			runoffset = 0
-		case uint8(globals.OpJumpFalse):
-			runoffset += 3
-			offsetJumpFalse := frame.ReadShort()
-			if isFalsey(vm.Peek()) {
-				frame.fpPtr += int(offsetJumpFalse)
+			stack[sp-1] = result
+		case globals.OpIncrementLocal:
+			slot := base + int(code[ip])
+			result, ok := addValues(stack[slot], constants[code[ip+1]])
+			ip += 2
+			if !ok {
+				vm.fail(frame, ip, "Operands must be two numbers or two strings.")
+				goto unwound
 			}
-		case uint8(globals.OpJump):
-			runoffset += 3
-			offsetJump := frame.ReadShort()
-			frame.fpPtr += int(offsetJump)
-		case uint8(globals.OpLoop):
-			offsetLoop := int(frame.ReadShort())
-			frame.fpPtr -= int(offsetLoop)
-		case uint8(globals.OpGreater):
-			runoffset++
-			err := vm.BinaryOp(func(v1, v2 Value) Value { return BoolValue(v1.As.(float64) > v2.As.(float64)) })
-			if err != nil {
-				vm.runtimeError(offset, runoffset, err.Error())
-				return InterpretRuntimeError
+			stack[slot] = result
+		case globals.OpNegate:
+			if !IsNumber(stack[sp-1]) {
+				vm.fail(frame, ip, "Operand must be a number.")
+				goto unwound
 			}
-		case uint8(globals.OpLess):
-			runoffset++
-			vm.BinaryOp(func(v1, v2 Value) Value { return BoolValue(v1.As.(float64) < v2.As.(float64)) })
-		case uint8(globals.OpNegate):
-			runoffset++
-			vm.Push(Value{Type: ValNumber, As: -vm.Pop().As.(float64)})
-		case uint8(globals.OpAdd):
-			runoffset++
-			b := vm.Pop()
-			a := vm.Pop()
-			if IsObjType(b, ObjStringType) && IsObjType(a, ObjStringType) {
-				aString := AsObjString(a)
-				bString := AsObjString(b)
-				resultString := append(aString.Chars, bString.Chars...)
-				hash := hashString(resultString, len(resultString))
-				resultObj := allocateString(resultString, len(resultString), ObjStringType, hash)
-				vm.Push(ObjStrValue(resultObj))
-			} else if IsNumber(a) && IsNumber(b) {
-				b := AsNumber(b)
-				a := AsNumber(a)
-				vm.Push(NumberValue(a + b))
+			stack[sp-1] = NumberValue(-AsNumber(stack[sp-1]))
+		case globals.OpIncrement, globals.OpDecrement:
+			if !IsNumber(stack[sp-1]) {
+				vm.fail(frame, ip, "Operand must be a number.")
+				goto unwound
+			}
+			stack[sp-1] = NumberValue(AsNumber(stack[sp-1]) + step(instruction == globals.OpIncrement))
+		case globals.OpNot:
+			stack[sp-1] = BoolValue(isFalsey(stack[sp-1]))
+		case globals.OpPrint:
+			sp--
+			FprintValue(vm.out, stack[sp])
+			fmt.Fprintf(vm.out, "\n")
+		case globals.OpJump:
+			ip += 2 + int(uint16(code[ip])<<8|uint16(code[ip+1]))
+		case globals.OpJumpFalse:
+			if isFalsey(stack[sp-1]) {
+				ip += 2 + int(uint16(code[ip])<<8|uint16(code[ip+1]))
+			} else {
+				ip += 2
+			}
+		case globals.OpJumpNil:
+			if IsNil(stack[sp-1]) {
+				ip += 2 + int(uint16(code[ip])<<8|uint16(code[ip+1]))
+			} else {
+				ip += 2
+			}
+		case globals.OpLessJumpFalse:
+			a, b := stack[sp-2], stack[sp-1]
+			if !IsNumber(a) || !IsNumber(b) {
+				ip += 2
+				vm.fail(frame, ip, "Operands must be numbers.")
+				goto unwound
+			}
+			sp--
+			stack[sp-1] = BoolValue(AsNumber(a) < AsNumber(b))
+			if AsNumber(a) < AsNumber(b) {
+				ip += 2
 			} else {
-				vm.runtimeError(offset, runoffset, "Operands must be two numbers or two strings.")
-				return InterpretRuntimeError
+				ip += 2 + int(uint16(code[ip])<<8|uint16(code[ip+1]))
+			}
+		case globals.OpLoop:
+			ip += 2 - int(uint16(code[ip])<<8|uint16(code[ip+1]))
+		case globals.OpCall:
+			argcount := int(code[ip])
+			ip++
+			frame.ip = ip
+			vm.stackTop = sp
+			if !callValue(stack[sp-argcount-1], argcount) {
+				goto unwound
+			}
+			goto reload
+		case globals.OpImport:
+			module := AsFunction(constants[code[ip]])
+			ip++
+			if vm.imported[module.name] {
+				stack[sp] = NilValue()
+				sp++
+				break
 			}
-		case uint8(globals.OpSubtract):
-			runoffset++
-			vm.BinaryOp(func(v1, v2 Value) Value { return Value{Type: ValNumber, As: v1.As.(float64) - v2.As.(float64)} })
-		case uint8(globals.OpMultiply):
-			runoffset++
-			vm.BinaryOp(func(v1, v2 Value) Value { return Value{Type: ValNumber, As: v1.As.(float64) * v2.As.(float64)} })
-		case uint8(globals.OpDivide):
-			runoffset++
-			vm.BinaryOp(func(v1, v2 Value) Value { return Value{Type: ValNumber, As: v1.As.(float64) / v2.As.(float64)} })
-		case uint8(globals.OpNot):
-			runoffset++
-			vm.Push(BoolValue(isFalsey(vm.Pop())))
+			vm.imported[module.name] = true
+			stack[sp] = ObjVal(module)
+			sp++
+			frame.ip = ip
+			vm.stackTop = sp
+			if !fcall(module, 0) {
+				goto unwound
+			}
+			goto reload
+		case globals.OpTry, globals.OpTryFinally:
+			target := ip + 2 + int(uint16(code[ip])<<8|uint16(code[ip+1]))
+			ip += 2
+			frame.handlers = append(frame.handlers, handler{ip: target, depth: sp - base, finally: instruction == globals.OpTryFinally})
+		case globals.OpEndTry:
+			frame.handlers = frame.handlers[:len(frame.handlers)-1]
+		case globals.OpEndFinally:
+			sp -= 2
+			if completion := stack[sp+1]; !IsNil(completion) {
+				frame.ip = ip
+				vm.stackTop = sp
+				if AsNumber(completion) == completionThrow {
+					vm.throw(stack[sp], vm.trace())
+					goto unwound
+				}
+				if vm.returnFrom(stack[sp]) {
+					return InterpretOk
+				}
+				goto reload
+			}
+		case globals.OpThrow:
+			frame.ip = ip
+			vm.stackTop = sp
+			vm.throw(stack[sp-1], vm.trace())
+			goto unwound
+		case globals.OpGetProperty:
+			value, message := getProperty(stack[sp-1], AsObjString(constants[code[ip]]))
+			ip++
+			if message != "" {
+				vm.fail(frame, ip, message)
+				goto unwound
+			}
+			stack[sp-1] = value
+		case globals.OpArgMissing:
+			stack[sp] = BoolValue(IsUndefined(stack[base+int(code[ip])]))
+			ip++
+			sp++
+		case globals.OpReturn:
+			frame.ip = ip
+			if vm.returnFrom(stack[sp-1]) {
+				return InterpretOk
+			}
+			goto reload
 		default:
-			fmt.Println("Runtime Error at", vm.chunk.Lines[offset])
+			vm.fail(frame, ip, fmt.Sprintf("Unknown opcode %d.", instruction))
+			goto unwound
+		}
+		continue
+
+		// Calls, returns and exceptions change the running frame.
+	unwound:
+		if !vm.running() {
 			return InterpretRuntimeError
 		}
+	reload:
+		frame = &vm.frame[vm.frameCount-1]
+		code = frame.function.chunk.Code
+		constants = frame.function.chunk.Constants.Values
+		ip = frame.ip
+		base = frame.slots
+		sp = vm.stackTop
+	}
+}
+
+// numberOp applies a numeric binary opcode to a and b.
+func numberOp(op globals.OpCode, a, b float64) Value {
+	switch op {
+	case globals.OpGreater:
+		return BoolValue(a > b)
+	case globals.OpLess:
+		return BoolValue(a < b)
+	case globals.OpSubtract:
+		return NumberValue(a - b)
+	case globals.OpMultiply:
+		return NumberValue(a * b)
+	case globals.OpModulo:
+		return NumberValue(math.Mod(a, b))
+	default:
+		return NumberValue(a / b)
+	}
+}
 
+// step returns the amount OpIncrement, if up, or OpDecrement adds to a number.
+func step(up bool) float64 {
+	if up {
+		return 1
+	}
+	return -1
+}
+
+// addValues returns the sum of a and b, or their concatenation if both are strings.
+//
+// It returns false if the operands are neither two numbers nor two strings.
+func addValues(a, b Value) (Value, bool) {
+	if IsNumber(a) && IsNumber(b) {
+		return NumberValue(AsNumber(a) + AsNumber(b)), true
 	}
+	if IsString(a) && IsString(b) {
+		return ObjStrValue(concatenate(AsObjString(a), AsObjString(b))), true
+	}
+	return Value{}, false
+}
 
+// concatenate joins two strings into a new interned string.
+//
+// Both operands keep the trailing NUL byte added by copyString, so only their
+// first Length bytes are copied before a single terminator is appended.
+func concatenate(a, b *ObjectString) *ObjectString {
+	length := a.Length + b.Length
+	chars := make([]byte, length+1)
+	copy(chars, a.Chars[:a.Length])
+	copy(chars[a.Length:], b.Chars[:b.Length])
+	hash := hashString(chars, length)
+	if interned := tableFindString(vm.strings, chars, length, hash); interned != nil {
+		return interned
+	}
+	return allocateString(chars, length, ObjStringType, hash)
 }
 
 // isFalsey checks if a value is falsey.
@@ -441,11 +791,3 @@ func (vm *VM) run() InterpretResult {
 func isFalsey(val Value) bool {
 	return IsNil(val) || (IsBool(val) && !AsBool(val))
 }
-
-// readString returns an ObjectString.
-//
-// No parameters.
-// Returns *ObjectString.
-func (frame *CallFrame) readString() *ObjectString {
-	return AsObjString(frame.ReadConstant())
-}
@@ -1,6 +1,12 @@
 package src
 
-// import "testing"
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/smekuria1/goclox/globals"
+)
 
 // func TestVM_run(t *testing.T) {
 // 	type fields struct {
@@ -30,3 +36,150 @@ package src
 // 		})
 // 	}
 // }
+
+func TestGlobalSlotsSurviveAcrossInterpretCalls(t *testing.T) {
+	InitVM()
+	defer FreeVM()
+
+	if got := Interpret("total = 1;"); got != InterpretRuntimeError {
+		t.Fatalf("assigning an undefined global = %v, want %v", got, InterpretRuntimeError)
+	}
+	for _, line := range []string{"var total = 1;", "total = total + 41;"} {
+		if got := Interpret(line); got != InterpretOk {
+			t.Fatalf("Interpret(%q) = %v, want %v", line, got, InterpretOk)
+		}
+	}
+	if len(vm.globalValues) != 1 {
+		t.Fatalf("got %d global slots, want 1", len(vm.globalValues))
+	}
+	if value := vm.globalValues[0]; !IsNumber(value) || AsNumber(value) != 42 {
+		t.Errorf("total = %v, want 42", value)
+	}
+}
+
+// outputTest is a script with the output and result it must give.
+type outputTest struct {
+	name   string
+	source string
+	want   string
+	result InterpretResult
+}
+
+// testOutputs runs each test's script on the stack VM and on the register VM.
+func testOutputs(t *testing.T, tests []outputTest) {
+	t.Helper()
+	defer func(optimize, registers bool) {
+		globals.OPTIMIZE_CODE, globals.REGISTER_VM = optimize, registers
+	}(globals.OPTIMIZE_CODE, globals.REGISTER_VM)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, registers := range []bool{false, true} {
+				globals.REGISTER_VM = registers
+				InitVM()
+				var out bytes.Buffer
+				SetOutput(&out)
+				result := Interpret(tt.source)
+				SetOutput(os.Stdout)
+				FreeVM()
+				if result != tt.result || out.String() != tt.want {
+					t.Errorf("registers=%v: got %v with output\n%s\nwant %v with output\n%s", registers, result, out.String(), tt.result, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	testOutputs(t, []outputTest{
+		{"caught", "try { throw 1; } catch (e) { print e + 1; }", "2\n", InterpretOk},
+		{"runtime error", "try { print -\"a\"; } catch (e) { print e.message; }", "Operand must be a number.\n", InterpretOk},
+		{"arity", "fun f(a) {}\ntry { f(); } catch (e) { print e.message; }", "Expected 1 arguments but got 0.\n", InterpretOk},
+		{
+			"trace",
+			"fun f() {\n  return missing;\n}\nfun g() { return f(); }\ntry { g(); } catch (e) { print e.trace; }",
+			"[line 2] in f()\n[line 4] in g()\n[line 5] in script\n", InterpretOk,
+		},
+		{"finally after catch", "try { throw 1; } catch (e) { print \"c\"; } finally { print \"f\"; }\nprint \"after\";", "c\nf\nafter\n", InterpretOk},
+		{"finally on return", "fun f() { try { return 1; } finally { print \"f\"; } }\nprint f();", "f\n1\n", InterpretOk},
+		{"throw from catch", "try { throw 1; } catch (e) { throw e + 1; } finally { print \"f\"; }", "f\nUncaught exception: 2\n[line 1] in script\n", InterpretRuntimeError},
+		{"throw from finally", "fun f() { try { return 1; } finally { throw \"no\"; } }\ntry { f(); } catch (e) { print e; }", "no\n", InterpretOk},
+		{"uncaught", "fun f() {\n  throw \"bad\";\n}\nf();", "Uncaught exception: bad\n[line 2] in f()\n[line 4] in script\n", InterpretRuntimeError},
+		{"uncaught error", "print 1 + nil;", "Operands must be two numbers or two strings.\n[line 1] in script\n", InterpretRuntimeError},
+		{"not an error", "try { throw 1; } catch (e) { print e.message; }", "Only errors and lists have properties.\n[line 1] in script\n", InterpretRuntimeError},
+		{"no such property", "try { print nil + 1; } catch (e) { print e.line; }", "Undefined property 'line'.\n[line 1] in script\n", InterpretRuntimeError},
+		{"stack restored", "var a = \"a\";\n{\n  var b = \"b\";\n  try { print a + (b + -nil); } catch (e) {}\n  print b;\n}", "b\n", InterpretOk},
+	})
+}
+
+func TestLambdas(t *testing.T) {
+	testOutputs(t, []outputTest{
+		{"function expression", "var add = fun (a, b) { return a + b; };\nprint add(1, 2);", "3\n", InterpretOk},
+		{"arrow", "print ((a, b) => a * b)(3, 4);", "12\n", InterpretOk},
+		{"no parameters", "var f = () => \"c\";\nprint f();", "c\n", InterpretOk},
+		{"callback", "fun apply(f, x) { return f(x); }\nprint apply((x) => -x, 5);\nprint apply(fun (x) { return x + 1; }, 5);", "-5\n6\n", InterpretOk},
+		{"reads globals when called", "var y = 3;\nvar f = () => y;\ny = 4;\nprint f();", "4\n", InterpretOk},
+		{"in a block", "{\n  var square = (x) => x * x;\n  print square(9);\n}", "81\n", InterpretOk},
+		{"names", "print fun () {};\n\nvar f = (x) => x;\nprint f;", "lambda@1\nlambda@3\n", InterpretOk},
+		{"grouping", "var x = 2;\nprint (x) * 3;\nprint (1 + 2) * 3;", "6\n9\n", InterpretOk},
+		{"arity", "((a) => a)();", "Expected 1 arguments but got 0.\n[line 1] in script\n", InterpretRuntimeError},
+		{
+			"trace",
+			"var check = (x) => x + nil;\ncheck(1);",
+			"Operands must be two numbers or two strings.\n[line 1] in lambda@1()\n[line 2] in script\n", InterpretRuntimeError,
+		},
+		{
+			"enclosing local",
+			"var x = \"global x\";\nfun outer() {\n  var x = \"local x\";\n  return (() => x)();\n}\nprint outer();",
+			"Error [line 4], at 'x': Can't use local variable 'x' of an enclosing function.\n 4 |   return (() => x)();\n   |                 ^\n", InterpretCompileError,
+		},
+	})
+}
+
+func TestParameters(t *testing.T) {
+	testOutputs(t, []outputTest{
+		{"defaults", "fun greet(name, greeting = \"Hello\") { return greeting + \", \" + name; }\nprint greet(\"world\");\nprint greet(\"world\", \"Hi\");", "Hello, world\nHi, world\n", InterpretOk},
+		{"evaluated at each call", "var calls = 0;\nfun next() { calls += 1; return calls; }\nfun f(x = next()) { return x; }\nprint f();\nprint f();\nprint f(10);\nprint calls;", "1\n2\n10\n2\n", InterpretOk},
+		{"earlier parameters", "fun h(a, b = a + 1, c = b * 2) { return a + b + c; }\nprint h(1);\nprint h(1, 1);", "7\n4\n", InterpretOk},
+		{"explicit nil", "fun g(x = 1) { return x; }\nprint g(nil);", "nil\n", InterpretOk},
+		{"rest", "fun r(first, ...rest) { print rest; return rest.length; }\nprint r(0);\nprint r(0, 1, \"two\", nil);", "[]\n0\n[1, two, nil]\n3\n", InterpretOk},
+		{"default and rest", "fun both(first = 0, ...rest) { return first + rest.length; }\nprint both();\nprint both(10, 1, 1);", "0\n12\n", InterpretOk},
+		{"arrow default", "var add = (a, b = 1) => a + b;\nprint add(1);\nprint add(1, 2);", "2\n3\n", InterpretOk},
+		{"exact arity", "fun f(a, b) {}\nf(1);", "Expected 2 arguments but got 1.\n[line 2] in script\n", InterpretRuntimeError},
+		{"too few", "fun f(a, b = 1) {}\nf();", "Expected 1 to 2 arguments but got 0.\n[line 2] in script\n", InterpretRuntimeError},
+		{"too many", "fun f(a, b = 1) {}\nf(1, 2, 3);", "Expected 1 to 2 arguments but got 3.\n[line 2] in script\n", InterpretRuntimeError},
+		{"too few for rest", "fun f(a, ...rest) {}\nf();", "Expected at least 1 arguments but got 0.\n[line 2] in script\n", InterpretRuntimeError},
+	})
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	testOutputs(t, []outputTest{
+		{"globals", "var n = 10;\nn += 5;\nprint n;\nn -= 3;\nprint n;\nn *= 2;\nprint n;\nn /= 4;\nprint n;\nn %= 4;\nprint n;", "15\n12\n24\n6\n2\n", InterpretOk},
+		{"locals", "{\n  var n = 10;\n  n += 5;\n  n -= 3;\n  n *= 2;\n  n /= 4;\n  n %= 4;\n  print n;\n}", "2\n", InterpretOk},
+		{"strings", "var s = \"Hello\";\ns += \", world\";\nprint s;", "Hello, world\n", InterpretOk},
+		{"value", "var x = 1;\nprint x += x *= 3;\nprint x;", "4\n4\n", InterpretOk},
+		{"modulo", "print 17 % 5;\nprint -7 % 3;\nprint 5.5 % 2;", "2\n-1\n1.5\n", InterpretOk},
+		{"global increments", "var n = 1;\nprint n++;\nprint n;\nprint ++n;\nprint n--;\nprint --n;", "1\n2\n3\n3\n1\n", InterpretOk},
+		{"local increments", "{\n  var n = 1;\n  print n++;\n  print ++n;\n  print n--;\n  print --n;\n}", "1\n3\n3\n1\n", InterpretOk},
+		{"loop", "var steps = 0;\nfor (var i = 4; i > 0; i--) steps++;\nprint steps;", "4\n", InterpretOk},
+		{"operand type", "var s = \"a\";\ns -= 1;", "Operands must be numbers.\n[line 2] in script\n", InterpretRuntimeError},
+		{"increment type", "var s = \"a\";\ns++;", "Operand must be a number.\n[line 2] in script\n", InterpretRuntimeError},
+		{"decrement type", "{\n  var s = nil;\n  --s;\n}", "Operand must be a number.\n[line 3] in script\n", InterpretRuntimeError},
+	})
+}
+
+func TestConditionals(t *testing.T) {
+	testOutputs(t, []outputTest{
+		{"conditional", "var b = 2;\nprint b > 1 ? \"big\" : \"small\";\nprint nil ? 1 : 2;", "big\n2\n", InterpretOk},
+		{"nested", "fun size(n) { return n > 5 ? \"big\" : n > 1 ? \"medium\" : \"small\"; }\nprint size(9);\nprint size(3);\nprint size(0);", "big\nmedium\nsmall\n", InterpretOk},
+		{"conditional precedence", "print true ? 1 : 2 + 10;\nprint false ? 1 : 2 + 10;", "1\n12\n", InterpretOk},
+		{"conditional short-circuits", "fun f() { print \"called\"; return 0; }\nprint true ? 1 : f();\nprint false ? f() : 2;", "1\n2\n", InterpretOk},
+		{"coalesce", "var a = nil;\nprint a ?? \"default\";\nprint 2 ?? \"default\";\nprint false ?? \"default\";\nprint a ?? nil ?? 3;", "default\n2\nfalse\n3\n", InterpretOk},
+		{"coalesce short-circuits", "fun f() { print \"called\"; return 0; }\nprint 1 ?? f();", "1\n", InterpretOk},
+		{"coalesce precedence", "print nil ?? false or true;\nprint nil ?? 1 ? \"a\" : \"b\";", "true\na\n", InterpretOk},
+		{"safe access", "fun fail() { return -nil; }\ntry { fail(); } catch (e) { print e?.message; }\nvar none = nil;\nprint none?.message;\nprint none?.message ?? \"no message\";", "Operand must be a number.\nnil\nno message\n", InterpretOk},
+		{"safe access chain", "var none = nil;\nprint none?.message.length;\nprint none?.a?.b.c ?? \"none\";\nfun f() { return nil; }\nprint f()?.g(1);", "nil\nnone\nnil\n", InterpretOk},
+		{"safe access chain on a value", "fun list(...items) { return items; }\nprint list(1, 2)?.length;", "2\n", InterpretOk},
+		{"grouping ends the chain", "print (nil?.a).b;", "Only errors and lists have properties.\n[line 1] in script\n", InterpretRuntimeError},
+		{"safe access on a value", "var n = 1;\nprint n?.length;", "Only errors and lists have properties.\n[line 2] in script\n", InterpretRuntimeError},
+	})
+}